@@ -0,0 +1,25 @@
+package main
+
+import "fmt"
+
+// RequireRecords returns a first page assertion that the page has at least n records
+func RequireRecords(n int) func(ResultSet) error {
+	return func(rs ResultSet) error {
+		if len(rs.Data.Records) < n {
+			return fmt.Errorf("expected at least %v records, page has %v", n, len(rs.Data.Records))
+		}
+		return nil
+	}
+}
+
+// RequireColumns returns a first page assertion that the page header has the named columns
+func RequireColumns(names ...string) func(ResultSet) error {
+	return func(rs ResultSet) error {
+		for _, name := range names {
+			if _, err := columnPosition(rs.Data.Header.Columns, name); err != nil {
+				return fmt.Errorf("expected column %v", name)
+			}
+		}
+		return nil
+	}
+}
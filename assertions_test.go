@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRequireRecordsAndColumns(t *testing.T) {
+	rs := ResultSet{Data: Data{Header: Header{Columns: testColumns("id", "name")}, Records: Records{{"1", "a"}, {"2", "b"}}}}
+	for _, test := range []struct {
+		name      string
+		assertion func(ResultSet) error
+		err       string
+	}{
+		{name: "records", assertion: RequireRecords(2)},
+		{name: "too few records", assertion: RequireRecords(3), err: "expected at least 3 records, page has 2"},
+		{name: "columns", assertion: RequireColumns("name", "id")},
+		{name: "missing column", assertion: RequireColumns("id", "email"), err: "expected column email"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.assertion(rs)
+			if len(test.err) == 0 && err != nil {
+				t.Errorf("got %v", err)
+			} else if len(test.err) > 0 && (err == nil || err.Error() != test.err) {
+				t.Errorf("got %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestFirstPageAssertion(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {}}))
+
+	calls := 0
+	count := func(ResultSet) error {
+		calls++
+		return nil
+	}
+	r, err := NewClient(s.URL, WithFirstPageAssertion(count), WithFirstPageAssertion(RequireRecords(1))).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil || r.PageCount != 2 {
+		t.Fatalf("got %v pages, %v, want the 2 pages", r.PageCount, err)
+	}
+	if calls != 1 {
+		t.Errorf("assertion applied to %v pages, want only the first", calls)
+	}
+
+	failed := errors.New("not wanted")
+	_, err = NewClient(s.URL, WithFirstPageAssertion(func(ResultSet) error { return failed })).consumeAllPages(context.Background(), "h", "t1")
+	var ae *assertionError
+	if !errors.As(err, &ae) || !errors.Is(err, failed) {
+		t.Fatalf("got %v, want an assertionError of the failure", err)
+	}
+	if got := s.tokens(); len(got) != 3 {
+		t.Errorf("requested %v, want no page after the failed first page of the second run", got)
+	}
+}
+
+func TestRequireFlags(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t1"}
+
+	if _, stderr, code := runMain(t, append(args, "-require-records", "1", "-require-column", "id")...); code != 0 {
+		t.Errorf("passing: exit %v, stderr %q", code, stderr)
+	}
+	for _, test := range []struct {
+		args []string
+		err  string
+	}{
+		{args: []string{"-require-records", "2"}, err: "expected at least 2 records, page has 1"},
+		{args: []string{"-require-column", "id", "-require-column", "name"}, err: "expected column name"},
+	} {
+		_, stderr, code := runMain(t, append(args, test.args...)...)
+		if code == 0 || !strings.Contains(stderr, "first page assertion failed") || !strings.Contains(stderr, test.err) {
+			t.Errorf("%v: exit %v, stderr %q", test.args, code, stderr)
+		}
+	}
+}
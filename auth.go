@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTokenFileTTL is how long a token read from a file is used before the
+// file is read again, to pick up a rotated token
+const defaultTokenFileTTL = 10 * time.Second
+
+// TokenSource returns the bearer token to authenticate page requests with
+type TokenSource func(ctx context.Context) (string, error)
+
+// ExpiringTokenSource returns a bearer token together with the time it expires
+type ExpiringTokenSource func(ctx context.Context) (string, time.Time, error)
+
+// TokenFileSource returns an ExpiringTokenSource reading the bearer token from
+// the file at path, such as a mounted secret that is rotated in place.  Each
+// token read is used for ttl before the file is read again, or until rejected
+func TokenFileSource(path string, ttl time.Duration) ExpiringTokenSource {
+	return func(ctx context.Context) (string, time.Time, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", time.Time{}, &tokenFileError{err}
+		}
+		token := strings.TrimSpace(string(b))
+		if len(token) == 0 {
+			return "", time.Time{}, fmt.Errorf("token file %v: empty", path)
+		}
+		return token, time.Now().Add(ttl), nil
+	}
+}
+
+// tokenFileError is the failure to read a token file, such as while it is
+// replaced during rotation, so is retried as a network failure
+type tokenFileError struct {
+	err error
+}
+
+func (e *tokenFileError) Error() string {
+	return "token file: " + e.err.Error()
+}
+
+func (e *tokenFileError) Unwrap() error {
+	return e.err
+}
+
+// CommandTokenSource returns a TokenSource running the command, split into its
+// program and arguments at whitespace, whose output is the bearer token, such as
+// a cloud CLI printing an access token
+func CommandTokenSource(command string) TokenSource {
+	return func(ctx context.Context) (string, error) {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return "", fmt.Errorf("token command: empty")
+		}
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("token command: %w: %v", err, strings.TrimSpace(stderr.String()))
+		}
+		token := strings.TrimSpace(string(out))
+		if len(token) == 0 {
+			return "", fmt.Errorf("token command %v: no token output", args[0])
+		}
+		return token, nil
+	}
+}
+
+// bearerAuth provides the bearer token for each page request, cached until its
+// expiry when the source gives one.  It is safe for concurrent use
+type bearerAuth struct {
+	mu     sync.Mutex
+	source ExpiringTokenSource
+	token  string
+	expiry time.Time
+}
+
+// bearerToken returns the cached token if it has not expired, otherwise a new
+// token from the source
+func (a *bearerAuth) bearerToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.token) > 0 && time.Now().Before(a.expiry) {
+		return a.token, nil
+	}
+	token, expiry, err := a.source(ctx)
+	if err != nil {
+		return "", err
+	}
+	a.token, a.expiry = token, expiry
+	return token, nil
+}
+
+// invalidate discards the cached token if it is still token, so that the next
+// request obtains a new one
+func (a *bearerAuth) invalidate(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token == token {
+		a.token = ""
+	}
+}
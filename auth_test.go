@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bearerServer returns a pageServer of a chain of pages t1 to t3 rejecting, with
+// a 401, requests without a bearer token of valid()
+func bearerServer(t *testing.T, valid func(req Request) string) *pageServer {
+	t.Helper()
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2", "t3"}, [][][]string{{{"1"}}, {{"2"}}, {{"3"}}}))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if r.Header.Get("Authorization") != "Bearer "+valid(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return true
+		}
+		return false
+	})
+	return s
+}
+
+// bearers returns the Authorization headers of the requests received by s
+func bearers(s *pageServer) []string {
+	headers := []string{}
+	for _, r := range s.received() {
+		headers = append(headers, strings.TrimPrefix(r.header.Get("Authorization"), "Bearer "))
+	}
+	return headers
+}
+
+func TestTokenSourceRefresh(t *testing.T) {
+	// The token first issued expires once the first page is retrieved
+	var mu sync.Mutex
+	expired, issued := false, 0
+	s := bearerServer(t, func(req Request) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Token != "t1" {
+			expired = true
+		}
+		if expired {
+			return "fresh"
+		}
+		return "stale"
+	})
+	source := func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if issued++; expired {
+			return "fresh", nil
+		}
+		return "stale", nil
+	}
+
+	r, err := NewClient(s.URL, WithTokenSource(source)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil || r.PageCount != 3 {
+		t.Fatalf("got %v pages, %v, want the run to continue with the new token", r.PageCount, err)
+	}
+	if got, want := bearers(s), []string{"stale", "stale", "fresh", "fresh"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+	if issued != 4 {
+		t.Errorf("source called %v times, want before each of the 4 requests", issued)
+	}
+}
+
+func TestTokenSourceRejected(t *testing.T) {
+	s := bearerServer(t, func(Request) string { return "valid" })
+	_, err := NewClient(s.URL, WithTokenSource(func(context.Context) (string, error) { return "invalid", nil })).consumeAllPages(context.Background(), "h", "t1")
+	if err == nil {
+		t.Fatal("rejected token accepted")
+	}
+	if n := len(s.received()); n != 2 {
+		t.Errorf("%v requests, want the 401 retried once", n)
+	}
+}
+
+func TestCommandTokenSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := CommandTokenSource("cat " + path)(context.Background()); err != nil || token != "secret" {
+		t.Errorf("got %q, %v", token, err)
+	}
+	for _, command := range []string{"", "true", "cat " + path + ".missing"} {
+		if _, err := CommandTokenSource(command)(context.Background()); err == nil || !strings.HasPrefix(err.Error(), "token command") {
+			t.Errorf("%q: got %v", command, err)
+		}
+	}
+}
+
+func TestAuthTokenCommandFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The token is rotated once the first page is retrieved
+	var mu sync.Mutex
+	valid := "first"
+	s := bearerServer(t, func(req Request) string {
+		mu.Lock()
+		defer mu.Unlock()
+		current := valid
+		if req.Token == "t1" {
+			if err := os.WriteFile(path, []byte("second"), 0o600); err != nil {
+				t.Error(err)
+			}
+			valid = "second"
+		}
+		return current
+	})
+
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-auth-token-command", "cat "+path)
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if got, want := bearers(s), []string{"first", "second", "second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
+
+func TestTokenFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The file is replaced with the new token once the first page is retrieved
+	var mu sync.Mutex
+	valid := "old"
+	s := bearerServer(t, func(req Request) string {
+		mu.Lock()
+		defer mu.Unlock()
+		current := valid
+		if req.Token == "t1" {
+			rotated := path + ".new"
+			if err := os.WriteFile(rotated, []byte("new\n"), 0o600); err != nil {
+				t.Error(err)
+			}
+			if err := os.Rename(rotated, path); err != nil {
+				t.Error(err)
+			}
+			valid = "new"
+		}
+		return current
+	})
+
+	r, err := NewClient(s.URL, WithExpiringTokenSource(TokenFileSource(path, 0))).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil || r.PageCount != 3 {
+		t.Fatalf("got %v pages, %v, want the run to continue with the rotated token", r.PageCount, err)
+	}
+	if got, want := bearers(s), []string{"old", "new", "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
+
+// restoringClock is a fakeClock calling restore before each wait
+type restoringClock struct {
+	*fakeClock
+	restore func()
+}
+
+func (c *restoringClock) After(d time.Duration) <-chan time.Time {
+	c.restore()
+	return c.fakeClock.After(d)
+}
+
+func TestTokenFileMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	write := func() {
+		if err := os.WriteFile(path, []byte("secret"), 0o600); err != nil {
+			t.Error(err)
+		}
+	}
+	write()
+	// The file is removed once the first page is retrieved, as if mid-rotation
+	newServer := func() *pageServer {
+		return bearerServer(t, func(req Request) string {
+			if req.Token == "t1" {
+				if err := os.Remove(path); err != nil {
+					t.Error(err)
+				}
+			}
+			return "secret"
+		})
+	}
+
+	t.Run("retried", func(t *testing.T) {
+		s := newServer()
+		clock := &restoringClock{fakeClock: newFakeClock(), restore: write}
+		r, err := NewClient(s.URL, WithExpiringTokenSource(TokenFileSource(path, 0)), WithClock(clock),
+			WithRetryPolicy(RetryClassNetwork, RetryPolicy{Attempts: 2, Backoff: time.Second})).consumeAllPages(context.Background(), "h", "t1")
+		if err != nil || r.PageCount != 3 {
+			t.Fatalf("got %v pages, %v, want the read retried once the file is restored", r.PageCount, err)
+		}
+		if n := len(s.received()); n != 3 {
+			t.Errorf("%v requests, want 3", n)
+		}
+	})
+
+	t.Run("no policy", func(t *testing.T) {
+		write()
+		s := newServer()
+		_, err := NewClient(s.URL, WithExpiringTokenSource(TokenFileSource(path, 0))).consumeAllPages(context.Background(), "h", "t1")
+		if err == nil || !strings.Contains(err.Error(), "authentication: token file: ") || !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("got %v, want the missing token file", err)
+		}
+		if n := len(s.received()); n != 1 {
+			t.Errorf("%v requests, want none after the file is removed", n)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// etagCache stores page bodies on disk, with the ETag they were served with,
+// keyed by (hash, token)
+type etagCache struct {
+	dir string
+}
+
+// paths returns the files holding the body and ETag of the (hash, token) page
+func (e *etagCache) paths(hash, token string) (string, string) {
+	name := filepath.Join(e.dir, idempotencyKey(hash, token))
+	return name + ".json", name + ".etag"
+}
+
+// etag returns the ETag of the cached (hash, token) page, or "" if it is not cached
+func (e *etagCache) etag(hash, token string) string {
+	_, etagPath := e.paths(hash, token)
+	b, err := os.ReadFile(etagPath)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// load returns the cached body of the (hash, token) page
+func (e *etagCache) load(hash, token string) ([]byte, error) {
+	bodyPath, _ := e.paths(hash, token)
+	return os.ReadFile(bodyPath)
+}
+
+// store caches the body of the (hash, token) page with its ETag.  The ETag is
+// written last, so that a partially stored entry is never used
+func (e *etagCache) store(hash, token, etag string, body []byte) error {
+	if err := os.MkdirAll(e.dir, 0o755); err != nil {
+		return err
+	}
+	bodyPath, etagPath := e.paths(hash, token)
+	os.Remove(etagPath)
+	if err := writeFileAtomic(bodyPath, body); err != nil {
+		return err
+	}
+	return writeFileAtomic(etagPath, []byte(etag))
+}
+
+// remove invalidates the cached (hash, token) page
+func (e *etagCache) remove(hash, token string) {
+	bodyPath, etagPath := e.paths(hash, token)
+	os.Remove(etagPath)
+	os.Remove(bodyPath)
+}
+
+// writeFileAtomic writes data to a temporary file which is then renamed to path
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// etagServer returns a pageServer of a chain of pages served with an ETag,
+// answering a request with a matching If-None-Match with 304 Not Modified
+func etagServer(t *testing.T) *pageServer {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}, {"2"}}, {{"3"}}}))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		etag := `"v-` + req.Token + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+		return false
+	})
+	return s
+}
+
+func TestETagCache(t *testing.T) {
+	s := etagServer(t)
+	dir := t.TempDir()
+
+	run := func() (RunResult, [][]string) {
+		t.Helper()
+		sink := &memorySink{}
+		r, err := NewClient(s.URL, WithCacheDir(dir), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r, sink.records
+	}
+
+	first, firstRecords := run()
+	if !reflect.DeepEqual(first.StatusCounts, StatusTally{"2xx": 2}) {
+		t.Errorf("first run statuses %v, want 2xx: 2", first.StatusCounts)
+	}
+	second, secondRecords := run()
+	if !reflect.DeepEqual(second.StatusCounts, StatusTally{"3xx": 2}) {
+		t.Errorf("second run statuses %v, want 3xx: 2", second.StatusCounts)
+	}
+	if !reflect.DeepEqual(secondRecords, firstRecords) || second.TotalRecords() != 3 {
+		t.Errorf("second run records %v, want %v", secondRecords, firstRecords)
+	}
+	for _, r := range s.received()[2:] {
+		if len(r.header.Get("If-None-Match")) == 0 {
+			t.Errorf("token %v requested unconditionally", r.Token)
+		}
+	}
+}
+
+func TestETagCacheMiss(t *testing.T) {
+	s := etagServer(t)
+	c := NewClient(s.URL, WithCacheDir(t.TempDir()))
+	if _, err := c.consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A body lost since its ETag was stored is retrieved again unconditionally
+	bodyPath, _ := c.cache.paths("h", "t1")
+	if err := os.Remove(bodyPath); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.TotalRecords() != 3 || !reflect.DeepEqual(r.StatusCounts, StatusTally{"2xx": 1, "3xx": 2}) {
+		t.Errorf("got %v records with statuses %v, want 3 with 2xx: 1, 3xx: 2", r.TotalRecords(), r.StatusCounts)
+	}
+	if _, err := os.Stat(bodyPath); err != nil {
+		t.Errorf("page not cached again: %v", err)
+	}
+}
+
+func TestETagCacheInvalidated(t *testing.T) {
+	s := etagServer(t)
+	c := NewClient(s.URL, WithCacheDir(t.TempDir()))
+	if _, err := c.consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A page no longer served with an ETag is removed from the cache
+	s.setHandle(nil)
+	if _, err := c.consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if etag := c.cache.etag("h", "t1"); len(etag) > 0 {
+		t.Errorf("cached with ETag %v", etag)
+	}
+}
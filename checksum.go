@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+)
+
+// Checksum algorithms of the outputs
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumMD5    = "md5"
+)
+
+// newChecksumHash returns a hash of the checksum algorithm
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	}
+	return nil, fmt.Errorf("unknown checksum algorithm %v", algorithm)
+}
+
+// checksumWriter hashes the bytes written to an output, and once the output is
+// closed writes their checksum to a sidecar of the output named by the
+// algorithm, such as out.csv.sha256.  The sidecar has the line sha256sum or
+// md5sum would write, so the output can be verified with sha256sum -c
+type checksumWriter struct {
+	ctx       context.Context
+	out       io.WriteCloser
+	path      string
+	algorithm string
+	hash      hash.Hash
+}
+
+// newChecksumWriter returns a checksumWriter of the output at path
+func newChecksumWriter(ctx context.Context, out io.WriteCloser, path, algorithm string) (*checksumWriter, error) {
+	h, err := newChecksumHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumWriter{ctx: ctx, out: out, path: path, algorithm: algorithm, hash: h}, nil
+}
+
+// Write writes p to the output, hashing the bytes written
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+// Close closes the output and writes the sidecar, which is not written should
+// the output fail to close, as its content may then differ from that hashed
+func (w *checksumWriter) Close() error {
+	if err := w.out.Close(); err != nil {
+		return err
+	}
+
+	sidecar, err := openOutput(w.ctx, w.path+"."+w.algorithm)
+	if err != nil {
+		return fmt.Errorf("checksum: %w", err)
+	}
+	line := hex.EncodeToString(w.hash.Sum(nil)) + "  " + path.Base(w.path) + "\n"
+	if _, err := io.WriteString(sidecar, line); err != nil {
+		sidecar.Close()
+		return fmt.Errorf("checksum: %w", err)
+	}
+	if err := sidecar.Close(); err != nil {
+		return fmt.Errorf("checksum: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.csv")
+	out, err := openOutput(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newChecksumWriter(context.Background(), out, path, ChecksumSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("id\n"))
+	w.Write([]byte("1\n2\n"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("id\n1\n2\n"))
+	if b, _ := os.ReadFile(path + ".sha256"); string(b) != hex.EncodeToString(sum[:])+"  out.csv\n" {
+		t.Errorf("sidecar %q", b)
+	}
+	if _, err := newChecksumWriter(context.Background(), out, path, "crc32"); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
+
+func TestChecksumFlag(t *testing.T) {
+	s := newPageServer(t, numberedPages(3, 2))
+	for algorithm, sum := range map[string]func([]byte) string{
+		ChecksumSHA256: func(b []byte) string { s := sha256.Sum256(b); return hex.EncodeToString(s[:]) },
+		ChecksumMD5:    func(b []byte) string { s := md5.Sum(b); return hex.EncodeToString(s[:]) },
+	} {
+		t.Run(algorithm, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.csv")
+			if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-output-format", "csv", "-output", path, "-checksum", algorithm); code != 0 {
+				t.Fatalf("exit %v, stderr %q", code, stderr)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The sidecar matches the hash of the file as written
+			if got, _ := os.ReadFile(path + "." + algorithm); string(got) != sum(b)+"  out.csv\n" {
+				t.Errorf("sidecar %q, want the %v of %q", got, algorithm, b)
+			}
+		})
+	}
+	for _, args := range [][]string{{"-checksum", "crc32", "-output", filepath.Join(t.TempDir(), "out")}, {"-checksum", ChecksumSHA256}} {
+		if _, _, code := runMain(t, append([]string{"-url", s.URL, "-hash", "h", "-token", "t0"}, args...)...); code == 0 {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,1902 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultNextTokenPath is the location of the next page token in a page response
+const defaultNextTokenPath = "meta.next"
+
+// Policies for handling a page whose response cannot be decoded
+const (
+	DecodeErrorAbort = "abort"
+	DecodeErrorSkip  = "skip"
+)
+
+// slowPageMinSamples is the number of pages retrieved before the slow page
+// watchdog is armed
+const slowPageMinSamples = 5
+
+// Handling of a record count that differs from the server's meta.total
+const (
+	TotalMismatchWarn  = "warn"
+	TotalMismatchError = "error"
+)
+
+// Handling of a null value in a column that the server declares not nullable
+const (
+	NullViolationError = "error"
+	NullViolationAllow = "allow"
+)
+
+// snippetLength is the maximum number of body bytes reported for an undecodable page
+const snippetLength = 200
+
+// Client retrieves the pages of a result set from a dataproxy server.  A Client
+// is not modified once NewClient returns, with the state of each pagination
+// confined to its call, and so may be shared by goroutines paginating
+// concurrently.  Its RecordSink and page hooks are then called concurrently
+type Client struct {
+	url                  string
+	httpClient           *http.Client
+	nextTokenPath        []string
+	decodeErrorPolicy    string
+	maxConsecutiveErrors int
+	since                *sinceFilter
+	idempotencyKeys      bool
+	runFor               time.Duration
+	queryParams          url.Values
+	cache                *etagCache
+	pageCache            *PageCache
+	sink                 RecordSink
+	jobSink              func(Job) (RecordSink, error)
+	stopPredicate        func([]Column, []string) bool
+	maxIdle              time.Duration
+	coalesced            *atomic.Int64
+	skipPages            int
+	decompressors        map[string]Decompressor
+	acceptEncoding       string
+	assertions           []func(ResultSet) error
+	pause                *PauseGate
+	stop                 *StopGate
+	captureDir           string
+	captureCompress      string
+	captureOverwrite     bool
+	slowPageFactor       float64
+	coercions            map[string]string
+	objectRecords        bool
+	retryPolicies        map[string]RetryPolicy
+	deadline             time.Duration
+	pagination           Pagination
+	totalPolicy          string
+	useNumber            bool
+	duplicatePages       string
+	auth                 *bearerAuth
+	retrySem             chan struct{}
+	pageHooks            []func(context.Context, PageEvent)
+	maxRedirects         int
+	redirectAuth         string
+	pins                 []string
+	emptyRetries         int
+	emptyBackoff         time.Duration
+	exprs                []*RecordExpr
+	shardConcurrency     int
+	trimSpace            bool
+	invalidUTF8          string
+	stream               bool
+	streamColumns        []Column
+	tokenReuse           string
+	pageHints            bool
+	redactToken          TokenRedactor
+	eodStatuses          map[int]bool
+	serverFields         []string
+	serverTimeHeader     string
+	maxServerTime        time.Duration
+	serverTimePolicy     string
+	envelope             *Envelope
+	firstTokenPath       string
+	throttle             *adaptiveThrottle
+	jobTagColumn         string
+	validateTypes        bool
+	nullViolation        string
+	maxConns             int
+	clock                Clock
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithNextTokenPath sets the dot separated path to the next page token within
+// a page response (e.g. "meta.pagination.next"), for servers that do not
+// return it as meta.next
+func WithNextTokenPath(path string) Option {
+	return func(c *Client) {
+		c.nextTokenPath = strings.Split(path, ".")
+	}
+}
+
+// WithDecodeErrorPolicy sets how a page that cannot be decoded is handled:
+// DecodeErrorAbort (the default) ends the run, whilst DecodeErrorSkip logs the
+// page and continues pagination, provided its next token can be recovered
+func WithDecodeErrorPolicy(policy string) Option {
+	return func(c *Client) {
+		c.decodeErrorPolicy = policy
+	}
+}
+
+// WithMaxConsecutiveErrors ends pagination with an error once n pages in a row
+// have been skipped under DecodeErrorSkip, as that suggests a systemic problem
+// rather than isolated bad pages.  A page retrieved successfully resets the
+// count, and 0, the default, skips any number of pages
+func WithMaxConsecutiveErrors(n int) Option {
+	return func(c *Client) {
+		c.maxConsecutiveErrors = n
+	}
+}
+
+// WithSince excludes records whose timestamp in the named column is before the
+// cutoff from the record counts and output
+func WithSince(column string, cutoff time.Time) Option {
+	return func(c *Client) {
+		c.since = &sinceFilter{column: column, cutoff: cutoff}
+	}
+}
+
+// WithIdempotencyKeys sets whether each page request carries an Idempotency-Key
+// header derived from its (hash, token), allowing the server to recognise
+// repeated requests for the same page
+func WithIdempotencyKeys(enabled bool) Option {
+	return func(c *Client) {
+		c.idempotencyKeys = enabled
+	}
+}
+
+// WithRunFor sets a time budget for paginating a result set.  Once it has
+// elapsed pagination stops cleanly, returning the pages retrieved so far
+// rather than an error
+func WithRunFor(d time.Duration) Option {
+	return func(c *Client) {
+		c.runFor = d
+	}
+}
+
+// WithQueryParams adds the parameters to the query string of each page request,
+// in addition to any query already present in the client's url
+func WithQueryParams(params url.Values) Option {
+	return func(c *Client) {
+		if c.queryParams == nil {
+			c.queryParams = url.Values{}
+		}
+		for key, values := range params {
+			c.queryParams[key] = append(c.queryParams[key], values...)
+		}
+	}
+}
+
+// WithCacheDir caches page responses served with an ETag in the directory.
+// Cached pages are requested with If-None-Match, and a 304 Not Modified
+// response is satisfied from the cache
+func WithCacheDir(dir string) Option {
+	return func(c *Client) {
+		c.cache = &etagCache{dir: dir}
+	}
+}
+
+// WithPageCache reuses the decoded pages held by the cache for pages already
+// retrieved, and adds those retrieved to it.  Pages served from the cache make no
+// request, so are neither captured nor counted in the status tally
+func WithPageCache(cache *PageCache) Option {
+	return func(c *Client) {
+		c.pageCache = cache
+	}
+}
+
+// WithRecordSink writes the records of each page to the sink as it is retrieved
+func WithRecordSink(sink RecordSink) Option {
+	return func(c *Client) {
+		c.sink = sink
+	}
+}
+
+// WithJobRecordSink writes the records of each job to a sink of its own, in
+// place of any sink given by WithRecordSink.  The sink is opened by open as
+// each attempt at the job starts, and closed once the attempt completes
+func WithJobRecordSink(open func(Job) (RecordSink, error)) Option {
+	return func(c *Client) {
+		c.jobSink = open
+	}
+}
+
+// WithStopPredicate ends pagination after the page holding the first record for
+// which stop returns true.  The records are those as output, after any
+// transformations and filtering, and the whole of the matching page is output,
+// including the records after the match, but no page after it.  As pagination
+// ends early, the records retrieved are not checked against the server's total.
+// Each shard of a sharded pagination stops at its own first match, and an NDJSON
+// stream is not stopped
+func WithStopPredicate(stop func(columns []Column, record []string) bool) Option {
+	return func(c *Client) {
+		c.stopPredicate = stop
+	}
+}
+
+// WithMaxIdleTime abandons a response whose body receives no data for d,
+// failing its page, to detect a stalled connection that remains open
+func WithMaxIdleTime(d time.Duration) Option {
+	return func(c *Client) {
+		c.maxIdle = d
+	}
+}
+
+// WithCoalesceIdentical drops the records of a page identical to those of the
+// page before it, whilst still following its next token, counting the pages
+// coalesced across all paginations of the client
+func WithCoalesceIdentical(enabled bool) Option {
+	return func(c *Client) {
+		c.coalesced = nil
+		if enabled {
+			c.coalesced = &atomic.Int64{}
+		}
+	}
+}
+
+// coalescedPages returns the number of pages coalesced by WithCoalesceIdentical
+func (c *Client) coalescedPages() int64 {
+	if c.coalesced == nil {
+		return 0
+	}
+	return c.coalesced.Load()
+}
+
+// WithSkipPages discards the records of the first n pages of each pagination,
+// which are still retrieved to follow their tokens, so that output starts from
+// page n+1.  As records are discarded, those output are not checked against
+// the server's total.  The pages of any shards are not skipped
+func WithSkipPages(n int) Option {
+	return func(c *Client) {
+		c.skipPages = n
+	}
+}
+
+// WithDecompressor decompresses responses with the content encoding, such as br,
+// using open, adding the encoding to the Accept-Encoding of requests.  The
+// default gzip and zstd decompressors may also be replaced
+func WithDecompressor(encoding string, open Decompressor) Option {
+	return func(c *Client) {
+		c.decompressors[strings.ToLower(encoding)] = open
+	}
+}
+
+// WithFirstPageAssertion checks the first page of each pagination with the
+// assertion, ending the pagination with an assertionError if it fails
+func WithFirstPageAssertion(assertion func(ResultSet) error) Option {
+	return func(c *Client) {
+		c.assertions = append(c.assertions, assertion)
+	}
+}
+
+// WithPauseGate holds pagination between pages whilst the gate is paused
+func WithPauseGate(gate *PauseGate) Option {
+	return func(c *Client) {
+		c.pause = gate
+	}
+}
+
+// WithStopGate ends pagination cleanly between pages once the gate is stopped
+func WithStopGate(gate *StopGate) Option {
+	return func(c *Client) {
+		c.stop = gate
+	}
+}
+
+// WithHTTPClient sets the http.Client used to send page requests, in place of
+// http.DefaultClient
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = client
+	}
+}
+
+// WithReplayDir answers page requests from responses captured in the directory,
+// in files named by their token, rather than from the server
+func WithReplayDir(dir string) Option {
+	return func(c *Client) {
+		c.httpClient = &http.Client{Transport: replayTransport{dir: dir, redact: c.tokenRef}}
+	}
+}
+
+// WithCaptureDir writes the decompressed body of each page to the directory as it
+// is retrieved, in a file named by its token that WithReplayDir can read.  An
+// existing capture fails the pagination unless overwrite is set
+func WithCaptureDir(dir string, overwrite bool) Option {
+	return func(c *Client) {
+		c.captureDir = dir
+		c.captureOverwrite = overwrite
+	}
+}
+
+// WithCaptureCompression compresses the pages written by WithCaptureDir, with
+// CaptureCompressGzip or CaptureCompressZstd, adding its extension to their
+// file names.  The default is CaptureCompressNone
+func WithCaptureCompression(compression string) Option {
+	return func(c *Client) {
+		c.captureCompress = compression
+	}
+}
+
+// WithSlowPageFactor aborts the pagination with a slowPageError when a page takes
+// longer than factor times the mean request duration of the pages before it.
+// The watchdog is armed once slowPageMinSamples pages have been retrieved
+func WithSlowPageFactor(factor float64) Option {
+	return func(c *Client) {
+		c.slowPageFactor = factor
+	}
+}
+
+// WithCoercions overrides the types of the named columns declared in the page
+// header, parsing their values as the type given for each column.  A value that
+// is not valid for its type is an error decoding the page
+func WithCoercions(coercions map[string]string) Option {
+	return func(c *Client) {
+		c.coercions = maps.Clone(coercions)
+	}
+}
+
+// WithObjectRecords decodes page records as JSON objects keyed by column name,
+// rather than as arrays of values ordered by column position.  The values are
+// placed at the positions of their columns, with missing keys taken as null
+func WithObjectRecords(enabled bool) Option {
+	return func(c *Client) {
+		c.objectRecords = enabled
+	}
+}
+
+// WithRetryPolicy retries page requests failing with the class of error (one of
+// the RetryClass constants) according to the policy.  Classes without a policy
+// are not retried
+func WithRetryPolicy(class string, policy RetryPolicy) Option {
+	return func(c *Client) {
+		if c.retryPolicies == nil {
+			c.retryPolicies = map[string]RetryPolicy{}
+		}
+		c.retryPolicies[class] = policy
+	}
+}
+
+// WithDeadline sets an overall deadline for paginating a result set.  Unlike
+// WithRunFor, reaching the deadline cancels any request in progress and ends
+// the pagination with a deadlineError
+func WithDeadline(d time.Duration) Option {
+	return func(c *Client) {
+		c.deadline = d
+	}
+}
+
+// WithPagination sets the strategy for requesting successive pages, by default
+// TokenPagination
+func WithPagination(p Pagination) Option {
+	return func(c *Client) {
+		c.pagination = p
+	}
+}
+
+// WithNullViolationPolicy sets how a null value, held as "", in a column whose
+// header says it is not nullable is handled: an error for the page
+// (NullViolationError, the default) or written as it is (NullViolationAllow).
+// Nulls are checked in records that are decoded for output or transformation
+func WithNullViolationPolicy(policy string) Option {
+	return func(c *Client) {
+		c.nullViolation = policy
+	}
+}
+
+// WithTotalMismatchPolicy sets how a completed pagination retrieving a different
+// number of records to the meta.total given by the server is handled:
+// TotalMismatchWarn (the default) logs a warning, TotalMismatchError fails it
+func WithTotalMismatchPolicy(policy string) Option {
+	return func(c *Client) {
+		c.totalPolicy = policy
+	}
+}
+
+// WithUseNumber decodes the numbers in page responses as json.Number, so that
+// numeric record values (e.g. 19 digit IDs) are output exactly as sent, rather
+// than as the nearest float64
+func WithUseNumber(enabled bool) Option {
+	return func(c *Client) {
+		c.useNumber = enabled
+	}
+}
+
+// WithDuplicatePagesPolicy sets whether pages whose records are identical to
+// those of an earlier page in the pagination are reported: DuplicatePagesOff
+// (the default), DuplicatePagesWarn logging a warning, or DuplicatePagesError
+// failing the pagination with a duplicatePageError
+func WithDuplicatePagesPolicy(policy string) Option {
+	return func(c *Client) {
+		c.duplicatePages = policy
+	}
+}
+
+// WithTrimSpace sets whether the leading and trailing whitespace of each cell is
+// removed, before any coercion of its type
+func WithTrimSpace(trim bool) Option {
+	return func(c *Client) {
+		c.trimSpace = trim
+	}
+}
+
+// WithInvalidUTF8Policy sets the handling of invalid UTF-8 sequences in a page:
+// InvalidUTF8Replace (the default) with U+FFFD, InvalidUTF8Strip removing them,
+// or InvalidUTF8Error failing to decode the page
+func WithInvalidUTF8Policy(policy string) Option {
+	return func(c *Client) {
+		c.invalidUTF8 = policy
+	}
+}
+
+// WithNDJSONStream sets that each job's response is a stream of NDJSON records,
+// optionally gzip compressed, rather than pages.  The records are mapped to the
+// columns by name, or if columns is nil, to those of the stream's header line
+func WithNDJSONStream(columns []Column) Option {
+	return func(c *Client) {
+		c.stream = true
+		c.streamColumns = slices.Clone(columns)
+	}
+}
+
+// WithTokenReusePolicy sets the handling of a page whose next token was already
+// requested in the pagination: TokenReuseWarn (the default) logs a warning and
+// ends the pagination, TokenReuseError fails it with a reusedTokenError
+func WithTokenReusePolicy(policy string) Option {
+	return func(c *Client) {
+		c.tokenReuse = policy
+	}
+}
+
+// WithNextPageHints sets whether the slow page watchdog allows for the duration
+// a page's response headers hint the next page will take, in X-Next-Page-Hint or
+// the next-page metric of Server-Timing, so that a heavy page is not cut off
+func WithNextPageHints(use bool) Option {
+	return func(c *Client) {
+		c.pageHints = use
+	}
+}
+
+// WithMaxConcurrentRetries limits the retries of failed page requests in progress
+// at once, across all paginations using the client, to n.  This avoids many
+// concurrent jobs retrying together against a recovering server
+func WithMaxConcurrentRetries(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.retrySem = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithAdaptiveThrottle spaces page requests, across all paginations using the
+// client, to a rate in requests per second that starts at initial and adapts to
+// 429 responses within the minimum and maximum rates.  See adaptiveThrottle
+func WithAdaptiveThrottle(initial, minRate, maxRate float64) Option {
+	return func(c *Client) {
+		c.throttle = newAdaptiveThrottle(initial, minRate, maxRate)
+	}
+}
+
+// WithTypeValidation checks every value of each page against the type of its
+// column, after any coercions, failing the page on the first invalid value.  The
+// records are not changed
+func WithTypeValidation(enabled bool) Option {
+	return func(c *Client) {
+		c.validateTypes = enabled
+	}
+}
+
+// WithJobTagColumn prepends a column of the name to the records written to the
+// sink, holding the label of each job paginated by consumeJobs, or otherwise its
+// hash, so that the records of several jobs can be told apart
+func WithJobTagColumn(name string) Option {
+	return func(c *Client) {
+		c.jobTagColumn = name
+	}
+}
+
+// WithMaxConnsPerHost limits the connections to the server, across all
+// paginations using the client, to n, with requests waiting for a connection
+// to become available.  This caps the load on the server independently of the
+// number of concurrent jobs
+func WithMaxConnsPerHost(n int) Option {
+	return func(c *Client) {
+		c.maxConns = n
+	}
+}
+
+// WithPageHook calls hook after each page of a pagination is retrieved, on the
+// goroutine of the pagination
+func WithPageHook(hook func(context.Context, PageEvent)) Option {
+	return func(c *Client) {
+		c.pageHooks = append(c.pageHooks, hook)
+	}
+}
+
+// WithRedirects sets the maximum number of redirects followed by a page request,
+// with 0 returning the redirect response itself, and whether the Authorization
+// header is preserved (RedirectAuthPreserve) or stripped (RedirectAuthStrip, the
+// default) when redirected to another host.  It has no effect if the client's
+// http.Client has its own CheckRedirect
+func WithRedirects(max int, auth string) Option {
+	return func(c *Client) {
+		c.maxRedirects = max
+		c.redirectAuth = auth
+	}
+}
+
+// WithPinnedCertSHA256 rejects connections to servers whose leaf certificate does
+// not have one of the SHA-256 fingerprints, given in hex with optional colons
+func WithPinnedCertSHA256(pins []string) Option {
+	return func(c *Client) {
+		c.pins = append(c.pins, pins...)
+	}
+}
+
+// WithRetryOnEmpty retries a page up to n times, after the backoff (doubling on
+// each retry), if it has no records and no next token although it was reached
+// from an earlier page.  This guards against servers spuriously returning empty
+// final pages; a genuinely empty final page is accepted after the n retries
+func WithRetryOnEmpty(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.emptyRetries = n
+		c.emptyBackoff = backoff
+	}
+}
+
+// WithRecordExpr applies the expression to the records of each page: a filter
+// keeps only the records for which it is true, counting the others as filtered,
+// whilst otherwise the value of its column is computed.  An error evaluating
+// the expression is an error decoding the page
+func WithRecordExpr(e *RecordExpr) Option {
+	return func(c *Client) {
+		c.exprs = append(c.exprs, e)
+	}
+}
+
+// WithShardConcurrency sets the maximum number of shards paginated in parallel,
+// when the first page of a job lists independent shards.  The default is 1
+func WithShardConcurrency(n int) Option {
+	return func(c *Client) {
+		c.shardConcurrency = n
+	}
+}
+
+// WithTokenSource authenticates each page request with a bearer token from the
+// source, which is called before every request.  A 401 Unauthorized response
+// is retried once
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) {
+		c.auth = &bearerAuth{source: func(ctx context.Context) (string, time.Time, error) {
+			token, err := source(ctx)
+			return token, time.Time{}, err
+		}}
+	}
+}
+
+// WithExpiringTokenSource authenticates each page request with a bearer token
+// from the source, cached until its expiry.  A 401 Unauthorized response
+// discards the cached token, and the request is retried once with a new token
+func WithExpiringTokenSource(source ExpiringTokenSource) Option {
+	return func(c *Client) {
+		c.auth = &bearerAuth{source: source}
+	}
+}
+
+// WithEndOfDataStatus treats a response with any of the status codes, such as
+// 204 No Content or 404 Not Found for the next token, as the end of the
+// pagination rather than a page
+func WithEndOfDataStatus(codes ...int) Option {
+	return func(c *Client) {
+		if c.eodStatuses == nil {
+			c.eodStatuses = map[int]bool{}
+		}
+		for _, code := range codes {
+			c.eodStatuses[code] = true
+		}
+	}
+}
+
+// WithServerFields asks the server to return only the named columns, by listing
+// them in the fields of each page request, reducing the size of the pages
+func WithServerFields(fields ...string) Option {
+	return func(c *Client) {
+		c.serverFields = slices.Clone(fields)
+	}
+}
+
+// WithServerTimeHeader reads the server's processing time for each page from the
+// response header, so that the request duration can be split into server and
+// network time.  Server-Timing totals the durations of its metrics, whilst any
+// other header holds a Go duration or a number of milliseconds
+func WithServerTimeHeader(header string) Option {
+	return func(c *Client) {
+		c.serverTimeHeader = header
+	}
+}
+
+// WithMaxServerTime sets the budget for the server's processing time of each
+// page, as given by the server time header, independent of network time.  A
+// page exceeding it is logged under ServerTimeWarn, whilst ServerTimeAbort
+// fails the pagination with a serverTimeError once the page is retrieved
+func WithMaxServerTime(budget time.Duration, policy string) Option {
+	return func(c *Client) {
+		c.maxServerTime = budget
+		c.serverTimePolicy = policy
+	}
+}
+
+// WithEnvelope unwraps each page response from the envelope before it is
+// decoded, failing the pagination with an envelopeError for a page whose
+// envelope reports a failure
+func WithEnvelope(env Envelope) Option {
+	return func(c *Client) {
+		c.envelope = &env
+	}
+}
+
+// WithFirstTokenPath sets the location, relative to the client's url, of the
+// endpoint returning the first token of a hash, in place of "first-token"
+func WithFirstTokenPath(path string) Option {
+	return func(c *Client) {
+		c.firstTokenPath = path
+	}
+}
+
+// WithTokenRedactor sets the form in which pagination tokens are shown in logs
+// and errors, which by default is HashedToken.  RawToken shows them unchanged
+func WithTokenRedactor(redactor TokenRedactor) Option {
+	return func(c *Client) {
+		c.redactToken = redactor
+	}
+}
+
+// WithClock sets the clock measuring the durations of page requests and timing
+// retry backoff and rate limiting, which by default is the real clock
+func WithClock(clock Clock) Option {
+	return func(c *Client) {
+		c.clock = clock
+	}
+}
+
+// NewClient returns a Client for the dataproxy at the specified url
+func NewClient(url string, opts ...Option) *Client {
+	c := &Client{
+		url:               url,
+		httpClient:        http.DefaultClient,
+		nextTokenPath:     strings.Split(defaultNextTokenPath, "."),
+		decodeErrorPolicy: DecodeErrorAbort,
+		pagination:        TokenPagination{},
+		totalPolicy:       TotalMismatchWarn,
+		tokenReuse:        TokenReuseWarn,
+		duplicatePages:    DuplicatePagesOff,
+		maxRedirects:      defaultMaxRedirects,
+		redirectAuth:      RedirectAuthStrip,
+		redactToken:       HashedToken,
+		firstTokenPath:    defaultFirstTokenPath,
+		captureCompress:   CaptureCompressNone,
+		decompressors:     map[string]Decompressor{},
+		clock:             realClock{},
+	}
+	for _, d := range defaultDecompressors {
+		c.decompressors[d.encoding] = d.open
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.acceptEncoding = acceptEncoding(c.decompressors)
+	if c.throttle != nil {
+		c.throttle.clock = c.clock
+	}
+
+	// Redirects and pins are applied to a copy, leaving the supplied http.Client unchanged
+	hc := *c.httpClient
+	if hc.CheckRedirect == nil {
+		hc.CheckRedirect = c.checkRedirect
+	}
+	if len(c.pins) > 0 {
+		hc.Transport = pinTransport(hc.Transport, c.pins)
+	}
+	if c.maxConns > 0 {
+		hc.Transport = maxConnsTransport(hc.Transport, c.maxConns)
+	}
+	c.httpClient = &hc
+	return c
+}
+
+// maxConnsTransport returns a copy of rt limited to n connections per host, with
+// up to n of them kept idle for reuse.  Transports other than *http.Transport
+// are returned unchanged
+func maxConnsTransport(rt http.RoundTripper, n int) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+	t = t.Clone()
+	t.MaxConnsPerHost = n
+	t.MaxIdleConnsPerHost = max(t.MaxIdleConnsPerHost, n)
+	return t
+}
+
+// decodePage generically decodes the page response body, with numbers as
+// json.Number if useNumber is set, after handling any invalid UTF-8
+func (c *Client) decodePage(body []byte) (map[string]interface{}, error) {
+	body, err := sanitizeUTF8(c.invalidUTF8, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if !c.useNumber {
+		err = json.Unmarshal(body, &result)
+		return result, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("invalid character after top-level value")
+	}
+	return result, nil
+}
+
+// jsonInt returns the integer value of a generically decoded JSON number
+func jsonInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err == nil
+	}
+	return 0, false
+}
+
+// lookupPath walks the decoded JSON object along path, returning the value found
+func lookupPath(obj map[string]interface{}, path []string) (interface{}, error) {
+	var v interface{} = obj
+	for i, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%v is not an object", strings.Join(path[:i], "."))
+		}
+		if v, ok = m[key]; !ok {
+			return nil, fmt.Errorf("%v not found", strings.Join(path[:i+1], "."))
+		}
+	}
+	return v, nil
+}
+
+// nextToken extracts the next page token from the decoded page response, with a
+// null value treated as "" (no further pages)
+func (c *Client) nextToken(result map[string]interface{}) (string, error) {
+	v, err := lookupPath(result, c.nextTokenPath)
+	if err != nil {
+		return "", fmt.Errorf("next token: %v", err)
+	}
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("next token: %v is not a string", strings.Join(c.nextTokenPath, "."))
+	}
+}
+
+// pageURL returns the url of the page endpoint, including any query parameters
+func (c *Client) pageURL() (string, error) {
+	return c.endpointURL("page")
+}
+
+// endpointURL returns the url of the endpoint at path relative to the client's
+// url, including any query parameters
+func (c *Client) endpointURL(path string) (string, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return "", err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	if len(c.queryParams) > 0 {
+		q := u.Query()
+		for key, values := range c.queryParams {
+			for _, v := range values {
+				q.Add(key, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// idempotencyKey returns the key identifying requests for the (hash, token) page
+func idempotencyKey(hash, token string) string {
+	sum := sha256.Sum256([]byte(hash + "\x00" + token))
+	return hex.EncodeToString(sum[:])
+}
+
+// assertionError reports the failure of a first page assertion
+type assertionError struct {
+	token string
+	err   error
+}
+
+func (e *assertionError) Error() string {
+	return fmt.Sprintf("first page assertion failed for token %v: %v", e.token, e.err)
+}
+
+func (e *assertionError) Unwrap() error {
+	return e.err
+}
+
+// assertFirstPage applies the first page assertions to the page body, whose
+// decoded form is result
+func (c *Client) assertFirstPage(token string, body []byte, result map[string]interface{}) error {
+	var rs ResultSet
+	if c.objectRecords {
+		// Records are positioned by the header, so the page is decoded without them
+		var page struct {
+			Meta Meta `json:"meta"`
+			Data struct {
+				Header Header `json:"header"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return newDecodeError(c.tokenRef(token), body, err)
+		}
+		rawRecords, err := decodeRecords(result)
+		if err != nil {
+			return newDecodeError(c.tokenRef(token), body, err)
+		}
+		records, err := objectStringRecords(page.Data.Header.Columns, rawRecords)
+		if err != nil {
+			return newDecodeError(c.tokenRef(token), body, err)
+		}
+		rs.Meta, rs.Data.Header, rs.Data.Records = page.Meta, page.Data.Header, records
+	} else if err := json.Unmarshal(body, &rs); err != nil {
+		return newDecodeError(c.tokenRef(token), body, err)
+	}
+	for _, assertion := range c.assertions {
+		if err := assertion(rs); err != nil {
+			return &assertionError{token: c.tokenRef(token), err: err}
+		}
+	}
+	return nil
+}
+
+// slowPageError reports a page abandoned by the slow page watchdog
+type slowPageError struct {
+	token string
+	limit time.Duration
+	mean  time.Duration
+	hint  time.Duration
+}
+
+func (e *slowPageError) Error() string {
+	if e.hint > 0 {
+		return fmt.Sprintf("page for token %v exceeded %v, the slow page limit from the server's hinted duration of %v", e.token, e.limit, e.hint)
+	}
+	return fmt.Sprintf("page for token %v exceeded %v, the slow page limit from a mean request duration of %v", e.token, e.limit, e.mean)
+}
+
+// metaTotal returns the meta.total hint of the number of records in the result
+// set from the decoded page response, or -1 if it has none
+func metaTotal(result map[string]interface{}) int {
+	v, err := lookupPath(result, []string{"meta", "total"})
+	if err != nil {
+		return -1
+	}
+	total, ok := jsonInt(v)
+	if !ok || total < 0 {
+		return -1
+	}
+	return total
+}
+
+// metaShards returns the start tokens of the independent shards listed by the
+// meta.shards of the result, or nil if it lists none
+func metaShards(result map[string]interface{}) []string {
+	v, err := lookupPath(result, []string{"meta", "shards"})
+	if err != nil {
+		return nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	shards := []string{}
+	for _, item := range list {
+		if token, ok := item.(string); ok && len(token) > 0 {
+			shards = append(shards, token)
+		}
+	}
+	if len(shards) == 0 {
+		return nil
+	}
+	return shards
+}
+
+// checkTotal compares the number of records retrieved with the server's total,
+// warning of a mismatch or failing with a totalMismatchError according to the policy
+func (c *Client) checkTotal(hash, firstToken string, total, retrieved int) error {
+	if total == retrieved {
+		return nil
+	}
+	err := &totalMismatchError{total: total, retrieved: retrieved}
+	if c.totalPolicy == TotalMismatchError {
+		return err
+	}
+	log.Printf("Warning: hash: %v, first token: %v: %v", hash, c.tokenRef(firstToken), err)
+	return nil
+}
+
+// totalMismatchError reports a pagination retrieving a different number of
+// records to the total given by the server
+type totalMismatchError struct {
+	total     int
+	retrieved int
+}
+
+func (e *totalMismatchError) Error() string {
+	return fmt.Sprintf("server total of %v records, retrieved %v", e.total, e.retrieved)
+}
+
+// pageError identifies the page of the pagination, numbered from 1, at which
+// the pagination failed with err
+type pageError struct {
+	page  int
+	token string
+	err   error
+}
+
+func (e *pageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pageError) Unwrap() error {
+	return e.err
+}
+
+// endOfDataError reports a response whose status signals the end of the pagination
+type endOfDataError struct {
+	status int
+}
+
+func (e *endOfDataError) Error() string {
+	return fmt.Sprintf("end of data signalled by status %v", e.status)
+}
+
+// deadlineError reports a pagination ended by its overall deadline
+type deadlineError struct {
+	deadline time.Duration
+}
+
+func (e *deadlineError) Error() string {
+	return fmt.Sprintf("deadline of %v exceeded", e.deadline)
+}
+
+// decodeError describes a page whose response could not be decoded.  If the
+// next token could still be read from the response, recovered is true and
+// pagination can continue from nextToken
+type decodeError struct {
+	token     string
+	nextToken string
+	recovered bool
+	snippet   string
+	status    int
+	err       error
+}
+
+func (e *decodeError) Error() string {
+	return fmt.Sprintf("unable to decode page for token %v: %v", e.token, e.err)
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+// newDecodeError returns a decodeError for the page, retaining the start of its body
+func newDecodeError(token string, body []byte, err error) *decodeError {
+	if len(body) > snippetLength {
+		body = body[:snippetLength]
+	}
+	return &decodeError{token: token, snippet: string(body), err: err}
+}
+
+// pageDecodeError returns a decodeError for the page response, with its status
+func (c *Client) pageDecodeError(token string, resp *http.Response, body []byte, err error) *decodeError {
+	de := newDecodeError(c.tokenRef(token), body, err)
+	de.status = resp.StatusCode
+	return de
+}
+
+// decodeRecords returns the records in the decoded page response
+func decodeRecords(result map[string]interface{}) ([]interface{}, error) {
+	v, err := lookupPath(result, []string{"data", "records"})
+	if err != nil {
+		return nil, fmt.Errorf("records: %v", err)
+	}
+	records, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("records: data.records is not an array")
+	}
+	return records, nil
+}
+
+// decodeColumns returns the columns of the decoded page header
+func decodeColumns(result map[string]interface{}) ([]Column, error) {
+	v, err := lookupPath(result, []string{"data", "header", "columns"})
+	if err != nil {
+		return nil, fmt.Errorf("header: %v", err)
+	}
+	cols, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("header: data.header.columns is not an array")
+	}
+
+	columns := make([]Column, 0, len(cols))
+	for i, col := range cols {
+		m, ok := col.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("header: column %v is not an object", i)
+		}
+		name, _ := m["name"].(string)
+		typ, _ := m["type"].(string)
+		pos, ok := jsonInt(m["position"])
+		if len(name) == 0 || !ok {
+			return nil, fmt.Errorf("header: column %v has no name or position", i)
+		}
+		column := Column{Name: name, Type: typ, Position: pos}
+		if nullable, ok := m["nullable"].(bool); ok {
+			column.Nullable = &nullable
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// columnPosition returns the position of the named column
+func columnPosition(columns []Column, name string) (int, error) {
+	for _, col := range columns {
+		if col.Name == name {
+			return col.Position, nil
+		}
+	}
+	return 0, fmt.Errorf("header: column %v not found", name)
+}
+
+// stringRecords converts the decoded records to their values, with numbers,
+// booleans and nulls coerced to strings
+func stringRecords(records []interface{}) ([][]string, error) {
+	values := make([][]string, len(records))
+	for i, record := range records {
+		cells, ok := record.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record %v is not an array", i)
+		}
+		values[i] = make([]string, len(cells))
+		for j, cell := range cells {
+			v, err := cellString(cell)
+			if err != nil {
+				return nil, fmt.Errorf("record %v: value %v: %v", i, j, err)
+			}
+			values[i][j] = v
+		}
+	}
+	return values, nil
+}
+
+// objectStringRecords converts the decoded records, each an object keyed by column
+// name, to their values ordered by column position.  Missing keys are taken as null
+func objectStringRecords(columns []Column, records []interface{}) ([][]string, error) {
+	width := 0
+	for _, col := range columns {
+		if col.Position < 0 {
+			return nil, fmt.Errorf("header: column %v has a negative position", col.Name)
+		}
+		if col.Position >= width {
+			width = col.Position + 1
+		}
+	}
+
+	values := make([][]string, len(records))
+	for i, record := range records {
+		obj, ok := record.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record %v is not an object", i)
+		}
+		values[i] = make([]string, width)
+		for _, col := range columns {
+			v, err := cellString(obj[col.Name])
+			if err != nil {
+				return nil, fmt.Errorf("record %v: column %v: %v", i, col.Name, err)
+			}
+			values[i][col.Position] = v
+		}
+	}
+	return values, nil
+}
+
+// excludeBefore returns the records whose timestamp in the since filter column
+// is not before its cutoff
+func (c *Client) excludeBefore(columns []Column, records [][]string) ([][]string, error) {
+	pos, err := columnPosition(columns, c.since.column)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make([][]string, 0, len(records))
+	for i, record := range records {
+		if pos >= len(record) {
+			return nil, fmt.Errorf("record %v: no value for column %v", i, c.since.column)
+		}
+		t, err := parseTimestamp(record[pos])
+		if err != nil {
+			return nil, fmt.Errorf("record %v: %v", i, err)
+		}
+		if !t.Before(c.since.cutoff) {
+			kept = append(kept, record)
+		}
+	}
+	return kept, nil
+}
+
+// pageRecords returns the columns of the decoded page and the values of its
+// records, with any coercions applied, excluding any removed by the since filter
+// and with any expressions applied
+func (c *Client) pageRecords(result map[string]interface{}, rawRecords []interface{}) ([]Column, [][]string, error) {
+	columns, err := decodeColumns(result)
+	if err != nil {
+		return nil, nil, err
+	}
+	var records [][]string
+	if c.objectRecords {
+		records, err = objectStringRecords(columns, rawRecords)
+	} else {
+		records, err = stringRecords(rawRecords)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.transformRecords(columns, records)
+}
+
+// transformRecords applies the trimming, coercions, since filter and expressions
+// to the records, returning the resulting columns and records
+func (c *Client) transformRecords(columns []Column, records [][]string) ([]Column, [][]string, error) {
+	var err error
+	if c.trimSpace {
+		trimRecords(records)
+	}
+	if len(c.coercions) > 0 {
+		if columns, records, err = coerceRecords(c.coercions, columns, records); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.validateTypes {
+		if err := validateRecords(columns, records); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.nullViolation != NullViolationAllow {
+		if err := checkNulls(columns, records); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.since != nil {
+		if records, err = c.excludeBefore(columns, records); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, e := range c.exprs {
+		if columns, records, err = e.apply(columns, records); err != nil {
+			return nil, nil, err
+		}
+	}
+	return columns, records, nil
+}
+
+// postPage sends the request for the (hash, token) page, conditional on the
+// page having changed if etag is not "", recording the status of each response
+// in tally.  Failed requests are retried according to the retry policy of their
+// class, with the outcome of the last attempt returned
+func (c *Client) postPage(ctx context.Context, hash, token string, jsonData []byte, etag string, tally StatusTally) (*http.Response, error) {
+	pageURL, err := c.pageURL()
+	if err != nil {
+		return nil, err
+	}
+
+	retries := map[string]int{}
+	reauthenticated := false
+	for {
+		bearer := ""
+		if c.auth != nil {
+			if bearer, err = c.auth.bearerToken(ctx); err != nil {
+				err = fmt.Errorf("authentication: %w", err)
+				class := classifyFailure(nil, err)
+				if !c.canRetry(class, retries) {
+					return nil, err
+				}
+				if err := c.awaitRetry(ctx, token, class, retries, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		retrying := len(retries) > 0
+		if retrying && c.retrySem != nil {
+			select {
+			case c.retrySem <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if c.throttle != nil {
+			if err := c.throttle.wait(ctx); err != nil {
+				if retrying && c.retrySem != nil {
+					<-c.retrySem
+				}
+				return nil, err
+			}
+		}
+		resp, err := c.sendPage(ctx, pageURL, hash, token, jsonData, etag, bearer)
+		if retrying && c.retrySem != nil {
+			<-c.retrySem
+		}
+		if err == nil {
+			tally.record(resp.StatusCode)
+			if c.throttle != nil {
+				c.throttle.record(resp.StatusCode == http.StatusTooManyRequests)
+			}
+		}
+
+		// A rejected token may have expired early, so is replaced and the request retried once
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && c.auth != nil && !reauthenticated {
+			reauthenticated = true
+			resp.Body.Close()
+			c.auth.invalidate(bearer)
+			continue
+		}
+
+		class := classifyFailure(resp, err)
+		if !c.canRetry(class, retries) {
+			return resp, err
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("status %v", resp.StatusCode)
+		}
+		if err := c.awaitRetry(ctx, token, class, retries, err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// canRetry returns whether the retry policy of class allows another attempt,
+// given the retries of each class already made
+func (c *Client) canRetry(class string, retries map[string]int) bool {
+	policy, ok := c.retryPolicies[class]
+	return ok && retries[class] < policy.Attempts
+}
+
+// awaitRetry counts the retry of the token page after the failure err of class,
+// and waits for the backoff of its policy before the retry is attempted
+func (c *Client) awaitRetry(ctx context.Context, token, class string, retries map[string]int, err error) error {
+	policy := c.retryPolicies[class]
+	retries[class]++
+	log.Printf("Retrying page: token: %v, class: %v, attempt: %v of %v, error: %v", c.tokenRef(token), class, retries[class], policy.Attempts, err)
+	return sleepCtx(ctx, c.clock, retryDelay(policy, retries[class]))
+}
+
+// sendPage makes a single request for the (hash, token) page to pageURL, with
+// the bearer token if it is not "".  Each attempt sends a new request, and its
+// GetBody re-creates the body from jsonData, so that redirects of the attempt
+// also carry the whole body
+func (c *Client) sendPage(ctx context.Context, pageURL, hash, token string, jsonData []byte, etag, bearer string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pageURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(jsonData)), nil
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", c.acceptEncoding)
+	if c.idempotencyKeys {
+		req.Header.Set("Idempotency-Key", idempotencyKey(hash, token))
+	}
+	if len(etag) > 0 {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if len(bearer) > 0 {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// requestPage requests the (hash, token) page, conditionally if the page is in
+// the ETag cache, returning the response and, if the response is a 304 Not
+// Modified, the cached body.  A response with an end of data status returns
+// an endOfDataError
+func (c *Client) requestPage(ctx context.Context, hash, token string, jsonData []byte, tally StatusTally) (*http.Response, []byte, error) {
+	etag := ""
+	if c.cache != nil {
+		etag = c.cache.etag(hash, token)
+	}
+
+	resp, err := c.postPage(ctx, hash, token, jsonData, etag, tally)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.eodStatuses[resp.StatusCode] {
+		resp.Body.Close()
+		return nil, nil, &endOfDataError{status: resp.StatusCode}
+	}
+
+	if resp.StatusCode == http.StatusNotModified && c.cache != nil {
+		body, err := c.cache.load(hash, token)
+		if err == nil {
+			return resp, body, nil
+		}
+		// Cached copy lost since its ETag was read, so retrieve the page unconditionally
+		c.cache.remove(hash, token)
+		resp.Body.Close()
+		if resp, err = c.postPage(ctx, hash, token, jsonData, "", tally); err != nil {
+			return nil, nil, err
+		}
+	}
+	return resp, nil, nil
+}
+
+// pageResult is the outcome of retrieving a page with consumePage
+type pageResult struct {
+	// nextToken is the token of the next page, with "" signifying no further pages
+	nextToken string
+	// records is the number of records, excluding those filtered by the since
+	// filter or an expression, which are counted by filtered
+	records  int
+	filtered int
+	// bytes is the size of the page received, or of its body if served from the cache
+	bytes int64
+	// total is the number of records in the result set given by the page's
+	// meta.total hint, or -1 if it has none
+	total int
+	// shards are the start tokens of any independent shards listed in meta.shards
+	shards []string
+	// hint is the duration the response headers suggest the next page will
+	// take, if page hints are used, otherwise 0
+	hint time.Duration
+	// requestDuration and unmarshalDuration are the time taken to retrieve and
+	// to decode the page, and serverDuration is the processing time given by
+	// the server time header, if set
+	requestDuration   time.Duration
+	serverDuration    time.Duration
+	unmarshalDuration time.Duration
+	// matched is whether a record of the page matches the stop predicate
+	matched bool
+}
+
+// consumePage processes the specified (hash, token) page details, retrieving the page
+// and unmarshalling the return JSON results into a ResultSet, returning its pageResult.
+// The status of each response received is recorded in tally, and the first page
+// assertions are applied if this is the first page.  The checksum of the page's
+// records is added to digests, if not nil, to detect duplicate pages, and
+// compared by coalesce, if not nil, with the previous page's to coalesce them.
+// The records of a page to discard are neither counted nor output.  A response
+// with an end of data status returns an endOfDataError.  A page in the page cache
+// is decoded from the cache without a request, and is otherwise added to it
+func (c *Client) consumePage(ctx context.Context, hash, token string, first bool, tally StatusTally, digests pageDigests, coalesce *pageCoalescer, discard bool) (pageResult, error) {
+	jsonData, err := c.pagination.requestBody(hash, token, c.serverFields)
+	if err != nil {
+		return pageResult{}, err
+	}
+
+	var key pageCacheKey
+	var cached *pageCacheEntry
+	if c.pageCache != nil {
+		key = pageCacheKey{url: c.url, hash: hash, token: token, request: string(jsonData)}
+		cached = c.pageCache.get(key)
+	}
+
+	ctx, idle := newIdleWatchdog(ctx, c.maxIdle)
+	defer idle.stop()
+
+	t1 := c.clock.Now()
+	t2 := t1
+
+	var resp *http.Response
+	var body []byte
+	var pageBytes int64
+	var result map[string]interface{}
+	if cached != nil {
+		resp, body, pageBytes, result = cached.response(), cached.body, cached.pageBytes, cached.result
+	} else {
+		var raw []byte
+		resp, raw, err = c.requestPage(ctx, hash, token, jsonData, tally)
+		if err != nil {
+			return pageResult{}, err
+		}
+		defer resp.Body.Close()
+
+		t2 = c.clock.Now()
+
+		if raw == nil {
+			resp.Body = idle.watch(resp.Body)
+			if raw, pageBytes, err = c.readBody(resp); err != nil {
+				return pageResult{}, idle.cause(err)
+			}
+
+			if c.cache != nil {
+				if etag := resp.Header.Get("ETag"); len(etag) > 0 {
+					if err := c.cache.store(hash, token, etag, raw); err != nil {
+						log.Printf("Unable to cache page for token %v: %v", c.tokenRef(token), err)
+					}
+				} else {
+					c.cache.remove(hash, token)
+				}
+			}
+		}
+
+		if len(c.captureDir) > 0 {
+			if err := capturePage(c.captureDir, token, raw, c.captureOverwrite, c.captureCompress, c.tokenRef); err != nil {
+				return pageResult{}, err
+			}
+		}
+
+		if pageBytes == 0 {
+			pageBytes = int64(len(raw))
+		}
+
+		if body, err = c.unwrapEnvelope(token, raw); err != nil {
+			var ee *envelopeError
+			if errors.As(err, &ee) {
+				return pageResult{}, err
+			}
+			return pageResult{}, c.pageDecodeError(token, resp, raw, err)
+		}
+
+		// Normally would decode to a ResultSet object to have direct access to all
+		// the decoded data.  Since only want nextToken and recordCount, generic
+		// decoding is faster (~75% of the full decoding time)
+		if result, err = c.decodePage(body); err != nil {
+			return pageResult{}, c.pageDecodeError(token, resp, body, err)
+		}
+	}
+
+	rawRecords, recordsErr := decodeRecords(result)
+	nextToken, err := c.pagination.nextCursor(c, result, token, rawRecords)
+	if err != nil {
+		return pageResult{}, c.pageDecodeError(token, resp, body, err)
+	}
+	if first && len(c.assertions) > 0 {
+		if err := c.assertFirstPage(token, body, result); err != nil {
+			return pageResult{}, err
+		}
+	}
+
+	if discard && recordsErr == nil {
+		rawRecords = rawRecords[:0]
+	}
+
+	// The records of a page identical to the page before it are dropped
+	if recordsErr == nil && coalesce != nil {
+		identical, err := coalesce.identical(rawRecords)
+		if err != nil {
+			return pageResult{}, err
+		}
+		if identical {
+			log.Printf("Coalescing page for token %v, identical to the previous page", c.tokenRef(token))
+			c.coalesced.Add(1)
+			rawRecords = rawRecords[:0]
+		}
+	}
+
+	var columns []Column
+	var records [][]string
+	err = recordsErr
+	if err == nil {
+		if err := digests.checkDuplicate(c.duplicatePages, c.tokenRef(token), rawRecords); err != nil {
+			return pageResult{}, err
+		}
+	}
+	if err == nil && (c.since != nil || c.sink != nil || c.stopPredicate != nil || len(c.coercions) > 0 || len(c.exprs) > 0 || c.validateTypes) {
+		columns, records, err = c.pageRecords(result, rawRecords)
+	}
+	if err != nil {
+		de := c.pageDecodeError(token, resp, body, err)
+		de.nextToken, de.recovered = nextToken, true
+		return pageResult{}, de
+	}
+	recordCount := len(rawRecords)
+	filteredCount := 0
+	if records != nil {
+		filteredCount = recordCount - len(records)
+		recordCount = len(records)
+	}
+
+	if c.pageCache != nil && cached == nil {
+		c.pageCache.put(&pageCacheEntry{key: key, status: resp.StatusCode, header: resp.Header.Clone(), body: body, pageBytes: pageBytes, result: result})
+	}
+
+	t3 := c.clock.Now()
+
+	if c.sink != nil {
+		if err := c.sink.WriteRecords(columns, records); err != nil {
+			return pageResult{}, fmt.Errorf("output: %w", err)
+		}
+	}
+
+	hint := time.Duration(0)
+	if c.pageHints {
+		hint = nextPageHint(resp.Header)
+	}
+
+	matched := false
+	if c.stopPredicate != nil {
+		for _, record := range records {
+			if matched = c.stopPredicate(columns, record); matched {
+				break
+			}
+		}
+	}
+
+	return pageResult{
+		nextToken:         nextToken,
+		records:           recordCount,
+		filtered:          filteredCount,
+		bytes:             pageBytes,
+		total:             metaTotal(result),
+		shards:            metaShards(result),
+		hint:              hint,
+		requestDuration:   t2.Sub(t1),
+		serverDuration:    c.serverDuration(resp.Header),
+		unmarshalDuration: t3.Sub(t2),
+		matched:           matched,
+	}, nil
+}
+
+// consumeAllPages retrieves all the pages for the given (hash, firstToken), returning the
+// RunResult of the pagination.  If the first page lists independent shards, these are paginated
+// concurrently in place of the first page's next token, and their results included
+func (c *Client) consumeAllPages(ctx context.Context, hash, firstToken string) (RunResult, error) {
+	if c.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, c.deadline, &deadlineError{deadline: c.deadline})
+		defer cancel()
+	}
+
+	started := c.clock.Now()
+	var r RunResult
+	var err error
+	if c.stream {
+		r, err = c.consumeStream(ctx, hash, firstToken)
+	} else {
+		runCtx := ctx
+		if c.runFor > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(ctx, c.runFor)
+			defer cancel()
+		}
+		r, err = c.paginate(ctx, runCtx, hash, firstToken, true)
+	}
+	if err != nil {
+		return RunResult{}, err
+	}
+	r.Elapsed = c.clock.Now().Sub(started)
+	return r, nil
+}
+
+// paginate retrieves the pages from firstToken, returning as consumeAllPages.  runCtx
+// ends when the run for time budget expires, which stops pagination without error.
+// The root pagination is that of the job, which applies the first page assertions,
+// fans out to any shards its first page lists and checks the server's total
+func (c *Client) paginate(ctx, runCtx context.Context, hash, firstToken string, root bool) (RunResult, error) {
+	c, sizer := c.withPageSizer()
+
+	// timeLimited is true when the run for budget has expired, rather than ctx ending
+	timeLimited := func() bool {
+		return ctx.Err() == nil && runCtx.Err() != nil
+	}
+
+	// deadlineExceeded returns the deadlineError if the deadline has ended ctx, otherwise nil
+	deadlineExceeded := func() error {
+		var de *deadlineError
+		if cause := context.Cause(ctx); ctx.Err() != nil && errors.As(cause, &de) {
+			return cause
+		}
+		return nil
+	}
+
+	tally := StatusTally{}
+	pageCount := 0
+	skippedPages := 0
+	filteredRecords := 0
+	recordCounts := []int{}
+	pageSizes := []int64{}
+	totalDurationRequest := time.Duration(0)
+	totalServerDuration := time.Duration(0)
+	totalUnmarshalDuration := time.Duration(0)
+	serverTotal := -1
+	firstCounts := []int{}
+	emptyRetries := 0
+	consecutiveErrors := 0
+	var shards []string
+	hint := time.Duration(0)
+	requested := map[string]bool{}
+	var digests pageDigests
+	if c.duplicatePages != DuplicatePagesOff {
+		digests = pageDigests{}
+	}
+	var coalesce *pageCoalescer
+	if c.coalesced != nil {
+		coalesce = &pageCoalescer{}
+	}
+	stopped := false
+	matched := false
+	nextToken := firstToken
+	for len(nextToken) > 0 {
+		if c.pause != nil && pageCount+skippedPages > 0 {
+			if err := c.pause.wait(runCtx); err != nil && !timeLimited() {
+				if de := deadlineExceeded(); de != nil {
+					err = fmt.Errorf("%w, waiting to retrieve page for token %v after %v pages", de, c.tokenRef(nextToken), pageCount)
+				}
+				return RunResult{}, err
+			}
+		}
+		if timeLimited() {
+			break
+		}
+		if c.stop != nil && c.stop.Stopped() {
+			stopped = true
+			break
+		}
+
+		// Once armed, the watchdog abandons a page taking too long relative to those before it
+		pageCtx, cancel := runCtx, context.CancelFunc(func() {})
+		var slow *slowPageError
+		if c.slowPageFactor > 0 && pageCount >= slowPageMinSamples {
+			mean := totalDurationRequest / time.Duration(pageCount)
+			slow = &slowPageError{token: c.tokenRef(nextToken), limit: time.Duration(c.slowPageFactor * float64(mean)), mean: mean}
+			if hint > mean {
+				slow.limit, slow.hint = time.Duration(c.slowPageFactor*float64(hint)), hint
+			}
+			pageCtx, cancel = context.WithTimeout(runCtx, slow.limit)
+		}
+
+		first := pageCount+skippedPages == 0
+		discard := root && pageCount < c.skipPages
+		page, err := c.consumePage(pageCtx, hash, nextToken, first && root, tally, digests, coalesce, discard)
+		slowed := slow != nil && runCtx.Err() == nil && pageCtx.Err() != nil
+		cancel()
+		hint = page.hint
+		if err != nil {
+			err = &pageError{page: pageCount + skippedPages + 1, token: c.tokenRef(nextToken), err: err}
+		}
+		if err != nil {
+			var eod *endOfDataError
+			if timeLimited() || errors.As(err, &eod) {
+				break
+			}
+			if de := deadlineExceeded(); de != nil {
+				return RunResult{}, fmt.Errorf("%w, retrieving page for token %v after %v pages", de, c.tokenRef(nextToken), pageCount)
+			}
+			if slowed {
+				return RunResult{}, slow
+			}
+
+			var de *decodeError
+			if c.decodeErrorPolicy != DecodeErrorSkip || !errors.As(err, &de) {
+				return RunResult{}, err
+			}
+			if !de.recovered {
+				return RunResult{}, fmt.Errorf("%w (next token unrecoverable)", err)
+			}
+
+			if consecutiveErrors++; c.maxConsecutiveErrors > 0 && consecutiveErrors >= c.maxConsecutiveErrors {
+				return RunResult{}, fmt.Errorf("%v consecutive pages undecodable: %w", consecutiveErrors, err)
+			}
+			log.Printf("Skipping page: token: %v, error: %v, body: %q", de.token, de.err, c.redactTokenIn(de.snippet, de.nextToken))
+			requested[nextToken] = true
+			if nextToken, err = c.checkTokenReuse(hash, nextToken, de.nextToken, requested); err != nil {
+				return RunResult{}, err
+			}
+			skippedPages++
+			continue
+		}
+
+		// An empty final page reached from an earlier page may be spurious, so is retried
+		if page.records+page.filtered == 0 && len(page.nextToken) == 0 && pageCount > 0 && !discard && emptyRetries < c.emptyRetries {
+			emptyRetries++
+			log.Printf("Retrying empty page: token: %v, attempt: %v of %v", c.tokenRef(nextToken), emptyRetries, c.emptyRetries)
+			if err := sleepCtx(runCtx, c.clock, retryDelay(RetryPolicy{Backoff: c.emptyBackoff}, emptyRetries)); err != nil && !timeLimited() {
+				if de := deadlineExceeded(); de != nil {
+					err = de
+				}
+				return RunResult{}, err
+			}
+			continue
+		}
+		emptyRetries = 0
+		if sizer != nil {
+			sizer.observe(page.requestDuration, page.records+page.filtered)
+		}
+
+		if err := c.checkServerTime(nextToken, page.serverDuration); err != nil {
+			return RunResult{}, err
+		}
+
+		if page.total >= 0 {
+			serverTotal = page.total
+		}
+		if len(firstCounts) < estimateMinPages {
+			firstCounts = append(firstCounts, page.records+page.filtered)
+		}
+		event := PageEvent{Page: pageCount + skippedPages + 1, Hash: hash, Token: nextToken, Next: page.nextToken, Records: page.records, Bytes: page.bytes}
+		if root {
+			event.EstimatedPages = estimatePages(serverTotal, firstCounts)
+		}
+		for _, hook := range c.pageHooks {
+			hook(ctx, event)
+		}
+		requested[nextToken] = true
+		if nextToken, err = c.checkTokenReuse(hash, nextToken, page.nextToken, requested); err != nil {
+			return RunResult{}, err
+		}
+		pageCount++
+		consecutiveErrors = 0
+		recordCounts = append(recordCounts, page.records)
+		pageSizes = append(pageSizes, page.bytes)
+		filteredRecords += page.filtered
+		totalDurationRequest += page.requestDuration
+		totalServerDuration += page.serverDuration
+		totalUnmarshalDuration += page.unmarshalDuration
+
+		// The page with the first record matching the stop predicate is the last
+		if page.matched {
+			matched = true
+			break
+		}
+
+		// The shards replace the remainder of the job's own pagination
+		if first && root && page.shards != nil {
+			shards = page.shards
+			break
+		}
+	}
+
+	if len(shards) > 0 {
+		results := c.paginateShards(ctx, runCtx, hash, shards)
+		var failed *JobResult
+		for i, r := range results {
+			if r.Err != nil && (failed == nil || errors.Is(failed.Err, errShardCancelled) && !errors.Is(r.Err, errShardCancelled)) {
+				failed = &results[i]
+			}
+		}
+		if failed != nil {
+			return RunResult{}, fmt.Errorf("shard %v: %w", c.tokenRef(failed.Job.Token), failed.Err)
+		}
+		merged := mergeChains(results, c.redactToken)
+		pageCount += merged.PageCount
+		recordCounts = append(recordCounts, merged.RecordCounts...)
+		pageSizes = append(pageSizes, merged.PageSizes...)
+		filteredRecords += merged.FilteredRecords
+		skippedPages += merged.SkippedPages
+		totalDurationRequest += merged.RequestDuration
+		totalServerDuration += merged.ServerDuration
+		totalUnmarshalDuration += merged.UnmarshalDuration
+		tally.add(merged.StatusCounts)
+		stopped = stopped || merged.TimeLimited
+	}
+
+	// A complete pagination is checked against the server's total, if it gave one
+	if root && serverTotal >= 0 && !timeLimited() && !stopped && !matched && c.skipPages == 0 {
+		if err := c.checkTotal(hash, firstToken, serverTotal, totalRecords(recordCounts)+filteredRecords); err != nil {
+			return RunResult{}, err
+		}
+	}
+
+	return RunResult{
+		PageCount:         pageCount,
+		RecordCounts:      recordCounts,
+		RequestDuration:   totalDurationRequest,
+		ServerDuration:    totalServerDuration,
+		UnmarshalDuration: totalUnmarshalDuration,
+		SkippedPages:      skippedPages,
+		FilteredRecords:   filteredRecords,
+		TimeLimited:       timeLimited() || stopped,
+		StatusCounts:      tally,
+		PageSizes:         pageSizes,
+	}, nil
+}
+
+// errShardCancelled is the error of a shard cancelled, or never started, because another shard failed
+var errShardCancelled = errors.New("cancelled after another shard failed")
+
+// paginateShards paginates each of the shards of the hash, with at most
+// shardConcurrency in progress at any time, returning their results in order.
+// The first shard to fail cancels those still in progress, which, with any yet
+// to start, fail with errShardCancelled
+func (c *Client) paginateShards(ctx, runCtx context.Context, hash string, shards []string) []JobResult {
+	concurrency := c.shardConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Both are cancelled, so that a cancelled shard is not mistaken for time limited
+	shardCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	shardRunCtx, cancelRun := context.WithCancel(runCtx)
+	defer cancelRun()
+
+	var mu sync.Mutex
+	failed := -1
+
+	results := make([]JobResult, len(shards))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		results[i] = JobResult{Job: Job{Hash: hash, Token: shard}, Err: errShardCancelled}
+		sem <- struct{}{}
+		if shardCtx.Err() != nil {
+			<-sem
+			continue
+		}
+		wg.Add(1)
+		go func(i int, shard string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			r := JobResult{Job: Job{Hash: hash, Token: shard}}
+			r.RunResult, r.Err = c.paginate(shardCtx, shardRunCtx, hash, shard, false)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case r.Err == nil:
+			case failed < 0 && ctx.Err() == nil:
+				failed = i
+				cancel()
+				cancelRun()
+			case failed >= 0:
+				r.Err = errShardCancelled
+			}
+			results[i] = r
+		}(i, shard)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,752 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gford1000-go/dataproxy/client/dataproxytest"
+	"go.uber.org/goleak"
+)
+
+func TestNextTokenPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+		want string
+		err  string
+	}{
+		{name: "default", path: defaultNextTokenPath, body: `{"meta":{"next":"t2"}}`, want: "t2"},
+		{name: "nested", path: "meta.pagination.next", body: `{"meta":{"pagination":{"next":"t2"}}}`, want: "t2"},
+		{name: "nested null", path: "meta.pagination.next", body: `{"meta":{"pagination":{"next":null}}}`, want: ""},
+		{name: "nested empty", path: "meta.pagination.next", body: `{"meta":{"pagination":{"next":""}}}`, want: ""},
+		{name: "missing", path: "meta.pagination.next", body: `{"meta":{"next":"t2"}}`, err: "meta.pagination not found"},
+		{name: "not an object", path: "meta.pagination.next", body: `{"meta":{"pagination":"t2"}}`, err: "meta.pagination is not an object"},
+		{name: "not a string", path: "meta.pagination.next", body: `{"meta":{"pagination":{"next":2}}}`, err: "meta.pagination.next is not a string"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var result map[string]interface{}
+			if err := json.Unmarshal([]byte(test.body), &result); err != nil {
+				t.Fatal(err)
+			}
+			got, err := NewClient("http://localhost", WithNextTokenPath(test.path)).nextToken(result)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Fatalf("got %q, %v, want %q", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestNestedNextTokenPagination(t *testing.T) {
+	page := func(next string, records string) []byte {
+		return []byte(`{"meta":{"pagination":{"next":` + next + `}},"data":{"header":{"columns":[{"name":"id","type":"string","position":0}]},"records":` + records + `}}`)
+	}
+	s := newPageServer(t, map[string][]byte{
+		"t1": page(`"t2"`, `[["1"],["2"]]`),
+		"t2": page(`"t3"`, `[["3"]]`),
+		"t3": page(`null`, `[]`),
+	})
+
+	r, err := NewClient(s.URL, WithNextTokenPath("meta.pagination.next")).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.PageCount != 3 || !reflect.DeepEqual(r.RecordCounts, []int{2, 1, 0}) {
+		t.Errorf("got %v pages of %v records, want 3 of [2 1 0]", r.PageCount, r.RecordCounts)
+	}
+	if got := s.tokens(); !reflect.DeepEqual(got, []string{"t1", "t2", "t3"}) {
+		t.Errorf("requested tokens %v", got)
+	}
+}
+
+func TestDecodeErrorPolicy(t *testing.T) {
+	columns := testColumns("id")
+	pages := chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{{{"1"}}, {{"2"}}, {{"3"}}})
+	// The middle page's records are malformed, but its next token is readable
+	pages["t2"] = []byte(`{"meta":{"next":"t3"},"data":{"header":{"columns":[{"name":"id","type":"string","position":0}]},"records":"broken"}}`)
+	s := newPageServer(t, pages)
+
+	t.Run("abort", func(t *testing.T) {
+		_, err := NewClient(s.URL).consumeAllPages(context.Background(), "h", "t1")
+		var de *decodeError
+		var pe *pageError
+		if !errors.As(err, &de) || !errors.As(err, &pe) || pe.page != 2 {
+			t.Fatalf("got %v, want a decodeError at page 2", err)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		r, err := NewClient(s.URL, WithDecodeErrorPolicy(DecodeErrorSkip)).consumeAllPages(context.Background(), "h", "t1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.PageCount != 2 || r.SkippedPages != 1 || r.TotalRecords() != 2 {
+			t.Errorf("got %v pages, %v skipped, %v records, want 2, 1 and 2", r.PageCount, r.SkippedPages, r.TotalRecords())
+		}
+	})
+
+	t.Run("skip unrecoverable", func(t *testing.T) {
+		s := newPageServer(t, map[string][]byte{
+			"t1": testPage("t2", columns, []string{"1"}),
+			"t2": []byte(`{"meta":`),
+		})
+		_, err := NewClient(s.URL, WithDecodeErrorPolicy(DecodeErrorSkip)).consumeAllPages(context.Background(), "h", "t1")
+		if err == nil || !strings.Contains(err.Error(), "next token unrecoverable") {
+			t.Fatalf("got %v, want the next token unrecoverable", err)
+		}
+	})
+}
+
+func TestDecodeErrorSnippet(t *testing.T) {
+	body := strings.Repeat("x", 2*snippetLength)
+	de := newDecodeError("t1", []byte(body), errors.New("bad"))
+	if len(de.snippet) != snippetLength {
+		t.Errorf("snippet of %v bytes, want %v", len(de.snippet), snippetLength)
+	}
+}
+
+// brokenChain returns the pages of a chain of the tokens t1 to tn, a record each,
+// whose pages numbered broken have malformed records but a readable next token
+func brokenChain(n int, broken ...int) map[string][]byte {
+	tokens, records := []string{}, [][][]string{}
+	for i := 1; i <= n; i++ {
+		tokens = append(tokens, fmt.Sprint("t", i))
+		records = append(records, [][]string{{fmt.Sprint(i)}})
+	}
+	pages := chainPages(testColumns("id"), tokens, records)
+	for _, i := range broken {
+		next := ""
+		if i < n {
+			next = fmt.Sprint("t", i+1)
+		}
+		pages[fmt.Sprint("t", i)] = []byte(`{"meta":{"next":"` + next + `"},"data":{"header":{"columns":[{"name":"id","type":"string","position":0}]},"records":"broken"}}`)
+	}
+	return pages
+}
+
+func TestMaxConsecutiveErrors(t *testing.T) {
+	t.Run("burst", func(t *testing.T) {
+		s := newPageServer(t, brokenChain(8, 3, 4, 5))
+		_, err := NewClient(s.URL, WithDecodeErrorPolicy(DecodeErrorSkip), WithMaxConsecutiveErrors(3)).consumeAllPages(context.Background(), "h", "t1")
+		var de *decodeError
+		if !errors.As(err, &de) || !strings.HasPrefix(err.Error(), "3 consecutive pages undecodable: ") {
+			t.Fatalf("got %v, want the run aborted", err)
+		}
+		if got := s.tokens(); len(got) != 5 {
+			t.Errorf("requested %v, want no page after the third failure", got)
+		}
+	})
+
+	t.Run("scattered", func(t *testing.T) {
+		// Each success resets the count
+		s := newPageServer(t, brokenChain(8, 2, 3, 5, 6, 8))
+		r, err := NewClient(s.URL, WithDecodeErrorPolicy(DecodeErrorSkip), WithMaxConsecutiveErrors(3)).consumeAllPages(context.Background(), "h", "t1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.PageCount != 3 || r.SkippedPages != 5 || r.TotalRecords() != 3 {
+			t.Errorf("got %v pages, %v skipped, %v records, want 3, 5 and 3", r.PageCount, r.SkippedPages, r.TotalRecords())
+		}
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		s := newPageServer(t, brokenChain(8, 2, 3, 4, 5, 6, 7))
+		r, err := NewClient(s.URL, WithDecodeErrorPolicy(DecodeErrorSkip)).consumeAllPages(context.Background(), "h", "t1")
+		if err != nil || r.SkippedPages != 6 {
+			t.Errorf("got %v skipped, %v, want every failure skipped", r.SkippedPages, err)
+		}
+	})
+}
+
+func TestMaxConsecutiveErrorsFlag(t *testing.T) {
+	s := newPageServer(t, brokenChain(4, 2, 3))
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t1", "-on-decode-error", DecodeErrorSkip}
+	if stdout, stderr, code := runMain(t, append(args, "-max-consecutive-errors", "2")...); code == 0 || !strings.Contains(stdout+stderr, "2 consecutive pages undecodable") {
+		t.Errorf("exit %v, stdout %q, stderr %q, want the run aborted", code, stdout, stderr)
+	}
+	if _, stderr, code := runMain(t, append(args, "-max-consecutive-errors", "3")...); code != 0 {
+		t.Errorf("exit %v, stderr %q", code, stderr)
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-max-consecutive-errors", "2"); code == 0 {
+		t.Error("-max-consecutive-errors accepted without -on-decode-error skip")
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	// The first request for each page fails, and is retried
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts[req.Token]++; attempts[req.Token] == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+
+	c := NewClient(s.URL, WithIdempotencyKeys(true), WithRetryPolicy(RetryClassServer, RetryPolicy{Attempts: 1}))
+	if _, err := c.consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := map[string][]string{}
+	for _, r := range s.received() {
+		keys[r.Token] = append(keys[r.Token], r.header.Get("Idempotency-Key"))
+	}
+	for token, k := range keys {
+		if len(k) != 2 || len(k[0]) == 0 || k[0] != k[1] {
+			t.Errorf("token %v: keys %v, want the same key for both attempts", token, k)
+		}
+	}
+	if keys["t1"][0] == keys["t2"][0] {
+		t.Errorf("pages share the key %v", keys["t1"][0])
+	}
+	if keys["t1"][0] != idempotencyKey("h", "t1") {
+		t.Errorf("key %v, want %v", keys["t1"][0], idempotencyKey("h", "t1"))
+	}
+}
+
+func TestIdempotencyKeysDisabled(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1"}, [][][]string{{{"1"}}}))
+	if _, err := NewClient(s.URL).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if key := s.received()[0].header.Get("Idempotency-Key"); len(key) > 0 {
+		t.Errorf("sent key %v", key)
+	}
+}
+
+func TestRunFor(t *testing.T) {
+	columns := testColumns("id")
+	tokens := []string{}
+	records := [][][]string{}
+	for i := range 20 {
+		tokens = append(tokens, fmt.Sprintf("t%v", i))
+		records = append(records, [][]string{{fmt.Sprint(i)}})
+	}
+	s := newPageServer(t, chainPages(columns, tokens, records))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		time.Sleep(30 * time.Millisecond)
+		return false
+	})
+
+	r, err := NewClient(s.URL, WithRunFor(100*time.Millisecond)).consumeAllPages(context.Background(), "h", "t0")
+	if err != nil {
+		t.Fatalf("time limited run failed: %v", err)
+	}
+	if !r.TimeLimited {
+		t.Error("run not reported as time limited")
+	}
+	if r.PageCount == 0 || r.PageCount >= len(tokens) || r.TotalRecords() != r.PageCount {
+		t.Errorf("got %v pages of %v records, want some but not all of %v pages", r.PageCount, r.TotalRecords(), len(tokens))
+	}
+}
+
+func TestQueryParams(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+
+	params := url.Values{"format": {"compact"}, "q": {"a b&c=d"}}
+	c := NewClient(s.URL+"/base/?region=eu", WithQueryParams(params), WithQueryParams(url.Values{"format": {"v2"}}))
+	if _, err := c.consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := url.Values{"region": {"eu"}, "format": {"compact", "v2"}, "q": {"a b&c=d"}}
+	for _, r := range s.received() {
+		if !reflect.DeepEqual(r.query, want) || r.path != "/base/page" {
+			t.Errorf("token %v: path %v, query %v, want /base/page and %v", r.Token, r.path, r.query, want)
+		}
+	}
+}
+
+func TestDataproxytestServer(t *testing.T) {
+	columns := []dataproxytest.Column{{Name: "id", Type: "string", Position: 0}, {Name: "name", Type: "string", Position: 1}}
+	for _, test := range []struct {
+		records  int
+		pageSize int
+		pages    int
+	}{
+		{records: 10, pageSize: 3, pages: 4},
+		{records: 9, pageSize: 3, pages: 3},
+		{records: 2, pageSize: 5, pages: 1},
+		{records: 0, pageSize: 5, pages: 1},
+	} {
+		t.Run(fmt.Sprintf("%v records by %v", test.records, test.pageSize), func(t *testing.T) {
+			records := [][]string{}
+			for i := range test.records {
+				records = append(records, []string{fmt.Sprint(i), fmt.Sprintf("name %v", i)})
+			}
+			s := dataproxytest.NewServer("h", columns, records, test.pageSize)
+			defer s.Close()
+			if s.PageCount() != test.pages {
+				t.Fatalf("server has %v pages, want %v", s.PageCount(), test.pages)
+			}
+
+			sink := &memorySink{}
+			r, err := NewClient(s.URL, WithRecordSink(sink)).consumeAllPages(context.Background(), s.Hash, s.FirstToken())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.PageCount != test.pages || r.TotalRecords() != test.records {
+				t.Errorf("got %v pages of %v records, want %v of %v", r.PageCount, r.TotalRecords(), test.pages, test.records)
+			}
+			if s.Requests() != test.pages {
+				t.Errorf("server received %v requests, want %v", s.Requests(), test.pages)
+			}
+			if len(records) > 0 && !reflect.DeepEqual(sink.records, records) {
+				t.Errorf("output %v, want %v", sink.records, records)
+			}
+		})
+	}
+}
+
+func TestDataproxytestServerUnknownHash(t *testing.T) {
+	s := dataproxytest.NewServer("h", []dataproxytest.Column{{Name: "id", Type: "string"}}, [][]string{{"1"}}, 1)
+	defer s.Close()
+
+	_, err := NewClient(s.URL).consumeAllPages(context.Background(), "other", s.FirstToken())
+	var de *decodeError
+	if !errors.As(err, &de) || de.status != http.StatusNotFound {
+		t.Fatalf("got %v, want a decodeError of the 404", err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	tokens := []string{}
+	records := [][][]string{}
+	for i := range 20 {
+		tokens = append(tokens, fmt.Sprintf("t%v", i))
+		records = append(records, [][]string{{fmt.Sprint(i)}})
+	}
+	s := newPageServer(t, chainPages(testColumns("id"), tokens, records))
+	// The fourth page would take far longer than the deadline
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		delay := 20 * time.Millisecond
+		if req.Token == "t3" {
+			delay = 10 * time.Second
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+		return false
+	})
+
+	start := time.Now()
+	_, err := NewClient(s.URL, WithDeadline(150*time.Millisecond)).consumeAllPages(context.Background(), "h", "t0")
+	var de *deadlineError
+	if !errors.As(err, &de) || !strings.Contains(err.Error(), "deadline of 150ms exceeded") {
+		t.Fatalf("got %v, want a deadlineError", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ended after %v, want the request in flight cancelled promptly", elapsed)
+	}
+	if n := len(s.received()); n != 4 {
+		t.Errorf("%v requests, want 4", n)
+	}
+}
+
+func TestTotalMismatch(t *testing.T) {
+	columns := testColumns("id")
+	totalPages := func(total int) map[string][]byte {
+		page := func(next string, records ...[]string) []byte {
+			rs := ResultSet{Meta: Meta{NextToken: next, Total: &total}, Data: Data{Header: Header{Columns: columns}, Records: Records(records)}}
+			b, err := json.Marshal(rs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}
+		return map[string][]byte{"t1": page("t2", []string{"1"}, []string{"2"}), "t2": page("", []string{"3"})}
+	}
+
+	for _, test := range []struct {
+		name   string
+		total  int
+		policy string
+		err    string
+	}{
+		{name: "matching", total: 3, policy: TotalMismatchError},
+		{name: "mismatch warned", total: 4, policy: TotalMismatchWarn},
+		{name: "mismatch", total: 4, policy: TotalMismatchError, err: "server total of 4 records, retrieved 3"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := newPageServer(t, totalPages(test.total))
+			r, err := NewClient(s.URL, WithTotalMismatchPolicy(test.policy)).consumeAllPages(context.Background(), "h", "t1")
+			if len(test.err) > 0 {
+				var te *totalMismatchError
+				if !errors.As(err, &te) || err.Error() != test.err {
+					t.Errorf("got %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || r.TotalRecords() != 3 {
+				t.Errorf("got %v records, %v", r.TotalRecords(), err)
+			}
+		})
+	}
+
+	// Without a total, nothing is checked
+	s := newPageServer(t, chainPages(columns, []string{"t1"}, [][][]string{{{"1"}}}))
+	if _, err := NewClient(s.URL, WithTotalMismatchPolicy(TotalMismatchError)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Errorf("without a total: %v", err)
+	}
+}
+
+func TestMetaTotalOmitted(t *testing.T) {
+	b, err := json.Marshal(Meta{NextToken: "t2"})
+	if err != nil || strings.Contains(string(b), "total") {
+		t.Errorf("got %s, %v, want no total", b, err)
+	}
+}
+
+// shardedPages returns pages of a first page t0, whose meta lists three shards
+// of two pages each, with the records of each page identifying it
+func shardedPages(columns []Column) map[string][]byte {
+	first := ResultSet{Meta: Meta{NextToken: "unsharded", Shards: []string{"a1", "b1", "c1"}}, Data: Data{Header: Header{Columns: columns}, Records: Records{{"t0"}}}}
+	b, _ := json.Marshal(first)
+	pages := map[string][]byte{"t0": b}
+	for _, shard := range []string{"a", "b", "c"} {
+		maps.Copy(pages, chainPages(columns, []string{shard + "1", shard + "2"}, [][][]string{{{shard + "1"}}, {{shard + "2"}, {shard + "2"}}}))
+	}
+	return pages
+}
+
+func TestShards(t *testing.T) {
+	columns := testColumns("page")
+	s := newPageServer(t, shardedPages(columns))
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return false
+	})
+	sink := &memorySink{}
+
+	result, err := NewClient(s.URL, WithShardConcurrency(3), WithRecordSink(sink), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PageCount != 7 || result.TotalRecords() != 10 {
+		t.Errorf("got %v pages of %v records, want 7 of 10", result.PageCount, result.TotalRecords())
+	}
+	tokens := s.tokens()
+	slices.Sort(tokens)
+	if want := []string{"a1", "a2", "b1", "b2", "c1", "c2", "t0"}; !slices.Equal(tokens, want) {
+		t.Errorf("requested %v, want each page once, without the unsharded next token", tokens)
+	}
+	got := map[string]int{}
+	for _, record := range sink.records {
+		got[record[0]]++
+	}
+	if want := map[string]int{"t0": 1, "a1": 1, "a2": 2, "b1": 1, "b2": 2, "c1": 1, "c2": 2}; !maps.Equal(got, want) {
+		t.Errorf("wrote %v, want %v", got, want)
+	}
+	if maxInFlight < 2 || maxInFlight > 3 {
+		t.Errorf("%v pages in flight at once, want the shards paginated in parallel up to their concurrency", maxInFlight)
+	}
+}
+
+func TestShardFailure(t *testing.T) {
+	s := newPageServer(t, shardedPages(testColumns("page")))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "b2" {
+			http.Error(w, "failed", http.StatusBadRequest)
+			return true
+		}
+		return false
+	})
+	_, err := NewClient(s.URL, WithShardConcurrency(3), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t0")
+	if err == nil || !strings.HasPrefix(err.Error(), "shard b1:") {
+		t.Errorf("got %v, want the failure of shard b1", err)
+	}
+}
+
+func TestServerFields(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	if _, err := NewClient(s.URL, WithServerFields("id", "name")).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-server-fields", "id, name"); code != 0 {
+		t.Fatalf("exit %v", code)
+	}
+	if _, err := NewClient(s.URL).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	received := s.received()
+	if len(received) != 6 {
+		t.Fatalf("received %v requests", len(received))
+	}
+	for i, r := range received {
+		if i < 4 && !slices.Equal(r.Fields, []string{"id", "name"}) {
+			t.Errorf("request %v: fields %v, want id and name", i, r.Fields)
+		}
+		if i >= 4 && strings.Contains(string(r.body), "fields") {
+			t.Errorf("request %v: body %s, want fields omitted when unset", i, r.body)
+		}
+	}
+}
+
+func TestShardFailureNoLeaks(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	s := newPageServer(t, shardedPages(testColumns("page")))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		switch req.Token {
+		case "a2":
+			http.Error(w, "failed", http.StatusBadRequest)
+			return true
+		case "b2", "c2":
+			// Held until the failure of a2 cancels them
+			<-r.Context().Done()
+			return true
+		}
+		return false
+	})
+	hc := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+	_, err := NewClient(s.URL, WithHTTPClient(hc), WithShardConcurrency(3), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t0")
+	if err == nil || !strings.HasPrefix(err.Error(), "shard a1:") {
+		t.Errorf("got %v, want the failure of shard a1", err)
+	}
+	hc.CloseIdleConnections()
+	s.Close()
+}
+
+// TestConcurrentClient shares a Client, and its http.Client, between goroutines
+// paginating at once, with the state shared across paginations exercised: the
+// cached bearer token refreshed as it expires, the retry semaphore, page hooks
+// and the sink.  Run with -race to check the sharing is safe
+func TestConcurrentClient(t *testing.T) {
+	const jobs, pages = 16, 5
+	all := map[string][]byte{}
+	for j := range jobs {
+		tokens := []string{}
+		records := [][][]string{}
+		for p := range pages {
+			tokens = append(tokens, fmt.Sprintf("j%v-t%v", j, p))
+			records = append(records, [][]string{{fmt.Sprintf("%v-%v", j, p)}})
+		}
+		maps.Copy(all, chainPages(testColumns("id"), tokens, records))
+	}
+	s := newPageServer(t, all)
+	var mu sync.Mutex
+	failed := map[string]bool{}
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return true
+		}
+		// Each page fails once, to be retried
+		if !failed[req.Token] {
+			failed[req.Token] = true
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+
+	var issued atomic.Int64
+	source := func(context.Context) (string, time.Time, error) {
+		return fmt.Sprintf("bearer-%v", issued.Add(1)), time.Now().Add(5 * time.Millisecond), nil
+	}
+	var hooked atomic.Int64
+	sink := &memorySink{}
+	c := NewClient(s.URL,
+		WithHTTPClient(&http.Client{Timeout: 5 * time.Second}),
+		WithExpiringTokenSource(source),
+		WithRetryPolicy(RetryClassServer, RetryPolicy{Attempts: 2, Backoff: time.Millisecond}),
+		WithMaxConcurrentRetries(4),
+		WithPageHook(func(context.Context, PageEvent) { hooked.Add(1) }),
+		WithRecordSink(sink))
+
+	var wg sync.WaitGroup
+	results := make([]RunResult, jobs)
+	errs := make([]error, jobs)
+	for j := range jobs {
+		wg.Go(func() {
+			results[j], errs[j] = c.consumeAllPages(context.Background(), "h", fmt.Sprintf("j%v-t0", j))
+		})
+	}
+	wg.Wait()
+
+	for j := range jobs {
+		if errs[j] != nil {
+			t.Errorf("job %v: %v", j, errs[j])
+		} else if results[j].PageCount != pages || results[j].StatusCounts["5xx"] != pages {
+			t.Errorf("job %v: %v pages with statuses %v, want %v pages each retried once", j, results[j].PageCount, results[j].StatusCounts, pages)
+		}
+	}
+	if issued.Load() < 2 {
+		t.Error("bearer token never refreshed")
+	}
+	if n := hooked.Load(); n != jobs*pages {
+		t.Errorf("page hook called %v times, want %v", n, jobs*pages)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.records) != jobs*pages {
+		t.Errorf("wrote %v records, want %v", len(sink.records), jobs*pages)
+	}
+}
+
+// connCountingServer returns a pageServer of numberedPages(3, 1), slow enough
+// that concurrent jobs overlap, with the peak of its open connections
+func connCountingServer(t *testing.T) (*pageServer, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	open, peak := 0, 0
+	s := &pageServer{pages: numberedPages(3, 1)}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch state {
+		case http.StateNew:
+			open++
+			peak = max(peak, open)
+		case http.StateClosed, http.StateHijacked:
+			open--
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	s.setHandle(func(http.ResponseWriter, *http.Request, Request) bool {
+		time.Sleep(20 * time.Millisecond)
+		return false
+	})
+	return s, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return peak
+	}
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	jobs := []Job{}
+	for i := range 6 {
+		jobs = append(jobs, Job{Hash: fmt.Sprint("h", i), Token: "t0"})
+	}
+	for _, test := range []struct {
+		name     string
+		maxConns int
+		check    func(peak int) bool
+	}{
+		{name: "capped", maxConns: 2, check: func(peak int) bool { return peak <= 2 }},
+		{name: "uncapped", check: func(peak int) bool { return peak > 2 }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, peak := connCountingServer(t)
+			// A transport of its own, so that no idle connections are shared between runs
+			hc := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+			results := NewClient(s.URL, WithHTTPClient(hc), WithMaxConnsPerHost(test.maxConns)).consumeJobs(context.Background(), jobs, len(jobs), JobErrorContinue, 0)
+			for _, r := range results {
+				if r.Err != nil || r.PageCount != 3 {
+					t.Errorf("%v: got %v pages, %v, want every job completed", r.Job.Hash, r.PageCount, r.Err)
+				}
+			}
+			if n := len(s.received()); n != 3*len(jobs) {
+				t.Errorf("%v requests, want %v", n, 3*len(jobs))
+			}
+			if !test.check(peak()) {
+				t.Errorf("peak of %v connections with 6 concurrent jobs and a limit of %v", peak(), test.maxConns)
+			}
+		})
+	}
+}
+
+func TestRunResult(t *testing.T) {
+	pages := chainPages(testColumns("id"), []string{"t1", "t2", "t3"}, [][][]string{{{"1"}, {"2"}, {"3"}}, {{"4"}}, {}})
+	s := newPageServer(t, pages)
+	clock := newFakeClock()
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		clock.advance(100 * time.Millisecond)
+		w.Header().Set("X-Server-Time", "40ms")
+		return false
+	})
+	e, err := CompileRecordExpr(`id != "2"`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewClient(s.URL, WithClock(clock), WithServerTimeHeader("X-Server-Time"), WithRecordExpr(e)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RunResult{
+		PageCount:         3,
+		RecordCounts:      []int{2, 1, 0},
+		RequestDuration:   300 * time.Millisecond,
+		ServerDuration:    120 * time.Millisecond,
+		UnmarshalDuration: 0,
+		FilteredRecords:   1,
+		StatusCounts:      StatusTally{"2xx": 3},
+		PageSizes:         []int64{int64(len(pages["t1"])), int64(len(pages["t2"])), int64(len(pages["t3"]))},
+		Elapsed:           300 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+	if r.TotalRecords() != 3 {
+		t.Errorf("%v records, want 3", r.TotalRecords())
+	}
+}
+
+func TestConsumePageResult(t *testing.T) {
+	total := 5
+	body, err := json.Marshal(ResultSet{Meta: Meta{NextToken: "t2", Total: &total, Shards: []string{"a1", "b1"}}, Data: Data{Header: Header{Columns: testColumns("id")}, Records: Records{{"1"}, {"2"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newPageServer(t, map[string][]byte{"t1": body})
+	clock := newFakeClock()
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		clock.advance(50 * time.Millisecond)
+		w.Header().Set("X-Server-Time", "20")
+		return false
+	})
+	page, err := NewClient(s.URL, WithClock(clock), WithServerTimeHeader("X-Server-Time")).consumePage(context.Background(), "h", "t1", true, StatusTally{}, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pageResult{nextToken: "t2", records: 2, bytes: int64(len(body)), total: 5, shards: []string{"a1", "b1"}, requestDuration: 50 * time.Millisecond, serverDuration: 20 * time.Millisecond}
+	if !reflect.DeepEqual(page, want) {
+		t.Errorf("got %+v, want %+v", page, want)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time for a Client, measuring the durations of page
+// requests and timing the waits of retry backoff and rate limiting.  Timeouts
+// and deadlines are set on contexts, and so follow the real clock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock of the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// sleepCtx waits for d on the clock, returning early with the error of ctx if
+// it ends first
+func sleepCtx(ctx context.Context, clock Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
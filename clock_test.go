@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitingClock is a fakeClock recording the duration of each wait
+type waitingClock struct {
+	*fakeClock
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (w *waitingClock) After(d time.Duration) <-chan time.Time {
+	w.mu.Lock()
+	w.waits = append(w.waits, d)
+	w.mu.Unlock()
+	return w.fakeClock.After(d)
+}
+
+// slowFailingServer returns a pageServer of numberedPages(2, 1) taking latency
+// on the clock over each request, and failing the first failures requests
+// with 503
+func slowFailingServer(t *testing.T, clock *fakeClock, latency time.Duration, failures int) *pageServer {
+	t.Helper()
+	s := newPageServer(t, numberedPages(2, 1))
+	var mu sync.Mutex
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		clock.advance(latency)
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+	return s
+}
+
+func TestClockBackoff(t *testing.T) {
+	clock := &waitingClock{fakeClock: newFakeClock()}
+	s := slowFailingServer(t, clock.fakeClock, 0, 3)
+	started := time.Now()
+	r, err := NewClient(s.URL, WithClock(clock), WithRetryPolicy(RetryClassServer, RetryPolicy{Attempts: 3, Backoff: 10 * time.Second})).consumeAllPages(context.Background(), "h", "t0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second}; !reflect.DeepEqual(clock.waits, want) {
+		t.Errorf("waited %v, want the backoff doubling from 10s", clock.waits)
+	}
+	// The waits pass on the clock alone
+	if r.Elapsed != 70*time.Second {
+		t.Errorf("elapsed %v, want the 70s of backoff", r.Elapsed)
+	}
+	if real := time.Since(started); real > 5*time.Second {
+		t.Errorf("took %v", real)
+	}
+}
+
+func TestClockDurations(t *testing.T) {
+	clock := &waitingClock{fakeClock: newFakeClock()}
+	s := slowFailingServer(t, clock.fakeClock, 250*time.Millisecond, 2)
+	r, err := NewClient(s.URL, WithClock(clock), WithRetryPolicy(RetryClassServer, RetryPolicy{Attempts: 2, Backoff: 100 * time.Millisecond})).consumeAllPages(context.Background(), "h", "t0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first page's request takes its 3 attempts and the backoff between
+	// them, and the second page a single attempt
+	if want := 4*250*time.Millisecond + 300*time.Millisecond; r.RequestDuration != want || r.Elapsed != want {
+		t.Errorf("request duration %v, elapsed %v, want %v", r.RequestDuration, r.Elapsed, want)
+	}
+	if r.UnmarshalDuration != 0 {
+		t.Errorf("unmarshal duration %v, want none on the clock", r.UnmarshalDuration)
+	}
+}
+
+func TestClockRetryOnEmpty(t *testing.T) {
+	clock := &waitingClock{fakeClock: newFakeClock()}
+	// The empty final page is reached from an earlier page, so is retried
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {}}))
+	r, err := NewClient(s.URL, WithClock(clock), WithRetryOnEmpty(3, time.Second)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}; !reflect.DeepEqual(clock.waits, want) || r.Elapsed != 7*time.Second {
+		t.Errorf("waited %v, elapsed %v, want the backoff doubling from 1s", clock.waits, r.Elapsed)
+	}
+}
+
+func TestClockThrottle(t *testing.T) {
+	clock := &waitingClock{fakeClock: newFakeClock()}
+	s := newPageServer(t, numberedPages(4, 1))
+	// At 2 requests a second, the requests after the first wait 500ms each
+	r, err := NewClient(s.URL, WithAdaptiveThrottle(2, 1, 2), WithClock(clock)).consumeAllPages(context.Background(), "h", "t0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{500 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond}; !reflect.DeepEqual(clock.waits, want) || r.Elapsed != 1500*time.Millisecond {
+		t.Errorf("waited %v, elapsed %v, want %v", clock.waits, r.Elapsed, want)
+	}
+}
+
+func TestSleepCtx(t *testing.T) {
+	clock := &waitingClock{fakeClock: newFakeClock()}
+	if err := sleepCtx(context.Background(), clock, 0); err != nil || len(clock.waits) != 0 {
+		t.Errorf("waited %v, %v, want no wait", clock.waits, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sleepCtx(ctx, realClock{}, time.Hour); err != context.Canceled {
+		t.Errorf("got %v, want the wait ended by the context", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column types that can be set by a coercion, overriding the type in the page header
+const (
+	ColumnTypeString    = "string"
+	ColumnTypeInt       = "int"
+	ColumnTypeFloat     = "float"
+	ColumnTypeBool      = "bool"
+	ColumnTypeTimestamp = "timestamp"
+)
+
+// parseCoercions parses a "column:type,column:type" specification into a map of
+// column name to type
+func parseCoercions(spec string) (map[string]string, error) {
+	coercions := map[string]string{}
+	for _, item := range strings.Split(spec, ",") {
+		name, typ, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || len(name) == 0 {
+			return nil, fmt.Errorf("coerce %q: expected column:type", item)
+		}
+		if _, err := coerceValue(typ, ""); err != nil {
+			return nil, fmt.Errorf("coerce %q: %v", item, err)
+		}
+		coercions[name] = typ
+	}
+	return coercions, nil
+}
+
+// coerceValue returns the canonical form of the value as the type, or an error if
+// it is not a valid value of the type.  Empty (null) values are left as they are
+func coerceValue(typ, value string) (string, error) {
+	switch typ {
+	case ColumnTypeString, ColumnTypeInt, ColumnTypeFloat, ColumnTypeBool, ColumnTypeTimestamp:
+	default:
+		return "", fmt.Errorf("unknown type %q", typ)
+	}
+	if len(value) == 0 {
+		return value, nil
+	}
+
+	switch typ {
+	case ColumnTypeInt:
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an int", value)
+		}
+		return strconv.FormatInt(i, 10), nil
+	case ColumnTypeFloat:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a float", value)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	case ColumnTypeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("%q is not a bool", value)
+		}
+		return strconv.FormatBool(b), nil
+	case ColumnTypeTimestamp:
+		t, err := parseTimestamp(value)
+		if err != nil {
+			return "", err
+		}
+		return t.Format(time.RFC3339Nano), nil
+	}
+	return value, nil
+}
+
+// coerceRecords returns the columns with their types overridden by the coercions,
+// and the records with the values of the coerced columns in their canonical form
+func coerceRecords(coercions map[string]string, columns []Column, records [][]string) ([]Column, [][]string, error) {
+	coerced := append([]Column{}, columns...)
+	for name, typ := range coercions {
+		pos, err := columnPosition(columns, name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("coerce: %v", err)
+		}
+		for i := range coerced {
+			if coerced[i].Name == name {
+				coerced[i].Type = typ
+			}
+		}
+		for i, record := range records {
+			if pos >= len(record) {
+				continue
+			}
+			v, err := coerceValue(typ, record[pos])
+			if err != nil {
+				return nil, nil, fmt.Errorf("coerce: record %v: column %v: %v", i, name, err)
+			}
+			record[pos] = v
+		}
+	}
+	return coerced, records, nil
+}
+
+// validateRecords checks that every value of the records is valid for the type
+// of its column, as coerceValue would parse it, without changing the records.
+// Columns of types other than those that can be coerced to are not checked
+func validateRecords(columns []Column, records [][]string) error {
+	for _, col := range columns {
+		if _, err := coerceValue(col.Type, ""); err != nil || col.Type == ColumnTypeString {
+			continue
+		}
+		for i, record := range records {
+			if col.Position >= len(record) {
+				continue
+			}
+			if _, err := coerceValue(col.Type, record[col.Position]); err != nil {
+				return fmt.Errorf("validate: record %v: column %v: %v", i, col.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkNulls checks that no record holds a null in a column that is not
+// nullable.  Empty values are taken as null, and columns whose nullability is
+// unknown are not checked
+func checkNulls(columns []Column, records [][]string) error {
+	for _, col := range columns {
+		if col.Nullable == nil || *col.Nullable {
+			continue
+		}
+		for i, record := range records {
+			if col.Position >= len(record) || len(record[col.Position]) == 0 {
+				return fmt.Errorf("null: record %v: column %v is not nullable", i, col.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCoercions(t *testing.T) {
+	got, err := parseCoercions("amount:float, active:bool")
+	if want := map[string]string{"amount": ColumnTypeFloat, "active": ColumnTypeBool}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, %v, want %v", got, err, want)
+	}
+	for _, spec := range []string{"amount", ":float", "amount:decimal"} {
+		if _, err := parseCoercions(spec); err == nil {
+			t.Errorf("%q: parsed", spec)
+		}
+	}
+}
+
+func TestCoerceValue(t *testing.T) {
+	for _, test := range []struct {
+		typ, value, want string
+	}{
+		{ColumnTypeFloat, "1.50", "1.5"},
+		{ColumnTypeFloat, " 2 ", "2"},
+		{ColumnTypeInt, "007", "7"},
+		{ColumnTypeBool, "TRUE", "true"},
+		{ColumnTypeTimestamp, "2024-03-01 12:00:00", "2024-03-01T12:00:00Z"},
+		{ColumnTypeString, " as is ", " as is "},
+		{ColumnTypeFloat, "", ""},
+	} {
+		if got, err := coerceValue(test.typ, test.value); err != nil || got != test.want {
+			t.Errorf("%v %q: got %q, %v, want %q", test.typ, test.value, got, err, test.want)
+		}
+	}
+	for _, test := range []struct{ typ, value string }{{ColumnTypeFloat, "1,5"}, {ColumnTypeInt, "1.5"}, {ColumnTypeBool, "yes"}} {
+		if _, err := coerceValue(test.typ, test.value); err == nil {
+			t.Errorf("%v %q: coerced", test.typ, test.value)
+		}
+	}
+}
+
+func TestCoercions(t *testing.T) {
+	columns := testColumns("id", "amount")
+	s := newPageServer(t, chainPages(columns, []string{"t1"}, [][][]string{{{"1", "10.50"}, {"2", "3"}}}))
+	sink := &memorySink{}
+
+	if _, err := NewClient(s.URL, WithCoercions(map[string]string{"amount": ColumnTypeFloat}), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if sink.columns[1].Type != ColumnTypeFloat || sink.columns[0].Type != "string" {
+		t.Errorf("columns %+v, want amount coerced to float", sink.columns)
+	}
+	if want := [][]string{{"1", "10.5"}, {"2", "3"}}; !reflect.DeepEqual(sink.records, want) {
+		t.Errorf("records %v, want %v", sink.records, want)
+	}
+}
+
+func TestCoercionErrors(t *testing.T) {
+	columns := testColumns("id", "amount")
+	s := newPageServer(t, chainPages(columns, []string{"t1"}, [][][]string{{{"1", "10.50"}, {"2", "ten"}}}))
+
+	_, err := NewClient(s.URL, WithCoercions(map[string]string{"amount": ColumnTypeFloat})).consumeAllPages(context.Background(), "h", "t1")
+	if err == nil || !strings.Contains(err.Error(), `record 1: column amount: "ten" is not a float`) {
+		t.Errorf("got %v, want the invalid value", err)
+	}
+	_, err = NewClient(s.URL, WithCoercions(map[string]string{"missing": ColumnTypeFloat})).consumeAllPages(context.Background(), "h", "t1")
+	if err == nil || !strings.Contains(err.Error(), "coerce: ") {
+		t.Errorf("got %v, want the missing column", err)
+	}
+}
+
+func TestCoerceFlag(t *testing.T) {
+	columns := testColumns("id", "amount")
+	s := newPageServer(t, chainPages(columns, []string{"t1"}, [][][]string{{{"1", "10.50"}}}))
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-records-only", "-output-format", "csv", "-coerce", "amount:float")
+	if code != 0 || stdout != "id,amount\n1,10.5\n" {
+		t.Errorf("exit %v, output %q, stderr %q", code, stdout, stderr)
+	}
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-coerce", "amount:decimal"); code == 0 || !strings.Contains(stderr, `unknown type "decimal"`) {
+		t.Errorf("unknown type: exit %v, stderr %q", code, stderr)
+	}
+}
+
+func TestValidateTypes(t *testing.T) {
+	columns := []Column{{Name: "id", Type: ColumnTypeInt, Position: 0}, {Name: "amount", Type: ColumnTypeFloat, Position: 1}, {Name: "note", Type: ColumnTypeString, Position: 2}}
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1", "2.5", "x"}}, {{"2", "3", "y"}, {"3", "4,5", "z"}}}))
+
+	sink := &memorySink{}
+	_, err := NewClient(s.URL, WithTypeValidation(true), WithRecordSink(sink), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t1")
+	if want := `unable to decode page for token t2: validate: record 1: column amount: "4,5" is not a float`; err == nil || err.Error() != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+	// The page number is reported by the pageError, as in -error-format json
+	var pe *pageError
+	if !errors.As(err, &pe) || pe.page != 2 {
+		t.Errorf("got %v, want a pageError of page 2", err)
+	}
+	if !reflect.DeepEqual(sink.records, [][]string{{"1", "2.5", "x"}}) {
+		t.Errorf("wrote %v, want only the valid page, unchanged", sink.records)
+	}
+
+	// Without validation the value is passed through
+	if _, err := NewClient(s.URL).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Errorf("without validation: %v", err)
+	}
+	// A coercion overrides the declared type that is validated
+	if _, err := NewClient(s.URL, WithTypeValidation(true), WithCoercions(map[string]string{"amount": ColumnTypeString})).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Errorf("with amount coerced to string: %v", err)
+	}
+}
+
+func TestNullViolation(t *testing.T) {
+	notNullable, nullable := false, true
+	columns := []Column{{Name: "id", Type: ColumnTypeInt, Position: 0, Nullable: &notNullable}, {Name: "note", Type: ColumnTypeString, Position: 1, Nullable: &nullable},
+		{Name: "other", Type: ColumnTypeString, Position: 2}}
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1", "", ""}}, {{"2", "x", "y"}, {"", "z", ""}}}))
+
+	sink := &memorySink{}
+	_, err := NewClient(s.URL, WithRecordSink(sink), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t1")
+	if want := "unable to decode page for token t2: null: record 1: column id is not nullable"; err == nil || err.Error() != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+	if !reflect.DeepEqual(sink.records, [][]string{{"1", "", ""}}) {
+		t.Errorf("wrote %v, want only the first page, with its nulls in nullable columns and those of unknown nullability", sink.records)
+	}
+	// The nullability decoded from the header is that sent by the server
+	if !reflect.DeepEqual(sink.columns, columns) {
+		t.Errorf("got columns %v, want %v", sink.columns, columns)
+	}
+
+	sink = &memorySink{}
+	if _, err := NewClient(s.URL, WithRecordSink(sink), WithNullViolationPolicy(NullViolationAllow)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.records) != 3 {
+		t.Errorf("wrote %v, want the null written as it is", sink.records)
+	}
+}
+
+func TestColumnNullableOmitted(t *testing.T) {
+	notNullable := false
+	for _, test := range []struct {
+		column Column
+		want   string
+	}{
+		{column: Column{Name: "a", Type: "string"}, want: `{"name":"a","type":"string","position":0}`},
+		{column: Column{Name: "a", Type: "string", Nullable: &notNullable}, want: `{"name":"a","type":"string","position":0,"nullable":false}`},
+	} {
+		if b, err := json.Marshal(test.column); err != nil || string(b) != test.want {
+			t.Errorf("got %s, %v, want %s", b, err, test.want)
+		}
+	}
+}
+
+func TestOnNullViolationFlag(t *testing.T) {
+	notNullable := false
+	s := newPageServer(t, chainPages([]Column{{Name: "id", Type: "string", Nullable: &notNullable}}, []string{"t1"}, [][][]string{{{""}}}))
+	// Nulls are checked in the records decoded for output
+	output := filepath.Join(t.TempDir(), "out.csv")
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", output)
+	if code == 0 || !strings.Contains(stdout+stderr, "column id is not nullable") {
+		t.Errorf("exit %v, stdout %q, stderr %q, want the null to fail the run", code, stdout, stderr)
+	}
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", output, "-on-null-violation", NullViolationAllow); code != 0 {
+		t.Errorf("exit %v, stderr %q", code, stderr)
+	}
+	if b, _ := os.ReadFile(output); string(b) != "id\n\n" {
+		t.Errorf("file %q, want the null written", b)
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-on-null-violation", "ignore"); code == 0 {
+		t.Error("unknown policy accepted")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Decompressor returns a reader decompressing r, whose Close releases the
+// resources of the decompressor without closing r
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// CommandDecompressor returns a Decompressor running the command, split into
+// its program and arguments at whitespace, which reads the compressed body from
+// its stdin and writes it decompressed to its stdout, such as brotli -dc
+func CommandDecompressor(command string) Decompressor {
+	return func(r io.Reader) (io.ReadCloser, error) {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("decompress command: empty")
+		}
+		cr := &commandReader{cmd: exec.Command(args[0], args[1:]...)}
+		cr.cmd.Stdin = r
+		cr.cmd.Stderr = &cr.stderr
+		stdout, err := cr.cmd.StdoutPipe()
+		if err != nil {
+			return nil, fmt.Errorf("decompress command: %w", err)
+		}
+		if err := cr.cmd.Start(); err != nil {
+			return nil, fmt.Errorf("decompress command: %w", err)
+		}
+		cr.stdout = stdout
+		return cr, nil
+	}
+}
+
+// commandReader reads the output of a CommandDecompressor's command, returning
+// its failure once the output ends
+type commandReader struct {
+	cmd    *exec.Cmd
+	stdout io.Reader
+	stderr bytes.Buffer
+	done   bool
+}
+
+func (c *commandReader) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if err == io.EOF && !c.done {
+		c.done = true
+		if werr := c.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("decompress command: %w: %v", werr, strings.TrimSpace(c.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+// Close stops the command should its output not have been read to the end
+func (c *commandReader) Close() error {
+	if !c.done {
+		c.done = true
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	}
+	return nil
+}
+
+// defaultDecompressors are the decompressors of the content encodings every
+// client can decompress, by encoding in order of preference
+var defaultDecompressors = []struct {
+	encoding string
+	open     Decompressor
+}{
+	{"zstd", func(r io.Reader) (io.ReadCloser, error) {
+		// A single threaded decoder starts no background goroutines, and Close
+		// releases its resources
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}},
+	{"gzip", func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}},
+}
+
+// acceptEncoding returns the Accept-Encoding header listing the content
+// encodings of the decompressors, the defaults first in order of preference.
+// As it is set explicitly, the transport leaves gzip responses for readBody to
+// decompress
+func acceptEncoding(decompressors map[string]Decompressor) string {
+	encodings := []string{}
+	for _, d := range defaultDecompressors {
+		encodings = append(encodings, d.encoding)
+	}
+	custom := []string{}
+	for encoding := range decompressors {
+		if !slices.Contains(encodings, encoding) {
+			custom = append(custom, encoding)
+		}
+	}
+	slices.Sort(custom)
+	return strings.Join(append(encodings, custom...), ", ")
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// truncatedBodyError is returned when fewer bytes of a response body are
+// received than its Content-Length declares
+type truncatedBodyError struct {
+	declared int64
+	received int64
+}
+
+func (e *truncatedBodyError) Error() string {
+	return fmt.Sprintf("truncated response: received %v of the %v bytes declared by Content-Length", e.received, e.declared)
+}
+
+// readBody reads the response body, decompressing it according to its Content-Encoding,
+// and returns it with the number of bytes received before decompression.  A body
+// shorter than its Content-Length, if declared, returns a truncatedBodyError, as
+// the part received may otherwise decode as a complete page
+func (c *Client) readBody(resp *http.Response) ([]byte, int64, error) {
+	cr := &countingReader{r: resp.Body}
+	body, err := c.decompress(resp, cr)
+	if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+		// Any bytes after the end of the compressed stream still count towards the length
+		_, cerr := io.Copy(io.Discard, cr)
+		if resp.ContentLength >= 0 && cr.n < resp.ContentLength {
+			return nil, cr.n, &truncatedBodyError{declared: resp.ContentLength, received: cr.n}
+		}
+		if err == nil {
+			err = cerr
+		}
+	}
+	return body, cr.n, err
+}
+
+// decompress reads the response body from r, decompressing it according to its Content-Encoding
+func (c *Client) decompress(resp *http.Response, r io.Reader) ([]byte, error) {
+	dr, err := c.decompressReader(resp.Header.Get("Content-Encoding"), r)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+	return io.ReadAll(dr)
+}
+
+// compressBody returns body compressed with the compression, one of the CaptureCompress values
+func compressBody(compression string, body []byte) ([]byte, error) {
+	switch compression {
+	case CaptureCompressNone:
+		return body, nil
+	case CaptureCompressGzip:
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CaptureCompressZstd:
+		zw, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zw.Close()
+		return zw.EncodeAll(body, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
+
+// decompressReader returns a reader of r decompressed by the client's
+// decompressor of the content encoding, whose Close releases the resources of
+// the decompressor
+func (c *Client) decompressReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "" || encoding == "identity" {
+		return io.NopCloser(r), nil
+	}
+	open, ok := c.decompressors[encoding]
+	if !ok {
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+	return open(r)
+}
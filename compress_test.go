@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedServer returns a pageServer answering with the pages compressed
+// with the content encoding
+func compressedServer(t *testing.T, encoding string, pages map[string][]byte) *pageServer {
+	t.Helper()
+	compressed := map[string][]byte{}
+	for token, page := range pages {
+		var buf bytes.Buffer
+		switch encoding {
+		case "zstd":
+			zw, err := zstd.NewWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw.Write(page)
+			zw.Close()
+		case "gzip":
+			gw := gzip.NewWriter(&buf)
+			gw.Write(page)
+			gw.Close()
+		}
+		compressed[token] = buf.Bytes()
+	}
+	s := newPageServer(t, pages)
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(compressed[req.Token])
+		return true
+	})
+	return s
+}
+
+func TestCompressedResponses(t *testing.T) {
+	columns := testColumns("id")
+	for _, encoding := range []string{"zstd", "gzip"} {
+		t.Run(encoding, func(t *testing.T) {
+			s := compressedServer(t, encoding, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}, {"2"}}, {{"3"}}}))
+			sink := &memorySink{}
+			r, err := NewClient(s.URL, WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := [][]string{{"1"}, {"2"}, {"3"}}; r.PageCount != 2 || !reflect.DeepEqual(sink.records, want) {
+				t.Errorf("got %v pages of %v, want 2 of %v", r.PageCount, sink.records, want)
+			}
+			for _, req := range s.received() {
+				if got := req.header.Get("Accept-Encoding"); got != "zstd, gzip" {
+					t.Errorf("Accept-Encoding %q, want zstd, gzip", got)
+				}
+			}
+		})
+	}
+}
+
+func TestUnsupportedContentEncoding(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1"}, [][][]string{{{"1"}}}))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		w.Header().Set("Content-Encoding", "br")
+		w.Write([]byte("compressed"))
+		return true
+	})
+	if _, err := NewClient(s.URL).consumeAllPages(context.Background(), "h", "t1"); err == nil {
+		t.Error("decoded a br response")
+	}
+}
+
+func TestZstdDecoderReleased(t *testing.T) {
+	zw, _ := zstd.NewWriter(nil)
+	frame := zw.EncodeAll([]byte(`{"meta":{}}`), nil)
+	zw.Close()
+
+	c := NewClient("http://localhost")
+	before := runtime.NumGoroutine()
+	for range 50 {
+		dr, err := c.decompressReader("zstd", bytes.NewReader(frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(dr); err != nil {
+			t.Fatal(err)
+		}
+		dr.Close()
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%v goroutines after decompressing, %v before", after, before)
+	}
+}
+
+func TestTruncatedBody(t *testing.T) {
+	page := testPage("", testColumns("id"), []string{"1"})
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(page)
+	gw.Close()
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{name: "identity", body: page},
+		{name: "gzip", encoding: "gzip", body: gzipped.Bytes()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// The whole page is sent, so would decode, but not the whole of the declared length
+			s := newPageServer(t, nil)
+			s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+				if len(test.encoding) > 0 {
+					w.Header().Set("Content-Encoding", test.encoding)
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(test.body)+100))
+				w.Write(test.body)
+				return true
+			})
+			_, err := NewClient(s.URL).consumeAllPages(context.Background(), "h", "t1")
+			var te *truncatedBodyError
+			if !errors.As(err, &te) || te.declared != int64(len(test.body)+100) || te.received != int64(len(test.body)) {
+				t.Fatalf("got %v, want the truncation detected", err)
+			}
+			if want := fmt.Sprintf("truncated response: received %v of the %v bytes declared by Content-Length", len(test.body), len(test.body)+100); !strings.Contains(err.Error(), want) {
+				t.Errorf("got %q, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestReadBodyComplete(t *testing.T) {
+	// A complete compressed body is counted by its bytes received, not decompressed
+	page := testPage("", testColumns("id"), []string{"1"})
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(page)
+	gw.Close()
+	body := gzipped.Bytes()
+	resp := &http.Response{Header: http.Header{"Content-Encoding": {"gzip"}}, ContentLength: int64(len(body)), Body: io.NopCloser(bytes.NewReader(body))}
+
+	got, n, err := NewClient("http://localhost").readBody(resp)
+	if err != nil || n != int64(len(body)) || !bytes.Equal(got, page) {
+		t.Errorf("read %v of %v bytes as %q, %v", n, len(body), got, err)
+	}
+}
+
+// base64Server returns a pageServer answering with the pages base64 encoded,
+// under the content encoding b64
+func base64Server(t *testing.T, pages map[string][]byte) *pageServer {
+	t.Helper()
+	s := newPageServer(t, pages)
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		w.Header().Set("Content-Encoding", "b64")
+		w.Write([]byte(base64.StdEncoding.EncodeToString(pages[req.Token])))
+		return true
+	})
+	return s
+}
+
+func TestCustomDecompressor(t *testing.T) {
+	s := base64Server(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	b64 := func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(base64.NewDecoder(base64.StdEncoding, r)), nil
+	}
+	sink := &memorySink{}
+	r, err := NewClient(s.URL, WithDecompressor("B64", b64), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"1"}, {"2"}}; r.PageCount != 2 || !reflect.DeepEqual(sink.records, want) {
+		t.Errorf("got %v pages of %v, want 2 of %v", r.PageCount, sink.records, want)
+	}
+	// The custom encoding is accepted after the defaults
+	if got := s.received()[0].header.Get("Accept-Encoding"); got != "zstd, gzip, b64" {
+		t.Errorf("Accept-Encoding %q, want zstd, gzip, b64", got)
+	}
+}
+
+func TestCommandDecompressor(t *testing.T) {
+	if _, err := exec.LookPath("base64"); err != nil {
+		t.Skip("base64 not found")
+	}
+	body := strings.Repeat("decompressed ", 10000)
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+
+	dr, err := CommandDecompressor("base64 -d")(strings.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(dr); err != nil || string(b) != body {
+		t.Errorf("got %v bytes, %v, want the body decoded", len(b), err)
+	}
+	dr.Close()
+
+	// The failure of the command is returned once its output ends
+	dr, err = CommandDecompressor("base64 -d")(strings.NewReader("not base64!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(dr); err == nil || !strings.HasPrefix(err.Error(), "decompress command: ") {
+		t.Errorf("got %v, want the command's failure", err)
+	}
+	dr.Close()
+
+	// Closing before the output ends stops the command
+	dr, err = CommandDecompressor("base64 -d")(strings.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr.Read(make([]byte, 10))
+	done := make(chan struct{})
+	go func() {
+		dr.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("command not stopped")
+	}
+
+	for _, command := range []string{"", "no-such-decompressor"} {
+		if _, err := CommandDecompressor(command)(strings.NewReader(encoded)); err == nil {
+			t.Errorf("%q: started", command)
+		}
+	}
+}
+
+func TestDecompressCommandFlag(t *testing.T) {
+	if _, err := exec.LookPath("base64"); err != nil {
+		t.Skip("base64 not found")
+	}
+	s := base64Server(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-decompress-command", "b64=base64 -d", "-output-format", "csv", "-records-only")
+	if code != 0 || stdout != "id\n1\n2\n" {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1"); code == 0 {
+		t.Error("b64 response decoded without -decompress-command")
+	}
+}
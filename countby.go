@@ -0,0 +1,135 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"text/tabwriter"
+)
+
+// Handling of the values of a -count-by column once the number of distinct
+// values counted reaches its maximum
+const (
+	CountByOverflowOther = "other"
+	CountByOverflowError = "error"
+)
+
+// defaultCountByMaxValues is the default maximum number of distinct values counted
+const defaultCountByMaxValues = 100000
+
+// ValueCount is the number of records holding a value of a column
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// CountBy holds the most frequent values of a column.  Distinct is the number
+// of values counted separately, and Other the number of records whose values
+// were counted together, once the maximum distinct values had been reached
+type CountBy struct {
+	Column   string       `json:"column"`
+	Records  int          `json:"records"`
+	Distinct int          `json:"distinct"`
+	Other    int          `json:"other,omitempty"`
+	Top      []ValueCount `json:"top"`
+}
+
+// countBySink is a RecordSink counting the records holding each value of a
+// column as they are written, with nulls and records of pages lacking the
+// column counted as "".  At most maxValues distinct values are counted, after
+// which the records of new values are counted together as other, with a
+// warning, or with CountByOverflowError fail the write
+type countBySink struct {
+	mu        sync.Mutex
+	column    string
+	maxValues int
+	overflow  string
+	counts    map[string]int
+	records   int
+	other     int
+}
+
+// newCountBySink returns an empty countBySink of the column
+func newCountBySink(column string, maxValues int, overflow string) *countBySink {
+	return &countBySink{column: column, maxValues: maxValues, overflow: overflow, counts: map[string]int{}}
+}
+
+// WriteRecords adds the records to the counts of their values
+func (s *countBySink) WriteRecords(columns []Column, records [][]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	position := -1
+	if i := slices.IndexFunc(columns, func(col Column) bool { return col.Name == s.column }); i >= 0 {
+		position = columns[i].Position
+	}
+	for _, record := range records {
+		v := ""
+		if position >= 0 && position < len(record) {
+			v = record[position]
+		}
+		if _, ok := s.counts[v]; !ok && len(s.counts) >= s.maxValues {
+			if s.overflow == CountByOverflowError {
+				return fmt.Errorf("count by %v: more than %v distinct values", s.column, s.maxValues)
+			}
+			if s.other == 0 {
+				log.Printf("Warning: count by %v: more than %v distinct values, counting further values as other", s.column, s.maxValues)
+			}
+			s.other++
+			s.records++
+			continue
+		}
+		s.counts[v]++
+		s.records++
+	}
+	return nil
+}
+
+// Close does nothing, with the counts still available
+func (s *countBySink) Close() error {
+	return nil
+}
+
+// top returns the counts of the n most frequent values, most frequent first,
+// with values of equal frequency in order
+func (s *countBySink) top(n int) CountBy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make([]ValueCount, 0, len(s.counts))
+	for v, count := range s.counts {
+		values = append(values, ValueCount{Value: v, Count: count})
+	}
+	slices.SortFunc(values, func(a, b ValueCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Value, b.Value))
+	})
+	return CountBy{Column: s.column, Records: s.records, Distinct: len(s.counts), Other: s.other, Top: values[:min(n, len(values))]}
+}
+
+// printCountBy writes the most frequent values to w as a table, or as JSON
+func printCountBy(w io.Writer, format string, c CountBy) error {
+	if format == StatsFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(c)
+	}
+
+	fmt.Fprintf(w, "Count by %v: %v records, %v distinct values\n", c.Column, c.Records, c.Distinct)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VALUE\tCOUNT")
+	for _, v := range c.Top {
+		value := v.Value
+		if len(value) == 0 {
+			value = "(null)"
+		}
+		fmt.Fprintf(tw, "%v\t%v\n", value, v.Count)
+	}
+	if c.Other > 0 {
+		fmt.Fprintf(tw, "(other)\t%v\n", c.Other)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// countByFixture are pages of records by region, the last page lacking the column
+var countByFixture = [][][]string{
+	{{"1", "eu"}, {"2", "us"}, {"3", "eu"}},
+	{{"4", ""}, {"5", "apac"}, {"6", "eu"}, {"7", "us"}},
+}
+
+func TestCountBy(t *testing.T) {
+	s := newCountBySink("region", 10, CountByOverflowOther)
+	columns := testColumns("id", "region")
+	for _, page := range countByFixture {
+		if err := s.WriteRecords(columns, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A page lacking the column counts its records as null
+	if err := s.WriteRecords(testColumns("id"), [][]string{{"8"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := CountBy{Column: "region", Records: 8, Distinct: 4, Top: []ValueCount{{"eu", 3}, {"", 2}, {"us", 2}, {"apac", 1}}}
+	if got := s.top(10); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	want.Top = want.Top[:2]
+	if got := s.top(2); !reflect.DeepEqual(got, want) {
+		t.Errorf("top 2: got %+v, want %+v", got, want)
+	}
+}
+
+func TestCountByOverflow(t *testing.T) {
+	columns := testColumns("id", "region")
+	s := newCountBySink("region", 2, CountByOverflowOther)
+	for _, page := range countByFixture {
+		if err := s.WriteRecords(columns, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Values already counted are still counted once the maximum is reached
+	want := CountBy{Column: "region", Records: 7, Distinct: 2, Other: 2, Top: []ValueCount{{"eu", 3}, {"us", 2}}}
+	if got := s.top(10); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	s = newCountBySink("region", 2, CountByOverflowError)
+	s.WriteRecords(columns, countByFixture[0])
+	if err := s.WriteRecords(columns, countByFixture[1]); err == nil || err.Error() != "count by region: more than 2 distinct values" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestPrintCountBy(t *testing.T) {
+	var b bytes.Buffer
+	c := CountBy{Column: "region", Records: 7, Distinct: 2, Other: 2, Top: []ValueCount{{"eu", 3}, {"", 2}}}
+	if err := printCountBy(&b, StatsFormatText, c); err != nil {
+		t.Fatal(err)
+	}
+	want := "Count by region: 7 records, 2 distinct values\nVALUE    COUNT\neu       3\n(null)   2\n(other)  2\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestCountByFlag(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id", "region"), []string{"t1", "t2"}, countByFixture))
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-count-by", "region", "-count-by-top", "2")
+	if code != 0 || !strings.Contains(stdout, "Count by region: 7 records, 4 distinct values\nVALUE  COUNT\neu     3\nus     2\n") {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	stdout, stderr, code = runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-count-by", "region", "-stats-format", "json")
+	var got CountBy
+	if i := strings.Index(stdout, "{"); code != 0 || i < 0 || json.NewDecoder(strings.NewReader(stdout[i:])).Decode(&got) != nil || got.Records != 7 || !reflect.DeepEqual(got.Top[0], ValueCount{"eu", 3}) {
+		t.Errorf("json: exit %v, got %+v from stdout %q, stderr %q", code, got, stdout, stderr)
+	}
+
+	if stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-count-by", "region", "-count-by-max-values", "2", "-count-by-overflow", CountByOverflowError); code == 0 || !strings.Contains(stdout+stderr, "more than 2 distinct values") {
+		t.Errorf("exit %v, stdout %q, stderr %q, want the run failed", code, stdout, stderr)
+	}
+}
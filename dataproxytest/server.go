@@ -0,0 +1,137 @@
+// Package dataproxytest provides a fake dataproxy server for testing
+// code that uses the client.
+package dataproxytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Column describes a column of the served dataset
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Position int    `json:"position"`
+}
+
+type request struct {
+	Hash  string `json:"hash"`
+	Token string `json:"token"`
+}
+
+type header struct {
+	Columns []Column `json:"columns"`
+}
+
+type data struct {
+	Header  header     `json:"header"`
+	Records [][]string `json:"records"`
+}
+
+type meta struct {
+	NextToken string `json:"next"`
+}
+
+type resultSet struct {
+	Meta meta `json:"meta"`
+	Data data `json:"data"`
+}
+
+// Server is an httptest.Server serving a dataset as pages of at most pageSize
+// records, from the page endpoint beneath its URL.  Pages are identified by
+// tokens "page-0", "page-1", ...; an unknown hash or token returns 404
+type Server struct {
+	*httptest.Server
+	Hash     string
+	columns  []Column
+	records  [][]string
+	pageSize int
+	requests int64
+}
+
+// NewServer starts a Server for the dataset identified by hash.  A dataset with
+// no records is served as a single empty page.  The caller should Close the
+// Server when finished
+func NewServer(hash string, columns []Column, records [][]string, pageSize int) *Server {
+	if pageSize < 1 {
+		pageSize = 1
+	}
+	s := &Server{
+		Hash:     hash,
+		columns:  columns,
+		records:  records,
+		pageSize: pageSize,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.servePage))
+	return s
+}
+
+// FirstToken returns the token of the first page
+func (s *Server) FirstToken() string {
+	return pageToken(0)
+}
+
+// PageCount returns the number of pages the dataset is served as
+func (s *Server) PageCount() int {
+	if len(s.records) == 0 {
+		return 1
+	}
+	return (len(s.records) + s.pageSize - 1) / s.pageSize
+}
+
+// Requests returns the number of page requests received, the POSTs to the
+// page endpoint, excluding any other request such as a HEAD or OPTIONS
+func (s *Server) Requests() int {
+	return int(atomic.LoadInt64(&s.requests))
+}
+
+func pageToken(page int) string {
+	return fmt.Sprintf("page-%d", page)
+}
+
+func (s *Server) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/page") {
+		http.NotFound(w, r)
+		return
+	}
+	atomic.AddInt64(&s.requests, 1)
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := strconv.Atoi(strings.TrimPrefix(req.Token, "page-"))
+	if req.Hash != s.Hash || !strings.HasPrefix(req.Token, "page-") || err != nil || page < 0 || page >= s.PageCount() {
+		http.NotFound(w, r)
+		return
+	}
+
+	start := page * s.pageSize
+	end := start + s.pageSize
+	if end > len(s.records) {
+		end = len(s.records)
+	}
+
+	rs := resultSet{
+		Data: data{
+			Header:  header{Columns: s.columns},
+			Records: [][]string{},
+		},
+	}
+	if start < end {
+		rs.Data.Records = s.records[start:end]
+	}
+	if page+1 < s.PageCount() {
+		rs.Meta.NextToken = pageToken(page + 1)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rs)
+}
@@ -0,0 +1,58 @@
+package dataproxytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServerPages(t *testing.T) {
+	columns := []Column{{Name: "id", Type: "string", Position: 0}}
+	s := NewServer("h", columns, [][]string{{"1"}, {"2"}, {"3"}}, 2)
+	defer s.Close()
+
+	post := func(body string) (*http.Response, resultSet) {
+		t.Helper()
+		resp, err := http.Post(s.URL+"/page", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var rs resultSet
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&rs); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp, rs
+	}
+
+	_, rs := post(`{"hash":"h","token":"page-0"}`)
+	if rs.Meta.NextToken != "page-1" || !reflect.DeepEqual(rs.Data.Records, [][]string{{"1"}, {"2"}}) || !reflect.DeepEqual(rs.Data.Header.Columns, columns) {
+		t.Errorf("first page: %+v", rs)
+	}
+	_, rs = post(`{"hash":"h","token":"page-1"}`)
+	if rs.Meta.NextToken != "" || !reflect.DeepEqual(rs.Data.Records, [][]string{{"3"}}) {
+		t.Errorf("last page: %+v", rs)
+	}
+
+	for _, body := range []string{`{"hash":"other","token":"page-0"}`, `{"hash":"h","token":"page-2"}`, `{"hash":"h","token":"next"}`} {
+		if resp, _ := post(body); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%v: status %v, want 404", body, resp.StatusCode)
+		}
+	}
+	// Only page requests are counted, not a warmup or preflight
+	for _, method := range []string{http.MethodHead, http.MethodOptions} {
+		req, _ := http.NewRequest(method, s.URL+"/page", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if s.Requests() != 5 {
+		t.Errorf("requests %v, want 5", s.Requests())
+	}
+}
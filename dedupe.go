@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// Handling of a page whose records are identical to those of an earlier page
+const (
+	DuplicatePagesOff   = "off"
+	DuplicatePagesWarn  = "warn"
+	DuplicatePagesError = "error"
+)
+
+// pageDigests maps the checksum of the records of each page retrieved in a
+// pagination to the token of the page
+type pageDigests map[string]string
+
+// duplicatePageError reports a page whose records are identical to those of an
+// earlier page of the pagination
+type duplicatePageError struct {
+	token   string
+	earlier string
+}
+
+func (e *duplicatePageError) Error() string {
+	return fmt.Sprintf("page for token %v has the same records as the page for token %v", e.token, e.earlier)
+}
+
+// recordsDigest returns a checksum of the decoded records
+func recordsDigest(records []interface{}) (string, error) {
+	b, err := json.Marshal(records)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkDuplicate records the checksum of the records of the page for token,
+// warning of or failing with a duplicatePageError if an earlier page had the
+// same records, according to the policy.  Pages without records are not compared
+func (d pageDigests) checkDuplicate(policy, token string, records []interface{}) error {
+	if d == nil || len(records) == 0 {
+		return nil
+	}
+	digest, err := recordsDigest(records)
+	if err != nil {
+		return err
+	}
+	earlier, ok := d[digest]
+	if !ok {
+		d[digest] = token
+		return nil
+	}
+	dup := &duplicatePageError{token: token, earlier: earlier}
+	if policy == DuplicatePagesError {
+		return dup
+	}
+	log.Printf("Warning: %v", dup)
+	return nil
+}
+
+// pageCoalescer holds the checksum of the records of the previous page of a
+// pagination, so that a page repeating them, as a retrying or faulty server may
+// return under a new token, can be coalesced into the page before it
+type pageCoalescer struct {
+	previous string
+}
+
+// identical reports whether the records are identical to those of the previous
+// page, noting them as the previous page's.  Pages without records are never
+// identical
+func (p *pageCoalescer) identical(records []interface{}) (bool, error) {
+	if len(records) == 0 {
+		p.previous = ""
+		return false, nil
+	}
+	digest, err := recordsDigest(records)
+	if err != nil {
+		return false, err
+	}
+	identical := digest == p.previous
+	p.previous = digest
+	return identical, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDuplicatePages(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3", "t4"}, [][][]string{{{"1"}, {"2"}}, {{"3"}}, {{"1"}, {"2"}}, {}}))
+
+	_, err := NewClient(s.URL, WithDuplicatePagesPolicy(DuplicatePagesError), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t1")
+	var de *duplicatePageError
+	if !errors.As(err, &de) || de.token != "t3" || de.earlier != "t1" {
+		t.Fatalf("got %v, want t3 reported as a duplicate of t1", err)
+	}
+
+	for _, policy := range []string{DuplicatePagesWarn, DuplicatePagesOff} {
+		r, err := NewClient(s.URL, WithDuplicatePagesPolicy(policy)).consumeAllPages(context.Background(), "h", "t1")
+		if err != nil || r.TotalRecords() != 5 {
+			t.Errorf("%v: got %v records, %v, want all 5", policy, r.TotalRecords(), err)
+		}
+	}
+}
+
+func TestCheckDuplicate(t *testing.T) {
+	d := pageDigests{}
+	page := []interface{}{[]interface{}{"1", 2.0}}
+	if err := d.checkDuplicate(DuplicatePagesError, "t1", page); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.checkDuplicate(DuplicatePagesError, "t2", []interface{}{[]interface{}{"1", "2"}}); err != nil {
+		t.Errorf("differently typed records reported as duplicates: %v", err)
+	}
+	if err := d.checkDuplicate(DuplicatePagesError, "t3", []interface{}{}); err != nil {
+		t.Errorf("empty pages compared: %v", err)
+	}
+	if err := d.checkDuplicate(DuplicatePagesError, "t4", page); err == nil || err.Error() != "page for token t4 has the same records as the page for token t1" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestDedupePagesFlag(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"1"}}}))
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t1", "-show-tokens", "-dedupe-pages"}
+
+	if _, stderr, code := runMain(t, append(args, DuplicatePagesWarn)...); code != 0 || !strings.Contains(stderr, "page for token t2 has the same records as the page for token t1") {
+		t.Errorf("warn: exit %v, stderr %q", code, stderr)
+	}
+	if stdout, stderr, code := runMain(t, append(args, DuplicatePagesError)...); code == 0 || !strings.Contains(stdout+stderr, "page for token t2 has the same records") {
+		t.Errorf("error: exit %v, stderr %q", code, stderr)
+	}
+}
+
+func TestCoalesceIdentical(t *testing.T) {
+	columns := testColumns("id")
+	// Only the page repeating the page immediately before it is coalesced
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3", "t4"}, [][][]string{{{"1"}, {"2"}}, {{"1"}, {"2"}}, {{"3"}}, {{"1"}, {"2"}}}))
+	sink := &memorySink{}
+	c := NewClient(s.URL, WithCoalesceIdentical(true), WithRecordSink(sink))
+	r, err := c.consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sink.records, [][]string{{"1"}, {"2"}, {"3"}, {"1"}, {"2"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrote %v, want %v", got, want)
+	}
+	if r.PageCount != 4 || c.coalescedPages() != 1 {
+		t.Errorf("got %v pages, %v coalesced, want every token followed and one page coalesced", r.PageCount, c.coalescedPages())
+	}
+	if got := s.tokens(); !reflect.DeepEqual(got, []string{"t1", "t2", "t3", "t4"}) {
+		t.Errorf("requested %v", got)
+	}
+
+	sink = &memorySink{}
+	if _, err := NewClient(s.URL, WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1"); err != nil || len(sink.records) != 7 {
+		t.Errorf("wrote %v, %v, want nothing coalesced by default", sink.records, err)
+	}
+}
+
+func TestCoalesceIdenticalFlag(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{{{"1"}}, {{"1"}}, {{"2"}}}))
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-coalesce-identical", "-output-format", "csv", "-records-only")
+	if code != 0 || stdout != "id\n1\n2\n" {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if stdout, _, _ := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-coalesce-identical"); !strings.Contains(stdout, "Coalesced pages: 1\n") {
+		t.Errorf("stdout %q, want the coalesced page reported", stdout)
+	}
+}
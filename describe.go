@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Formats for the -describe output
+const (
+	StatsFormatText = "text"
+	StatsFormatJSON = "json"
+)
+
+// previewRecords is the maximum number of records shown by -preview
+const previewRecords = 10
+
+// fetchPage retrieves only the page for (hash, token), returning its body
+// unwrapped from any envelope
+func (c *Client) fetchPage(ctx context.Context, hash, token string) ([]byte, error) {
+	jsonData, err := c.pagination.requestBody(hash, token, c.serverFields)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.postPage(ctx, hash, token, jsonData, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _, err := c.readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	page, err := c.unwrapEnvelope(token, body)
+	if err != nil {
+		var ee *envelopeError
+		if errors.As(err, &ee) {
+			return nil, err
+		}
+		return nil, newDecodeError(c.tokenRef(token), body, err)
+	}
+	return page, nil
+}
+
+// describe retrieves only the page for (hash, token), returning its columns with
+// the types of any coercions
+func (c *Client) describe(ctx context.Context, hash, token string) ([]Column, error) {
+	body, err := c.fetchPage(ctx, hash, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var page struct {
+		Data struct {
+			Header Header `json:"header"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, newDecodeError(c.tokenRef(token), body, err)
+	}
+	columns := page.Data.Header.Columns
+	if len(c.coercions) > 0 {
+		if columns, _, err = coerceRecords(c.coercions, columns, nil); err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+// preview retrieves only the page for (hash, token), returning its columns and
+// records as they would be output
+func (c *Client) preview(ctx context.Context, hash, token string) ([]Column, [][]string, error) {
+	body, err := c.fetchPage(ctx, hash, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err := c.decodePage(body)
+	if err != nil {
+		return nil, nil, newDecodeError(c.tokenRef(token), body, err)
+	}
+	rawRecords, err := decodeRecords(result)
+	if err != nil {
+		return nil, nil, newDecodeError(c.tokenRef(token), body, err)
+	}
+	columns, records, err := c.pageRecords(result, rawRecords)
+	if err != nil {
+		return nil, nil, newDecodeError(c.tokenRef(token), body, err)
+	}
+	return columns, records, nil
+}
+
+// printPreview provides the columns, followed by a table of at most limit of
+// the records, to w
+func printPreview(w io.Writer, columns []Column, records [][]string, limit int) error {
+	if err := printColumns(w, StatsFormatText, columns); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	columns = columnsByPosition(columns)
+	for i, col := range columns {
+		if i > 0 {
+			fmt.Fprint(tw, "\t")
+		}
+		fmt.Fprint(tw, col.Name)
+	}
+	fmt.Fprintln(tw)
+	for i, record := range records {
+		if i == limit {
+			break
+		}
+		for j, col := range columns {
+			if j > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			if col.Position < len(record) {
+				fmt.Fprint(tw, record[col.Position])
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(records) > limit {
+		fmt.Fprintf(w, "(%v of %v records shown)\n", limit, len(records))
+	}
+	return nil
+}
+
+// printColumns provides a formatted output of the columns to w, as a table or as JSON
+func printColumns(w io.Writer, format string, columns []Column) error {
+	if format == StatsFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(columns)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "POSITION\tNAME\tTYPE")
+	for _, col := range columns {
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", col.Position, col.Name, col.Type)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	columns := []Column{{Name: "id", Type: "int", Position: 0}, {Name: "name", Type: "string", Position: 1}}
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1", "a"}}, {{"2", "b"}}}))
+
+	got, err := NewClient(s.URL).describe(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, columns) {
+		t.Errorf("got %v, want %v", got, columns)
+	}
+	if n := len(s.received()); n != 1 {
+		t.Errorf("%v requests, want 1", n)
+	}
+}
+
+func TestDescribeFlag(t *testing.T) {
+	columns := []Column{{Name: "id", Type: "int", Position: 0}, {Name: "name", Type: "string", Position: 1}}
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1", "a"}}, {{"2", "b"}}}))
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-describe")
+	want := "POSITION  NAME  TYPE\n0         id    int\n1         name  string\n"
+	if code != 0 || stdout != want {
+		t.Errorf("exit %v, stdout %q, want %q, stderr %q", code, stdout, want, stderr)
+	}
+
+	stdout, _, code = runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-describe", "-stats-format", "json")
+	var got []Column
+	if err := json.Unmarshal([]byte(stdout), &got); code != 0 || err != nil || !reflect.DeepEqual(got, columns) {
+		t.Errorf("json: exit %v, columns %v, %v", code, got, err)
+	}
+	if n := len(s.received()); n != 2 {
+		t.Errorf("%v requests for two runs, want 2", n)
+	}
+}
+func TestPrintPreview(t *testing.T) {
+	columns := testColumns("id", "name")
+	records := [][]string{{"1", "a"}, {"2", "b"}, {"3", "c"}}
+	var buf bytes.Buffer
+	if err := printPreview(&buf, columns, records, 2); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "id  name\n1   a\n2   b\n") || strings.Contains(out, "3   c") || !strings.HasSuffix(out, "(2 of 3 records shown)\n") {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestPreviewFlag(t *testing.T) {
+	columns := testColumns("id")
+	records := [][]string{}
+	for i := range previewRecords + 5 {
+		records = append(records, []string{fmt.Sprint(i)})
+	}
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{records, {{"last"}}}))
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-preview")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if tokens := s.tokens(); !reflect.DeepEqual(tokens, []string{"t1"}) {
+		t.Errorf("requested %v, want only the first page", tokens)
+	}
+	if !strings.Contains(stdout, "NAME") || !strings.Contains(stdout, fmt.Sprint(previewRecords-1)+"\n") || strings.Contains(stdout, fmt.Sprint(previewRecords)+"\n") {
+		t.Errorf("output %q, want the schema and the first %v records", stdout, previewRecords)
+	}
+	if want := fmt.Sprintf("(%v of %v records shown)\n", previewRecords, len(records)); !strings.HasSuffix(stdout, want) {
+		t.Errorf("output %q, want it truncated with %q", stdout, want)
+	}
+}
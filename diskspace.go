@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// diskCheckInterval is the minimum time between checks of the free disk space
+const diskCheckInterval = time.Second
+
+// lowDiskSpaceError reports the free space of the output directory falling below the minimum
+type lowDiskSpaceError struct {
+	dir  string
+	free uint64
+	min  uint64
+}
+
+func (e *lowDiskSpaceError) Error() string {
+	return fmt.Sprintf("free space of %v is %v bytes, below the minimum of %v", e.dir, e.free, e.min)
+}
+
+// checkDiskSpace returns a lowDiskSpaceError if the free space of dir, as given
+// by freeBytes, is below min
+func checkDiskSpace(freeBytes func(string) (uint64, error), dir string, min uint64) error {
+	free, err := freeBytes(dir)
+	if err != nil {
+		return fmt.Errorf("free space of %v: %v", dir, err)
+	}
+	if free < min {
+		return &lowDiskSpaceError{dir: dir, free: free, min: min}
+	}
+	return nil
+}
+
+// diskSpaceSink is a RecordSink refusing further records once the free space of
+// the output directory falls below min, checked at most every diskCheckInterval
+type diskSpaceSink struct {
+	mu        sync.Mutex
+	sink      RecordSink
+	dir       string
+	min       uint64
+	freeBytes func(string) (uint64, error)
+	lastCheck time.Time
+}
+
+// newDiskSpaceSink returns a diskSpaceSink writing to sink, with the free space
+// of dir found by freeBytes
+func newDiskSpaceSink(sink RecordSink, dir string, min uint64, freeBytes func(string) (uint64, error)) *diskSpaceSink {
+	return &diskSpaceSink{sink: sink, dir: dir, min: min, freeBytes: freeBytes}
+}
+
+// WriteRecords writes the records to the underlying sink, unless free space is low
+func (d *diskSpaceSink) WriteRecords(columns []Column, records [][]string) error {
+	d.mu.Lock()
+	if time.Since(d.lastCheck) >= diskCheckInterval {
+		d.lastCheck = time.Now()
+		if err := checkDiskSpace(d.freeBytes, d.dir, d.min); err != nil {
+			d.mu.Unlock()
+			return err
+		}
+	}
+	d.mu.Unlock()
+	return d.sink.WriteRecords(columns, records)
+}
+
+// Close closes the underlying sink, flushing the records written
+func (d *diskSpaceSink) Close() error {
+	return d.sink.Close()
+}
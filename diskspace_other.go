@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// freeDiskBytes is unsupported on this platform
+func freeDiskBytes(dir string) (uint64, error) {
+	return 0, errors.New("free disk space is not supported on this platform")
+}
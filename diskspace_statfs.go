@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// freeDiskBytes returns the space available to unprivileged users on the
+// filesystem holding dir
+func freeDiskBytes(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiskSpaceSink(t *testing.T) {
+	free := uint64(1000)
+	freeBytes := func(string) (uint64, error) { return free, nil }
+	mem := &memorySink{}
+	sink := newDiskSpaceSink(mem, "out", 500, freeBytes)
+	columns := testColumns("id")
+
+	if err := sink.WriteRecords(columns, [][]string{{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+	// The free space is not checked again within diskCheckInterval
+	free = 100
+	if err := sink.WriteRecords(columns, [][]string{{"2"}}); err != nil {
+		t.Fatalf("checked within the interval: %v", err)
+	}
+	sink.lastCheck = sink.lastCheck.Add(-diskCheckInterval)
+	err := sink.WriteRecords(columns, [][]string{{"3"}})
+	var le *lowDiskSpaceError
+	if !errors.As(err, &le) || le.free != 100 || le.min != 500 || le.dir != "out" {
+		t.Fatalf("got %v, want a lowDiskSpaceError", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(mem.records) != 2 || !mem.closed {
+		t.Errorf("wrote %v, closed %v, want the records before the low space, then closed", mem.records, mem.closed)
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	if err := checkDiskSpace(func(string) (uint64, error) { return 500, nil }, "out", 500); err != nil {
+		t.Errorf("free space at the minimum: %v", err)
+	}
+	err := checkDiskSpace(func(string) (uint64, error) { return 0, errors.New("unsupported") }, "out", 500)
+	if err == nil || err.Error() != "free space of out: unsupported" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestMinFreeBytesFlag(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1"}, [][][]string{{{"1"}}}))
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", t.TempDir()+"/out.csv", "-min-free-bytes", "18446744073709551615")
+	if code == 0 || !strings.Contains(stderr, "free space of") {
+		t.Fatalf("exit %v with an unattainable minimum, stderr %q", code, stderr)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// drainTimeoutError is returned when a sink has not closed within the drain timeout
+type drainTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *drainTimeoutError) Error() string {
+	return fmt.Sprintf("not flushed within the drain timeout of %v, so may be incomplete", e.timeout)
+}
+
+// closeWithin closes the sink, waiting up to timeout for it to complete, or
+// indefinitely if timeout is 0.  Should the timeout pass first, a
+// drainTimeoutError is returned with the close left running, so that the
+// process may exit with the output as written so far: the records flushed
+// before the timeout remain in place, and only those still buffered are lost
+func closeWithin(sink RecordSink, timeout time.Duration) error {
+	if timeout <= 0 {
+		return sink.Close()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sink.Close()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return &drainTimeoutError{timeout: timeout}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// slowCloseSink is a memorySink whose Close waits until release is closed,
+// returning err
+type slowCloseSink struct {
+	memorySink
+	release chan struct{}
+	err     error
+}
+
+func (s *slowCloseSink) Close() error {
+	<-s.release
+	s.memorySink.Close()
+	return s.err
+}
+
+func TestCloseWithin(t *testing.T) {
+	sink := &slowCloseSink{release: make(chan struct{})}
+	defer close(sink.release)
+
+	started := time.Now()
+	err := closeWithin(sink, 20*time.Millisecond)
+	var de *drainTimeoutError
+	if !errors.As(err, &de) || de.timeout != 20*time.Millisecond {
+		t.Fatalf("got %v, want the drain timeout", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("returned after %v, want the timeout to end the wait", elapsed)
+	}
+	if err.Error() != "not flushed within the drain timeout of 20ms, so may be incomplete" {
+		t.Errorf("got %q", err)
+	}
+}
+
+func TestCloseWithinCompletes(t *testing.T) {
+	failure := errors.New("flush failed")
+	for _, timeout := range []time.Duration{0, time.Second} {
+		sink := &slowCloseSink{release: make(chan struct{}), err: failure}
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			close(sink.release)
+		}()
+		if err := closeWithin(sink, timeout); !errors.Is(err, failure) || !sink.closed {
+			t.Errorf("timeout %v: got %v, closed %v, want the close completed with its error", timeout, err, sink.closed)
+		}
+	}
+}
+
+func TestDrainTimeoutFlag(t *testing.T) {
+	if _, stderr, code := runMain(t, "-url", "http://127.0.0.1:1", "-hash", "h", "-token", "t1", "-drain-timeout", "-1s"); code == 0 {
+		t.Errorf("negative timeout accepted, stderr %q", stderr)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Envelope describes a wrapper around each page response, such as
+// {"status":"ok","result":{...}} or {"status":"error","message":"..."}.  A page
+// whose Status field holds OK carries the ResultSet in its Result field,
+// otherwise its Message field explains the failure
+type Envelope struct {
+	Status  string
+	OK      string
+	Result  string
+	Message string
+}
+
+// defaultEnvelope is the envelope of the example above
+var defaultEnvelope = Envelope{Status: "status", OK: "ok", Result: "result", Message: "message"}
+
+// parseEnvelope parses a "key=value,key=value" specification of the fields of an
+// envelope, with keys status, ok, result and message.  Fields not given take the
+// value of defaultEnvelope, so that "default" gives defaultEnvelope itself
+func parseEnvelope(spec string) (Envelope, error) {
+	env := defaultEnvelope
+	if spec == "default" {
+		return env, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || len(value) == 0 {
+			return Envelope{}, fmt.Errorf("envelope %q: expected key=value", item)
+		}
+		switch key {
+		case "status":
+			env.Status = value
+		case "ok":
+			env.OK = value
+		case "result":
+			env.Result = value
+		case "message":
+			env.Message = value
+		default:
+			return Envelope{}, fmt.Errorf("envelope %q: unknown key %q, expected status, ok, result or message", item, key)
+		}
+	}
+	return env, nil
+}
+
+// envelopeError reports a page whose envelope status is not the success value
+type envelopeError struct {
+	token   string
+	status  string
+	message string
+}
+
+func (e *envelopeError) Error() string {
+	if len(e.message) == 0 {
+		return fmt.Sprintf("page for token %v has envelope status %q", e.token, e.status)
+	}
+	return fmt.Sprintf("page for token %v has envelope status %q: %v", e.token, e.status, e.message)
+}
+
+// unwrapEnvelope returns the body of the ResultSet within the page body, or an
+// envelopeError if the envelope reports a failure.  Other errors are of a body
+// that is not a valid envelope.  The body is returned unchanged when no envelope
+// is configured
+func (c *Client) unwrapEnvelope(token string, body []byte) ([]byte, error) {
+	if c.envelope == nil {
+		return body, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("envelope: %v", err)
+	}
+
+	var status string
+	if err := json.Unmarshal(fields[c.envelope.Status], &status); err != nil {
+		return nil, fmt.Errorf("envelope: %v is missing or not a string", c.envelope.Status)
+	}
+	if status != c.envelope.OK {
+		e := &envelopeError{token: c.tokenRef(token), status: status}
+		if err := json.Unmarshal(fields[c.envelope.Message], &e.message); err != nil {
+			// A message that is not a string is reported as its JSON
+			e.message = string(fields[c.envelope.Message])
+		}
+		return nil, e
+	}
+
+	result, ok := fields[c.envelope.Result]
+	if !ok {
+		return nil, fmt.Errorf("envelope: %v not found", c.envelope.Result)
+	}
+	return result, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// envelopePages returns the pages of t1 -> t2 each wrapped in an ok envelope of env
+func envelopePages(env Envelope) map[string][]byte {
+	pages := chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}})
+	for token, page := range pages {
+		pages[token] = fmt.Appendf(nil, `{%q:%q,%q:%s}`, env.Status, env.OK, env.Result, page)
+	}
+	return pages
+}
+
+func TestEnvelopeOK(t *testing.T) {
+	custom := Envelope{Status: "state", OK: "success", Result: "data", Message: "message"}
+	for _, env := range []Envelope{defaultEnvelope, custom} {
+		s := newPageServer(t, envelopePages(env))
+		sink := &memorySink{}
+		if _, err := NewClient(s.URL, WithEnvelope(env), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+			t.Fatalf("%+v: %v", env, err)
+		}
+		if want := [][]string{{"1"}, {"2"}}; !reflect.DeepEqual(sink.records, want) {
+			t.Errorf("%+v: wrote %v, want %v", env, sink.records, want)
+		}
+	}
+}
+
+func TestEnvelopeError(t *testing.T) {
+	pages := envelopePages(defaultEnvelope)
+	pages["t2"] = []byte(`{"status":"error","message":"quota exceeded"}`)
+	s := newPageServer(t, pages)
+	_, err := NewClient(s.URL, WithEnvelope(defaultEnvelope), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t1")
+	var ee *envelopeError
+	if !errors.As(err, &ee) || ee.status != "error" || ee.message != "quota exceeded" || ee.token != "t2" {
+		t.Fatalf("got %v, want an envelopeError of t2", err)
+	}
+	if !strings.Contains(err.Error(), `page for token t2 has envelope status "error": quota exceeded`) {
+		t.Errorf("got %v", err)
+	}
+
+	for body, want := range map[string]string{
+		`{"status":"ok"}`: "result not found",
+		`{"result":{}}`:   "status is missing or not a string",
+		`{"status":"failed","message":{"code":7}}`: `{"code":7}`,
+	} {
+		pages["t2"] = []byte(body)
+		s := newPageServer(t, pages)
+		if _, err := NewClient(s.URL, WithEnvelope(defaultEnvelope)).consumeAllPages(context.Background(), "h", "t1"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%v: got %v, want %q", body, err, want)
+		}
+	}
+}
+
+func TestParseEnvelope(t *testing.T) {
+	if env, err := parseEnvelope("default"); err != nil || env != defaultEnvelope {
+		t.Errorf("got %+v, %v", env, err)
+	}
+	want := Envelope{Status: "state", OK: "success", Result: "result", Message: "message"}
+	if env, err := parseEnvelope("status=state, ok=success"); err != nil || env != want {
+		t.Errorf("got %+v, %v, want %+v", env, err, want)
+	}
+	for _, spec := range []string{"status", "status=", "body=x"} {
+		if _, err := parseEnvelope(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// eodServer returns a pageServer of t1 -> t2 -> t3, whose t3 is answered with the status
+func eodServer(t *testing.T, status int) *pageServer {
+	t.Helper()
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2", "t3"}, [][][]string{{{"1"}}, {{"2"}}, {{"3"}}}))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "t3" {
+			w.WriteHeader(status)
+			return true
+		}
+		return false
+	})
+	return s
+}
+
+func TestEndOfDataStatus(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusNotFound} {
+		s := eodServer(t, status)
+		result, err := NewClient(s.URL, WithEndOfDataStatus(204, 404)).consumeAllPages(context.Background(), "h", "t1")
+		if err != nil {
+			t.Fatalf("%v: %v", status, err)
+		}
+		if result.PageCount != 2 || result.TotalRecords() != 2 {
+			t.Errorf("%v: got %v pages of %v records, want the 2 data pages", status, result.PageCount, result.TotalRecords())
+		}
+	}
+
+	s := eodServer(t, http.StatusNoContent)
+	if _, err := NewClient(s.URL, WithEndOfDataStatus(404)).consumeAllPages(context.Background(), "h", "t1"); err == nil {
+		t.Error("204 not listed, but ended the pagination cleanly")
+	}
+}
+
+func TestEODStatusFlag(t *testing.T) {
+	s := eodServer(t, http.StatusNoContent)
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-eod-status", "204,404", "-output-format", "csv")
+	if code != 0 || stdout != "id\n1\n2\n" {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-eod-status", "2x4"); code == 0 || !strings.Contains(stderr, "2x4") {
+		t.Errorf("invalid status: exit %v, stderr %q", code, stderr)
+	}
+}
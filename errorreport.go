@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Formats of the error reported when a run fails
+const (
+	ErrorFormatText = "text"
+	ErrorFormatJSON = "json"
+)
+
+// errorReport is the JSON form of the error failing a run, with the errors of
+// any failed jobs
+type errorReport struct {
+	Message string           `json:"message"`
+	Jobs    []jobErrorReport `json:"jobs,omitempty"`
+}
+
+// jobErrorReport is the JSON form of the error of a failed job.  Page is the
+// number, from 1, of the page at which it failed, and Status the HTTP status
+// of that page's response, both omitted if not known.  Cause is the innermost
+// error wrapped by the job's error
+type jobErrorReport struct {
+	Hash      string `json:"hash"`
+	Token     string `json:"token"`
+	Message   string `json:"message"`
+	Page      int    `json:"page,omitempty"`
+	PageToken string `json:"page_token,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	Cause     string `json:"cause,omitempty"`
+}
+
+// writeErrorReport writes the error failing the run to w as an errorReport,
+// including the errors of the failed jobs of results
+func writeErrorReport(w io.Writer, err error, results []JobResult, redact TokenRedactor) {
+	report := errorReport{Message: err.Error()}
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		j := jobErrorReport{Hash: r.Job.Hash, Token: redact(r.Job.Token), Message: r.Err.Error()}
+		var pe *pageError
+		if errors.As(r.Err, &pe) {
+			j.Page, j.PageToken = pe.page, pe.token
+		}
+		var de *decodeError
+		if errors.As(r.Err, &de) {
+			j.Status = de.status
+		}
+		cause := r.Err
+		for next := errors.Unwrap(cause); next != nil; next = errors.Unwrap(cause) {
+			cause = next
+		}
+		if cause != r.Err {
+			j.Cause = cause.Error()
+		}
+		report.Jobs = append(report.Jobs, j)
+	}
+
+	b, merr := json.Marshal(report)
+	if merr != nil {
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", b)
+}
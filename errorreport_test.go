@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestErrorFormatJSON(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "t2" {
+			http.Error(w, "bad token", http.StatusBadRequest)
+			return true
+		}
+		return false
+	})
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t1"}
+
+	_, _, textCode := runMain(t, args...)
+	_, stderr, code := runMain(t, append(args, "-error-format", "json")...)
+	if code == 0 || code != textCode {
+		t.Fatalf("exit %v, want the nonzero exit %v of the text format", code, textCode)
+	}
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	var report errorReport
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &report); err != nil {
+		t.Fatalf("final stderr line %q: %v", lines[len(lines)-1], err)
+	}
+	if len(report.Message) == 0 || len(report.Jobs) != 1 {
+		t.Fatalf("report %+v, want the failed job", report)
+	}
+	j := report.Jobs[0]
+	if j.Hash != "h" || j.Token != HashedToken("t1") || j.Page != 2 || j.PageToken != HashedToken("t2") || j.Status != http.StatusBadRequest || len(j.Cause) == 0 {
+		t.Errorf("job %+v, want its failure at page 2 with a 400", j)
+	}
+	if strings.Contains(stderr, `"t2"`) {
+		t.Errorf("raw token in stderr %q", stderr)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+// estimateMinPages is the number of pages whose record counts must agree before
+// the number of pages of a result set is estimated
+const estimateMinPages = 3
+
+// estimatePages returns a best-effort estimate of the number of pages of a
+// result set of total records, from the record counts of its first pages
+// before any filtering, or 0 if there is no estimate.  An estimate needs the
+// total, as given by the server's meta.total, and the first estimateMinPages
+// pages to have the same, non-zero, record count.  Servers may vary their page
+// sizes later in a pagination, so the estimate is suitable only for progress
+func estimatePages(total int, counts []int) int {
+	if total < 0 || len(counts) < estimateMinPages {
+		return 0
+	}
+	perPage := counts[0]
+	if perPage == 0 {
+		return 0
+	}
+	for _, n := range counts[1:estimateMinPages] {
+		if n != perPage {
+			return 0
+		}
+	}
+	return (total + perPage - 1) / perPage
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestEstimatePages(t *testing.T) {
+	for _, test := range []struct {
+		total  int
+		counts []int
+		want   int
+	}{
+		{total: 100, counts: []int{10, 10, 10}, want: 10},
+		{total: 101, counts: []int{10, 10, 10, 4}, want: 11},
+		{total: -1, counts: []int{10, 10, 10}, want: 0},
+		{total: 100, counts: []int{10, 10}, want: 0},
+		{total: 100, counts: []int{10, 9, 10}, want: 0},
+		{total: 100, counts: []int{0, 0, 0}, want: 0},
+	} {
+		if got := estimatePages(test.total, test.counts); got != test.want {
+			t.Errorf("%v of %v: got %v, want %v", test.counts, test.total, got, test.want)
+		}
+	}
+}
+
+func TestEstimatedPagesOfRun(t *testing.T) {
+	// 5 pages of 2 records, with a total on the first page only
+	pages := numberedPages(5, 2)
+	var first ResultSet
+	json.Unmarshal(pages["t0"], &first)
+	total := 10
+	first.Meta.Total = &total
+	pages["t0"], _ = json.Marshal(first)
+	s := newPageServer(t, pages)
+
+	var mu sync.Mutex
+	estimates := []int{}
+	hook := func(_ context.Context, e PageEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		estimates = append(estimates, e.EstimatedPages)
+	}
+	if _, err := NewClient(s.URL, WithPageHook(hook)).consumeAllPages(context.Background(), "h", "t0"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 0, 5, 5, 5}; !slices.Equal(estimates, want) {
+		t.Errorf("estimates %v, want %v once the first %v pages agree", estimates, want, estimateMinPages)
+	}
+
+	p := newProgress()
+	p.page(context.Background(), PageEvent{Hash: "h", EstimatedPages: 5})
+	p.page(context.Background(), PageEvent{Hash: "g", EstimatedPages: 3})
+	if got := p.estimate(); got != 8 {
+		t.Errorf("progress estimate %v, want the sum over hashes of 8", got)
+	}
+}
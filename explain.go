@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// redacted replaces secret values in the explained configuration
+const redacted = "REDACTED"
+
+// secretKeys are substrings of query parameter names whose values are secrets
+var secretKeys = []string{"token", "key", "secret", "password", "passwd", "auth", "signature", "sig", "credential"}
+
+// explainedSetting is a setting of the explained configuration, with the source
+// of its value: "flag" if set on the command line, "derived" if set from other
+// settings, otherwise "default"
+type explainedSetting struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// explain writes the configuration resolved from the flags of fs as a JSON object
+// of setting name to explainedSetting, with any secrets redacted
+func explain(w io.Writer, fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	settings := map[string]explainedSetting{}
+	fs.VisitAll(func(f *flag.Flag) {
+		s := explainedSetting{Source: "default"}
+		switch {
+		case set[f.Name]:
+			s.Source = "flag"
+		case f.Value.String() != f.DefValue:
+			s.Source = "derived"
+		}
+
+		if g, ok := f.Value.(flag.Getter); ok {
+			s.Value = g.Get()
+		} else {
+			s.Value = f.Value.String()
+		}
+		if q, ok := f.Value.(queryParams); ok {
+			s.Value = redactQuery(url.Values(q)).Encode()
+		}
+		switch v := s.Value.(type) {
+		case time.Duration:
+			s.Value = v.String()
+		case string:
+			s.Value = redactURL(v)
+		}
+		settings[f.Name] = s
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(settings)
+}
+
+// isSecretKey returns true if the query parameter name suggests its value is a secret
+func isSecretKey(name string) bool {
+	name = strings.ToLower(name)
+	for _, key := range secretKeys {
+		if strings.Contains(name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactQuery returns a copy of the query parameters with secret values redacted
+func redactQuery(values url.Values) url.Values {
+	out := url.Values{}
+	for name, vs := range values {
+		for _, v := range vs {
+			if isSecretKey(name) {
+				v = redacted
+			}
+			out.Add(name, v)
+		}
+	}
+	return out
+}
+
+// redactURL returns the value with the password and secret query parameters of a
+// URL redacted.  Values that are not URLs are returned unchanged
+func redactURL(value string) string {
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	if len(u.RawQuery) > 0 {
+		u.RawQuery = redactQuery(u.Query()).Encode()
+	}
+	return u.String()
+}
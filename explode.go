@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Handling by an explodeSink of a record whose value of the column is empty
+const (
+	ExplodeEmptyKeep = "keep"
+	ExplodeEmptyDrop = "drop"
+)
+
+// explodeSink is a RecordSink splitting the value of a column on a delimiter,
+// passing each record on to its underlying sink as one record per element,
+// with the other columns repeated, as SQL's UNNEST would.  A record whose
+// value is empty is passed on unchanged under ExplodeEmptyKeep, or dropped
+// under ExplodeEmptyDrop, and the records of pages without the column are
+// passed on unchanged
+type explodeSink struct {
+	sink      RecordSink
+	column    string
+	delimiter string
+	empty     string
+}
+
+// parseExplode returns the column and delimiter of a column,delimiter value,
+// whose delimiter is everything after the first comma, so may itself be a comma
+func parseExplode(s string) (string, string, error) {
+	column, delimiter, ok := strings.Cut(s, ",")
+	if !ok || len(column) == 0 || len(delimiter) == 0 {
+		return "", "", fmt.Errorf("explode %q: expected column,delimiter", s)
+	}
+	return column, delimiter, nil
+}
+
+// newExplodeSink returns an explodeSink writing to sink
+func newExplodeSink(sink RecordSink, column, delimiter, empty string) *explodeSink {
+	return &explodeSink{sink: sink, column: column, delimiter: delimiter, empty: empty}
+}
+
+// WriteRecords writes the exploded records to the underlying sink
+func (s *explodeSink) WriteRecords(columns []Column, records [][]string) error {
+	i := slices.IndexFunc(columns, func(col Column) bool { return col.Name == s.column })
+	if i < 0 {
+		return s.sink.WriteRecords(columns, records)
+	}
+	position := columns[i].Position
+
+	exploded := make([][]string, 0, len(records))
+	for _, record := range records {
+		if position >= len(record) || len(record[position]) == 0 {
+			if s.empty == ExplodeEmptyKeep {
+				exploded = append(exploded, record)
+			}
+			continue
+		}
+		for _, element := range strings.Split(record[position], s.delimiter) {
+			row := slices.Clone(record)
+			row[position] = element
+			exploded = append(exploded, row)
+		}
+	}
+
+	if len(exploded) == 0 {
+		return nil
+	}
+	return s.sink.WriteRecords(columns, exploded)
+}
+
+// Close closes the underlying sink
+func (s *explodeSink) Close() error {
+	return s.sink.Close()
+}
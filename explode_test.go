@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExplode(t *testing.T) {
+	for s, want := range map[string][2]string{"tags,;": {"tags", ";"}, "tags,,": {"tags", ","}, "tags, | ": {"tags", " | "}} {
+		column, delimiter, err := parseExplode(s)
+		if err != nil || column != want[0] || delimiter != want[1] {
+			t.Errorf("%q: got %q, %q, %v", s, column, delimiter, err)
+		}
+	}
+	for _, s := range []string{"tags", "tags,", ",;"} {
+		if _, _, err := parseExplode(s); err == nil {
+			t.Errorf("%q: accepted", s)
+		}
+	}
+}
+
+func TestExplodeSink(t *testing.T) {
+	columns := testColumns("id", "tags", "name")
+	records := [][]string{{"1", "a;b;c", "x"}, {"2", "d", "y"}, {"3", "", "z"}, {"4", "e;", "w"}}
+	for _, test := range []struct {
+		empty string
+		want  [][]string
+	}{
+		{empty: ExplodeEmptyKeep, want: [][]string{{"1", "a", "x"}, {"1", "b", "x"}, {"1", "c", "x"}, {"2", "d", "y"}, {"3", "", "z"}, {"4", "e", "w"}, {"4", "", "w"}}},
+		{empty: ExplodeEmptyDrop, want: [][]string{{"1", "a", "x"}, {"1", "b", "x"}, {"1", "c", "x"}, {"2", "d", "y"}, {"4", "e", "w"}, {"4", "", "w"}}},
+	} {
+		t.Run(test.empty, func(t *testing.T) {
+			sink := &memorySink{}
+			s := newExplodeSink(sink, "tags", ";", test.empty)
+			if err := s.WriteRecords(columns, records); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sink.records, test.want) {
+				t.Errorf("wrote %v, want %v", sink.records, test.want)
+			}
+			// The records given are unchanged
+			if records[0][1] != "a;b;c" {
+				t.Errorf("record changed to %v", records[0])
+			}
+			if err := s.Close(); err != nil || !sink.closed {
+				t.Errorf("closed %v, %v", sink.closed, err)
+			}
+		})
+	}
+}
+
+func TestExplodeSinkWithoutColumn(t *testing.T) {
+	sink := &memorySink{}
+	s := newExplodeSink(sink, "tags", ";", ExplodeEmptyDrop)
+	records := [][]string{{"1", "a;b"}}
+	if err := s.WriteRecords(testColumns("id", "name"), records); err != nil || !reflect.DeepEqual(sink.records, records) {
+		t.Errorf("wrote %v, %v, want the records unchanged", sink.records, err)
+	}
+	// A page of only empty values dropped writes nothing
+	if err := s.WriteRecords(testColumns("id", "tags"), [][]string{{"2", ""}}); err != nil || sink.writes != 1 {
+		t.Errorf("%v writes, %v", sink.writes, err)
+	}
+}
+
+func TestExplodeFlag(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id", "tags"), []string{"t1", "t2"}, [][][]string{{{"1", "a|b"}, {"2", ""}}, {{"3", "c|d|e"}}}))
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-explode", "tags,|", "-explode-empty", ExplodeEmptyDrop, "-output-format", "csv", "-records-only")
+	if want := "id,tags\n1,a\n1,b\n3,c\n3,d\n3,e\n"; code != 0 || stdout != want {
+		t.Errorf("exit %v, stdout %q, want %q, stderr %q", code, stdout, want, stderr)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// RecordExpr is an expression evaluated for each record, either filtering the
+// records (when column is "") or computing the value of a new column
+type RecordExpr struct {
+	source  string
+	column  string
+	program *vm.Program
+}
+
+// CompileRecordExpr compiles the expression, which must be boolean if it is a
+// filter (column is "").  See exprScope for the variables in scope
+func CompileRecordExpr(source, column string) (*RecordExpr, error) {
+	opts := []expr.Option{expr.AllowUndefinedVariables()}
+	if len(column) == 0 {
+		opts = append(opts, expr.AsBool())
+	}
+	program, err := expr.Compile(source, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("expr: %v", err)
+	}
+	return &RecordExpr{source: source, column: column, program: program}, nil
+}
+
+// exprScope returns the variables in scope when evaluating an expression for
+// the record: each column name is bound to its value, as an int, float or bool
+// for columns of those types (see the ColumnType constants), otherwise as a
+// string.  Empty values are nil
+func exprScope(columns []Column, record []string) (map[string]interface{}, error) {
+	scope := make(map[string]interface{}, len(columns))
+	for _, col := range columns {
+		if col.Position >= len(record) || len(record[col.Position]) == 0 {
+			scope[col.Name] = nil
+			continue
+		}
+		s := record[col.Position]
+		var v interface{} = s
+		var err error
+		switch col.Type {
+		case ColumnTypeInt:
+			v, err = strconv.ParseInt(s, 10, 64)
+		case ColumnTypeFloat:
+			v, err = strconv.ParseFloat(s, 64)
+		case ColumnTypeBool:
+			v, err = strconv.ParseBool(s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("column %v: %q is not a valid %v", col.Name, s, col.Type)
+		}
+		scope[col.Name] = v
+	}
+	return scope, nil
+}
+
+// matches reports whether the record passes a filter expression
+func (e *RecordExpr) matches(columns []Column, record []string) (bool, error) {
+	scope, err := exprScope(columns, record)
+	if err != nil {
+		return false, fmt.Errorf("expr: %v", err)
+	}
+	out, err := expr.Run(e.program, scope)
+	if err != nil {
+		return false, fmt.Errorf("expr: %v", err)
+	}
+	match, _ := out.(bool)
+	return match, nil
+}
+
+// stopPredicate returns the filter expression as the predicate of
+// WithStopPredicate.  A record for which it cannot be evaluated does not match,
+// with the first such failure logged
+func (e *RecordExpr) stopPredicate() func(columns []Column, record []string) bool {
+	var once sync.Once
+	return func(columns []Column, record []string) bool {
+		match, err := e.matches(columns, record)
+		if err != nil {
+			once.Do(func() { log.Printf("Unable to evaluate stop expression %q, taken as false: %v", e.source, err) })
+		}
+		return match
+	}
+}
+
+// apply returns the records kept by a filter expression, or the columns and
+// records with the computed column added to each record
+func (e *RecordExpr) apply(columns []Column, records [][]string) ([]Column, [][]string, error) {
+	width := 0
+	for _, col := range columns {
+		if col.Position >= width {
+			width = col.Position + 1
+		}
+	}
+
+	kept := make([][]string, 0, len(records))
+	for i, record := range records {
+		scope, err := exprScope(columns, record)
+		if err != nil {
+			return nil, nil, fmt.Errorf("expr: record %v: %v", i, err)
+		}
+		out, err := expr.Run(e.program, scope)
+		if err != nil {
+			return nil, nil, fmt.Errorf("expr: record %v: %v", i, err)
+		}
+
+		if len(e.column) == 0 {
+			if keep, _ := out.(bool); keep {
+				kept = append(kept, record)
+			}
+			continue
+		}
+
+		value, err := cellString(out)
+		if err != nil {
+			return nil, nil, fmt.Errorf("expr: record %v: %v", i, err)
+		}
+		extended := make([]string, width+1)
+		copy(extended, record)
+		extended[width] = value
+		kept = append(kept, extended)
+	}
+
+	if len(e.column) > 0 {
+		columns = append(append([]Column{}, columns...), Column{Name: e.column, Type: ColumnTypeString, Position: width})
+	}
+	return columns, kept, nil
+}
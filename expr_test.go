@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exprColumns are an int, float, bool and string column
+var exprColumns = []Column{
+	{Name: "id", Type: ColumnTypeInt, Position: 0},
+	{Name: "price", Type: ColumnTypeFloat, Position: 1},
+	{Name: "active", Type: ColumnTypeBool, Position: 2},
+	{Name: "name", Type: ColumnTypeString, Position: 3},
+}
+
+func TestRecordExprFilter(t *testing.T) {
+	e, err := CompileRecordExpr(`active && price > 1.5 && name != "b"`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, records, err := e.apply(exprColumns, [][]string{{"1", "2.5", "true", "a"}, {"2", "1.0", "true", "c"}, {"3", "9", "true", "b"}, {"4", "9", "false", "d"}, {"5", "9", "true", ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(columns, exprColumns) {
+		t.Errorf("filter changed the columns to %v", columns)
+	}
+	if want := [][]string{{"1", "2.5", "true", "a"}, {"5", "9", "true", ""}}; !reflect.DeepEqual(records, want) {
+		t.Errorf("kept %v, want %v", records, want)
+	}
+}
+
+func TestRecordExprColumn(t *testing.T) {
+	e, err := CompileRecordExpr(`name == nil ? "none" : name + "-" + string(id * 10)`, "label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, records, err := e.apply(exprColumns, [][]string{{"1", "2.5", "true", "a"}, {"2", "", "", ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := columns[len(columns)-1]; len(columns) != 5 || last.Name != "label" || last.Position != 4 {
+		t.Errorf("columns %v, want label added at position 4", columns)
+	}
+	if want := [][]string{{"1", "2.5", "true", "a", "a-10"}, {"2", "", "", "", "none"}}; !reflect.DeepEqual(records, want) {
+		t.Errorf("got %v, want %v", records, want)
+	}
+}
+
+func TestRecordExprErrors(t *testing.T) {
+	if _, err := CompileRecordExpr(`1 + 2`, ""); err == nil || !strings.HasPrefix(err.Error(), "expr:") {
+		t.Errorf("non-boolean filter compiled: %v", err)
+	}
+	// The type of id is known only once evaluated
+	e, err := CompileRecordExpr(`id + 1`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.apply(exprColumns, [][]string{{"2", "", "", ""}}); err == nil || !strings.HasPrefix(err.Error(), "expr: record 0:") {
+		t.Errorf("got %v, want an error of the filter not returning a bool", err)
+	}
+	e, err = CompileRecordExpr(`id > 1`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.apply(exprColumns, [][]string{{"2", "", "", ""}, {"x", "", "", ""}}); err == nil || !strings.Contains(err.Error(), "record 1: column id") {
+		t.Errorf("got %v, want an error of the invalid int", err)
+	}
+}
+
+func TestRecordExprOfRun(t *testing.T) {
+	columns := testColumns("id", "name")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1", "a"}, {"2", "b"}}, {{"3", "a"}}}))
+	e, err := CompileRecordExpr(`name == "a"`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &memorySink{}
+	if _, err := NewClient(s.URL, WithRecordExpr(e), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"1", "a"}, {"3", "a"}}; !reflect.DeepEqual(sink.records, want) {
+		t.Errorf("wrote %v, want %v", sink.records, want)
+	}
+
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-expr", "id +")
+	if code == 0 || !strings.Contains(stderr, "expr:") {
+		t.Errorf("invalid -expr: exit %v, stderr %q", code, stderr)
+	}
+}
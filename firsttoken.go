@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// defaultFirstTokenPath is the location, relative to the client's url, of the
+// endpoint returning the first token of a hash
+const defaultFirstTokenPath = "first-token"
+
+// firstTokenRequest is the body of a request for the first token of a hash
+type firstTokenRequest struct {
+	Hash string `json:"hash"`
+}
+
+// firstTokenResponse is the body of the response giving the first token of a hash
+type firstTokenResponse struct {
+	Token string `json:"token"`
+}
+
+// firstToken requests the token of the first page of the hash from the first
+// token endpoint, which responds with {"token": "..."}.  The request carries
+// the client's query parameters and bearer token, but is not retried
+func (c *Client) firstToken(ctx context.Context, hash string) (string, error) {
+	jsonData, err := json.Marshal(firstTokenRequest{Hash: hash})
+	if err != nil {
+		return "", err
+	}
+
+	endpoint, err := c.endpointURL(c.firstTokenPath)
+	if err != nil {
+		return "", err
+	}
+
+	bearer := ""
+	if c.auth != nil {
+		if bearer, err = c.auth.bearerToken(ctx); err != nil {
+			return "", fmt.Errorf("authentication: %w", err)
+		}
+	}
+
+	resp, err := c.sendPage(ctx, endpoint, hash, "", jsonData, "", bearer)
+	if err != nil {
+		return "", fmt.Errorf("first token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("first token for hash %v: status %v", hash, resp.StatusCode)
+	}
+	body, _, err := c.readBody(resp)
+	if err != nil {
+		return "", fmt.Errorf("first token for hash %v: %w", hash, err)
+	}
+	var ft firstTokenResponse
+	if err := json.Unmarshal(body, &ft); err != nil {
+		return "", fmt.Errorf("first token for hash %v: %w", hash, err)
+	}
+	if len(ft.Token) == 0 {
+		return "", fmt.Errorf("first token for hash %v: no token in response", hash)
+	}
+	return ft.Token, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// firstTokenServer returns a pageServer of t1 -> t2 whose endpoint at path
+// answers with t1 as the first token of hash h
+func firstTokenServer(t *testing.T, path string) *pageServer {
+	t.Helper()
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if !strings.HasSuffix(r.URL.Path, path) {
+			return false
+		}
+		if req.Hash != "h" {
+			http.NotFound(w, r)
+			return true
+		}
+		w.Write([]byte(`{"token":"t1"}`))
+		return true
+	})
+	return s
+}
+
+func TestFirstToken(t *testing.T) {
+	s := firstTokenServer(t, "/"+defaultFirstTokenPath)
+	c := NewClient(s.URL+"/page", WithQueryParams(map[string][]string{"tenant": {"a"}}))
+	token, err := c.firstToken(context.Background(), "h")
+	if err != nil || token != "t1" {
+		t.Fatalf("got %v, %v", token, err)
+	}
+	r := s.received()[0]
+	if r.path != "/page/first-token" || r.query.Get("tenant") != "a" {
+		t.Errorf("requested %v?%v, want the endpoint beneath the url with its query", r.path, r.query)
+	}
+
+	if _, err := c.firstToken(context.Background(), "other"); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("unknown hash: got %v", err)
+	}
+}
+
+func TestAutoFirstTokenFlag(t *testing.T) {
+	s := firstTokenServer(t, "/tokens/first")
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-auto-first-token", "-first-token-path", "tokens/first", "-output-format", "csv")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if stdout != "id\n1\n2\n" {
+		t.Errorf("stdout %q", stdout)
+	}
+	if tokens := s.tokens(); !slices.Equal(tokens, []string{"", "t1", "t2"}) {
+		t.Errorf("requested %v, want the first token and then the pages", tokens)
+	}
+
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-auto-first-token", "-token", "t1"); code == 0 {
+		t.Errorf("-auto-first-token with -token: exit 0, stderr %q", stderr)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// queryParams is a repeatable flag of key=value query parameters
+type queryParams url.Values
+
+func (q queryParams) String() string {
+	return url.Values(q).Encode()
+}
+
+func (q queryParams) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("%q: expected key=value", s)
+	}
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("%q: not valid UTF-8", s)
+	}
+	url.Values(q).Add(s[:i], s[i+1:])
+	return nil
+}
+
+// decompressCommands is a repeatable flag of encoding=command decompressors,
+// by content encoding
+type decompressCommands map[string]string
+
+func (d decompressCommands) String() string {
+	items := []string{}
+	for encoding, command := range d {
+		items = append(items, encoding+"="+command)
+	}
+	slices.Sort(items)
+	return strings.Join(items, ",")
+}
+
+func (d decompressCommands) Set(s string) error {
+	encoding, command, _ := strings.Cut(s, "=")
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if len(encoding) == 0 || len(strings.Fields(command)) == 0 || encoding == "identity" {
+		return fmt.Errorf("%q: expected encoding=command", s)
+	}
+	d[encoding] = command
+	return nil
+}
+
+// stringList is a repeatable flag of string values
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// statusCodes is a flag of comma separated HTTP status codes, which may be repeated
+type statusCodes []int
+
+func (s *statusCodes) String() string {
+	codes := []string{}
+	for _, code := range *s {
+		codes = append(codes, strconv.Itoa(code))
+	}
+	return strings.Join(codes, ",")
+}
+
+func (s *statusCodes) Set(v string) error {
+	for _, item := range strings.Split(v, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || code < 100 || code > 599 {
+			return fmt.Errorf("%q: expected an HTTP status code", item)
+		}
+		*s = append(*s, code)
+	}
+	return nil
+}
+
+// outputSpec is an output of the records, in format, which is "" for that of
+// -output-format, to the file or URL at path
+type outputSpec struct {
+	format string
+	path   string
+}
+
+// outputSpecs is a repeatable flag of [format:]path outputs, where the prefix is
+// taken as the format only if it names an output format, so that URLs such as
+// s3://bucket/key are left intact
+type outputSpecs []outputSpec
+
+func (o *outputSpecs) String() string {
+	specs := []string{}
+	for _, spec := range *o {
+		if len(spec.format) > 0 {
+			specs = append(specs, spec.format+":"+spec.path)
+		} else {
+			specs = append(specs, spec.path)
+		}
+	}
+	return strings.Join(specs, ",")
+}
+
+func (o *outputSpecs) Set(s string) error {
+	spec := outputSpec{path: s}
+	if format, path, ok := strings.Cut(s, ":"); ok && isOutputFormat(format) {
+		spec = outputSpec{format: format, path: path}
+	}
+	if len(spec.path) == 0 {
+		return fmt.Errorf("%q: expected [format:]path", s)
+	}
+	*o = append(*o, spec)
+	return nil
+}
+
+// resolve returns the outputs with those without a format given the format,
+// omitting any of format none
+func (o outputSpecs) resolve(format string) []outputSpec {
+	resolved := []outputSpec{}
+	for _, spec := range o {
+		if len(spec.format) == 0 {
+			spec.format = format
+		}
+		if spec.format != OutputFormatNone {
+			resolved = append(resolved, spec)
+		}
+	}
+	return resolved
+}
+
+// argCheck is a check of the command line arguments, which are invalid if its
+// condition holds, reported with its error
+type argCheck struct {
+	invalid bool
+	err     string
+}
+
+// choiceCheck returns the argCheck of a flag whose value must be one of the choices
+func choiceCheck(name, value string, choices ...string) argCheck {
+	expected := choices[len(choices)-1]
+	if len(choices) > 1 {
+		expected = strings.Join(choices[:len(choices)-1], ", ") + " or " + expected
+	}
+	return argCheck{invalid: !slices.Contains(choices, value), err: fmt.Sprintf("%v %q: expected %v", name, value, expected)}
+}
+
+// checkArgs returns the errors of the checks whose conditions hold, joined, or
+// nil if the arguments are valid
+func checkArgs(checks []argCheck) error {
+	errs := []error{}
+	for _, check := range checks {
+		if check.invalid {
+			errs = append(errs, errors.New(check.err))
+		}
+	}
+	return errors.Join(errs...)
+}
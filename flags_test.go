@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryParamsFlag(t *testing.T) {
+	q := queryParams{}
+	for _, s := range []string{"format=compact", "x=1=2"} {
+		if err := q.Set(s); err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+	}
+	if want := (queryParams{"format": {"compact"}, "x": {"1=2"}}); !reflect.DeepEqual(q, want) {
+		t.Errorf("got %v, want %v", q, want)
+	}
+	for _, s := range []string{"format", "=compact"} {
+		if err := q.Set(s); err == nil {
+			t.Errorf("%q: accepted", s)
+		}
+	}
+}
+
+func TestDecompressCommandsFlag(t *testing.T) {
+	d := decompressCommands{}
+	for _, s := range []string{"br=brotli -dc", "LZ4=lz4 -dc=x"} {
+		if err := d.Set(s); err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+	}
+	if want := (decompressCommands{"br": "brotli -dc", "lz4": "lz4 -dc=x"}); !reflect.DeepEqual(d, want) {
+		t.Errorf("got %v, want %v", d, want)
+	}
+	for _, s := range []string{"br", "=brotli", "br=", "identity=cat"} {
+		if err := d.Set(s); err == nil {
+			t.Errorf("%q: accepted", s)
+		}
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	if err := checkArgs([]argCheck{{false, "-a is invalid"}, choiceCheck("-b", "x", "x", "y")}); err != nil {
+		t.Errorf("got %v, want the arguments valid", err)
+	}
+	err := checkArgs([]argCheck{{true, "-a is invalid"}, {false, "-c is invalid"}, choiceCheck("-b", "z", "x", "y", "w"), choiceCheck("-d", "z", "x")})
+	if want := "-a is invalid\n-b \"z\": expected x, y or w\n-d \"z\": expected x"; err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
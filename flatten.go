@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// flattenSink is a RecordSink expanding the JSON object values of the named
+// columns into additional "column.key" columns, with nested objects giving
+// "column.key.subkey".  As the added columns are the union of the keys across
+// all records, the records are buffered, within the memory limit, and only
+// written to the underlying sink on Close.  Values that are not JSON objects
+// are left as they are
+type flattenSink struct {
+	mu      sync.Mutex
+	sink    RecordSink
+	names   []string
+	columns []Column
+	records *recordBuffer
+}
+
+// newFlattenSink returns a flattenSink writing to sink
+func newFlattenSink(sink RecordSink, names []string, limit memoryLimit) *flattenSink {
+	return &flattenSink{sink: sink, names: names, records: newRecordBuffer(limit)}
+}
+
+// WriteRecords buffers the records, which must have the same columns as all other pages
+func (f *flattenSink) WriteRecords(columns []Column, records [][]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.columns == nil {
+		for _, name := range f.names {
+			if _, err := columnPosition(columns, name); err != nil {
+				return fmt.Errorf("flatten: %v", err)
+			}
+		}
+		f.columns = columns
+	} else if !reflect.DeepEqual(f.columns, columns) {
+		return fmt.Errorf("flatten: pages have differing columns")
+	}
+
+	if err := f.records.add(records); err != nil {
+		return fmt.Errorf("flatten: %w", err)
+	}
+	return nil
+}
+
+// Close writes the flattened records to the underlying sink, and closes it
+func (f *flattenSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var err error
+	if f.records.len() > 0 {
+		err = f.flatten()
+	}
+	if rerr := f.records.release(); err == nil {
+		err = rerr
+	}
+	if cerr := f.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// flatten writes the records to the underlying sink with the flattened columns
+// expanded, bufferedBatchSize records at a time
+func (f *flattenSink) flatten() error {
+	// Determine the union of the keys of the flattened column values
+	positions := []int{}
+	keys := map[int][]string{}
+	for _, name := range f.names {
+		pos, _ := columnPosition(f.columns, name)
+		if !slices.Contains(positions, pos) {
+			positions = append(positions, pos)
+		}
+	}
+	seen := map[int]map[string]bool{}
+	err := f.records.each(func(record []string) error {
+		for _, pos := range positions {
+			for key := range flattenedValue(record, pos) {
+				if seen[pos] == nil {
+					seen[pos] = map[string]bool{}
+				}
+				if !seen[pos][key] {
+					seen[pos][key] = true
+					keys[pos] = append(keys[pos], key)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, pos := range positions {
+		sort.Strings(keys[pos])
+	}
+
+	columns := []Column{}
+	for _, col := range columnsByPosition(f.columns) {
+		columns = append(columns, Column{Name: col.Name, Type: col.Type, Position: len(columns), Nullable: col.Nullable})
+		for _, key := range keys[col.Position] {
+			columns = append(columns, Column{Name: col.Name + "." + key, Type: "string", Position: len(columns)})
+		}
+	}
+
+	batch := [][]string{}
+	err = f.records.each(func(record []string) error {
+		out := make([]string, 0, len(columns))
+		for _, col := range columnsByPosition(f.columns) {
+			value := ""
+			if col.Position < len(record) {
+				value = record[col.Position]
+			}
+			var values map[string]string
+			if slices.Contains(positions, col.Position) {
+				values = flattenedValue(record, col.Position)
+			}
+			if values == nil {
+				out = append(out, value)
+				for range keys[col.Position] {
+					out = append(out, "")
+				}
+				continue
+			}
+			out = append(out, "")
+			for _, key := range keys[col.Position] {
+				out = append(out, values[key])
+			}
+		}
+		if batch = append(batch, out); len(batch) == bufferedBatchSize {
+			err := f.sink.WriteRecords(columns, batch)
+			batch = [][]string{}
+			return err
+		}
+		return nil
+	})
+	if err != nil || len(batch) == 0 {
+		return err
+	}
+	return f.sink.WriteRecords(columns, batch)
+}
+
+// flattenedValue returns the values of the JSON object at pos in the record,
+// keyed by their dot separated path, or nil if it is not a JSON object
+func flattenedValue(record []string, pos int) map[string]string {
+	if pos >= len(record) {
+		return nil
+	}
+	var v interface{}
+	if json.Unmarshal([]byte(record[pos]), &v) != nil {
+		return nil
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	values := map[string]string{}
+	flattenObject("", obj, values)
+	return values
+}
+
+// flattenObject adds the values of obj to out, keyed by their dot separated path
+// beneath prefix.  Values other than strings and objects are held as JSON
+func flattenObject(prefix string, obj map[string]interface{}, out map[string]string) {
+	for key, v := range obj {
+		path := key
+		if len(prefix) > 0 {
+			path = prefix + "." + key
+		}
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenObject(path, nested, out)
+			continue
+		}
+		s, err := cellString(v)
+		if err != nil {
+			continue
+		}
+		out[path] = s
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlattenSink(t *testing.T) {
+	m := &memorySink{}
+	f := newFlattenSink(m, []string{"attrs"}, memoryLimit{})
+	columns := testColumns("id", "attrs", "note")
+	pages := [][][]string{
+		{{"1", `{"b":"x","a":{"c":2,"d":true}}`, "n1"}, {"2", "not json", "n2"}},
+		{{"3", `{"e":null,"b":"y"}`, "n3"}, {"4", `["an","array"]`, "n4"}, {"5", "", "n5"}},
+	}
+	for _, records := range pages {
+		if err := f.WriteRecords(columns, records); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(m.records) > 0 {
+		t.Fatal("records written before Close")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for i, col := range m.columns {
+		if col.Position != i {
+			t.Errorf("column %v at position %v", col.Name, col.Position)
+		}
+		names = append(names, col.Name)
+	}
+	if want := []string{"id", "attrs", "attrs.a.c", "attrs.a.d", "attrs.b", "attrs.e", "note"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("columns %v, want %v", names, want)
+	}
+	want := [][]string{
+		{"1", "", "2", "true", "x", "", "n1"},
+		{"2", "not json", "", "", "", "", "n2"},
+		{"3", "", "", "", "y", "", "n3"},
+		{"4", `["an","array"]`, "", "", "", "", "n4"},
+		{"5", "", "", "", "", "", "n5"},
+	}
+	if !reflect.DeepEqual(m.records, want) {
+		t.Errorf("records\n%v\nwant\n%v", m.records, want)
+	}
+	if !m.closed {
+		t.Error("underlying sink not closed")
+	}
+}
+
+func TestFlattenSinkErrors(t *testing.T) {
+	f := newFlattenSink(&memorySink{}, []string{"missing"}, memoryLimit{})
+	if err := f.WriteRecords(testColumns("id"), [][]string{{"1"}}); err == nil || !strings.HasPrefix(err.Error(), "flatten: ") {
+		t.Errorf("got %v, want the missing column", err)
+	}
+
+	f = newFlattenSink(&memorySink{}, []string{"id"}, memoryLimit{})
+	if err := f.WriteRecords(testColumns("id"), [][]string{{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.WriteRecords(testColumns("id", "other"), [][]string{{"1", "2"}}); err == nil || !strings.Contains(err.Error(), "differing columns") {
+		t.Errorf("got %v, want differing columns", err)
+	}
+}
+
+func TestFlattenFlag(t *testing.T) {
+	columns := testColumns("id", "attrs")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1", `{"k":"v"}`}}, {{"2", "plain"}}}))
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-records-only", "-output-format", "ndjson", "-flatten", "attrs")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	want := `{"attrs":"","attrs.k":"v","id":"1"}` + "\n" + `{"attrs":"plain","attrs.k":"","id":"2"}` + "\n"
+	if stdout != want {
+		t.Errorf("output %q, want %q", stdout, want)
+	}
+}
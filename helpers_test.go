@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mainArgsEnv holds the JSON array of arguments with which the test binary runs
+// main in place of the tests, for runMain
+const mainArgsEnv = "DATAPROXY_CLIENT_MAIN_ARGS"
+
+func TestMain(m *testing.M) {
+	if encoded, ok := os.LookupEnv(mainArgsEnv); ok {
+		var args []string
+		if err := json.Unmarshal([]byte(encoded), &args); err != nil {
+			log.Fatal(err)
+		}
+		os.Args = append([]string{"client"}, args...)
+		main()
+		os.Exit(0)
+	}
+	// Logged warnings are not of interest unless a test fails
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// runMain runs the client with the arguments in a process of its own, returning
+// its stdout, stderr and exit code
+func runMain(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Env = append(os.Environ(), mainArgsEnv+"="+string(encoded))
+	var stdout, stderr strings.Builder
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	code := 0
+	if ee, ok := err.(*exec.ExitError); ok {
+		code = ee.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), code
+}
+
+// testColumns returns string columns of the names, positioned in order
+func testColumns(names ...string) []Column {
+	columns := make([]Column, len(names))
+	for i, name := range names {
+		columns[i] = Column{Name: name, Type: "string", Position: i}
+	}
+	return columns
+}
+
+// testPage returns the body of a page of the records with the columns, whose
+// next page has the token next
+func testPage(next string, columns []Column, records ...[]string) []byte {
+	rs := ResultSet{Meta: Meta{NextToken: next}, Data: Data{Header: Header{Columns: columns}, Records: Records(records)}}
+	if rs.Data.Records == nil {
+		rs.Data.Records = Records{}
+	}
+	b, err := json.Marshal(rs)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// pageServer is an httptest.Server answering page requests with the body held
+// for their token, recording the requests received.  An unknown token is 404
+type pageServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	pages    map[string][]byte
+	requests []pageRequest
+	// handle, if not nil, answers the request in place of the pages, unless it
+	// returns false
+	handle func(w http.ResponseWriter, r *http.Request, req Request) bool
+}
+
+// pageRequest is a request received by a pageServer
+type pageRequest struct {
+	Request
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// newPageServer starts a pageServer for the pages, closed when the test ends
+func newPageServer(t testing.TB, pages map[string][]byte) *pageServer {
+	t.Helper()
+	s := &pageServer{pages: pages}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *pageServer) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req Request
+	json.Unmarshal(body, &req)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, pageRequest{Request: req, method: r.Method, path: r.URL.Path, query: r.URL.Query(), header: r.Header.Clone(), body: body})
+	handle := s.handle
+	page, ok := s.pages[req.Token]
+	s.mu.Unlock()
+
+	if handle != nil && handle(w, r, req) {
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(page)
+}
+
+// setHandle sets the handler answering requests in place of the pages
+func (s *pageServer) setHandle(handle func(w http.ResponseWriter, r *http.Request, req Request) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handle = handle
+}
+
+// received returns the requests received so far
+func (s *pageServer) received() []pageRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]pageRequest{}, s.requests...)
+}
+
+// tokens returns the tokens of the requests received so far
+func (s *pageServer) tokens() []string {
+	tokens := []string{}
+	for _, r := range s.received() {
+		tokens = append(tokens, r.Token)
+	}
+	return tokens
+}
+
+// chainPages returns the pages of a chain with the tokens, each with the
+// records given for it, with the columns
+func chainPages(columns []Column, tokens []string, records [][][]string) map[string][]byte {
+	pages := map[string][]byte{}
+	for i, token := range tokens {
+		next := ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+		pages[token] = testPage(next, columns, records[i]...)
+	}
+	return pages
+}
+
+// memorySink is a RecordSink holding the records written to it
+type memorySink struct {
+	mu      sync.Mutex
+	columns []Column
+	records [][]string
+	writes  int
+	closed  bool
+}
+
+func (m *memorySink) WriteRecords(columns []Column, records [][]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.columns = columns
+	m.records = append(m.records, records...)
+	m.writes++
+	return nil
+}
+
+func (m *memorySink) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// idleTimeoutError is the cause of a response abandoned by its idleWatchdog
+type idleTimeoutError struct {
+	idle time.Duration
+}
+
+func (e *idleTimeoutError) Error() string {
+	return fmt.Sprintf("response stalled: no data received for %v", e.idle)
+}
+
+// idleWatchdog abandons a response whose body has received no data for the
+// idle duration, by cancelling the context of its request.  This detects a
+// connection that stays open whilst the server has stalled, however long the
+// response as a whole may take.  A nil idleWatchdog watches nothing
+type idleWatchdog struct {
+	idle   time.Duration
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	timer  *time.Timer
+}
+
+// newIdleWatchdog returns the context for a request, with its watchdog if idle
+// is positive, otherwise ctx itself and nil
+func newIdleWatchdog(ctx context.Context, idle time.Duration) (context.Context, *idleWatchdog) {
+	if idle <= 0 {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	return ctx, &idleWatchdog{idle: idle, ctx: ctx, cancel: cancel}
+}
+
+// watch returns the response body, read through the watchdog, starting it.
+// The wait for the response's headers is left to the other timeouts
+func (w *idleWatchdog) watch(body io.ReadCloser) io.ReadCloser {
+	if w == nil {
+		return body
+	}
+	w.timer = time.AfterFunc(w.idle, func() {
+		w.cancel(&idleTimeoutError{idle: w.idle})
+	})
+	return &idleBody{ReadCloser: body, watchdog: w}
+}
+
+// cause returns the idleTimeoutError in place of err if the watchdog abandoned
+// the response, otherwise err
+func (w *idleWatchdog) cause(err error) error {
+	if w == nil || err == nil {
+		return err
+	}
+	if cause, ok := context.Cause(w.ctx).(*idleTimeoutError); ok {
+		return cause
+	}
+	return err
+}
+
+// stop stops the watchdog, releasing its context
+func (w *idleWatchdog) stop() {
+	if w == nil {
+		return
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.cancel(nil)
+}
+
+// idleBody is a response body restarting its watchdog as data is received
+type idleBody struct {
+	io.ReadCloser
+	watchdog *idleWatchdog
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.watchdog.timer.Reset(b.watchdog.idle)
+	}
+	return n, err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stallingServer returns a pageServer of the page t1 sending the first half of
+// its body, then the rest after delay, in pieces of the given size each pause apart
+func stallingServer(t *testing.T, delay, pause time.Duration, size int) *pageServer {
+	t.Helper()
+	page := testPage("", testColumns("id"), []string{"1"}, []string{"2"})
+	s := newPageServer(t, map[string][]byte{"t1": page})
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		half := len(page) / 2
+		w.Write(page[:half])
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return true
+		}
+		for rest := page[half:]; len(rest) > 0; rest = rest[min(size, len(rest)):] {
+			w.Write(rest[:min(size, len(rest))])
+			w.(http.Flusher).Flush()
+			time.Sleep(pause)
+		}
+		return true
+	})
+	return s
+}
+
+func TestMaxIdleTime(t *testing.T) {
+	// The server stalls part way through the body
+	s := stallingServer(t, time.Minute, 0, 1)
+	started := time.Now()
+	_, err := NewClient(s.URL, WithMaxIdleTime(50*time.Millisecond)).consumeAllPages(context.Background(), "h", "t1")
+	var idle *idleTimeoutError
+	if !errors.As(err, &idle) || !strings.Contains(err.Error(), "response stalled: no data received for 50ms") {
+		t.Fatalf("got %v, want the stall detected", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("detected after %v", elapsed)
+	}
+}
+
+func TestMaxIdleTimeTrickle(t *testing.T) {
+	// The body takes far longer than the idle time, but data keeps arriving
+	s := stallingServer(t, 30*time.Millisecond, 10*time.Millisecond, 4)
+	r, err := NewClient(s.URL, WithMaxIdleTime(200*time.Millisecond)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil || r.TotalRecords() != 2 {
+		t.Errorf("got %v records, %v, want the slow response read", r.TotalRecords(), err)
+	}
+}
+
+func TestMaxIdleTimeNDJSONStream(t *testing.T) {
+	s := newPageServer(t, nil)
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		w.Write([]byte(`{"id":"1"}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		return true
+	})
+	_, err := NewClient(s.URL, WithMaxIdleTime(50*time.Millisecond), WithNDJSONStream(testColumns("id"))).consumeAllPages(context.Background(), "h", "t1")
+	var idle *idleTimeoutError
+	if !errors.As(err, &idle) {
+		t.Errorf("got %v, want the stalled stream detected", err)
+	}
+}
+
+func TestMaxIdleTimeFlag(t *testing.T) {
+	s := stallingServer(t, time.Minute, 0, 1)
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-max-idle-time", "100ms")
+	if code == 0 || !strings.Contains(stdout+stderr, "response stalled: no data received for 100ms") {
+		t.Errorf("exit %v, stdout %q, stderr %q, want the stall to fail the run", code, stdout, stderr)
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-max-idle-time", "-1s"); code == 0 {
+		t.Error("negative idle time accepted")
+	}
+}
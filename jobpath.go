@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// outputTemplatePlaceholders are the job attributes an output template may
+// use, as {name}: the job's hash, its label (or its hash if it has none), and
+// the date the run started, as YYYY-MM-DD in UTC
+var outputTemplatePlaceholders = []string{"hash", "label", "date"}
+
+// outputTemplate is an output path holding placeholders, such as
+// out/{label}-{date}.ndjson, from which the path of each job's output is derived
+type outputTemplate struct {
+	parts []string
+	date  string
+}
+
+// isOutputTemplate reports whether the output path holds placeholders
+func isOutputTemplate(path string) bool {
+	return strings.ContainsAny(path, "{}")
+}
+
+// parseOutputTemplate returns the template of the path, whose {date} is that of
+// started.  Unknown placeholders and unbalanced braces are errors
+func parseOutputTemplate(path string, started time.Time) (*outputTemplate, error) {
+	t := &outputTemplate{date: started.UTC().Format(time.DateOnly)}
+	rest := path
+	for len(rest) > 0 {
+		open := strings.IndexByte(rest, '{')
+		if close := strings.IndexByte(rest, '}'); close >= 0 && (open < 0 || close < open) {
+			return nil, fmt.Errorf("output template %q: unexpected }", path)
+		}
+		if open < 0 {
+			t.parts = append(t.parts, rest)
+			break
+		}
+		name, after, ok := strings.Cut(rest[open+1:], "}")
+		if !ok {
+			return nil, fmt.Errorf("output template %q: unterminated {", path)
+		}
+		if !slices.Contains(outputTemplatePlaceholders, name) {
+			return nil, fmt.Errorf("output template %q: unknown placeholder {%v}, expected one of {%v}", path, name, strings.Join(outputTemplatePlaceholders, "}, {"))
+		}
+		t.parts = append(t.parts, rest[:open], "{"+name+"}")
+		rest = after
+	}
+	return t, nil
+}
+
+// render returns the output path of the job.  Values are made safe to use in a
+// file name as partition names are, so that a value cannot add directories
+func (t *outputTemplate) render(job Job) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		switch part {
+		case "{hash}":
+			b.WriteString(partitionName(job.Hash))
+		case "{label}":
+			b.WriteString(partitionName(jobTag(job)))
+		case "{date}":
+			b.WriteString(t.date)
+		default:
+			b.WriteString(part)
+		}
+	}
+	return b.String()
+}
+
+// checkJobs returns an error if two of the jobs would share an output
+func (t *outputTemplate) checkJobs(jobs []Job) error {
+	seen := map[string]int{}
+	for i, job := range jobs {
+		path := t.render(job)
+		if j, ok := seen[path]; ok {
+			return fmt.Errorf("jobs %v and %v have the same output %v", j+1, i+1, path)
+		}
+		seen[path] = i
+	}
+	return nil
+}
+
+// makeParentDir creates the directories holding the local file at path, as
+// needed.  Object storage URLs need no directories
+func makeParentDir(path string) error {
+	if strings.Contains(path, "://") {
+		return nil
+	}
+	return os.MkdirAll(filepath.Dir(path), 0o755)
+}
+
+// withJobSink returns the client for the job, which when the client has a sink
+// per job is a copy writing to the job's own sink, with the function closing
+// it once the job is complete
+func (c *Client) withJobSink(job Job) (*Client, func() error, error) {
+	if c.jobSink == nil {
+		return c, func() error { return nil }, nil
+	}
+	sink, err := c.jobSink(job)
+	if err != nil {
+		return nil, nil, fmt.Errorf("output: %w", err)
+	}
+	jc := *c
+	jc.sink = sink
+	return &jc, sink.Close, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputTemplate(t *testing.T) {
+	started := time.Date(2026, 3, 1, 23, 30, 0, 0, time.FixedZone("east", 2*60*60))
+	tmpl, err := parseOutputTemplate("out/{label}-{date}/{hash}.csv", started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		job  Job
+		want string
+	}{
+		{job: Job{Hash: "h1", Label: "daily"}, want: "out/daily-2026-03-01/h1.csv"},
+		{job: Job{Hash: "h2"}, want: "out/h2-2026-03-01/h2.csv"},
+	} {
+		if got := tmpl.render(test.job); got != test.want {
+			t.Errorf("%+v: got %v, want %v", test.job, got, test.want)
+		}
+	}
+	// A value cannot add directories to the path
+	if got := tmpl.render(Job{Hash: "h", Label: "../x"}); strings.Count(got, "/") != 2 {
+		t.Errorf("got %v, want the label kept within its file name", got)
+	}
+}
+
+func TestOutputTemplateErrors(t *testing.T) {
+	for path, want := range map[string]string{
+		"out/{token}.csv": "unknown placeholder {token}",
+		"out/{hash.csv":   "unterminated {",
+		"out/hash}.csv":   "unexpected }",
+	} {
+		if _, err := parseOutputTemplate(path, time.Now()); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%v: got %v, want %q", path, err, want)
+		}
+	}
+
+	tmpl, err := parseOutputTemplate("out/{label}.csv", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.checkJobs([]Job{{Hash: "a", Label: "x"}, {Hash: "b"}, {Hash: "c", Label: "x"}}); err == nil || err.Error() != "jobs 1 and 3 have the same output out/x.csv" {
+		t.Errorf("got %v, want the shared output rejected", err)
+	}
+}
+
+func TestOutputTemplateFlag(t *testing.T) {
+	s := newPageServer(t, map[string][]byte{
+		"a1": testPage("", testColumns("id"), []string{"1"}),
+		"b1": testPage("", testColumns("id"), []string{"2"}, []string{"3"}),
+	})
+	dir := t.TempDir()
+	jobs := filepath.Join(dir, "jobs")
+	if err := os.WriteFile(jobs, []byte("ha a1 first\nhb b1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out", "{label}", "{hash}.csv")
+	if _, stderr, code := runMain(t, "-url", s.URL, "-jobs", jobs, "-output-format", "csv", "-output", output); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	for path, want := range map[string]string{
+		filepath.Join(dir, "out", "first", "ha.csv"): "id\n1\n",
+		filepath.Join(dir, "out", "hb", "hb.csv"):    "id\n2\n3\n",
+	} {
+		if b, err := os.ReadFile(path); err != nil || string(b) != want {
+			t.Errorf("%v: got %q, %v, want %q", path, b, err, want)
+		}
+	}
+
+	if _, stderr, code := runMain(t, "-url", s.URL, "-jobs", jobs, "-output-format", "csv", "-output", filepath.Join(dir, "{job}.csv")); code == 0 || !strings.Contains(stderr, "unknown placeholder {job}") {
+		t.Errorf("exit %v, stderr %q, want the unknown placeholder rejected", code, stderr)
+	}
+}
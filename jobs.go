@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job identifies an independent result set to be paginated, with an optional
+// label identifying its records in place of its hash
+type Job struct {
+	Hash  string
+	Token string
+	Label string
+}
+
+// RunResult holds the statistics of a pagination: the number of pages
+// retrieved and the records of each, the total durations of their retrieval,
+// of which the server's processing, and of unmarshalling, the number of
+// undecodable pages that were skipped, the number of records excluded by the
+// since filter, whether pagination was stopped early by the run for time
+// budget or the stop gate, the tally of response statuses received, the size
+// in bytes of each page, and the time the pagination took overall
+type RunResult struct {
+	PageCount         int
+	RecordCounts      []int
+	RequestDuration   time.Duration
+	ServerDuration    time.Duration
+	UnmarshalDuration time.Duration
+	SkippedPages      int
+	FilteredRecords   int
+	TimeLimited       bool
+	StatusCounts      StatusTally
+	PageSizes         []int64
+	Elapsed           time.Duration
+}
+
+// TotalRecords returns the number of records across all the pages
+func (r RunResult) TotalRecords() int {
+	return totalRecords(r.RecordCounts)
+}
+
+// JobResult holds the outcome of paginating a Job
+type JobResult struct {
+	Job Job
+	RunResult
+	Requeues int
+	Err      error
+}
+
+// Aggregate combines the results of a set of jobs.  Failed jobs are counted
+// but do not contribute to the totals
+type Aggregate struct {
+	Jobs              int
+	FailedJobs        int
+	PageCount         int
+	RecordCount       int
+	RequestDuration   time.Duration
+	ServerDuration    time.Duration
+	UnmarshalDuration time.Duration
+	SkippedPages      int
+	FilteredRecords   int
+	TimeLimitedJobs   int
+	StatusCounts      StatusTally
+	PageSizes         ByteStats
+	// Percentiles of the number of records per page, across all jobs
+	RecordsPerPageP50 int
+	RecordsPerPageP90 int
+	RecordsPerPageP99 int
+}
+
+// readJobs loads the jobs from the file, which has a hash, first token and
+// optional label per line separated by whitespace.  Blank lines and lines
+// starting with # are ignored
+func readJobs(path string) ([]Job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	jobs := []Job{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("%v:%v: expected hash, token and optional label", path, line)
+		}
+		job := Job{Hash: fields[0], Token: fields[1]}
+		if len(fields) == 3 {
+			job.Label = fields[2]
+		}
+		jobs = append(jobs, job)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%v: no jobs found", path)
+	}
+	return jobs, nil
+}
+
+// Handling of a failed job in a multi-job run
+const (
+	JobErrorContinue = "continue"
+	JobErrorFailFast = "fail-fast"
+)
+
+// errJobCancelled is the error of a job stopped, or never started, because an
+// earlier job failed with the fail fast policy
+var errJobCancelled = errors.New("cancelled after another job failed")
+
+// consumeJobs paginates the jobs, with at most concurrency jobs in progress at
+// any time.  The results are returned in the same order as the jobs.  With the
+// JobErrorFailFast policy the first failed job cancels the others, which fail
+// with errJobCancelled, whilst with JobErrorContinue all jobs are run.  A job
+// failing transiently, before writing any records, is requeued behind the
+// jobs waiting to start up to requeues times before it fails
+func (c *Client) consumeJobs(ctx context.Context, jobs []Job, concurrency int, policy string, requeues int) []JobResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]JobResult, len(jobs))
+	requeued := make([]int, len(jobs))
+
+	// A job is either queued or in progress, so the queue never holds more than all of them
+	queue := make(chan int, len(jobs))
+	for i := range jobs {
+		queue <- i
+	}
+	var pending sync.WaitGroup
+	pending.Add(len(jobs))
+	go func() {
+		pending.Wait()
+		close(queue)
+	}()
+
+	sem := make(chan struct{}, concurrency)
+	for i := range queue {
+		sem <- struct{}{}
+		go func(i int, job Job) {
+			defer func() { <-sem }()
+
+			r := JobResult{Job: job, Requeues: requeued[i]}
+			if context.Cause(ctx) == errJobCancelled {
+				r.Err = errJobCancelled
+				results[i] = r
+				pending.Done()
+				return
+			}
+			jc, wrote, closeSink, err := c.forAttempt(job)
+			if err != nil {
+				r.Err = err
+			} else {
+				r.RunResult, r.Err = jc.consumeAllPages(ctx, job.Hash, job.Token)
+				if err := closeSink(); err != nil && r.Err == nil {
+					r.Err = fmt.Errorf("output: %w", err)
+				}
+			}
+			if r.Err != nil && requeued[i] < requeues && ctx.Err() == nil && transientJobError(r.Err) && !wrote() {
+				requeued[i]++
+				log.Printf("Requeueing job: hash: %v, requeue: %v of %v, error: %v", job.Hash, requeued[i], requeues, r.Err)
+				queue <- i
+				return
+			}
+			if r.Err != nil && policy == JobErrorFailFast {
+				if context.Cause(ctx) == errJobCancelled {
+					r.Err = errJobCancelled
+				} else {
+					cancel(errJobCancelled)
+				}
+			}
+			results[i] = r
+			pending.Done()
+		}(i, jobs[i])
+	}
+
+	return results
+}
+
+// mergeChains combines the results of independently paginated chains of the same
+// hash into a single result, whose Job.Token lists the seed tokens of the chains.
+// The merged result fails if any chain failed, with the chain's token shown by redact
+func mergeChains(results []JobResult, redact TokenRedactor) JobResult {
+	merged := JobResult{RunResult: RunResult{RecordCounts: []int{}, StatusCounts: StatusTally{}}}
+	tokens := []string{}
+	for _, r := range results {
+		merged.Job.Hash = r.Job.Hash
+		tokens = append(tokens, r.Job.Token)
+		if r.Err != nil {
+			if merged.Err == nil {
+				merged.Err = fmt.Errorf("chain %v: %w", redact(r.Job.Token), r.Err)
+			}
+			continue
+		}
+		merged.PageCount += r.PageCount
+		merged.RecordCounts = append(merged.RecordCounts, r.RecordCounts...)
+		merged.RequestDuration += r.RequestDuration
+		merged.ServerDuration += r.ServerDuration
+		merged.UnmarshalDuration += r.UnmarshalDuration
+		merged.SkippedPages += r.SkippedPages
+		merged.FilteredRecords += r.FilteredRecords
+		merged.TimeLimited = merged.TimeLimited || r.TimeLimited
+		merged.StatusCounts.add(r.StatusCounts)
+		merged.PageSizes = append(merged.PageSizes, r.PageSizes...)
+		merged.Elapsed = max(merged.Elapsed, r.Elapsed)
+	}
+	merged.Job.Token = strings.Join(tokens, ",")
+	return merged
+}
+
+// ByteStats summarises the sizes of a set of pages
+type ByteStats struct {
+	Min  int64
+	Max  int64
+	Mean float64
+}
+
+func (b ByteStats) String() string {
+	return fmt.Sprintf("%v/%v/%.0f", b.Min, b.Max, b.Mean)
+}
+
+// pageByteStats returns the min, max and mean of the page sizes
+func pageByteStats(sizes []int64) ByteStats {
+	if len(sizes) == 0 {
+		return ByteStats{}
+	}
+	b := ByteStats{Min: sizes[0], Max: sizes[0]}
+	total := int64(0)
+	for _, size := range sizes {
+		if size < b.Min {
+			b.Min = size
+		}
+		if size > b.Max {
+			b.Max = size
+		}
+		total += size
+	}
+	b.Mean = float64(total) / float64(len(sizes))
+	return b
+}
+
+// percentile returns the nearest-rank p'th percentile of the sorted values
+func percentile(sorted []int, p int) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// AggregateResults combines the results of the jobs into a single set of totals,
+// with the records per page percentiles calculated over the pages of all successful jobs
+func AggregateResults(results []JobResult) Aggregate {
+	a := Aggregate{Jobs: len(results), StatusCounts: StatusTally{}}
+	recordCounts := []int{}
+	pageSizes := []int64{}
+	for _, r := range results {
+		if r.Err != nil {
+			a.FailedJobs++
+			continue
+		}
+		a.PageCount += r.PageCount
+		a.RecordCount += r.TotalRecords()
+		a.RequestDuration += r.RequestDuration
+		a.ServerDuration += r.ServerDuration
+		a.UnmarshalDuration += r.UnmarshalDuration
+		a.SkippedPages += r.SkippedPages
+		a.FilteredRecords += r.FilteredRecords
+		if r.TimeLimited {
+			a.TimeLimitedJobs++
+		}
+		a.StatusCounts.add(r.StatusCounts)
+		recordCounts = append(recordCounts, r.RecordCounts...)
+		pageSizes = append(pageSizes, r.PageSizes...)
+	}
+	a.PageSizes = pageByteStats(pageSizes)
+
+	sort.Ints(recordCounts)
+	a.RecordsPerPageP50 = percentile(recordCounts, 50)
+	a.RecordsPerPageP90 = percentile(recordCounts, 90)
+	a.RecordsPerPageP99 = percentile(recordCounts, 99)
+
+	return a
+}
+
+// printAggregate provides a formatted output of the combined activity of all jobs to w
+func printAggregate(w io.Writer, a Aggregate) {
+	fmt.Fprintf(w, "All jobs: %v, Failed: %v\n", a.Jobs, a.FailedJobs)
+	fmt.Fprintf(w, "  Pages: %v\n", a.PageCount)
+	fmt.Fprintf(w, "  Records: %v\n", a.RecordCount)
+	if a.SkippedPages > 0 {
+		fmt.Fprintf(w, "  Skipped pages: %v\n", a.SkippedPages)
+	}
+	if a.FilteredRecords > 0 {
+		fmt.Fprintf(w, "  Filtered records: %v\n", a.FilteredRecords)
+	}
+	if a.TimeLimitedJobs > 0 {
+		fmt.Fprintf(w, "  Jobs stopped early: %v\n", a.TimeLimitedJobs)
+	}
+	fmt.Fprintf(w, "  HTTP statuses: %v\n", a.StatusCounts)
+	fmt.Fprintf(w, "  Records per page (p50/p90/p99): %v/%v/%v\n", a.RecordsPerPageP50, a.RecordsPerPageP90, a.RecordsPerPageP99)
+	fmt.Fprintf(w, "  Page bytes (min/max/mean): %v\n", a.PageSizes)
+	fmt.Fprintf(w, "  Duration to retrieve pages: %v\n", a.RequestDuration)
+	if a.ServerDuration > 0 {
+		fmt.Fprintf(w, "    Server processing: %v, network and queueing: %v\n", a.ServerDuration, a.RequestDuration-a.ServerDuration)
+	}
+	fmt.Fprintf(w, "  Duration to unmarshal pages: %v\n", a.UnmarshalDuration)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggregateResults(t *testing.T) {
+	results := []JobResult{
+		{Job: Job{Hash: "a"}, RunResult: RunResult{PageCount: 2, RecordCounts: []int{10, 5}, RequestDuration: time.Second, UnmarshalDuration: time.Millisecond,
+			SkippedPages: 1, FilteredRecords: 3, StatusCounts: StatusTally{"2xx": 3}, PageSizes: []int64{100, 50}}},
+		{Job: Job{Hash: "b"}, RunResult: RunResult{PageCount: 3, RecordCounts: []int{1, 2, 3}, RequestDuration: 2 * time.Second, UnmarshalDuration: 2 * time.Millisecond,
+			TimeLimited: true, StatusCounts: StatusTally{"2xx": 3, "5xx": 1}, PageSizes: []int64{10, 20, 30}}},
+		{Job: Job{Hash: "c"}, RunResult: RunResult{PageCount: 9, RecordCounts: []int{100}}, Err: errors.New("failed")},
+	}
+
+	a := AggregateResults(results)
+	want := Aggregate{
+		Jobs:              3,
+		FailedJobs:        1,
+		PageCount:         5,
+		RecordCount:       21,
+		RequestDuration:   3 * time.Second,
+		UnmarshalDuration: 3 * time.Millisecond,
+		SkippedPages:      1,
+		FilteredRecords:   3,
+		TimeLimitedJobs:   1,
+		StatusCounts:      StatusTally{"2xx": 6, "5xx": 1},
+		PageSizes:         ByteStats{Min: 10, Max: 100, Mean: 42},
+		RecordsPerPageP50: 3,
+		RecordsPerPageP90: 10,
+		RecordsPerPageP99: 10,
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("got %+v\nwant %+v", a, want)
+	}
+}
+
+func TestConsumeJobsAggregate(t *testing.T) {
+	columns := testColumns("id")
+	pages := chainPages(columns, []string{"a1", "a2"}, [][][]string{{{"1"}, {"2"}}, {{"3"}}})
+	for token, page := range chainPages(columns, []string{"b1", "b2", "b3"}, [][][]string{{{"4"}}, {{"5"}, {"6"}, {"7"}}, {}}) {
+		pages[token] = page
+	}
+	s := newPageServer(t, pages)
+
+	jobs := []Job{{Hash: "a", Token: "a1"}, {Hash: "b", Token: "b1"}}
+	results := NewClient(s.URL).consumeJobs(context.Background(), jobs, 2, JobErrorContinue, 0)
+	a := AggregateResults(results)
+
+	pageCount, recordCount := 0, 0
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatalf("job %v: %v", r.Job.Hash, r.Err)
+		}
+		pageCount += r.PageCount
+		recordCount += r.TotalRecords()
+	}
+	if a.Jobs != 2 || a.PageCount != pageCount || a.RecordCount != recordCount {
+		t.Errorf("aggregate of %v jobs, %v pages, %v records, want 2 jobs with the sum of %v and %v", a.Jobs, a.PageCount, a.RecordCount, pageCount, recordCount)
+	}
+	if pageCount != 5 || recordCount != 7 {
+		t.Errorf("jobs retrieved %v pages of %v records, want 5 of 7", pageCount, recordCount)
+	}
+	if results[0].Job.Hash != "a" || results[1].Job.Hash != "b" {
+		t.Errorf("results not in job order: %v, %v", results[0].Job, results[1].Job)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for p, want := range map[int]int{0: 1, 50: 5, 90: 9, 99: 10, 100: 10} {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("p%v: got %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("p50 of none: got %v", got)
+	}
+}
+
+func TestPrintAggregate(t *testing.T) {
+	a := Aggregate{
+		Jobs:              2,
+		FailedJobs:        1,
+		PageCount:         4,
+		RecordCount:       40,
+		RequestDuration:   2 * time.Second,
+		ServerDuration:    500 * time.Millisecond,
+		UnmarshalDuration: 10 * time.Millisecond,
+		StatusCounts:      StatusTally{"2xx": 4},
+		PageSizes:         ByteStats{Min: 10, Max: 30, Mean: 20},
+		RecordsPerPageP50: 10,
+		RecordsPerPageP90: 10,
+		RecordsPerPageP99: 10,
+	}
+	var buf bytes.Buffer
+	printAggregate(&buf, a)
+	want := `All jobs: 2, Failed: 1
+  Pages: 4
+  Records: 40
+  HTTP statuses: 2xx: 4
+  Records per page (p50/p90/p99): 10/10/10
+  Page bytes (min/max/mean): 10/30/20
+  Duration to retrieve pages: 2s
+    Server processing: 500ms, network and queueing: 1.5s
+  Duration to unmarshal pages: 10ms
+`
+	if buf.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", buf.String(), want)
+	}
+}
+
+func TestMergeChains(t *testing.T) {
+	results := []JobResult{
+		{Job: Job{Hash: "h", Token: "a1"}, RunResult: RunResult{PageCount: 2, RecordCounts: []int{2, 1}, Elapsed: time.Second, StatusCounts: StatusTally{"2xx": 2}}},
+		{Job: Job{Hash: "h", Token: "b1"}, RunResult: RunResult{PageCount: 1, RecordCounts: []int{4}, Elapsed: 2 * time.Second, StatusCounts: StatusTally{"2xx": 1}}},
+	}
+	merged := mergeChains(results, RawToken)
+	if merged.Err != nil || merged.Job != (Job{Hash: "h", Token: "a1,b1"}) || merged.PageCount != 3 || merged.TotalRecords() != 7 ||
+		merged.Elapsed != 2*time.Second || !reflect.DeepEqual(merged.StatusCounts, StatusTally{"2xx": 3}) {
+		t.Errorf("got %+v", merged)
+	}
+
+	results[1].Err = errors.New("failed")
+	if merged := mergeChains(results, RawToken); merged.Err == nil || merged.Err.Error() != "chain b1: failed" {
+		t.Errorf("got error %v, want chain b1: failed", merged.Err)
+	}
+}
+
+func TestSeedTokens(t *testing.T) {
+	columns := testColumns("id")
+	pages := chainPages(columns, []string{"a1", "a2"}, [][][]string{{{"1"}, {"2"}}, {{"3"}}})
+	for token, page := range chainPages(columns, []string{"b1", "b2"}, [][][]string{{{"4"}}, {{"5"}}}) {
+		pages[token] = page
+	}
+	s := newPageServer(t, pages)
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-tokens", "a1,b1", "-output-format", "csv", "-output", "-")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	sort.Strings(lines[1:])
+	if want := []string{"id", "1", "2", "3", "4", "5"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("output %v, want %v", lines, want)
+	}
+	if !strings.Contains(stderr, "Hash: h, First Token: ") || !strings.Contains(stderr, "Pages: 4\n") || !strings.Contains(stderr, "Records: 5\n") {
+		t.Errorf("summary %q, want a merged 4 pages of 5 records", stderr)
+	}
+	tokens := s.tokens()
+	sort.Strings(tokens)
+	if want := []string{"a1", "a2", "b1", "b2"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("requested %v, want %v", tokens, want)
+	}
+}
+
+func TestPageByteStats(t *testing.T) {
+	if got := pageByteStats(nil); got != (ByteStats{}) {
+		t.Errorf("stats of no pages %+v", got)
+	}
+	if got, want := pageByteStats([]int64{30, 10, 20, 41}), (ByteStats{Min: 10, Max: 41, Mean: 25.25}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := (ByteStats{Min: 10, Max: 41, Mean: 25.25}).String(); got != "10/41/25" {
+		t.Errorf("formatted as %q", got)
+	}
+}
+
+func TestPageSizesOfRun(t *testing.T) {
+	columns := testColumns("id", "name")
+	pages := chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{
+		{{"1", "a"}},
+		{{"2", strings.Repeat("b", 500)}, {"3", "c"}},
+		{},
+	})
+	s := newPageServer(t, pages)
+
+	r, err := NewClient(s.URL).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{int64(len(pages["t1"])), int64(len(pages["t2"])), int64(len(pages["t3"]))}
+	if !reflect.DeepEqual(r.PageSizes, want) {
+		t.Errorf("page sizes %v, want the fixture sizes %v", r.PageSizes, want)
+	}
+	stats := pageByteStats(r.PageSizes)
+	if stats.Min != want[2] || stats.Max != want[1] || stats.Mean != float64(want[0]+want[1]+want[2])/3 {
+		t.Errorf("stats %+v of the sizes %v", stats, want)
+	}
+}
+
+func TestJobErrorPolicy(t *testing.T) {
+	columns := testColumns("id")
+	pages := chainPages(columns, []string{"a1", "a2"}, [][][]string{{{"1"}}, {{"2"}}})
+	pages["b1"] = testPage("missing", columns, []string{"3"})
+	for token, page := range chainPages(columns, []string{"c1", "c2"}, [][][]string{{{"4"}}, {{"5"}}}) {
+		pages[token] = page
+	}
+	s := newPageServer(t, pages)
+	jobs := []Job{{Hash: "a", Token: "a1"}, {Hash: "b", Token: "b1"}, {Hash: "c", Token: "c1"}}
+
+	// Run one at a time, the failure of b precedes c
+	results := NewClient(s.URL).consumeJobs(context.Background(), jobs, 1, JobErrorContinue, 0)
+	if results[0].Err != nil || results[1].Err == nil || results[2].Err != nil || results[2].TotalRecords() != 2 {
+		t.Errorf("continue: got errors %v, %v, %v", results[0].Err, results[1].Err, results[2].Err)
+	}
+
+	results = NewClient(s.URL).consumeJobs(context.Background(), jobs, 1, JobErrorFailFast, 0)
+	if results[0].Err != nil || results[1].Err == nil || errors.Is(results[1].Err, errJobCancelled) || !errors.Is(results[2].Err, errJobCancelled) {
+		t.Errorf("fail-fast: got errors %v, %v, %v", results[0].Err, results[1].Err, results[2].Err)
+	}
+}
+
+func TestOnJobErrorFlag(t *testing.T) {
+	columns := testColumns("id")
+	pages := chainPages(columns, []string{"a1"}, [][][]string{{{"1"}}})
+	for token, page := range chainPages(columns, []string{"c1"}, [][][]string{{{"2"}}}) {
+		pages[token] = page
+	}
+	s := newPageServer(t, pages)
+	path := filepath.Join(t.TempDir(), "jobs")
+	if err := os.WriteFile(path, []byte("# hash token label\na a1 first\n\nb b1 failing\nc c1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		policy string
+		// requested is the number of pages requested of the server by the run
+		requested int
+	}{
+		{policy: JobErrorContinue, requested: 3},
+		{policy: JobErrorFailFast, requested: 2},
+	} {
+		start := len(s.received())
+		_, stderr, code := runMain(t, "-url", s.URL, "-jobs", path, "-concurrency", "1", "-on-job-error", test.policy)
+		if code == 0 || !strings.Contains(stderr, "of 3 jobs failed") {
+			t.Errorf("%v: exit %v, stderr %q", test.policy, code, stderr)
+		}
+		if n := len(s.received()) - start; n != test.requested {
+			t.Errorf("%v: requested %v pages, want %v", test.policy, n, test.requested)
+		}
+	}
+}
+
+func TestReadJobs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "jobs")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	jobs, err := readJobs(write("# comment\na a1 first\n\n  b b1  \n"))
+	if want := []Job{{Hash: "a", Token: "a1", Label: "first"}, {Hash: "b", Token: "b1"}}; err != nil || !reflect.DeepEqual(jobs, want) {
+		t.Errorf("got %v, %v, want %v", jobs, err, want)
+	}
+	for _, content := range []string{"# none\n", "a\n", "a a1 label extra\n"} {
+		if _, err := readJobs(write(content)); err == nil {
+			t.Errorf("%q: read", content)
+		}
+	}
+}
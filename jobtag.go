@@ -0,0 +1,55 @@
+package main
+
+import "fmt"
+
+// jobTagSink is a RecordSink prepending a column holding a tag identifying the
+// job to each record, before writing it to the sink shared by all jobs
+type jobTagSink struct {
+	sink   RecordSink
+	column string
+	tag    string
+}
+
+// WriteRecords writes the records to the shared sink, with the tag column at
+// position 0 and the other columns after it
+func (j *jobTagSink) WriteRecords(columns []Column, records [][]string) error {
+	tagged := make([]Column, 0, len(columns)+1)
+	tagged = append(tagged, Column{Name: j.column, Type: "string", Position: 0})
+	for _, col := range columns {
+		if col.Name == j.column {
+			return fmt.Errorf("job tag column %q is also a column of the result set", j.column)
+		}
+		col.Position++
+		tagged = append(tagged, col)
+	}
+
+	taggedRecords := make([][]string, len(records))
+	for i, record := range records {
+		taggedRecords[i] = append([]string{j.tag}, record...)
+	}
+	return j.sink.WriteRecords(tagged, taggedRecords)
+}
+
+// Close does nothing, as the shared sink is closed once all jobs are complete
+func (j *jobTagSink) Close() error {
+	return nil
+}
+
+// jobTag returns the tag of the job's records: its label, or otherwise its hash
+func jobTag(job Job) string {
+	if len(job.Label) > 0 {
+		return job.Label
+	}
+	return job.Hash
+}
+
+// forJob returns the client to paginate the job, which when there is a job tag
+// column is a copy writing to a jobTagSink around the client's sink
+func (c *Client) forJob(job Job) *Client {
+	if len(c.jobTagColumn) == 0 || c.sink == nil {
+		return c
+	}
+	jc := *c
+	jc.sink = &jobTagSink{sink: c.sink, column: c.jobTagColumn, tag: jobTag(job)}
+	return &jc
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// twoJobPages returns the pages of job a, a1 -> a2, and job b, b1
+func twoJobPages() map[string][]byte {
+	pages := chainPages(testColumns("id"), []string{"a1", "a2"}, [][][]string{{{"a-1"}}, {{"a-2"}}})
+	for token, page := range chainPages(testColumns("id"), []string{"b1"}, [][][]string{{{"b-1"}, {"b-2"}}}) {
+		pages[token] = page
+	}
+	return pages
+}
+
+func TestJobTagColumn(t *testing.T) {
+	s := newPageServer(t, twoJobPages())
+	sink := &memorySink{}
+	c := NewClient(s.URL, WithRecordSink(sink), WithJobTagColumn("job"))
+	jobs := []Job{{Hash: "ha", Token: "a1", Label: "first"}, {Hash: "hb", Token: "b1"}}
+	for _, r := range c.consumeJobs(context.Background(), jobs, 2, JobErrorContinue, 0) {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+
+	if sink.columns[0].Name != "job" || sink.columns[0].Position != 0 || sink.columns[1].Name != "id" || sink.columns[1].Position != 1 {
+		t.Errorf("columns %+v, want job prepended", sink.columns)
+	}
+	got := []string{}
+	for _, record := range sink.records {
+		got = append(got, strings.Join(record, ":"))
+	}
+	slices.Sort(got)
+	if want := []string{"first:a-1", "first:a-2", "hb:b-1", "hb:b-2"}; !slices.Equal(got, want) {
+		t.Errorf("wrote %v, want each record tagged with its job's label, or otherwise hash", got)
+	}
+}
+
+func TestJobTagColumnCollision(t *testing.T) {
+	s := newPageServer(t, twoJobPages())
+	_, err := NewClient(s.URL, WithRecordSink(&memorySink{}), WithJobTagColumn("id")).forJob(Job{Hash: "ha", Token: "a1"}).consumeAllPages(context.Background(), "ha", "a1")
+	if err == nil || !strings.Contains(err.Error(), `job tag column "id" is also a column`) {
+		t.Errorf("got %v, want an error of the colliding column", err)
+	}
+}
+
+func TestJobTagColumnFlag(t *testing.T) {
+	s := newPageServer(t, twoJobPages())
+	jobsPath := filepath.Join(t.TempDir(), "jobs")
+	if err := os.WriteFile(jobsPath, []byte("ha a1 first\nhb b1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-jobs", jobsPath, "-job-tag-column", "job", "-output-format", "csv", "-concurrency", "1")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if stdout != "job,id\nfirst,a-1\nfirst,a-2\nhb,b-1\nhb,b-2\n" {
+		t.Errorf("stdout %q", stdout)
+	}
+}
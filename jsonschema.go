@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+)
+
+// jsonSchemaDraft identifies the JSON Schema version of the documents written
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchemaProperty describes the value of a column in an NDJSON record object
+type jsonSchemaProperty struct {
+	Type        string `json:"type"`
+	MinLength   int    `json:"minLength,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// jsonSchema is a JSON Schema document describing the NDJSON record objects
+type jsonSchema struct {
+	Schema               string                        `json:"$schema"`
+	Type                 string                        `json:"type"`
+	Properties           map[string]jsonSchemaProperty `json:"properties"`
+	Required             []string                      `json:"required,omitempty"`
+	AdditionalProperties bool                          `json:"additionalProperties"`
+}
+
+// newJSONSchema returns the schema of the NDJSON record objects of the columns.
+// As the records hold every value as a string, with null as "", each property
+// is a string described by its column's type.  The values of columns that are
+// not nullable are required, and not empty.  As in the objects, the last
+// column of a name gives its property
+func newJSONSchema(columns []Column) jsonSchema {
+	schema := jsonSchema{
+		Schema:     jsonSchemaDraft,
+		Type:       "object",
+		Properties: map[string]jsonSchemaProperty{},
+	}
+	notNull := map[string]bool{}
+	for _, col := range columns {
+		prop := jsonSchemaProperty{Type: "string", Description: col.Type}
+		notNull[col.Name] = col.Nullable != nil && !*col.Nullable
+		if notNull[col.Name] {
+			prop.MinLength = 1
+		}
+		schema.Properties[col.Name] = prop
+	}
+	for name, required := range notNull {
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	slices.Sort(schema.Required)
+	return schema
+}
+
+// jsonSchemaSink is a RecordSink noting the columns of the records written to
+// its sink, and on closing writes the JSON Schema of their NDJSON record objects
+// to the file at path.  Should pages have differing columns, the schema has the
+// columns of every page, with the latest page giving those of the same name
+type jsonSchemaSink struct {
+	sink    RecordSink
+	path    string
+	mu      sync.Mutex
+	names   []string
+	columns map[string]Column
+}
+
+// newJSONSchemaSink returns a jsonSchemaSink writing the schema to path
+func newJSONSchemaSink(sink RecordSink, path string) *jsonSchemaSink {
+	return &jsonSchemaSink{sink: sink, path: path, columns: map[string]Column{}}
+}
+
+// WriteRecords notes the columns, and writes the records to the sink
+func (j *jsonSchemaSink) WriteRecords(columns []Column, records [][]string) error {
+	j.mu.Lock()
+	for _, col := range columns {
+		if _, ok := j.columns[col.Name]; !ok {
+			j.names = append(j.names, col.Name)
+		}
+		j.columns[col.Name] = col
+	}
+	j.mu.Unlock()
+	return j.sink.WriteRecords(columns, records)
+}
+
+// Close closes the sink and writes the schema, returning the first error
+func (j *jsonSchemaSink) Close() error {
+	err := j.sink.Close()
+
+	columns := make([]Column, 0, len(j.names))
+	for _, name := range j.names {
+		columns = append(columns, j.columns[name])
+	}
+	b, serr := json.MarshalIndent(newJSONSchema(columns), "", "  ")
+	if serr == nil {
+		serr = writeFileAtomic(j.path, append(b, '\n'))
+	}
+	if err == nil {
+		err = serr
+	}
+	return err
+}
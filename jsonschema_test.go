@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// compileSchema returns the validator of the JSON Schema document in the file at path
+func compileSchema(t *testing.T, path string) *jsonschema.Schema {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("schema.json", doc); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := c.Compile("schema.json")
+	if err != nil {
+		t.Fatalf("invalid schema %s: %v", b, err)
+	}
+	return schema
+}
+
+func TestNewJSONSchema(t *testing.T) {
+	notNullable, nullable := false, true
+	schema := newJSONSchema([]Column{{Name: "id", Type: ColumnTypeInt, Nullable: &notNullable}, {Name: "name", Type: ColumnTypeString, Nullable: &nullable},
+		{Name: "at", Type: ColumnTypeTimestamp}, {Name: "id", Type: ColumnTypeString, Nullable: &nullable}})
+	want := jsonSchema{
+		Schema: jsonSchemaDraft,
+		Type:   "object",
+		Properties: map[string]jsonSchemaProperty{
+			"id":   {Type: "string", Description: ColumnTypeString},
+			"name": {Type: "string", Description: ColumnTypeString},
+			"at":   {Type: "string", Description: ColumnTypeTimestamp},
+		},
+	}
+	if !reflect.DeepEqual(schema, want) {
+		t.Errorf("got %+v, want %+v, with the last id column giving its property", schema, want)
+	}
+}
+
+func TestJSONSchemaOut(t *testing.T) {
+	notNullable, nullable := false, true
+	columns := []Column{{Name: "id", Type: ColumnTypeInt, Position: 0, Nullable: &notNullable}, {Name: "name", Type: ColumnTypeString, Position: 1, Nullable: &nullable},
+		{Name: "note", Type: ColumnTypeString, Position: 2}}
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1", "a", ""}, {"2", "", "x"}}, {{"3", "c", "y"}}}))
+	dir := t.TempDir()
+	output, schemaPath := filepath.Join(dir, "out.ndjson"), filepath.Join(dir, "schema.json")
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "ndjson", "-output", output, "-jsonschema-out", schemaPath); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+
+	schema := compileSchema(t, schemaPath)
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); n++ {
+		record, err := jsonschema.UnmarshalJSON(strings.NewReader(scanner.Text()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := schema.Validate(record); err != nil {
+			t.Errorf("record %s: %v", scanner.Text(), err)
+		}
+	}
+	if n != 3 {
+		t.Errorf("validated %v records, want 3", n)
+	}
+
+	// Objects not of the shape of the records are rejected
+	for _, invalid := range []string{`{"name":"a","note":""}`, `{"id":"","name":"a","note":""}`, `{"id":1,"name":"a","note":""}`, `{"id":"1","name":"a","note":"","other":""}`} {
+		record, err := jsonschema.UnmarshalJSON(strings.NewReader(invalid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := schema.Validate(record); err == nil {
+			t.Errorf("%s accepted", invalid)
+		}
+	}
+}
+
+func TestJSONSchemaOutRequiresNDJSON(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1"}, [][][]string{{{"1"}}}))
+	dir := t.TempDir()
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", filepath.Join(dir, "out.csv"), "-jsonschema-out", filepath.Join(dir, "schema.json")); code == 0 {
+		t.Error("-jsonschema-out accepted without ndjson output")
+	}
+}
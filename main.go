@@ -1,24 +1,35 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 )
 
 type Request struct {
-	Hash  string `json:"hash"`
-	Token string `json:"token"`
+	Hash   string   `json:"hash"`
+	Token  string   `json:"token"`
+	Fields []string `json:"fields,omitempty"`
 }
 
 type Column struct {
 	Name     string `json:"name"`
 	Type     string `json:"type"`
 	Position int    `json:"position"`
+	// Nullable is whether the column may hold nulls, if the server says
+	Nullable *bool `json:"nullable,omitempty"`
 }
 
 type Header struct {
@@ -26,12 +37,14 @@ type Header struct {
 }
 
 type Data struct {
-	Header  Header     `json:"header"`
-	Records [][]string `json:"records"`
+	Header  Header  `json:"header"`
+	Records Records `json:"records"`
 }
 
 type Meta struct {
-	NextToken string `json:"next"`
+	NextToken string   `json:"next"`
+	Total     *int     `json:"total,omitempty"`
+	Shards    []string `json:"shards,omitempty"`
 }
 
 type ResultSet struct {
@@ -39,103 +52,960 @@ type ResultSet struct {
 	Data Data `json:"data"`
 }
 
-// consumePage processes the specified (hash, token) page details, retrieving the page
-// and unmarshalling the return JSON results into a ResultSet.
-// The duration to retrieve and unmarshal are determined, as is the number of records and
-// the token for the next page (with "" signifying no further pages)
-func consumePage(url, hash, token string) (string, int, time.Duration, time.Duration, error) {
-	var err error
+// totalRecords returns the sum of the per page record counts
+func totalRecords(recordCounts []int) int {
+	records := 0
+	for _, recordCount := range recordCounts {
+		records += recordCount
+	}
+	return records
+}
 
-	r := Request{Hash: hash, Token: token}
+// smallPages returns the number of pages with fewer than minRecords records
+func smallPages(recordCounts []int, minRecords int) int {
+	pages := 0
+	for _, recordCount := range recordCounts {
+		if recordCount < minRecords {
+			pages++
+		}
+	}
+	return pages
+}
 
-	jsonData, err := json.Marshal(r)
+// printConsumption provides a formatted output of the activity to w
+func printConsumption(w io.Writer, hash, firstToken string, r RunResult, stoppedEarly string, minRecordsPerPage, requeues int, err error) {
+	fmt.Fprintf(w, "Hash: %v, First Token: %v\n", hash, firstToken)
+	if requeues > 0 {
+		fmt.Fprintf(w, "  Requeued: %v\n", requeues)
+	}
 	if err != nil {
-		return "", 0, time.Duration(0), time.Duration(0), err
+		fmt.Fprintf(w, "Error: %v\n", err)
+		return
 	}
 
-	t1 := time.Now()
+	fmt.Fprintf(w, "  Pages: %v\n", r.PageCount)
+	fmt.Fprintf(w, "  Records: %v\n", r.TotalRecords())
+	if r.SkippedPages > 0 {
+		fmt.Fprintf(w, "  Skipped pages: %v\n", r.SkippedPages)
+	}
+	if small := smallPages(r.RecordCounts, minRecordsPerPage); small > 0 {
+		fmt.Fprintf(w, "  Warning: pages with fewer than %v records: %v\n", minRecordsPerPage, small)
+	}
+	if r.FilteredRecords > 0 {
+		fmt.Fprintf(w, "  Filtered records: %v\n", r.FilteredRecords)
+	}
+	fmt.Fprintf(w, "  HTTP statuses: %v\n", r.StatusCounts)
+	fmt.Fprintf(w, "  Page bytes (min/max/mean): %v\n", pageByteStats(r.PageSizes))
+	fmt.Fprintf(w, "  Duration to retrieve pages: %v\n", r.RequestDuration)
+	if r.ServerDuration > 0 {
+		fmt.Fprintf(w, "    Server processing: %v, network and queueing: %v\n", r.ServerDuration, r.RequestDuration-r.ServerDuration)
+	}
+	fmt.Fprintf(w, "  Duration to unmarshal pages: %v\n", r.UnmarshalDuration)
+	fmt.Fprintf(w, "  Elapsed: %v\n", r.Elapsed)
+	if len(stoppedEarly) > 0 {
+		fmt.Fprintf(w, "  Stopped early: %v\n", stoppedEarly)
+	}
+}
 
-	resp, err := http.Post(url+"/page", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", 0, time.Duration(0), time.Duration(0), err
+// stopReason returns why the job stopped before its last page, or "" if it did not
+func stopReason(r JobResult, gate *StopGate) string {
+	switch {
+	case !r.TimeLimited:
+		return ""
+	case gate.Stopped():
+		return "stop requested"
 	}
+	return "run time budget reached"
+}
 
-	t2 := time.Now()
+func main() {
+
+	baseURL := flag.String("url", "http://localhost:8090", "URL to dataproxy")
+	hash := flag.String("hash", "", "Hash of request")
+	firstToken := flag.String("token", "", "Token of first page, or @file to continue from the -manifest of an earlier run")
+	autoFirstToken := flag.Bool("auto-first-token", false, "Request the token of the first page of -hash from the server, in place of -token")
+	firstTokenPath := flag.String("first-token-path", defaultFirstTokenPath, "Path, relative to -url, of the endpoint returning the first token of a hash for -auto-first-token")
+	seedTokens := flag.String("tokens", "", "Comma separated first page tokens of independently paginated chains of -hash, reported together")
+	jobsFile := flag.String("jobs", "", "File of jobs to retrieve instead of -hash and -token, one per line as a hash, first token and optional label")
+	onJobError := flag.String("on-job-error", JobErrorContinue, "Handling of a failed job: continue with the other jobs, or fail-fast cancelling them; either way failed jobs exit nonzero")
+	jobRequeues := flag.Int("job-requeues", 0, "Times a job failing with a network error, 429 or 5xx once its retries are exhausted is requeued behind the waiting jobs, if it has not yet written any records")
+	concurrency := flag.Int("concurrency", 4, "Maximum number of jobs, or shards of a job, retrieved in parallel")
+	pagination := flag.String("pagination", PaginationToken, "Pagination style of the server: token, or offset for offset and limit requests with -token as the starting offset (default 0)")
+	pageTargetDuration := flag.Duration("page-target-duration", 0, "Time to retrieve each page that -page-limit adapts towards with -pagination offset, with 0 keeping the limit fixed")
+	pageLimitMin := flag.Int("page-limit-min", defaultMinPageLimit, "Smallest limit that -page-target-duration adapts to")
+	pageLimitMax := flag.Int("page-limit-max", defaultMaxPageLimit, "Largest limit that -page-target-duration adapts to")
+	pageLimit := flag.Int("page-limit", defaultPageLimit, "Records requested per page with -pagination offset")
+	nextTokenPath := flag.String("next-token-path", defaultNextTokenPath, "Dot separated path to the next page token in each page")
+	onDecodeError := flag.String("on-decode-error", DecodeErrorAbort, "Handling of undecodable pages: abort or skip")
+	maxConsecutiveErrors := flag.Int("max-consecutive-errors", 0, "With -on-decode-error skip, abort once this many pages in a row are skipped, with 0 for no limit")
+	idempotencyKeys := flag.Bool("idempotency-keys", false, "Send an Idempotency-Key header identifying each page")
+	preflight := flag.Bool("preflight", false, "Report the methods and headers a CORS preflight OPTIONS request to the page endpoint allows, before the run.  Not with -replay-dir, which has no server to ask")
+	preflightRequire := flag.Bool("preflight-require", false, "As -preflight, but fail the run unless the preflight succeeds and allows the page requests")
+	warmupConnection := flag.Bool("warmup-connection", false, "Establish a connection to the server with a HEAD request before the run, so that connection setup is not included in the duration of the first page.  As the page endpoint accepts only POST, a 405 response to the HEAD is expected and harmless.  Ignored with -replay-dir")
+	stopFile := flag.String("stop-file", "", "File whose appearance, checked every second, stops the run cleanly once the pages in progress complete, as does SIGTERM")
+	pauseFile := flag.String("pause-file", "", "File whose presence, checked every second, pauses pagination between pages until it is removed")
+	runFor := flag.Duration("run-for", 0, "Time budget after which pagination stops cleanly with the pages retrieved so far")
+	deadline := flag.Duration("deadline", 0, "Overall deadline for retrieving a result set, after which it fails, cancelling any request in progress")
+	params := queryParams{}
+	flag.Var(params, "query-param", "Query parameter key=value added to each page request (repeatable)")
+	retries := retryPolicies{}
+	flag.Var(retries, "retry", "Retry policy class=attempts[:backoff] for failed page requests, with classes network, tls, 5xx and 429 (repeatable)")
+	decompressors := decompressCommands{}
+	flag.Var(decompressors, "decompress-command", "Content encoding and the command decompressing it from stdin to stdout, as encoding=command, such as br=brotli -dc, added to the Accept-Encoding of requests (repeatable)")
+	throttleOn429 := flag.Bool("throttle-on-429", false, "Space page requests across all jobs at a rate that halves on each 429 response and recovers after sustained success")
+	throttleRate := flag.Float64("throttle-rate", 10, "Initial request rate per second for -throttle-on-429")
+	throttleMinRate := flag.Float64("throttle-min-rate", 1, "Minimum request rate per second for -throttle-on-429, also the step by which it recovers")
+	throttleMaxRate := flag.Float64("throttle-max-rate", 100, "Maximum request rate per second for -throttle-on-429")
+	maxConnections := flag.Int("max-concurrent-connections", 0, "Maximum connections to the server at once across all jobs, with jobs waiting for a free connection, and 0 for no limit")
+	maxConcurrentRetries := flag.Int("max-concurrent-retries", 0, "Maximum retries in progress at once across all jobs, with 0 for no limit")
+	maxRedirects := flag.Int("max-redirects", defaultMaxRedirects, "Maximum redirects followed by a page request")
+	authTokenFile := flag.String("auth-token-file", "", "File holding the bearer token for page requests, read again after -auth-token-file-ttl so that rotated tokens are used, with failed reads retried under the network -retry policy")
+	authTokenFileTTL := flag.Duration("auth-token-file-ttl", defaultTokenFileTTL, "How long a token read from -auth-token-file is used before the file is read again")
+	authTokenCommand := flag.String("auth-token-command", "", "Command run before each page request whose output is the bearer token, such as a CLI printing a short lived access token")
+	oauthTokenURL := flag.String("oauth-token-url", "", "OAuth2 token endpoint from which bearer tokens are obtained with the client credentials grant, and refreshed before they expire")
+	oauthClientID := flag.String("oauth-client-id", "", "Client id for -oauth-token-url")
+	oauthSecretEnv := flag.String("oauth-client-secret-env", defaultOAuthSecretEnv, "Environment variable holding the client secret for -oauth-token-url")
+	oauthScopes := flag.String("oauth-scopes", "", "Comma separated scopes requested from -oauth-token-url")
+	redirectAuth := flag.String("redirect-auth", RedirectAuthStrip, "Authorization header on redirects to another host: strip or preserve")
+	var pins stringList
+	flag.Var(&pins, "pin", "SHA-256 fingerprint, in hex, of an accepted server certificate (repeatable)")
+	retryOnEmpty := flag.Int("retry-on-empty", 0, "Times to retry an empty final page reached from an earlier page, in case it is spurious")
+	retryOnEmptyBackoff := flag.Duration("retry-on-empty-backoff", 500*time.Millisecond, "Delay before the first -retry-on-empty retry, doubling for each further retry")
+	replayDir := flag.String("replay-dir", "", "Directory of captured page responses, named by token, to read instead of the server")
+	captureDir := flag.String("capture-dir", "", "Directory to which each page response is written, named by token, for later -replay-dir runs")
+	captureCompress := flag.String("capture-compress", CaptureCompressNone, "Compression of the pages written to -capture-dir: none, gzip or zstd, which -replay-dir reads by their file extension")
+	captureOverwrite := flag.Bool("capture-overwrite", false, "Replace existing captures in -capture-dir, rather than failing")
+	cacheDir := flag.String("cache-dir", "", "Directory caching pages served with an ETag, avoiding downloading unchanged pages")
+	pageCacheSize := flag.Int("page-cache-size", 0, "Decoded pages held in memory by hash and token, so that jobs repeating pages already retrieved in the run, such as when requeued, reuse them without a request, with 0 for no cache")
+	envelope := flag.String("envelope", "", "Unwrap each page from an envelope such as {\"status\":\"ok\",\"result\":{...}}: \"default\", or comma separated key=value names of its status, ok, result and message fields, e.g. status=state,ok=success")
+	serverTimeHeader := flag.String("server-time-header", "", "Response header giving the server's processing time, e.g. Server-Timing or X-Processing-Time, to split request time into server and network time")
+	maxServerTime := flag.Duration("max-server-time", 0, "Budget for the server's processing time of each page, given by -server-time-header, with 0 disabling it")
+	onMaxServerTime := flag.String("on-max-server-time", ServerTimeWarn, "Handling of a page exceeding -max-server-time: warn, or abort the run")
+	nextPageHints := flag.Bool("next-page-hints", false, "Extend the -slow-page-factor limit for a page the previous response hinted would be slow, by X-Next-Page-Hint or Server-Timing next-page")
+	maxIdleTime := flag.Duration("max-idle-time", 0, "Abort when a response body receives no data for this long, detecting a stalled connection that remains open, with 0 disabling")
+	slowPageFactor := flag.Float64("slow-page-factor", 0, "Abort when a page takes longer than this multiple of the mean page request duration, with 0 disabling")
+	objectRecords := flag.Bool("object-records", false, "Decode records as JSON objects keyed by column name, rather than positional arrays")
+	useNumber := flag.Bool("use-number", false, "Decode numeric record values exactly as sent, rather than as float64 (needed for integers beyond 2^53)")
+	ndjsonStream := flag.Bool("ndjson-stream", false, "Read each job's response as a stream of NDJSON record objects, optionally gzip compressed, rather than pages")
+	streamSchema := flag.String("stream-schema", "", "JSON file of the header {\"columns\": [...]} for -ndjson-stream, otherwise given by the stream's first line")
+	serverFields := flag.String("server-fields", "", "Comma separated columns the server is asked to return, in the fields of each page request, reducing page sizes")
+	trimSpace := flag.Bool("trim-space", false, "Remove leading and trailing whitespace from each cell, before any -coerce")
+	invalidUTF8 := flag.String("invalid-utf8", InvalidUTF8Replace, "Handling of invalid UTF-8 in a page: replace (with U+FFFD), strip or error")
+	coerce := flag.String("coerce", "", "Comma separated column:type overrides of the declared column types, with types string, int, float, bool or timestamp")
+	validateTypes := flag.Bool("validate-types", false, "Fail the run on the first value that is not valid for the type of its column, as declared or set by -coerce, without changing the output")
+	exprSource := flag.String("expr", "", "Expression evaluated per record, with column names bound to their values: a boolean filter, or the value of -expr-column")
+	exprColumn := flag.String("expr-column", "", "Name of a column added to each record with the value of -expr, rather than filtering")
+	stopWhen := flag.String("stop-when", "", "Boolean expression, as for -expr, ending each pagination after the page holding the first record for which it is true, such as the first record older than a date in a descending result set; that page is output whole, and no page after it")
+	since := flag.String("since", "", "Exclude records before a timestamp, as column=timestamp")
+	describe := flag.Bool("describe", false, "Print the columns of the first page and exit, without paginating")
+	preview := flag.Bool("preview", false, "Print the columns and first records of the first page and exit, without paginating")
+	head := flag.Int("head", 0, "Print the first N records as CSV rows, without a header, and exit, retrieving only the pages holding them")
+	statsFormat := flag.String("stats-format", StatsFormatText, "Format of -describe, -profile and -count-by output: text or json")
+	profile := flag.Bool("profile", false, "Print statistics of the values of each column of the records retrieved once the run completes")
+	countByColumn := flag.String("count-by", "", "Column whose values are counted as records are retrieved, printing the most frequent once the run completes")
+	countByTop := flag.Int("count-by-top", 10, "Number of the most frequent -count-by values printed")
+	countByMaxValues := flag.Int("count-by-max-values", defaultCountByMaxValues, "Maximum distinct -count-by values counted, bounding its memory")
+	countByOverflow := flag.String("count-by-overflow", CountByOverflowOther, "Handling of further -count-by values once -count-by-max-values are counted: other, counting them together with a warning, or error, failing the run")
+	outputFormat := flag.String("output-format", OutputFormatNone, "Format of retrieved records output: none, ndjson, csv, fixed, sql or parquet")
+	recordsOnly := flag.Bool("records-only", false, "Write only the records to stdout, as ndjson unless -output-format is csv, without the summary")
+	var outputs outputSpecs
+	flag.Var(&outputs, "output", "File the records are output to, with - for stdout, an s3://bucket/key or gs://bucket/object URL, a unix:///path socket on which a reader listens, or an existing named pipe, prefixed by format: for a format other than -output-format, e.g. csv:out.csv (repeatable, default -).  A single output may hold {hash}, {label} and {date} placeholders, e.g. out/{label}-{date}.csv, to give each job an output of its own")
+	ndjsonRS := flag.Bool("ndjson-rs", false, "Precede each ndjson record with the RS character, as a JSON text sequence (RFC 7464)")
+	jobTagColumn := flag.String("job-tag-column", "", "Prepend a column of this name to the output records, holding the label of each job in -jobs, or otherwise its hash")
+	outputAppend := flag.Bool("output-append", false, "Append csv or ndjson records to an existing -output file; csv records continue under the file's header, which must match their columns")
+	noHeader := flag.Bool("no-header", false, "Omit the column header row from csv or fixed output, e.g. when appending to an existing file")
+	sqlTable := flag.String("table", "", "Table, optionally schema qualified, into which sql output inserts the records")
+	sqlBatchSize := flag.Int("sql-batch-size", defaultSQLBatchSize, "Maximum rows of each INSERT statement of sql output")
+	sqlValues := flag.String("sql-values", SQLValuesTyped, "Values of sql output: "+SQLValuesTyped+", writing those of int, float and bool columns as numbers and booleans, or "+SQLValuesText+", writing every value as a string")
+	widths := flag.String("widths", "", "Comma separated column:width widths of fixed output columns; other columns are sized to their longest value, buffering all records until the end of the run")
+	minFreeBytes := flag.Uint64("min-free-bytes", 0, "Abort output when the free disk space of the -output directory falls below this, with 0 disabling the check")
+	partitionBy := flag.String("partition-by", "", "Column whose values route records to separate files, named by value, in the -output directory")
+	flatten := flag.String("flatten", "", "Comma separated columns whose JSON object values are expanded into column.key columns (buffers all records)")
+	explode := flag.String("explode", "", "Column and delimiter, as column,delimiter, whose delimited values are split into a record per element with the other columns repeated, e.g. tags,;")
+	explodeEmpty := flag.String("explode-empty", ExplodeEmptyKeep, "Handling of a record whose -explode value is empty: keep, as a single record, or drop")
+	sampleRate := flag.Float64("sample-rate", 1, "Probability, from 0 to 1, of each record being output")
+	sampleSeed := flag.Int64("sample-seed", 1, "Seed of the -sample-rate generator, for reproducible samples")
+	outputBufferSize := flag.Int("output-buffer-size", defaultOutputBufferSize, "Size in bytes of the buffer in front of the records output")
+	allowSchemaEvolution := flag.Bool("allow-schema-evolution", false, "Output the union of the columns of all pages, with null for the columns a page lacks, holding all records in memory until the run completes")
+	maxMemoryRecords := flag.Int("max-memory-records", 0, "Maximum records held in memory by the outputs that buffer them until the run completes, -flatten, -allow-schema-evolution and fixed output without -widths for every column, with 0 for no limit")
+	onMemoryLimit := flag.String("on-memory-limit", MemoryLimitError, "Handling of buffered records beyond -max-memory-records: error, failing the run, or spill, moving them to a temporary file")
+	jsonSchemaOut := flag.String("jsonschema-out", "", "File to which a JSON Schema of the ndjson record objects output is written at completion")
+	outputQueueDepth := flag.Int("output-queue-depth", 0, "Pages of records that may be held in memory whilst being written, or waiting to be, so that writing overlaps retrieval, with 0 writing each page before the next is requested")
+	checksum := flag.String("checksum", "", "Write a checksum of each output file beside it, as "+ChecksumSHA256+" or "+ChecksumMD5+", in a file named by the algorithm, e.g. out.csv.sha256, that sha256sum -c can verify")
+	drainTimeout := flag.Duration("drain-timeout", 0, "Maximum time the output may take to flush once the run is interrupted or stopped, after which the run exits with the output possibly incomplete, with 0 waiting until it completes")
+	outputFlushInterval := flag.Duration("output-flush-interval", time.Second, "Maximum time records are held in the output buffer, with 0 flushing only at completion")
+	minRecordsPerPage := flag.Int("min-records-per-page", 0, "Warn of pages returning fewer records than this, to help tune server page sizes")
+	requireRecords := flag.Int("require-records", 0, "Minimum number of records the first page must have, failing the run otherwise")
+	var requireColumns stringList
+	flag.Var(&requireColumns, "require-column", "Column the first page must have, failing the run otherwise (repeatable)")
+	onNullViolation := flag.String("on-null-violation", NullViolationError, "Handling of a null in a column the server declares not nullable: error, or allow to write it")
+	onTotalMismatch := flag.String("on-total-mismatch", TotalMismatchWarn, "Handling of a record count differing from the server's meta.total: warn or error")
+	var eodStatuses statusCodes
+	flag.Var(&eodStatuses, "eod-status", "Comma separated HTTP status codes, e.g. 204,404, ending the pagination cleanly rather than being read as a page")
+	onTokenReuse := flag.String("on-token-reuse", TokenReuseWarn, "Handling of a page whose next token was already requested: warn, ending the pagination, or error")
+	skipPages := flag.Int("skip-pages", 0, "Discard the records of the first N pages, which are still retrieved to follow their tokens, so that output starts from page N+1")
+	coalesceIdentical := flag.Bool("coalesce-identical", false, "Drop the records of a page identical to those of the page before it, still following its next token, as a retrying or faulty server may repeat a page under a new token")
+	dedupePages := flag.String("dedupe-pages", DuplicatePagesOff, "Handling of pages with the same records as an earlier page: off, warn or error")
+	webhookURL := flag.String("webhook", "", "URL to which JSON run events are POSTed: start, each job's result, and completion or failure")
+	webhookPageInterval := flag.Duration("webhook-page-interval", 0, "Also POST page events to -webhook, at most once per interval, with 0 disabling them")
+	statsInterval := flag.Duration("stats-interval", 0, "Interval at which the progress of the run is printed to stderr, with 0 disabling it")
+	statusPath := flag.String("status-file", "", "File rewritten with a JSON snapshot of the progress of the run every -status-interval, ending with its state as done or error, for watchers to poll")
+	statusInterval := flag.Duration("status-interval", time.Second, "Interval at which -status-file is rewritten")
+	manifestPath := flag.String("manifest", "", "File to which a manifest of the run is written, recording the token to continue from with -token @file")
+	summaryCSV := flag.String("summary-csv", "", "CSV file to which a row of stats for each job is appended, building a history of runs")
+	expectRecords := flag.Int("expect-records", -1, "Expected total number of records; a mismatch exits nonzero")
+
+	printTokens := flag.Bool("print-tokens", false, "Print the page number, token, next token and record count of each page retrieved to stderr, as an audit of the cursor chain")
+	printTokensFile := flag.String("print-tokens-file", "", "File to which -print-tokens writes, in place of stderr")
+	decodeTokens := flag.Bool("decode-tokens", false, "Follow each -print-tokens line with the contents of its token, when it is base64 encoded JSON, as a diagnostic; the contents are shown even when the token itself is redacted")
+	showTokens := flag.Bool("show-tokens", false, "Show pagination tokens as they are in logs and the summary, rather than as a truncated hash")
+	errorFormat := flag.String("error-format", ErrorFormatText, "Format of the error written to stderr when the run fails: text or json")
+	explainConfig := flag.Bool("explain", false, "Print the resolved settings as JSON, with secrets redacted, and exit without running")
+
+	flag.Parse()
 
-	// Normally would decode to a ResultSet object to have direct access to all
-	// the decoded data.  Since only want nextToken and recordCount, generic
-	// decoding is faster (~75% of the full decoding time)
-	var result map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	redactToken := TokenRedactor(HashedToken)
+	if *showTokens {
+		redactToken = RawToken
+	}
+
+	var status *statusFile
+	var auditFile *os.File
+
+	// fatal exits with the error, written as an errorReport with -error-format json.
+	// As exiting skips deferred calls, the -print-tokens-file is closed here
+	fatal := func(err error, results ...JobResult) {
+		if auditFile != nil {
+			if cerr := auditFile.Close(); cerr != nil {
+				log.Printf("Unable to close -print-tokens-file: %v", cerr)
+			}
+		}
+		if status != nil {
+			if werr := status.write(RunStateError, err); werr != nil {
+				log.Printf("Unable to write status file: %v", werr)
+			}
+		}
+		if *errorFormat != ErrorFormatJSON {
+			log.Fatal(err)
+		}
+		writeErrorReport(os.Stderr, err, results, redactToken)
+		os.Exit(1)
+	}
+
+	// A -token of @file continues from the manifest written by an earlier run
+	resolvedHash, resolvedToken, err := resolveManifestToken(*hash, *firstToken, redactToken)
 	if err != nil {
-		return "", 0, time.Duration(0), time.Duration(0), err
+		fatal(err)
+	}
+	*hash, *firstToken = resolvedHash, resolvedToken
+
+	if *recordsOnly && *outputFormat == OutputFormatNone {
+		*outputFormat = OutputFormatNDJSON
+	}
+	if len(outputs) == 0 {
+		outputs = outputSpecs{{path: "-"}}
+	}
+	sinkOutputs := outputs.resolve(*outputFormat)
+	stdoutOutputs := 0
+	appendable := true
+	formats := map[string]bool{}
+	for _, o := range sinkOutputs {
+		formats[o.format] = true
+		if o.path == "-" {
+			stdoutOutputs++
+		}
+		if (o.format != OutputFormatCSV && o.format != OutputFormatNDJSON) || o.path == "-" || strings.Contains(o.path, "://") {
+			appendable = false
+		}
+	}
+	singleOutput := len(sinkOutputs) == 1
+	output := "-"
+	if singleOutput {
+		output = sinkOutputs[0].path
+	}
+	if *pagination == PaginationOffset && len(*firstToken) == 0 && len(*seedTokens) == 0 {
+		*firstToken = "0"
+	}
+
+	if *explainConfig {
+		if err := explain(os.Stdout, flag.CommandLine); err != nil {
+			fatal(err)
+		}
+		return
 	}
 
-	nextToken := result["meta"].(map[string]interface{})["next"].(string)
-	recordCount := len(result["data"].(map[string]interface{})["records"].([]interface{}))
+	noJobs := len(*jobsFile) == 0 && len(*seedTokens) == 0
+	templated := isOutputTemplate(output)
+	if err := checkArgs([]argCheck{
+		{len(*baseURL) == 0, "-url is required"},
+		{len(*nextTokenPath) == 0, "-next-token-path must not be empty"},
+		{*concurrency < 1, "-concurrency must be at least 1"},
+		{*runFor < 0, "-run-for must not be negative"},
+		{*deadline < 0, "-deadline must not be negative"},
+		{*maxConcurrentRetries < 0, "-max-concurrent-retries must not be negative"},
+		{*retryOnEmpty < 0, "-retry-on-empty must not be negative"},
+		{*retryOnEmptyBackoff < 0, "-retry-on-empty-backoff must not be negative"},
+		{*webhookPageInterval < 0, "-webhook-page-interval must not be negative"},
+		{*statsInterval < 0, "-stats-interval must not be negative"},
+		{*statusInterval <= 0, "-status-interval must be positive"},
 
-	t3 := time.Now()
+		{len(*oauthTokenURL) > 0 && len(*oauthClientID) == 0, "-oauth-token-url requires -oauth-client-id"},
+		{len(*oauthClientID) > 0 && len(*oauthTokenURL) == 0, "-oauth-client-id requires -oauth-token-url"},
+		{len(*oauthScopes) > 0 && len(*oauthTokenURL) == 0, "-oauth-scopes requires -oauth-token-url"},
+		{len(*authTokenFile) > 0 && len(*oauthTokenURL) > 0, "-auth-token-file cannot be used with -oauth-token-url"},
+		{len(*authTokenCommand) > 0 && len(*authTokenFile) > 0, "-auth-token-command cannot be used with -auth-token-file"},
+		{len(*authTokenCommand) > 0 && len(*oauthTokenURL) > 0, "-auth-token-command cannot be used with -oauth-token-url"},
+		{*authTokenFileTTL < 0, "-auth-token-file-ttl must not be negative"},
+		{*maxRedirects < 0, "-max-redirects must not be negative"},
+		{*maxConnections < 0, "-max-concurrent-connections must not be negative"},
+		choiceCheck("-redirect-auth", *redirectAuth, RedirectAuthStrip, RedirectAuthPreserve),
 
-	return nextToken, recordCount, t2.Sub(t1), t3.Sub(t2), nil
-}
+		{len(*jobsFile) == 0 && len(*hash) == 0, "-hash is required without -jobs"},
+		{len(*jobsFile) == 0 && !*autoFirstToken && len(*firstToken) == 0 && len(*seedTokens) == 0, "-token, -tokens or -auto-first-token is required without -jobs"},
+		{len(*jobsFile) == 0 && !*autoFirstToken && len(*firstToken) > 0 && len(*seedTokens) > 0, "-token cannot be used with -tokens"},
+		{*autoFirstToken && len(*firstToken) > 0, "-auto-first-token cannot be used with -token"},
+		{*autoFirstToken && !noJobs, "-auto-first-token cannot be used with -tokens or -jobs"},
+		{len(*jobsFile) > 0 && len(*seedTokens) > 0, "-jobs cannot be used with -tokens"},
+		{len(*printTokensFile) > 0 && !*printTokens, "-print-tokens-file requires -print-tokens"},
+		{*decodeTokens && !*printTokens, "-decode-tokens requires -print-tokens"},
+		{len(*manifestPath) > 0 && !noJobs, "-manifest cannot be used with -tokens or -jobs"},
+		choiceCheck("-on-job-error", *onJobError, JobErrorContinue, JobErrorFailFast),
+		{*jobRequeues < 0, "-job-requeues must not be negative"},
+
+		choiceCheck("-pagination", *pagination, PaginationToken, PaginationOffset),
+		{*pageLimit < 1, "-page-limit must be at least 1"},
+		{*pageTargetDuration < 0, "-page-target-duration must not be negative"},
+		{*pageTargetDuration > 0 && *pagination != PaginationOffset, "-page-target-duration requires -pagination offset"},
+		{*pageTargetDuration > 0 && *pageLimitMin < 1, "-page-limit-min must be at least 1"},
+		{*pageTargetDuration > 0 && *pageLimitMax < *pageLimitMin, "-page-limit-max must be at least -page-limit-min"},
+		{*pageTargetDuration > 0 && (*pageLimit < *pageLimitMin || *pageLimit > *pageLimitMax), "-page-limit must be between -page-limit-min and -page-limit-max"},
+		{*pageCacheSize < 0, "-page-cache-size must not be negative"},
+		{*pageCacheSize > 0 && *ndjsonStream, "-page-cache-size cannot be used with -ndjson-stream"},
+		{*skipPages < 0, "-skip-pages must not be negative"},
+		{*skipPages > 0 && *ndjsonStream, "-skip-pages cannot be used with -ndjson-stream"},
+
+		choiceCheck("-on-total-mismatch", *onTotalMismatch, TotalMismatchWarn, TotalMismatchError),
+		choiceCheck("-on-null-violation", *onNullViolation, NullViolationError, NullViolationAllow),
+		choiceCheck("-capture-compress", *captureCompress, captureCompressions...),
+		{*preflight && len(*replayDir) > 0, "-preflight cannot be used with -replay-dir"},
+		{*preflightRequire && len(*replayDir) > 0, "-preflight-require cannot be used with -replay-dir"},
+		choiceCheck("-on-token-reuse", *onTokenReuse, TokenReuseWarn, TokenReuseError),
+		{*maxServerTime < 0, "-max-server-time must not be negative"},
+		{*maxServerTime > 0 && len(*serverTimeHeader) == 0, "-max-server-time requires -server-time-header"},
+		choiceCheck("-on-max-server-time", *onMaxServerTime, ServerTimeWarn, ServerTimeAbort),
+		choiceCheck("-dedupe-pages", *dedupePages, DuplicatePagesOff, DuplicatePagesWarn, DuplicatePagesError),
+		choiceCheck("-on-decode-error", *onDecodeError, DecodeErrorAbort, DecodeErrorSkip),
+		{*maxConsecutiveErrors < 0, "-max-consecutive-errors must not be negative"},
+		{*maxConsecutiveErrors > 0 && *onDecodeError != DecodeErrorSkip, "-max-consecutive-errors requires -on-decode-error skip"},
+
+		{len(*streamSchema) > 0 && !*ndjsonStream, "-stream-schema requires -ndjson-stream"},
+		{len(*stopFile) > 0 && *ndjsonStream, "-stop-file cannot be used with -ndjson-stream"},
+		{len(*stopWhen) > 0 && *ndjsonStream, "-stop-when cannot be used with -ndjson-stream"},
+		{len(*pauseFile) > 0 && *ndjsonStream, "-pause-file cannot be used with -ndjson-stream"},
+		{len(*envelope) > 0 && *ndjsonStream, "-envelope cannot be used with -ndjson-stream"},
+		choiceCheck("-invalid-utf8", *invalidUTF8, InvalidUTF8Replace, InvalidUTF8Strip, InvalidUTF8Error),
+		choiceCheck("-stats-format", *statsFormat, StatsFormatText, StatsFormatJSON),
+		{*countByTop < 1, "-count-by-top must be at least 1"},
+		{*countByMaxValues < 1, "-count-by-max-values must be at least 1"},
+		choiceCheck("-count-by-overflow", *countByOverflow, CountByOverflowOther, CountByOverflowError),
+		choiceCheck("-error-format", *errorFormat, ErrorFormatText, ErrorFormatJSON),
+		{len(*exprColumn) > 0 && len(*exprSource) == 0, "-expr-column requires -expr"},
 
-// consumeAllPages retrieves all the pages for the given (hash, firstToken), returning the total
-// number of pages retrieved, the total number of records across these pages, and the total durations
-// for retrieval and unmarshalling
-func consumeAllPages(url, hash, firstToken string) (int, []int, time.Duration, time.Duration, error) {
-	pageCount := 0
-	recordCounts := []int{}
-	totalDurationRequest := time.Duration(0)
-	totalUnmarshalDuration := time.Duration(0)
-	nextToken := firstToken
-	for len(nextToken) > 0 {
-		token, recordCount, requestDuration, unMarshalDuration, err := consumePage(url, hash, nextToken)
+		{len(*flatten) > 0 && len(sinkOutputs) == 0, "-flatten requires -output-format"},
+		{len(*explode) > 0 && len(sinkOutputs) == 0, "-explode requires -output-format"},
+		choiceCheck("-explode-empty", *explodeEmpty, ExplodeEmptyKeep, ExplodeEmptyDrop),
+		{*explodeEmpty != ExplodeEmptyKeep && len(*explode) == 0, "-explode-empty requires -explode"},
+		{*allowSchemaEvolution && len(sinkOutputs) == 0, "-allow-schema-evolution requires -output-format"},
+		{len(*jobTagColumn) > 0 && len(sinkOutputs) == 0, "-job-tag-column requires -output-format"},
+		{*noHeader && !formats[OutputFormatCSV] && !formats[OutputFormatFixed], "-no-header requires csv or fixed output"},
+		{len(*widths) > 0 && !formats[OutputFormatFixed], "-widths requires fixed output"},
+		{formats[OutputFormatSQL] && len(*sqlTable) == 0, "sql output requires -table"},
+		{len(*sqlTable) > 0 && !formats[OutputFormatSQL], "-table requires sql output"},
+		{*sqlBatchSize < 1, "-sql-batch-size must be at least 1"},
+		choiceCheck("-sql-values", *sqlValues, SQLValuesTyped, SQLValuesText),
+		{*ndjsonRS && !formats[OutputFormatNDJSON], "-ndjson-rs requires ndjson output"},
+		{len(*jsonSchemaOut) > 0 && !formats[OutputFormatNDJSON], "-jsonschema-out requires ndjson output"},
+		{*outputAppend && (len(sinkOutputs) == 0 || !appendable), "-output-append requires csv or ndjson output to local files"},
+		{len(*checksum) > 0 && *checksum != ChecksumSHA256 && *checksum != ChecksumMD5, fmt.Sprintf("-checksum %q: expected %v or %v", *checksum, ChecksumSHA256, ChecksumMD5)},
+		{len(*checksum) > 0 && len(sinkOutputs) == 0, "-checksum requires -output-format"},
+		{len(*checksum) > 0 && *outputAppend, "-checksum cannot be used with -output-append"},
+		{stdoutOutputs > 1, "only one -output may be stdout"},
+		{len(*partitionBy) > 0 && (!singleOutput || output == "-"), "-partition-by requires a single -output file"},
+		{!singleOutput && slices.ContainsFunc(sinkOutputs, func(o outputSpec) bool { return isOutputTemplate(o.path) }), "an -output template requires a single -output"},
+		{templated && len(*partitionBy) > 0, "an -output template cannot be used with -partition-by"},
+		{templated && len(*jsonSchemaOut) > 0, "an -output template cannot be used with -jsonschema-out"},
+		{templated && *minFreeBytes > 0, "an -output template cannot be used with -min-free-bytes"},
+		{templated && *sampleRate < 1, "an -output template cannot be used with -sample-rate"},
+		{*minFreeBytes > 0 && (!singleOutput || output == "-" || strings.Contains(output, "://")), "-min-free-bytes requires a single local -output file"},
+		{*sampleRate < 0 || *sampleRate > 1, "-sample-rate must be between 0 and 1"},
+		{*sampleRate < 1 && len(sinkOutputs) == 0, "-sample-rate requires -output-format"},
+		{*throttleOn429 && *throttleMinRate <= 0, "-throttle-min-rate must be positive"},
+		{*throttleOn429 && (*throttleRate < *throttleMinRate || *throttleMaxRate < *throttleRate), "-throttle-rate must be between -throttle-min-rate and -throttle-max-rate"},
+		{*maxMemoryRecords < 0, "-max-memory-records must not be negative"},
+		choiceCheck("-on-memory-limit", *onMemoryLimit, MemoryLimitError, MemoryLimitSpill),
+		{*outputBufferSize < 1, "-output-buffer-size must be at least 1"},
+		{*outputFlushInterval < 0, "-output-flush-interval must not be negative"},
+		{*outputQueueDepth < 0, "-output-queue-depth must not be negative"},
+		{*drainTimeout < 0, "-drain-timeout must not be negative"},
+		{*minRecordsPerPage < 0, "-min-records-per-page must not be negative"},
+		{*slowPageFactor < 0, "-slow-page-factor must not be negative"},
+		{*maxIdleTime < 0, "-max-idle-time must not be negative"},
+		{*requireRecords < 0, "-require-records must not be negative"},
+
+		{*describe && !noJobs, "-describe cannot be used with -tokens or -jobs"},
+		{*preview && !noJobs, "-preview cannot be used with -tokens or -jobs"},
+		{*describe && *preview, "-describe cannot be used with -preview"},
+		{*head < 0, "-head must not be negative"},
+		{*head > 0 && !noJobs, "-head cannot be used with -tokens or -jobs"},
+		{*head > 0 && (*describe || *preview), "-head cannot be used with -describe or -preview"},
+		{*head > 0 && *ndjsonStream, "-head cannot be used with -ndjson-stream"},
+		{*head > 0 && len(sinkOutputs) > 0, "-head cannot be used with -output-format"},
+		{*recordsOnly && (*describe || *preview), "-records-only cannot be used with -describe or -preview"},
+		{*recordsOnly && (!singleOutput || output != "-"), "-records-only requires stdout as the only -output"},
+	}); err != nil {
+		fatal(err)
+	}
+
+	jobs := []Job{{Hash: *hash, Token: *firstToken}}
+	if len(*seedTokens) > 0 {
+		jobs = []Job{}
+		for _, token := range strings.Split(*seedTokens, ",") {
+			if token = strings.TrimSpace(token); len(token) == 0 {
+				fatal(errors.New("-tokens: empty token"))
+			}
+			jobs = append(jobs, Job{Hash: *hash, Token: token})
+		}
+	}
+	if len(*jobsFile) > 0 {
+		var err error
+		if jobs, err = readJobs(*jobsFile); err != nil {
+			fatal(err)
+		}
+	}
+
+	// An output holding placeholders gives each job an output of its own
+	var outputTmpl *outputTemplate
+	if templated {
+		var err error
+		if outputTmpl, err = parseOutputTemplate(output, time.Now()); err != nil {
+			fatal(err)
+		}
+		if err := outputTmpl.checkJobs(jobs); err != nil {
+			fatal(err)
+		}
+	}
+
+	opts := []Option{
+		WithTokenRedactor(redactToken),
+		WithNextTokenPath(*nextTokenPath),
+		WithDecodeErrorPolicy(*onDecodeError),
+		WithMaxConsecutiveErrors(*maxConsecutiveErrors),
+		WithIdempotencyKeys(*idempotencyKeys),
+		WithRunFor(*runFor),
+		WithDeadline(*deadline),
+		WithQueryParams(url.Values(params)),
+		WithSlowPageFactor(*slowPageFactor),
+		WithMaxIdleTime(*maxIdleTime),
+		WithNextPageHints(*nextPageHints),
+		WithServerTimeHeader(*serverTimeHeader),
+		WithMaxServerTime(*maxServerTime, *onMaxServerTime),
+		WithObjectRecords(*objectRecords),
+		WithTotalMismatchPolicy(*onTotalMismatch),
+		WithNullViolationPolicy(*onNullViolation),
+		WithTokenReusePolicy(*onTokenReuse),
+		WithEndOfDataStatus(eodStatuses...),
+		WithUseNumber(*useNumber),
+		WithDuplicatePagesPolicy(*dedupePages),
+		WithCoalesceIdentical(*coalesceIdentical),
+		WithSkipPages(*skipPages),
+		WithMaxConcurrentRetries(*maxConcurrentRetries),
+		WithMaxConnsPerHost(*maxConnections),
+		WithRedirects(*maxRedirects, *redirectAuth),
+		WithRetryOnEmpty(*retryOnEmpty, *retryOnEmptyBackoff),
+		WithShardConcurrency(*concurrency),
+		WithTrimSpace(*trimSpace),
+		WithInvalidUTF8Policy(*invalidUTF8),
+		WithFirstTokenPath(*firstTokenPath),
+		WithJobTagColumn(*jobTagColumn),
+		WithTypeValidation(*validateTypes),
+	}
+	if *pagination == PaginationOffset {
+		if *pageTargetDuration > 0 {
+			opts = append(opts, WithPagination(AdaptiveOffsetPagination{OffsetPagination: OffsetPagination{Limit: *pageLimit}, MinLimit: *pageLimitMin, MaxLimit: *pageLimitMax, Target: *pageTargetDuration}))
+		} else {
+			opts = append(opts, WithPagination(OffsetPagination{Limit: *pageLimit}))
+		}
+	}
+	if len(pins) > 0 {
+		opts = append(opts, WithPinnedCertSHA256(pins))
+	}
+	for encoding, command := range decompressors {
+		opts = append(opts, WithDecompressor(encoding, CommandDecompressor(command)))
+	}
+	if len(*authTokenFile) > 0 {
+		opts = append(opts, WithExpiringTokenSource(TokenFileSource(*authTokenFile, *authTokenFileTTL)))
+	}
+	if len(*authTokenCommand) > 0 {
+		opts = append(opts, WithTokenSource(CommandTokenSource(*authTokenCommand)))
+	}
+	if len(*oauthTokenURL) > 0 {
+		secret, ok := os.LookupEnv(*oauthSecretEnv)
+		if !ok {
+			fatal(fmt.Errorf("-oauth-token-url: the client secret is not set in %v", *oauthSecretEnv))
+		}
+		scopes := []string{}
+		for _, scope := range strings.Split(*oauthScopes, ",") {
+			if scope = strings.TrimSpace(scope); len(scope) > 0 {
+				scopes = append(scopes, scope)
+			}
+		}
+		opts = append(opts, WithExpiringTokenSource(ClientCredentialsSource(&http.Client{Timeout: oauthTimeout}, *oauthTokenURL, *oauthClientID, secret, scopes)))
+	}
+	if *throttleOn429 {
+		opts = append(opts, WithAdaptiveThrottle(*throttleRate, *throttleMinRate, *throttleMaxRate))
+	}
+	for class, policy := range retries {
+		opts = append(opts, WithRetryPolicy(class, policy))
+	}
+	if len(*replayDir) > 0 {
+		opts = append(opts, WithReplayDir(*replayDir))
+	}
+	if len(*captureDir) > 0 {
+		opts = append(opts, WithCaptureDir(*captureDir, *captureOverwrite), WithCaptureCompression(*captureCompress))
+	}
+	if len(*cacheDir) > 0 {
+		opts = append(opts, WithCacheDir(*cacheDir))
+	}
+	if *pageCacheSize > 0 {
+		opts = append(opts, WithPageCache(NewPageCache(*pageCacheSize)))
+	}
+	if *requireRecords > 0 {
+		opts = append(opts, WithFirstPageAssertion(RequireRecords(*requireRecords)))
+	}
+	if len(requireColumns) > 0 {
+		opts = append(opts, WithFirstPageAssertion(RequireColumns(requireColumns...)))
+	}
+	if *ndjsonStream {
+		var columns []Column
+		if len(*streamSchema) > 0 {
+			var err error
+			if columns, err = readStreamSchema(*streamSchema); err != nil {
+				fatal(err)
+			}
+		}
+		opts = append(opts, WithNDJSONStream(columns))
+	}
+	if len(*serverFields) > 0 {
+		fields := []string{}
+		for _, field := range strings.Split(*serverFields, ",") {
+			if field = strings.TrimSpace(field); len(field) == 0 {
+				fatal(errors.New("-server-fields: empty field"))
+			}
+			fields = append(fields, field)
+		}
+		opts = append(opts, WithServerFields(fields...))
+	}
+	if len(*envelope) > 0 {
+		env, err := parseEnvelope(*envelope)
+		if err != nil {
+			fatal(err)
+		}
+		opts = append(opts, WithEnvelope(env))
+	}
+	if len(*coerce) > 0 {
+		coercions, err := parseCoercions(*coerce)
 		if err != nil {
-			return 0, nil, time.Duration(0), time.Duration(0), err
+			fatal(err)
 		}
+		opts = append(opts, WithCoercions(coercions))
+	}
+	if len(*exprSource) > 0 {
+		e, err := CompileRecordExpr(*exprSource, *exprColumn)
+		if err != nil {
+			fatal(err)
+		}
+		opts = append(opts, WithRecordExpr(e))
+	}
+	if len(*stopWhen) > 0 {
+		e, err := CompileRecordExpr(*stopWhen, "")
+		if err != nil {
+			fatal(err)
+		}
+		opts = append(opts, WithStopPredicate(e.stopPredicate()))
+	}
+	if len(*since) > 0 {
+		f, err := parseSince(*since)
+		if err != nil {
+			fatal(err)
+		}
+		opts = append(opts, WithSince(f.column, f.cutoff))
+	}
 
-		nextToken = token
-		pageCount++
-		recordCounts = append(recordCounts, recordCount)
-		totalDurationRequest += requestDuration
-		totalUnmarshalDuration += unMarshalDuration
+	var hook *webhook
+	if len(*webhookURL) > 0 {
+		hook = &webhook{url: *webhookURL, pageInterval: *webhookPageInterval, redactToken: redactToken}
+		opts = append(opts, WithPageHook(hook.page))
 	}
 
-	return pageCount, recordCounts, totalDurationRequest, totalUnmarshalDuration, nil
-}
+	var tracker *manifestTracker
+	if len(*manifestPath) > 0 {
+		tracker = &manifestTracker{}
+		opts = append(opts, WithPageHook(tracker.page))
+	}
 
-// printConsumption provides a formatted output of the activity
-func printConsumption(hash, firstToken string, pageCount int, recordCounts []int, totalDurationRequest, totalUnmarshalDuration time.Duration, err error) {
-	fmt.Printf("Hash: %v, First Token: %v\n", hash, firstToken)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+	if *printTokens {
+		var w io.Writer = os.Stderr
+		if len(*printTokensFile) > 0 {
+			f, err := os.Create(*printTokensFile)
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			auditFile, w = f, f
+		}
+		opts = append(opts, WithPageHook((&tokenAudit{w: w, redact: redactToken, decode: *decodeTokens}).page))
+	}
+
+	var prog *progress
+	if *statsInterval > 0 || len(*statusPath) > 0 {
+		prog = newProgress()
+		opts = append(opts, WithPageHook(prog.page))
+	}
+
+	// Cancelling on interrupt allows buffered output to be flushed before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// SIGTERM, or the stop file appearing, stops the run once the pages in progress
+	// complete, rather than cancelling them as an interrupt does
+	stopGate := NewStopGate()
+	stopCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	if !*ndjsonStream {
+		opts = append(opts, WithStopGate(stopGate))
+		go watchStop(stopCtx, stopGate, *stopFile, stopFilePollInterval, syscall.SIGTERM)
+	}
+	if len(*pauseFile) > 0 {
+		pauseGate := NewPauseGate()
+		opts = append(opts, WithPauseGate(pauseGate))
+		go watchPause(stopCtx, pauseGate, *pauseFile, pauseFilePollInterval)
+	}
+
+	if *autoFirstToken {
+		token, err := NewClient(*baseURL, opts...).firstToken(ctx, *hash)
+		if err != nil {
+			fatal(err)
+		}
+		*firstToken = token
+		jobs[0].Token = token
+	}
+
+	if *describe {
+		columns, err := NewClient(*baseURL, opts...).describe(ctx, *hash, *firstToken)
+		if err != nil {
+			fatal(err)
+		}
+		if err := printColumns(os.Stdout, *statsFormat, columns); err != nil {
+			fatal(err)
+		}
 		return
 	}
 
-	records := 0
-	for _, recordCount := range recordCounts {
-		records += recordCount
+	if *preview {
+		columns, records, err := NewClient(*baseURL, opts...).preview(ctx, *hash, *firstToken)
+		if err != nil {
+			fatal(err)
+		}
+		if err := printPreview(os.Stdout, columns, records, previewRecords); err != nil {
+			fatal(err)
+		}
+		return
 	}
 
-	fmt.Printf("  Pages: %v\n", pageCount)
-	fmt.Printf("  Records: %v\n", records)
-	fmt.Printf("  Duration to retrieve pages: %v\n", totalDurationRequest)
-	fmt.Printf("  Duration to unmarshal pages: %v\n", totalUnmarshalDuration)
-}
+	if *head > 0 {
+		if err := NewClient(*baseURL, opts...).printHead(ctx, os.Stdout, *hash, *firstToken, *head); err != nil {
+			fatal(err)
+		}
+		return
+	}
 
-func main() {
+	if len(*statusPath) > 0 {
+		status = &statusFile{path: *statusPath, prog: prog, redact: redactToken}
+		if err := status.write(RunStateRunning, nil); err != nil {
+			fatal(fmt.Errorf("status file: %w", err))
+		}
+	}
 
-	url := flag.String("url", "http://localhost:8090", "URL to dataproxy")
-	hash := flag.String("hash", "", "Hash of request")
-	firstToken := flag.String("token", "", "Token of first page")
+	// The summary moves to stderr when stdout carries the records
+	var summary io.Writer = os.Stdout
+	var sink RecordSink
+	var sampler *samplingSink
+	var jobOutput func(Job) (RecordSink, error)
+	if len(sinkOutputs) > 0 {
+		var err error
+		memLimit := memoryLimit{records: *maxMemoryRecords, policy: *onMemoryLimit}
+		encOpts := encoderOptions{noHeader: *noHeader, recordSeparator: *ndjsonRS, appendOutput: *outputAppend, checksum: *checksum,
+			sqlTable: *sqlTable, sqlBatchSize: *sqlBatchSize, sqlValues: *sqlValues, memoryLimit: memLimit}
+		if len(*widths) > 0 {
+			if encOpts.widths, err = parseWidths(*widths); err != nil {
+				fatal(err)
+			}
+		}
+		var explodeColumn, explodeDelimiter string
+		if len(*explode) > 0 {
+			if explodeColumn, explodeDelimiter, err = parseExplode(*explode); err != nil {
+				fatal(err)
+			}
+		}
+		if outputTmpl != nil {
+			// Each job writes to an output of its own, with the records transformed as for a single output
+			format := sinkOutputs[0].format
+			jobOutput = func(job Job) (RecordSink, error) {
+				path := outputTmpl.render(job)
+				if err := makeParentDir(path); err != nil {
+					return nil, err
+				}
+				s, err := newStreamSink(ctx, format, path, *outputBufferSize, *outputFlushInterval, encOpts)
+				if err != nil {
+					return nil, err
+				}
+				if len(*flatten) > 0 {
+					s = newFlattenSink(s, strings.Split(*flatten, ","), memLimit)
+				}
+				if len(explodeColumn) > 0 {
+					s = newExplodeSink(s, explodeColumn, explodeDelimiter, *explodeEmpty)
+				}
+				if *allowSchemaEvolution {
+					s = newSchemaUnionSink(s, memLimit)
+				}
+				return s, nil
+			}
+		} else {
+			if len(*partitionBy) > 0 {
+				if sink, err = newPartitionSink(ctx, *partitionBy, sinkOutputs[0].format, output, *outputBufferSize, *outputFlushInterval, encOpts); err != nil {
+					fatal(err)
+				}
+			} else {
+				sinks := []RecordSink{}
+				for _, o := range sinkOutputs {
+					s, err := newStreamSink(ctx, o.format, o.path, *outputBufferSize, *outputFlushInterval, encOpts)
+					if err != nil {
+						newMultiSink(sinks...).Close()
+						fatal(err)
+					}
+					sinks = append(sinks, s)
+				}
+				sink = sinks[0]
+				if len(sinks) > 1 {
+					sink = newMultiSink(sinks...)
+				}
+			}
+			if len(*jsonSchemaOut) > 0 {
+				sink = newJSONSchemaSink(sink, *jsonSchemaOut)
+			}
+			if *minFreeBytes > 0 {
+				dir := output
+				if len(*partitionBy) == 0 {
+					dir = filepath.Dir(output)
+				}
+				if err := checkDiskSpace(freeDiskBytes, dir, *minFreeBytes); err != nil {
+					sink.Close()
+					fatal(err)
+				}
+				sink = newDiskSpaceSink(sink, dir, *minFreeBytes, freeDiskBytes)
+			}
+			if len(*flatten) > 0 {
+				sink = newFlattenSink(sink, strings.Split(*flatten, ","), memLimit)
+			}
+			if len(explodeColumn) > 0 {
+				sink = newExplodeSink(sink, explodeColumn, explodeDelimiter, *explodeEmpty)
+			}
+			if *sampleRate < 1 {
+				sampler = newSamplingSink(sink, *sampleRate, *sampleSeed)
+				sink = sampler
+			}
+			if *allowSchemaEvolution {
+				sink = newSchemaUnionSink(sink, memLimit)
+			}
+		}
+		if stdoutOutputs > 0 {
+			summary = os.Stderr
+		}
+		if *recordsOnly {
+			summary = io.Discard
+		}
+	}
 
-	flag.Parse()
+	// The profile is of the records as output, or as retrieved without an output
+	var profiler *profileSink
+	if *profile {
+		profiler = newProfileSink()
+	}
+
+	var counter *countBySink
+	if len(*countByColumn) > 0 {
+		counter = newCountBySink(*countByColumn, *countByMaxValues, *countByOverflow)
+	}
+	var stats []RecordSink
+	if profiler != nil {
+		stats = append(stats, profiler)
+	}
+	if counter != nil {
+		stats = append(stats, counter)
+	}
+
+	// completeSink adds the profile, the counts, and the queue in front of the output, to the sink
+	completeSink := func(sink RecordSink) RecordSink {
+		for _, s := range stats {
+			if sink == nil {
+				sink = s
+			} else {
+				sink = newMultiSink(sink, s)
+			}
+		}
+		if sink != nil && *outputQueueDepth > 0 {
+			sink = newQueueSink(sink, *outputQueueDepth)
+		}
+		return sink
+	}
+
+	if jobOutput != nil {
+		opts = append(opts, WithJobRecordSink(func(job Job) (RecordSink, error) {
+			s, err := jobOutput(job)
+			if err != nil {
+				return nil, err
+			}
+			return completeSink(s), nil
+		}))
+	} else if sink = completeSink(sink); sink != nil {
+		opts = append(opts, WithRecordSink(sink))
+	}
+
+	client := NewClient(*baseURL, opts...)
 
-	if len(*url) == 0 || len(*hash) == 0 || len(*firstToken) == 0 {
-		log.Fatal("invalid arguments")
+	if *preflight || *preflightRequire {
+		p, err := client.preflight(ctx)
+		switch {
+		case err != nil && *preflightRequire:
+			fatal(err)
+		case err != nil:
+			log.Printf("Warning: %v", err)
+		default:
+			printPreflight(summary, p)
+			if *preflightRequire && !p.OK() {
+				fatal(errors.New("preflight: page requests not allowed"))
+			}
+		}
 	}
 
-	pageCount, recordCounts, totalDurationRequest, totalUnmarshalDuration, err := consumeAllPages(*url, *hash, *firstToken)
+	if *warmupConnection {
+		if err := client.warmup(ctx); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	if hook != nil {
+		hook.start(ctx, len(jobs))
+	}
+
+	started := time.Now()
+
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	if *statsInterval > 0 {
+		go prog.report(progressCtx, os.Stderr, *statsInterval)
+	}
+	if status != nil {
+		go status.run(progressCtx, *statusInterval)
+	}
+
+	results := client.consumeJobs(ctx, jobs, *concurrency, *onJobError, *jobRequeues)
+	stopProgress()
+	stopWatching()
+	if len(*seedTokens) > 0 {
+		results = []JobResult{mergeChains(results, redactToken)}
+	}
+
+	var outputErr error
+	if sink != nil {
+		// Flushing is bounded only on shutdown, as a completed run has nothing to hurry for
+		drain := time.Duration(0)
+		if ctx.Err() != nil || stopGate.Stopped() {
+			drain = *drainTimeout
+		}
+		outputErr = closeWithin(sink, drain)
+	}
+	elapsed := time.Since(started)
+
+	for _, r := range results {
+		if *recordsOnly && r.Err != nil {
+			log.Printf("Hash: %v, First Token: %v, Error: %v", r.Job.Hash, redactToken(r.Job.Token), r.Err)
+		}
+		printConsumption(summary, r.Job.Hash, redactToken(r.Job.Token), r.RunResult, stopReason(r, stopGate), *minRecordsPerPage, r.Requeues, r.Err)
+	}
+
+	if len(*summaryCSV) > 0 {
+		if err := appendSummaryCSV(*summaryCSV, started, elapsed, results); err != nil {
+			log.Printf("Unable to append to summary CSV: %v", err)
+		}
+	}
+
+	if tracker != nil {
+		if err := writeManifest(*manifestPath, tracker.manifest(results[0])); err != nil {
+			log.Printf("Unable to write manifest: %v", err)
+		}
+	}
 
-	printConsumption(*hash, *firstToken, pageCount, recordCounts, totalDurationRequest, totalUnmarshalDuration, err)
+	a := AggregateResults(results)
+	if hook != nil {
+		hook.finish(ctx, results, a)
+	}
+	if len(results) > 1 {
+		printAggregate(summary, a)
+	}
+
+	if *coalesceIdentical {
+		fmt.Fprintf(summary, "Coalesced pages: %v\n", client.coalescedPages())
+	}
+
+	if sampler != nil {
+		seen, sampled := sampler.counts()
+		fmt.Fprintf(summary, "Sampled records: %v of %v\n", sampled, seen)
+	}
+
+	if profiler != nil {
+		if err := printProfile(summary, *statsFormat, profiler.profiles()); err != nil {
+			log.Printf("Unable to print profile: %v", err)
+		}
+	}
+
+	if counter != nil {
+		if err := printCountBy(summary, *statsFormat, counter.top(*countByTop)); err != nil {
+			log.Printf("Unable to print counts: %v", err)
+		}
+	}
+
+	if outputErr != nil {
+		fatal(fmt.Errorf("output: %w", outputErr), results...)
+	}
+
+	for _, r := range results {
+		var ae *assertionError
+		if errors.As(r.Err, &ae) {
+			fatal(ae, results...)
+		}
+	}
+
+	if a.FailedJobs > 0 {
+		fatal(fmt.Errorf("%v of %v jobs failed", a.FailedJobs, a.Jobs), results...)
+	}
+
+	if *expectRecords >= 0 && a.RecordCount != *expectRecords {
+		fatal(fmt.Errorf("record count mismatch: expected %v, retrieved %v", *expectRecords, a.RecordCount), results...)
+	}
+
+	if status != nil {
+		if err := status.write(RunStateDone, nil); err != nil {
+			log.Printf("Unable to write status file: %v", err)
+		}
+	}
 }
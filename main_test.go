@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpectRecords(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}, {"2"}}, {{"3"}}}))
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t1"}
+
+	stdout, stderr, code := runMain(t, append(args, "-expect-records", "3")...)
+	if code != 0 || !strings.Contains(stdout, "Records: 3") {
+		t.Errorf("matching: exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	stdout, stderr, code = runMain(t, append(args, "-expect-records", "4")...)
+	if code == 0 || !strings.Contains(stderr, "record count mismatch: expected 4, retrieved 3") {
+		t.Errorf("mismatching: exit %v, stderr %q", code, stderr)
+	}
+	if !strings.Contains(stdout, "Records: 3") {
+		t.Errorf("mismatching: summary not printed, stdout %q", stdout)
+	}
+}
+
+func TestPrintConsumption(t *testing.T) {
+	r := RunResult{
+		PageCount:         3,
+		RecordCounts:      []int{10, 10, 2},
+		RequestDuration:   3 * time.Second,
+		UnmarshalDuration: 30 * time.Millisecond,
+		StatusCounts:      StatusTally{"2xx": 3},
+		PageSizes:         []int64{100, 100, 20},
+		Elapsed:           4 * time.Second,
+	}
+	var buf bytes.Buffer
+	printConsumption(&buf, "h", "t1", r, "", 0, 0, nil)
+	want := `Hash: h, First Token: t1
+  Pages: 3
+  Records: 22
+  HTTP statuses: 2xx: 3
+  Page bytes (min/max/mean): 20/100/73
+  Duration to retrieve pages: 3s
+  Duration to unmarshal pages: 30ms
+  Elapsed: 4s
+`
+	if buf.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", buf.String(), want)
+	}
+
+	buf.Reset()
+	printConsumption(&buf, "h", "t1", RunResult{}, "", 0, 1, errors.New("page failed"))
+	if want := "Hash: h, First Token: t1\n  Requeued: 1\nError: page failed\n"; buf.String() != want {
+		t.Errorf("failed job: got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestRunForSummary(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{{{"1"}}, {{"2"}}, {{"3"}}}))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		time.Sleep(100 * time.Millisecond)
+		return false
+	})
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-run-for", "150ms")
+	if code != 0 || !strings.Contains(stdout, "Stopped early: run time budget reached") {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+}
+
+func TestMinRecordsPerPage(t *testing.T) {
+	if got := smallPages([]int{10, 2, 10, 0, 9}, 10); got != 3 {
+		t.Errorf("small pages: got %v, want 3", got)
+	}
+	if got := smallPages([]int{0, 1}, 0); got != 0 {
+		t.Errorf("small pages with no minimum: got %v, want 0", got)
+	}
+
+	var buf bytes.Buffer
+	printConsumption(&buf, "h", "t1", RunResult{PageCount: 3, RecordCounts: []int{10, 4, 1}}, "", 5, 0, nil)
+	if !strings.Contains(buf.String(), "  Warning: pages with fewer than 5 records: 2\n") {
+		t.Errorf("summary %q lacks the warning of 2 small pages", buf.String())
+	}
+	buf.Reset()
+	printConsumption(&buf, "h", "t1", RunResult{PageCount: 1, RecordCounts: []int{10}}, "", 5, 0, nil)
+	if strings.Contains(buf.String(), "Warning") {
+		t.Errorf("summary %q warns without small pages", buf.String())
+	}
+}
+
+func TestDeadlineFlag(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "t2" {
+			<-r.Context().Done()
+			return true
+		}
+		return false
+	})
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-deadline", "100ms")
+	if code == 0 || !strings.Contains(stdout+stderr, "deadline of 100ms exceeded") {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+}
+
+func TestRecordsOnly(t *testing.T) {
+	columns := testColumns("id", "name")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1", "a"}}, {{"2", "b"}}}))
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t1", "-records-only"}
+
+	stdout, stderr, code := runMain(t, args...)
+	if want := `{"id":"1","name":"a"}` + "\n" + `{"id":"2","name":"b"}` + "\n"; code != 0 || stdout != want {
+		t.Errorf("ndjson: exit %v, stdout %q, want %q", code, stdout, want)
+	}
+	if len(stderr) > 0 {
+		t.Errorf("stderr %q, want no summary", stderr)
+	}
+
+	stdout, stderr, code = runMain(t, append(args, "-output-format", "csv")...)
+	if code != 0 || stdout != "id,name\n1,a\n2,b\n" || len(stderr) > 0 {
+		t.Errorf("csv: exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	if _, _, code := runMain(t, append(args, "-output", filepath.Join(t.TempDir(), "out"))...); code == 0 {
+		t.Error("-records-only accepted with a file -output")
+	}
+}
+
+func TestRecordsOnlyFailure(t *testing.T) {
+	pages := chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}})
+	delete(pages, "t2")
+	s := newPageServer(t, pages)
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-records-only")
+	if code == 0 || stdout != `{"id":"1"}`+"\n" || !strings.Contains(stderr, "Error: ") {
+		t.Errorf("exit %v, stdout %q, stderr %q, want the records retrieved and the error logged", code, stdout, stderr)
+	}
+}
+
+func TestInvalidArguments(t *testing.T) {
+	s := newPageServer(t, numberedPages(1, 1))
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"-explode-empty", ExplodeEmptyDrop}, want: "-explode-empty requires -explode"},
+		{args: []string{"-explode-empty", "all"}, want: `-explode-empty "all": expected keep or drop`},
+		{args: []string{"-head", "2", "-output-format", "csv"}, want: "-head cannot be used with -output-format"},
+		{args: []string{"-head", "-1"}, want: "-head must not be negative"},
+		{args: []string{"-pause-file", "pause", "-ndjson-stream"}, want: "-pause-file cannot be used with -ndjson-stream"},
+		{args: []string{"-auth-token-command", "printf x", "-auth-token-file", "token"}, want: "-auth-token-command cannot be used with -auth-token-file"},
+		{args: []string{"-page-cache-size", "4", "-ndjson-stream"}, want: "-page-cache-size cannot be used with -ndjson-stream"},
+		{args: []string{"-stop-when", "id > 1", "-ndjson-stream"}, want: "-stop-when cannot be used with -ndjson-stream"},
+		{args: []string{"-pagination", "cursor"}, want: `-pagination "cursor": expected token or offset`},
+		{args: []string{"-output-format", "sql"}, want: "sql output requires -table"},
+		{args: []string{"-tokens", "a,,b"}, want: "-tokens: empty token"},
+		{args: []string{"-server-fields", "id,"}, want: "-server-fields: empty field"},
+	} {
+		args := append([]string{"-url", s.URL, "-hash", "h"}, test.args...)
+		if !slices.Contains(test.args, "-tokens") {
+			args = append(args, "-token", "t0")
+		}
+		if _, stderr, code := runMain(t, args...); code == 0 || !strings.Contains(stderr, test.want) {
+			t.Errorf("%v: exit %v, stderr %q, want %q", test.args, code, stderr, test.want)
+		}
+	}
+
+	// Each invalid argument is reported
+	_, stderr, _ := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-concurrency", "0", "-skip-pages", "-1")
+	if !strings.Contains(stderr, "-concurrency must be at least 1\n-skip-pages must not be negative") {
+		t.Errorf("stderr %q, want both errors", stderr)
+	}
+}
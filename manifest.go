@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestTokenPrefix marks a -token value naming a manifest to resume from
+const manifestTokenPrefix = "@"
+
+// Manifest records the outcome of a run of a single pagination, so that a later
+// run can continue from NextToken, the token of the first page not retrieved
+type Manifest struct {
+	Hash       string    `json:"hash"`
+	FirstToken string    `json:"first_token"`
+	NextToken  string    `json:"next_token,omitempty"`
+	Complete   bool      `json:"complete"`
+	Pages      int       `json:"pages"`
+	Records    int       `json:"records"`
+	Error      string    `json:"error,omitempty"`
+	Written    time.Time `json:"written"`
+}
+
+// manifestTracker follows the pages of a pagination, to record where it stopped
+type manifestTracker struct {
+	mu        sync.Mutex
+	started   bool
+	nextToken string
+}
+
+// page records the token following the retrieved page
+func (t *manifestTracker) page(_ context.Context, e PageEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.started = true
+	t.nextToken = e.Next
+}
+
+// manifest returns the Manifest of the pagination's result
+func (t *manifestTracker) manifest(r JobResult) Manifest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m := Manifest{
+		Hash:       r.Job.Hash,
+		FirstToken: r.Job.Token,
+		NextToken:  t.nextToken,
+		Pages:      r.PageCount,
+		Records:    r.TotalRecords(),
+		Written:    time.Now().UTC(),
+	}
+	if !t.started {
+		// Nothing was retrieved, so the run can be repeated from its first token
+		m.NextToken = r.Job.Token
+	}
+	m.Complete = len(m.NextToken) == 0
+	if r.Err != nil {
+		m.Error = r.Err.Error()
+	}
+	return m
+}
+
+// writeManifest writes the manifest to path, replacing any earlier manifest
+// only once the new one is complete
+func writeManifest(path string, m Manifest) error {
+	return writeJSONFile(path, m)
+}
+
+// writeJSONFile writes v as indented JSON to path, by way of a temporary file
+// renamed over any earlier file once complete, so that readers of path never
+// see a partial file
+func writeJSONFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readManifest returns the manifest at path, which must have a token to resume
+// from.  Tokens in its errors are shown in the form given by redact
+func readManifest(path string, redact TokenRedactor) (Manifest, error) {
+	var m Manifest
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("manifest %v: %v", path, err)
+	}
+	if len(m.Hash) == 0 {
+		return m, fmt.Errorf("manifest %v: no hash", path)
+	}
+	if m.Complete || len(m.NextToken) == 0 {
+		return m, fmt.Errorf("manifest %v: no resumable token, as the pagination from %v completed", path, redact(m.FirstToken))
+	}
+	return m, nil
+}
+
+// resolveManifestToken returns the hash and token to resume from if token is
+// @path naming a manifest, otherwise the hash and token unchanged.  A hash
+// given alongside the manifest must be that of the manifest
+func resolveManifestToken(hash, token string, redact TokenRedactor) (string, string, error) {
+	path, ok := strings.CutPrefix(token, manifestTokenPrefix)
+	if !ok {
+		return hash, token, nil
+	}
+	if len(path) == 0 {
+		return "", "", errors.New("-token @: no manifest path")
+	}
+	m, err := readManifest(path, redact)
+	if err != nil {
+		return "", "", err
+	}
+	if len(hash) > 0 && hash != m.Hash {
+		return "", "", fmt.Errorf("manifest %v: hash %v, not %v", path, m.Hash, hash)
+	}
+	return m.Hash, m.NextToken, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestManifestResume(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{{{"1"}}, {{"2"}}, {{"3"}}}))
+	var failing atomic.Bool
+	failing.Store(true)
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "t3" && failing.Load() {
+			http.Error(w, "failed", http.StatusBadRequest)
+			return true
+		}
+		return false
+	})
+	path := filepath.Join(t.TempDir(), "manifest.json")
+
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-manifest", path); code == 0 {
+		t.Fatal("first run succeeded")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Hash != "h" || m.FirstToken != "t1" || m.NextToken != "t3" || m.Complete || len(m.Error) == 0 {
+		t.Errorf("manifest %+v, want the run to continue from t3", m)
+	}
+
+	failing.Store(false)
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-token", "@"+path, "-manifest", path, "-output-format", "csv")
+	if code != 0 {
+		t.Fatalf("second run: exit %v, stderr %q", code, stderr)
+	}
+	if stdout != "id\n3\n" {
+		t.Errorf("second run wrote %q, want the records from t3", stdout)
+	}
+	if tokens := s.tokens(); !slices.Equal(tokens, []string{"t1", "t2", "t3", "t3"}) {
+		t.Errorf("requested %v", tokens)
+	}
+
+	// The second run completed the pagination, so leaves nothing to resume
+	_, stderr, code = runMain(t, "-url", s.URL, "-token", "@"+path)
+	if code == 0 || !strings.Contains(stderr, "no resumable token, as the pagination from "+HashedToken("t3")+" completed") {
+		t.Errorf("third run: exit %v, stderr %q", code, stderr)
+	}
+}
+
+func TestResolveManifestToken(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+	if err := writeManifest(path, Manifest{Hash: "h", FirstToken: "t1", NextToken: "t2"}); err != nil {
+		t.Fatal(err)
+	}
+	if hash, token, err := resolveManifestToken("", "@"+path, RawToken); err != nil || hash != "h" || token != "t2" {
+		t.Errorf("got %v, %v, %v", hash, token, err)
+	}
+	if hash, token, err := resolveManifestToken("x", "t9", RawToken); err != nil || hash != "x" || token != "t9" {
+		t.Errorf("plain token: got %v, %v, %v", hash, token, err)
+	}
+	for hash, token := range map[string]string{"other": "@" + path, "": "@"} {
+		if _, _, err := resolveManifestToken(hash, token, RawToken); err == nil {
+			t.Errorf("%v %v resolved", hash, token)
+		}
+	}
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(bad, []byte(`{"next_token":"t2"}`), 0o644)
+	if _, err := readManifest(bad, RawToken); err == nil || !strings.HasSuffix(err.Error(), "no hash") {
+		t.Errorf("got %v, want an error of the missing hash", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+// multiSink is a RecordSink writing every record to each of its sinks, e.g. to
+// output the same records in several formats
+type multiSink struct {
+	sinks []RecordSink
+}
+
+// newMultiSink returns a multiSink writing to the sinks
+func newMultiSink(sinks ...RecordSink) *multiSink {
+	return &multiSink{sinks: sinks}
+}
+
+// WriteRecords writes the records to each sink, returning the first error.  The
+// records are still written to the remaining sinks when one fails
+func (m *multiSink) WriteRecords(columns []Column, records [][]string) error {
+	var err error
+	for _, sink := range m.sinks {
+		if werr := sink.WriteRecords(columns, records); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// Close closes every sink, returning the first error
+func (m *multiSink) Close() error {
+	var err error
+	for _, sink := range m.sinks {
+		if cerr := sink.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// failingSink is a RecordSink whose writes fail with err, recording whether it was closed
+type failingSink struct {
+	err    error
+	closed bool
+}
+
+func (f *failingSink) WriteRecords([]Column, [][]string) error { return f.err }
+
+func (f *failingSink) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestMultiSink(t *testing.T) {
+	a, b := &memorySink{}, &memorySink{}
+	sink := newMultiSink(a, b)
+	columns := testColumns("id")
+	for _, records := range [][][]string{{{"1"}, {"2"}}, {{"3"}}} {
+		if err := sink.WriteRecords(columns, records); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"1"}, {"2"}, {"3"}}; !reflect.DeepEqual(a.records, want) || !reflect.DeepEqual(b.records, want) || !a.closed || !b.closed {
+		t.Errorf("wrote %v and %v, want %v to both, closed", a.records, b.records, want)
+	}
+}
+
+func TestMultiSinkError(t *testing.T) {
+	failed := &failingSink{err: errors.New("disk full")}
+	after := &memorySink{}
+	sink := newMultiSink(failed, after)
+	if err := sink.WriteRecords(testColumns("id"), [][]string{{"1"}}); !errors.Is(err, failed.err) {
+		t.Errorf("got %v, want %v", err, failed.err)
+	}
+	sink.Close()
+	if len(after.records) != 1 || !after.closed || !failed.closed {
+		t.Errorf("wrote %v, want the other sink still written and both closed", after.records)
+	}
+}
+
+func TestMultipleOutputs(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id", "name"), []string{"t1", "t2"}, [][][]string{{{"1", "a"}}, {{"2", "b"}}}))
+	dir := t.TempDir()
+	csvPath, ndjsonPath := filepath.Join(dir, "out.csv"), filepath.Join(dir, "out.ndjson")
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", csvPath, "-output", "ndjson:"+ndjsonPath, "-output", "-")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	csvOut, _ := os.ReadFile(csvPath)
+	ndjsonOut, _ := os.ReadFile(ndjsonPath)
+	if string(csvOut) != "id,name\n1,a\n2,b\n" || stdout != string(csvOut) {
+		t.Errorf("csv %q, stdout %q", csvOut, stdout)
+	}
+	if string(ndjsonOut) != "{\"id\":\"1\",\"name\":\"a\"}\n{\"id\":\"2\",\"name\":\"b\"}\n" {
+		t.Errorf("ndjson %q", ndjsonOut)
+	}
+}
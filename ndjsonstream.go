@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// streamBatchSize is the number of records of an NDJSON stream written to the sink at a time
+const streamBatchSize = 1000
+
+// gzipMagic starts a gzip stream, identifying gzip bodies sent without a Content-Encoding
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// readStreamSchema reads the columns of an NDJSON stream from a file holding a
+// JSON header object, as in a page's data.header
+func readStreamSchema(path string) ([]Column, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var header Header
+	if err := json.Unmarshal(b, &header); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if len(header.Columns) == 0 {
+		return nil, fmt.Errorf("%v: no columns", path)
+	}
+	return header.Columns, nil
+}
+
+// consumeStream retrieves the (hash, token) response as a stream of NDJSON
+// records, each a JSON object mapped to the columns by name, returning as
+// consumeAllPages with the stream counted as a single page.  Without the
+// streamColumns, the first line must be a header object giving the columns.
+// The body may be gzip compressed, with or without a Content-Encoding.  A
+// final line truncated by the end of the stream is logged and dropped
+func (c *Client) consumeStream(ctx context.Context, hash, token string) (RunResult, error) {
+	ctx, idle := newIdleWatchdog(ctx, c.maxIdle)
+	defer idle.stop()
+
+	tally := StatusTally{}
+	failed := func(err error) (RunResult, error) {
+		return RunResult{}, idle.cause(err)
+	}
+
+	jsonData, err := c.pagination.requestBody(hash, token, c.serverFields)
+	if err != nil {
+		return failed(err)
+	}
+
+	t1 := c.clock.Now()
+
+	resp, err := c.postPage(ctx, hash, token, jsonData, "", tally)
+	if err != nil {
+		return failed(err)
+	}
+	defer resp.Body.Close()
+	if err := c.checkServerTime(token, c.serverDuration(resp.Header)); err != nil {
+		return failed(err)
+	}
+
+	t2 := c.clock.Now()
+
+	cr := &countingReader{r: idle.watch(resp.Body)}
+	br := bufio.NewReader(cr)
+	encoding := strings.TrimSpace(resp.Header.Get("Content-Encoding"))
+	if len(encoding) == 0 {
+		if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+			encoding = "gzip"
+		}
+	}
+	dr, err := c.decompressReader(encoding, br)
+	if err != nil {
+		return failed(err)
+	}
+	defer dr.Close()
+
+	columns := c.streamColumns
+	recordCount := 0
+	filteredCount := 0
+	batch := []interface{}{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		records, err := objectStringRecords(columns, batch)
+		if err != nil {
+			return err
+		}
+		seen := len(records)
+		batch = batch[:0]
+		outColumns, records, err := c.transformRecords(columns, records)
+		if err != nil {
+			return err
+		}
+		recordCount += len(records)
+		filteredCount += seen - len(records)
+		if c.sink != nil {
+			if err := c.sink.WriteRecords(outColumns, records); err != nil {
+				return fmt.Errorf("output: %w", err)
+			}
+		}
+		return nil
+	}
+
+	lines := bufio.NewReader(dr)
+	for n := 1; ; n++ {
+		line, readErr := lines.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			return failed(fmt.Errorf("stream for token %v: line %v: %w", c.tokenRef(token), n, readErr))
+		}
+		// A line without its newline at the end of the stream may have been cut short
+		final := readErr != nil
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var obj map[string]interface{}
+			line, err := sanitizeUTF8(c.invalidUTF8, line)
+			if err == nil {
+				err = c.decodeStreamLine(line, &obj)
+			}
+			switch {
+			case err != nil && final:
+				log.Printf("Dropping truncated final line %v of stream for token %v: %q", n, c.tokenRef(token), newDecodeError(c.tokenRef(token), line, err).snippet)
+			case err != nil:
+				return failed(newDecodeError(c.tokenRef(token), line, fmt.Errorf("line %v: %v", n, err)))
+			case columns == nil:
+				var header Header
+				if err := json.Unmarshal(line, &header); err != nil || len(header.Columns) == 0 {
+					return failed(fmt.Errorf("stream for token %v: no schema, and line %v is not a header", c.tokenRef(token), n))
+				}
+				columns = header.Columns
+			default:
+				batch = append(batch, obj)
+				if len(batch) >= streamBatchSize {
+					if err := flush(); err != nil {
+						return failed(err)
+					}
+				}
+			}
+		}
+		if final {
+			if errors.Is(readErr, io.ErrUnexpectedEOF) {
+				log.Printf("Stream for token %v ended unexpectedly after %v lines", c.tokenRef(token), n)
+			}
+			break
+		}
+	}
+	if err := flush(); err != nil {
+		return failed(err)
+	}
+
+	t3 := c.clock.Now()
+
+	return RunResult{
+		PageCount:         1,
+		RecordCounts:      []int{recordCount},
+		RequestDuration:   t2.Sub(t1),
+		ServerDuration:    c.serverDuration(resp.Header),
+		UnmarshalDuration: t3.Sub(t2),
+		FilteredRecords:   filteredCount,
+		StatusCounts:      tally,
+		PageSizes:         []int64{cr.n},
+	}, nil
+}
+
+// decodeStreamLine decodes a line of an NDJSON stream into v, with numbers as
+// json.Number if useNumber is set
+func (c *Client) decodeStreamLine(line []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	if c.useNumber {
+		dec.UseNumber()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
+}
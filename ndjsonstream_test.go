@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// gzipStreamServer returns a server answering every request with the lines,
+// gzip compressed, naming the encoding in a Content-Encoding header if labelled
+func gzipStreamServer(t *testing.T, labelled bool, lines string) *httptest.Server {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(lines))
+	zw.Close()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if labelled {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestConsumeStream(t *testing.T) {
+	header := `{"columns":[{"name":"id","type":"int","position":0},{"name":"name","type":"string","position":1}]}` + "\n"
+	records := `{"id":1,"name":"a"}` + "\n" + `{"name":"b","id":2}` + "\n"
+	for _, test := range []struct {
+		name     string
+		labelled bool
+		columns  []Column
+		lines    string
+	}{
+		{name: "header line", lines: header + records},
+		{name: "schema", labelled: true, columns: []Column{{Name: "id", Type: "int", Position: 0}, {Name: "name", Type: "string", Position: 1}}, lines: records},
+		{name: "truncated", lines: header + records + `{"id":3,"na`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := gzipStreamServer(t, test.labelled, test.lines)
+			sink := &memorySink{}
+			result, err := NewClient(s.URL, WithNDJSONStream(test.columns), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := [][]string{{"1", "a"}, {"2", "b"}}; !reflect.DeepEqual(sink.records, want) {
+				t.Errorf("wrote %v, want %v", sink.records, want)
+			}
+			if result.PageCount != 1 || result.TotalRecords() != 2 {
+				t.Errorf("got %v pages of %v records, want the stream counted as a page of 2", result.PageCount, result.TotalRecords())
+			}
+		})
+	}
+}
+
+func TestConsumeStreamErrors(t *testing.T) {
+	for lines, want := range map[string]string{
+		`{"id":1}` + "\n": "is not a header",
+		`{"columns":[{"name":"id"}]}` + "\nnot json\n": "line 2",
+	} {
+		s := gzipStreamServer(t, false, lines)
+		if _, err := NewClient(s.URL, WithNDJSONStream(nil)).consumeAllPages(context.Background(), "h", "t1"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", lines, err, want)
+		}
+	}
+}
+
+func TestStreamSchemaFlag(t *testing.T) {
+	s := gzipStreamServer(t, false, `{"id":1}`+"\n"+`{"id":2}`+"\n")
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(`{"columns":[{"name":"id","type":"int","position":0}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-ndjson-stream", "-stream-schema", path, "-output-format", "csv")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if stdout != "id\n1\n2\n" {
+		t.Errorf("stdout %q", stdout)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"columns":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readStreamSchema(path); err == nil || !strings.HasSuffix(err.Error(), "no columns") {
+		t.Errorf("got %v, want an error of the schema without columns", err)
+	}
+}
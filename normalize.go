@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Handling of invalid UTF-8 sequences in a page body.  The JSON decoder replaces
+// them with U+FFFD, and so they are handled in the body before it is decoded
+const (
+	InvalidUTF8Error   = "error"
+	InvalidUTF8Replace = "replace"
+	InvalidUTF8Strip   = "strip"
+)
+
+// errInvalidUTF8 is the error decoding a page body with invalid UTF-8 under InvalidUTF8Error
+var errInvalidUTF8 = errors.New("body contains invalid UTF-8")
+
+// sanitizeUTF8 returns the body with its invalid UTF-8 sequences handled according
+// to the policy.  The body is returned unchanged when valid, or when replacing, as
+// the JSON decoder will make the replacement
+func sanitizeUTF8(policy string, body []byte) ([]byte, error) {
+	if policy != InvalidUTF8Error && policy != InvalidUTF8Strip || utf8.Valid(body) {
+		return body, nil
+	}
+	if policy == InvalidUTF8Error {
+		return nil, errInvalidUTF8
+	}
+	return bytes.ToValidUTF8(body, nil), nil
+}
+
+// trimRecords removes the leading and trailing whitespace of every cell of the records, in place
+func trimRecords(records [][]string) {
+	for _, record := range records {
+		for i, value := range record {
+			record[i] = strings.TrimSpace(value)
+		}
+	}
+}
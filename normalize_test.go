@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTrimSpace(t *testing.T) {
+	columns := testColumns("id", "name")
+	s := newPageServer(t, chainPages(columns, []string{"t1"}, [][][]string{{{" 1", "a \t"}, {"2", "\n b c "}}}))
+	for trim, want := range map[bool][][]string{
+		false: {{" 1", "a \t"}, {"2", "\n b c "}},
+		true:  {{"1", "a"}, {"2", "b c"}},
+	} {
+		sink := &memorySink{}
+		if _, err := NewClient(s.URL, WithTrimSpace(trim), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sink.records, want) {
+			t.Errorf("trim %v: wrote %q, want %q", trim, sink.records, want)
+		}
+	}
+}
+
+func TestInvalidUTF8Policy(t *testing.T) {
+	columns := testColumns("name")
+	body := bytes.Replace(testPage("", columns, []string{"aXb"}), []byte("aXb"), []byte("a\xffb"), 1)
+	s := newPageServer(t, map[string][]byte{"t1": body})
+	for _, test := range []struct {
+		policy string
+		want   string
+		err    error
+	}{
+		{policy: InvalidUTF8Replace, want: "a�b"},
+		{policy: InvalidUTF8Strip, want: "ab"},
+		{policy: InvalidUTF8Error, err: errInvalidUTF8},
+	} {
+		sink := &memorySink{}
+		_, err := NewClient(s.URL, WithInvalidUTF8Policy(test.policy), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1")
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%v: got %v, want %v", test.policy, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", test.policy, err)
+		}
+		if len(sink.records) != 1 || sink.records[0][0] != test.want {
+			t.Errorf("%v: wrote %q, want %q", test.policy, sink.records, test.want)
+		}
+	}
+}
+
+func TestSanitizeUTF8Valid(t *testing.T) {
+	body := []byte(`{"name":"é"}`)
+	for _, policy := range []string{InvalidUTF8Replace, InvalidUTF8Strip, InvalidUTF8Error} {
+		if got, err := sanitizeUTF8(policy, body); err != nil || !bytes.Equal(got, body) {
+			t.Errorf("%v: got %q, %v", policy, got, err)
+		}
+	}
+}
+
+func TestTrimSpaceBeforeCoercion(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("amount"), []string{"t1"}, [][][]string{{{" 10.50 "}}}))
+	sink := &memorySink{}
+	if _, err := NewClient(s.URL, WithTrimSpace(true), WithCoercions(map[string]string{"amount": ColumnTypeFloat}), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"10.5"}}; !reflect.DeepEqual(sink.records, want) {
+		t.Errorf("wrote %q, want %q", sink.records, want)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Defaults of the OAuth2 client credentials token exchange
+const (
+	defaultOAuthSecretEnv = "OAUTH_CLIENT_SECRET"
+	oauthTimeout          = 30 * time.Second
+	// oauthExpirySkew is how long before its expiry an access token is replaced,
+	// so that it does not expire whilst a request is in flight
+	oauthExpirySkew = 30 * time.Second
+)
+
+// oauthTokenResponse is the successful response of a token endpoint (RFC 6749 5.1)
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// oauthError reports a failed token request, with the error and description
+// of the token endpoint's error response (RFC 6749 5.2) when it gave one
+type oauthError struct {
+	status      int
+	code        string
+	description string
+}
+
+func (e *oauthError) Error() string {
+	msg := fmt.Sprintf("token endpoint: status %v", e.status)
+	if len(e.code) > 0 {
+		msg += ": " + e.code
+	}
+	if len(e.description) > 0 {
+		msg += ": " + e.description
+	}
+	return msg
+}
+
+// ClientCredentialsSource returns an ExpiringTokenSource obtaining access tokens
+// from the OAuth2 token endpoint at tokenURL with the client credentials grant,
+// authenticating as the client with HTTP Basic authentication.  The scopes are
+// requested if given.  A token without an expires_in is used until it is rejected
+func ClientCredentialsSource(httpClient *http.Client, tokenURL, clientID, clientSecret string, scopes []string) ExpiringTokenSource {
+	return func(ctx context.Context) (string, time.Time, error) {
+		form := url.Values{"grant_type": {"client_credentials"}}
+		if len(scopes) > 0 {
+			form.Set("scope", strings.Join(scopes, " "))
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+
+		requested := time.Now()
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("token endpoint: %w", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("token endpoint: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			e := &oauthError{status: resp.StatusCode}
+			var failure struct {
+				Error            string `json:"error"`
+				ErrorDescription string `json:"error_description"`
+			}
+			if json.Unmarshal(body, &failure) == nil {
+				e.code, e.description = failure.Error, failure.ErrorDescription
+			}
+			return "", time.Time{}, e
+		}
+
+		var token oauthTokenResponse
+		if err := json.Unmarshal(body, &token); err != nil {
+			return "", time.Time{}, fmt.Errorf("token endpoint: %w", err)
+		}
+		if len(token.AccessToken) == 0 {
+			return "", time.Time{}, fmt.Errorf("token endpoint: no access_token in response")
+		}
+		if len(token.TokenType) > 0 && !strings.EqualFold(token.TokenType, "bearer") {
+			return "", time.Time{}, fmt.Errorf("token endpoint: unsupported token_type %q", token.TokenType)
+		}
+
+		if token.ExpiresIn <= 0 {
+			return token.AccessToken, time.Now().AddDate(100, 0, 0), nil
+		}
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		return token.AccessToken, requested.Add(lifetime - min(oauthExpirySkew, lifetime/2)), nil
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenEndpoint returns a server issuing access tokens at-1, at-2 and so on to
+// the client "id" with secret "s3cret", each expiring after expiresIn seconds
+func tokenEndpoint(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var issued atomic.Int64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if r.Method != http.MethodPost || !ok || id != "id" || secret != "s3cret" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client","error_description":"unknown client"}`))
+			return
+		}
+		if r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("scope") != "read write" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"at-%v","token_type":"Bearer","expires_in":%v}`, issued.Add(1), expiresIn)
+	}))
+	t.Cleanup(s.Close)
+	return s, &issued
+}
+
+func TestClientCredentialsSource(t *testing.T) {
+	endpoint, issued := tokenEndpoint(t, 3600)
+	source := ClientCredentialsSource(endpoint.Client(), endpoint.URL, "id", "s3cret", []string{"read", "write"})
+
+	before := time.Now()
+	token, expiry, err := source(context.Background())
+	if err != nil || token != "at-1" {
+		t.Fatalf("got %v, %v", token, err)
+	}
+	if want := before.Add(time.Hour - oauthExpirySkew); expiry.Before(want) || expiry.After(want.Add(time.Minute)) {
+		t.Errorf("expiry %v, want the token replaced %v before it expires", expiry, oauthExpirySkew)
+	}
+
+	// The paginated run uses the obtained token, cached across its pages
+	s := bearerServer(t, func(Request) string { return "at-2" })
+	if _, err := NewClient(s.URL, WithExpiringTokenSource(source)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := issued.Load(); got != 2 {
+		t.Errorf("issued %v tokens for the run, want one", got-1)
+	}
+
+	_, _, err = ClientCredentialsSource(endpoint.Client(), endpoint.URL, "id", "wrong", []string{"read", "write"})(context.Background())
+	var oe *oauthError
+	if !errors.As(err, &oe) || oe.status != http.StatusUnauthorized || err.Error() != "token endpoint: status 401: invalid_client: unknown client" {
+		t.Errorf("got %v, want an oauthError of the rejected client", err)
+	}
+}
+
+func TestOAuthFlags(t *testing.T) {
+	endpoint, _ := tokenEndpoint(t, 3600)
+	s := bearerServer(t, func(Request) string { return "at-1" })
+	t.Setenv("TEST_OAUTH_SECRET", "s3cret")
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t1", "-oauth-token-url", endpoint.URL, "-oauth-client-id", "id", "-oauth-scopes", "read, write", "-oauth-client-secret-env", "TEST_OAUTH_SECRET"}
+
+	if _, stderr, code := runMain(t, args...); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if got := bearers(s); !slices.Equal(got, []string{"at-1", "at-1", "at-1"}) {
+		t.Errorf("bearers %v", got)
+	}
+
+	t.Setenv("TEST_OAUTH_SECRET", "wrong")
+	if stdout, stderr, code := runMain(t, args...); code == 0 || !strings.Contains(stdout+stderr, "invalid_client: unknown client") {
+		t.Errorf("wrong secret: exit %v, output %q", code, stdout+stderr)
+	}
+}
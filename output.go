@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Formats in which retrieved records can be output
+const (
+	OutputFormatNone    = "none"
+	OutputFormatNDJSON  = "ndjson"
+	OutputFormatCSV     = "csv"
+	OutputFormatParquet = "parquet"
+	OutputFormatFixed   = "fixed"
+	OutputFormatSQL     = "sql"
+)
+
+// isOutputFormat returns true if the name is that of an output format, whether
+// built in or requiring build tags
+func isOutputFormat(name string) bool {
+	switch name {
+	case OutputFormatNone, OutputFormatNDJSON, OutputFormatCSV, OutputFormatFixed, OutputFormatSQL:
+		return true
+	}
+	_, ok := outputFormatTags[name]
+	return ok
+}
+
+// defaultOutputBufferSize is the default size of the buffer in front of the output
+const defaultOutputBufferSize = 64 * 1024
+
+// RecordSink receives the records of each page as it is retrieved.  A RecordSink
+// shared by concurrently paginated jobs must be safe for concurrent use
+type RecordSink interface {
+	WriteRecords(columns []Column, records [][]string) error
+	Close() error
+}
+
+// recordEncoder writes records to w in an output format
+type recordEncoder interface {
+	encode(w io.Writer, columns []Column, records [][]string) error
+}
+
+// recordFinisher is implemented by a recordEncoder that must complete its
+// output, e.g. with a footer, once all records have been encoded
+type recordFinisher interface {
+	finish(w io.Writer) error
+}
+
+// outputEncoders create the encoders of output formats other than those built
+// in, and are registered by the files providing each format
+var outputEncoders = map[string]func() recordEncoder{}
+
+// outputFormatTags are the build tags that include support for optional output formats
+var outputFormatTags = map[string]string{
+	OutputFormatParquet: "parquet",
+}
+
+// streamSink is a RecordSink encoding records to a buffered output, which
+// is flushed at most flushInterval after a write, and on Close
+type streamSink struct {
+	mu            sync.Mutex
+	out           io.WriteCloser
+	buf           *bufio.Writer
+	enc           recordEncoder
+	flushInterval time.Duration
+	lastFlush     time.Time
+}
+
+// outputOpeners create the outputs for URLs of schemes other than local files,
+// and are registered by the files providing each scheme
+var outputOpeners = map[string]func(ctx context.Context, u *url.URL) (io.WriteCloser, error){}
+
+// outputSchemeTags are the build tags that include support for optional output schemes
+var outputSchemeTags = map[string]string{
+	"s3": "s3",
+	"gs": "gcs",
+}
+
+// openOutput opens the output at path: stdout if path is "" or "-", the output
+// of a URL of a registered scheme (e.g. s3://bucket/key, or unix:///path for a
+// Unix domain socket), an existing named pipe, otherwise a local file
+func openOutput(ctx context.Context, path string) (io.WriteCloser, error) {
+	if len(path) == 0 || path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	if strings.Contains(path, "://") {
+		u, err := url.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		if open, ok := outputOpeners[u.Scheme]; ok {
+			return open(ctx, u)
+		}
+		if tag, ok := outputSchemeTags[u.Scheme]; ok {
+			return nil, fmt.Errorf("output to %v:// requires building with -tags %v", u.Scheme, tag)
+		}
+		return nil, fmt.Errorf("unsupported output scheme %v", u.Scheme)
+	}
+
+	if isNamedPipe(path) {
+		return openNamedPipe(path)
+	}
+	return os.Create(path)
+}
+
+// openAppend opens the local file at path for appending, creating it if absent,
+// returning the first row of its existing content as a CSV header if header is
+// set, or nil if the file is empty
+func openAppend(path string, header bool) (io.WriteCloser, []string, error) {
+	if len(path) == 0 || path == "-" || strings.Contains(path, "://") {
+		return nil, nil, fmt.Errorf("output %q: only local files can be appended to", path)
+	}
+
+	var existing []string
+	if header {
+		f, err := os.Open(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+		if err == nil {
+			existing, err = csv.NewReader(f).Read()
+			f.Close()
+			if err != nil && err != io.EOF {
+				return nil, nil, fmt.Errorf("output %v: reading header: %w", path, err)
+			}
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, existing, nil
+}
+
+// encoderOptions are the settings of the output formats
+type encoderOptions struct {
+	// noHeader suppresses the CSV or fixed-width header row
+	noHeader bool
+	// recordSeparator prefixes each NDJSON record with the RS character (RFC 7464)
+	recordSeparator bool
+	// widths are the fixed-width output widths of columns, by name
+	widths map[string]int
+	// appendOutput appends to an existing csv or ndjson file, with csv records
+	// continuing under the file's header, which must match their columns
+	appendOutput bool
+	// checksum is the algorithm of the checksum written beside each output
+	// file, if any, as one of the Checksum values
+	checksum string
+	// sqlTable is the table the sql output inserts into, in INSERT statements
+	// of up to sqlBatchSize rows, with values written as one of the SQLValues
+	sqlTable     string
+	sqlBatchSize int
+	sqlValues    string
+	// memoryLimit bounds the records the fixed output holds whilst sizing its columns
+	memoryLimit memoryLimit
+}
+
+// newRecordEncoder returns an encoder of the output format
+func newRecordEncoder(format string, opts encoderOptions) (recordEncoder, error) {
+	switch format {
+	case OutputFormatNDJSON:
+		return &ndjsonEncoder{recordSeparator: opts.recordSeparator}, nil
+	case OutputFormatCSV:
+		return &csvEncoder{noHeader: opts.noHeader}, nil
+	case OutputFormatFixed:
+		return &fixedEncoder{widths: opts.widths, noHeader: opts.noHeader, records: newRecordBuffer(opts.memoryLimit)}, nil
+	case OutputFormatSQL:
+		return &sqlEncoder{table: opts.sqlTable, batchSize: opts.sqlBatchSize, values: opts.sqlValues}, nil
+	}
+
+	newEncoder, ok := outputEncoders[format]
+	if !ok {
+		if tag, ok := outputFormatTags[format]; ok {
+			return nil, fmt.Errorf("output format %v requires building with -tags %v", format, tag)
+		}
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+	return newEncoder(), nil
+}
+
+// newStreamSink returns a RecordSink writing records in the format to the output at path
+func newStreamSink(ctx context.Context, format, path string, bufferSize int, flushInterval time.Duration, opts encoderOptions) (RecordSink, error) {
+	enc, err := newRecordEncoder(format, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.WriteCloser
+	if opts.appendOutput {
+		if format != OutputFormatCSV && format != OutputFormatNDJSON {
+			return nil, fmt.Errorf("output format %v cannot be appended to", format)
+		}
+		csvEnc, isCSV := enc.(*csvEncoder)
+		var header []string
+		if out, header, err = openAppend(path, isCSV && !opts.noHeader); err != nil {
+			return nil, err
+		}
+		if isCSV {
+			csvEnc.existingHeader = header
+		}
+	} else if out, err = openOutput(ctx, path); err != nil {
+		return nil, err
+	}
+	if len(opts.checksum) > 0 && len(path) > 0 && path != "-" {
+		cw, err := newChecksumWriter(ctx, out, path, opts.checksum)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		out = cw
+	}
+
+	if bufferSize < 1 {
+		bufferSize = defaultOutputBufferSize
+	}
+
+	return &streamSink{
+		out:           out,
+		buf:           bufio.NewWriterSize(out, bufferSize),
+		enc:           enc,
+		flushInterval: flushInterval,
+		lastFlush:     time.Now(),
+	}, nil
+}
+
+// WriteRecords encodes the records to the buffer, flushing it if the flush interval has elapsed
+func (s *streamSink) WriteRecords(columns []Column, records [][]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.enc.encode(s.buf, columns, records); err != nil {
+		return err
+	}
+	if s.flushInterval > 0 && time.Since(s.lastFlush) >= s.flushInterval {
+		s.lastFlush = time.Now()
+		return s.buf.Flush()
+	}
+	return nil
+}
+
+// Close completes the encoding, flushes any buffered output and closes the output
+func (s *streamSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if f, ok := s.enc.(recordFinisher); ok {
+		err = f.finish(s.buf)
+	}
+	if ferr := s.buf.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := s.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// nopCloser prevents stdout being closed with the sink
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// columnsByPosition returns a copy of the columns, ordered by their position in a record
+func columnsByPosition(columns []Column) []Column {
+	sorted := append([]Column{}, columns...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Position < sorted[j].Position })
+	return sorted
+}
+
+// recordSeparator is the RS character preceding each record of a JSON text sequence (RFC 7464)
+const recordSeparator = 0x1E
+
+// ndjsonEncoder writes each record as a JSON object of column name to value, one
+// per line, preceded by the RS character if recordSeparator is set.  The objects
+// are written directly to a buffer reused across pages, without the map per
+// record that encoding/json would need, but with the same bytes: keys in sorted
+// order, and strings escaped as encoding/json escapes them
+type ndjsonEncoder struct {
+	recordSeparator bool
+	buf             []byte
+}
+
+func (e *ndjsonEncoder) encode(w io.Writer, columns []Column, records [][]string) error {
+	// As in a map, the last column of a name present in the record gives its value
+	names := []string{}
+	byName := map[string][]Column{}
+	for _, col := range columns {
+		if _, ok := byName[col.Name]; !ok {
+			names = append(names, col.Name)
+		}
+		byName[col.Name] = append(byName[col.Name], col)
+	}
+	sort.Strings(names)
+
+	buf := e.buf[:0]
+	for _, record := range records {
+		if e.recordSeparator {
+			buf = append(buf, recordSeparator)
+		}
+		buf = append(buf, '{')
+		first := true
+		for _, name := range names {
+			cols := byName[name]
+			for i := len(cols) - 1; i >= 0; i-- {
+				if cols[i].Position < len(record) {
+					if !first {
+						buf = append(buf, ',')
+					}
+					first = false
+					buf = appendJSONString(buf, name)
+					buf = append(buf, ':')
+					buf = appendJSONString(buf, record[cols[i].Position])
+					break
+				}
+			}
+		}
+		buf = append(buf, '}', '\n')
+	}
+	e.buf = buf
+	_, err := w.Write(buf)
+	return err
+}
+
+// appendJSONString appends s to buf as a JSON string, escaped as by encoding/json
+// with HTML escaping: invalid UTF-8 is replaced by U+FFFD, and <, >, &, U+2028
+// and U+2029 are escaped along with the control characters
+func appendJSONString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\b':
+				buf = append(buf, '\\', 'b')
+			case '\f':
+				buf = append(buf, '\\', 'f')
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\uFFFD"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+// csvEncoder writes records as CSV, preceded by a header row of the column names
+// unless noHeader is set.  When appending beneath an existingHeader, no header
+// is written and the column names must instead match the existing header.  The
+// records are encoded to a buffer reused across pages, as a csv.Writer given
+// the output's bufio.Writer would flush it with each page
+type csvEncoder struct {
+	noHeader       bool
+	wroteHeader    bool
+	existingHeader []string
+	buf            bytes.Buffer
+}
+
+func (e *csvEncoder) encode(w io.Writer, columns []Column, records [][]string) error {
+	e.buf.Reset()
+	cw := csv.NewWriter(&e.buf)
+	if !e.noHeader && !e.wroteHeader {
+		names := []string{}
+		for _, col := range columnsByPosition(columns) {
+			names = append(names, col.Name)
+		}
+		if e.existingHeader != nil {
+			if !slices.Equal(names, e.existingHeader) {
+				return fmt.Errorf("columns %v do not match the header %v of the output being appended to", strings.Join(names, ","), strings.Join(e.existingHeader, ","))
+			}
+		} else if err := cw.Write(names); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
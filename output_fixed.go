@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseWidths parses a "column:width,column:width" specification of fixed-width
+// output column widths into a map of column name to width
+func parseWidths(spec string) (map[string]int, error) {
+	widths := map[string]int{}
+	for _, item := range strings.Split(spec, ",") {
+		name, w, ok := strings.Cut(strings.TrimSpace(item), ":")
+		width, err := strconv.Atoi(w)
+		if !ok || len(name) == 0 || err != nil || width < 1 {
+			return nil, fmt.Errorf("widths %q: expected column:width, with a positive width", item)
+		}
+		widths[name] = width
+	}
+	return widths, nil
+}
+
+// fixedEncoder writes records as lines of fixed-width columns, with each value
+// padded with spaces or truncated to the width of its column, preceded by a
+// header line of the column names unless noHeader is set.  Columns without a
+// width in widths are given the width of their longest value plus one.  As this
+// is only known once every record has been seen, the records are buffered,
+// within the memory limit, and written by finish when any column needs its
+// width computed
+type fixedEncoder struct {
+	widths      map[string]int
+	noHeader    bool
+	wroteHeader bool
+	columns     []Column
+	records     *recordBuffer
+}
+
+func (e *fixedEncoder) encode(w io.Writer, columns []Column, records [][]string) error {
+	for _, col := range columns {
+		if _, ok := e.widths[col.Name]; !ok {
+			if e.columns == nil {
+				e.columns = columns
+			}
+			if err := e.records.add(records); err != nil {
+				return fmt.Errorf("fixed output: %w", err)
+			}
+			return nil
+		}
+	}
+	return e.write(w, columns, e.widths, records)
+}
+
+func (e *fixedEncoder) finish(w io.Writer) error {
+	if e.columns == nil {
+		return nil
+	}
+	defer e.records.release()
+
+	widths := map[string]int{}
+	for _, col := range e.columns {
+		if width, ok := e.widths[col.Name]; ok {
+			widths[col.Name] = width
+			continue
+		}
+		width := 0
+		if !e.noHeader {
+			width = utf8.RuneCountInString(col.Name)
+		}
+		widths[col.Name] = width
+	}
+	err := e.records.each(func(record []string) error {
+		for _, col := range e.columns {
+			if _, ok := e.widths[col.Name]; !ok && col.Position < len(record) {
+				widths[col.Name] = max(widths[col.Name], utf8.RuneCountInString(record[col.Position]))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, col := range e.columns {
+		if _, ok := e.widths[col.Name]; !ok {
+			widths[col.Name]++
+		}
+	}
+
+	if e.records.len() == 0 {
+		return e.write(w, e.columns, widths, nil)
+	}
+	batch := [][]string{}
+	err = e.records.each(func(record []string) error {
+		if batch = append(batch, record); len(batch) == bufferedBatchSize {
+			err := e.write(w, e.columns, widths, batch)
+			batch = batch[:0]
+			return err
+		}
+		return nil
+	})
+	if err != nil || len(batch) == 0 {
+		return err
+	}
+	return e.write(w, e.columns, widths, batch)
+}
+
+// write writes the header, if not yet written, and the records with the widths
+func (e *fixedEncoder) write(w io.Writer, columns []Column, widths map[string]int, records [][]string) error {
+	columns = columnsByPosition(columns)
+	var b strings.Builder
+	if !e.noHeader && !e.wroteHeader {
+		for _, col := range columns {
+			b.WriteString(fixedValue(col.Name, widths[col.Name]))
+		}
+		b.WriteByte('\n')
+		e.wroteHeader = true
+	}
+	for _, record := range records {
+		for _, col := range columns {
+			value := ""
+			if col.Position < len(record) {
+				value = record[col.Position]
+			}
+			b.WriteString(fixedValue(value, widths[col.Name]))
+		}
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// fixedValue returns the value padded with spaces or truncated to width characters
+func fixedValue(value string, width int) string {
+	n := utf8.RuneCountInString(value)
+	if n <= width {
+		return value + strings.Repeat(" ", width-n)
+	}
+	i := 0
+	for range width {
+		_, size := utf8.DecodeRuneInString(value[i:])
+		i += size
+	}
+	return value[:i]
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFixedOutput(t *testing.T) {
+	columns := testColumns("id", "name")
+	pages := [][][]string{{{"1", "ab"}, {"22", "héllo"}}, {{"333", "x"}}}
+	for _, test := range []struct {
+		name   string
+		widths map[string]int
+		want   string
+	}{
+		{name: "auto", want: "id  name  \n1   ab    \n22  héllo \n333 x     \n"},
+		{name: "widths", widths: map[string]int{"id": 2, "name": 3}, want: "idnam\n1 ab \n22hél\n33x  \n"},
+		{name: "mixed", widths: map[string]int{"name": 4}, want: "id  name\n1   ab  \n22  héll\n333 x   \n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.txt")
+			sink, err := newStreamSink(context.Background(), OutputFormatFixed, path, defaultOutputBufferSize, 0, encoderOptions{widths: test.widths})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, records := range pages {
+				if err := sink.WriteRecords(columns, records); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := os.ReadFile(path); string(b) != test.want {
+				t.Errorf("wrote %q, want %q", b, test.want)
+			}
+		})
+	}
+}
+
+func TestParseWidths(t *testing.T) {
+	widths, err := parseWidths("id:3, name:10")
+	if err != nil || len(widths) != 2 || widths["id"] != 3 || widths["name"] != 10 {
+		t.Errorf("got %v, %v", widths, err)
+	}
+	for _, spec := range []string{"id", "id:0", ":3", "id:x"} {
+		if _, err := parseWidths(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
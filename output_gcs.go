@@ -0,0 +1,47 @@
+//go:build gcs
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+func init() {
+	outputOpeners["gs"] = openGCSOutput
+}
+
+// gcsOutput streams what is written to it to a Cloud Storage object
+type gcsOutput struct {
+	*storage.Writer
+	client *storage.Client
+}
+
+// openGCSOutput starts the upload of gs://bucket/object, using Application
+// Default Credentials (STORAGE_EMULATOR_HOST selects an emulator)
+func openGCSOutput(ctx context.Context, u *url.URL) (io.WriteCloser, error) {
+	bucket, object := u.Host, strings.TrimPrefix(u.Path, "/")
+	if len(bucket) == 0 || len(object) == 0 {
+		return nil, fmt.Errorf("output %v: expected gs://bucket/object", u)
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsOutput{Writer: client.Bucket(bucket).Object(object).NewWriter(ctx), client: client}, nil
+}
+
+// Close completes the upload, returning any error from it
+func (o *gcsOutput) Close() error {
+	err := o.Writer.Close()
+	if cerr := o.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+//go:build gcs
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gcsMock is a Cloud Storage emulator accepting multipart uploads of objects
+type gcsMock struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *gcsMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := strings.CutPrefix(r.URL.Path, "/upload/storage/v1/b/")
+	if !ok || r.Method != http.MethodPost || r.URL.Query().Get("uploadType") != "multipart" {
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+		return
+	}
+	bucket = strings.TrimSuffix(bucket, "/o")
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The first part is the object's metadata, the second its content
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var parts [][]byte
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(p)
+		parts = append(parts, b)
+	}
+	if len(parts) != 2 {
+		http.Error(w, "expected metadata and media", http.StatusBadRequest)
+		return
+	}
+	var meta struct{ Name string }
+	if err := json.Unmarshal(parts[0], &meta); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := meta.Name
+
+	m.mu.Lock()
+	m.objects[bucket+"/"+name] = parts[1]
+	m.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"bucket":%q,"name":%q,"size":"%v"}`, bucket, name, len(parts[1]))
+}
+
+func newGCSMock(t *testing.T) *gcsMock {
+	t.Helper()
+	m := &gcsMock{objects: map[string][]byte{}}
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(s.URL, "http://"))
+	return m
+}
+
+func TestGCSOutput(t *testing.T) {
+	m := newGCSMock(t)
+	out, err := openOutput(context.Background(), "gs://bucket/dir/records.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{`{"id":"1"}` + "\n", `{"id":"2"}` + "\n"} {
+		if _, err := io.WriteString(out, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if got := string(m.objects["bucket/dir/records.ndjson"]); got != `{"id":"1"}`+"\n"+`{"id":"2"}`+"\n" {
+		t.Errorf("uploaded %q", got)
+	}
+}
+
+func TestGCSOutputURL(t *testing.T) {
+	newGCSMock(t)
+	if _, err := openOutput(context.Background(), "gs://bucket/"); err == nil || !strings.Contains(err.Error(), "expected gs://bucket/object") {
+		t.Errorf("got %v, want the expected URL form", err)
+	}
+}
+
+func TestGCSOutputOfRun(t *testing.T) {
+	m := newGCSMock(t)
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", "gs://bucket/out.csv"); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if got := string(m.objects["bucket/out.csv"]); got != "id\n1\n2\n" {
+		t.Errorf("uploaded %q", got)
+	}
+}
@@ -0,0 +1,162 @@
+//go:build parquet
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func init() {
+	outputEncoders[OutputFormatParquet] = func() recordEncoder { return &parquetEncoder{} }
+}
+
+// parquetEncoder writes records to a Parquet file whose schema is derived from
+// the columns of the first page, with a row group written for each page so that
+// only a page of records is held in memory.  Fields are optional, with empty
+// values written as null, except those of columns the server declares not
+// nullable, which are required and cannot be written empty
+type parquetEncoder struct {
+	columns []Column
+	indexes []int
+	writer  *parquet.Writer
+}
+
+// parquetRequired reports whether the field of the column is required, as the
+// column is not nullable
+func parquetRequired(col Column) bool {
+	return col.Nullable != nil && !*col.Nullable
+}
+
+// parquetNode returns the schema node of the column, by its type
+func parquetNode(col Column) parquet.Node {
+	var node parquet.Node
+	switch col.Type {
+	case ColumnTypeInt:
+		node = parquet.Int(64)
+	case ColumnTypeFloat:
+		node = parquet.Leaf(parquet.DoubleType)
+	case ColumnTypeBool:
+		node = parquet.Leaf(parquet.BooleanType)
+	case ColumnTypeTimestamp:
+		node = parquet.Timestamp(parquet.Microsecond)
+	default:
+		node = parquet.String()
+	}
+	if parquetRequired(col) {
+		return parquet.Required(node)
+	}
+	return parquet.Optional(node)
+}
+
+// parquetValue returns the value of a column of the type, parsed from s
+func parquetValue(typ, s string) (parquet.Value, error) {
+	if len(s) == 0 {
+		return parquet.NullValue(), nil
+	}
+	switch typ {
+	case ColumnTypeInt:
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return parquet.Value{}, fmt.Errorf("%q is not an int", s)
+		}
+		return parquet.Int64Value(i), nil
+	case ColumnTypeFloat:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return parquet.Value{}, fmt.Errorf("%q is not a float", s)
+		}
+		return parquet.DoubleValue(f), nil
+	case ColumnTypeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return parquet.Value{}, fmt.Errorf("%q is not a bool", s)
+		}
+		return parquet.BooleanValue(b), nil
+	case ColumnTypeTimestamp:
+		t, err := parseTimestamp(s)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.Int64Value(t.UnixMicro()), nil
+	default:
+		return parquet.ByteArrayValue([]byte(s)), nil
+	}
+}
+
+// open creates the writer, with the schema of the columns
+func (e *parquetEncoder) open(w io.Writer, columns []Column) error {
+	group := parquet.Group{}
+	for _, col := range columns {
+		if _, ok := group[col.Name]; ok {
+			return fmt.Errorf("parquet: duplicate column %v", col.Name)
+		}
+		group[col.Name] = parquetNode(col)
+	}
+
+	// Fields of the schema are ordered by name, giving the index of each column's values
+	e.indexes = make([]int, len(columns))
+	for i, field := range group.Fields() {
+		for j, col := range columns {
+			if col.Name == field.Name() {
+				e.indexes[j] = i
+			}
+		}
+	}
+
+	e.columns = columns
+	e.writer = parquet.NewWriter(w, parquet.NewSchema("records", group))
+	return nil
+}
+
+func (e *parquetEncoder) encode(w io.Writer, columns []Column, records [][]string) error {
+	if e.writer == nil {
+		if err := e.open(w, columns); err != nil {
+			return err
+		}
+	} else if !reflect.DeepEqual(e.columns, columns) {
+		return fmt.Errorf("parquet: pages have differing columns")
+	}
+
+	rows := make([]parquet.Row, len(records))
+	for i, record := range records {
+		row := make(parquet.Row, len(e.columns))
+		for j, col := range e.columns {
+			s := ""
+			if col.Position < len(record) {
+				s = record[col.Position]
+			}
+			v, err := parquetValue(col.Type, s)
+			if err != nil {
+				return fmt.Errorf("parquet: record %v: column %v: %v", i, col.Name, err)
+			}
+			definition := 1
+			switch {
+			case parquetRequired(col) && v.IsNull():
+				return fmt.Errorf("parquet: record %v: column %v: null in a column that is not nullable", i, col.Name)
+			case parquetRequired(col) || v.IsNull():
+				definition = 0
+			}
+			row[e.indexes[j]] = v.Level(0, definition, e.indexes[j])
+		}
+		rows[i] = row
+	}
+
+	if _, err := e.writer.WriteRows(rows); err != nil {
+		return err
+	}
+	return e.writer.Flush()
+}
+
+// finish writes the Parquet footer
+func (e *parquetEncoder) finish(w io.Writer) error {
+	if e.writer == nil {
+		return nil
+	}
+	return e.writer.Close()
+}
@@ -0,0 +1,104 @@
+//go:build parquet
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetOutput(t *testing.T) {
+	notNullable := false
+	columns := []Column{
+		{Name: "id", Type: ColumnTypeInt, Position: 0, Nullable: &notNullable},
+		{Name: "amount", Type: ColumnTypeFloat, Position: 1},
+		{Name: "active", Type: ColumnTypeBool, Position: 2},
+		{Name: "updated", Type: ColumnTypeTimestamp, Position: 3},
+		{Name: "name", Type: ColumnTypeString, Position: 4},
+	}
+	pages := [][][]string{
+		{{"1", "1.5", "true", "2024-03-01T12:00:00Z", "a"}, {"2", "", "false", "", "b"}},
+		{{"3", "-2", "", "2024-03-02", ""}},
+	}
+	path := filepath.Join(t.TempDir(), "out.parquet")
+	sink, err := newStreamSink(context.Background(), OutputFormatParquet, path, defaultOutputBufferSize, 0, encoderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, records := range pages {
+		if err := sink.WriteRecords(columns, records); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.NumRows() != 3 || len(pf.RowGroups()) != len(pages) {
+		t.Errorf("%v rows in %v row groups, want 3 in %v", pf.NumRows(), len(pf.RowGroups()), len(pages))
+	}
+
+	type row struct {
+		ID      int64    `parquet:"id"`
+		Amount  *float64 `parquet:"amount,optional"`
+		Active  *bool    `parquet:"active,optional"`
+		Updated *int64   `parquet:"updated,optional"`
+		Name    *string  `parquet:"name,optional"`
+	}
+	rows, err := parquet.Read[row](f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("read %v rows", len(rows))
+	}
+	if r := rows[0]; r.ID != 1 || *r.Amount != 1.5 || !*r.Active || *r.Updated != 1709294400000000 || *r.Name != "a" {
+		t.Errorf("first row %+v", r)
+	}
+	if r := rows[1]; r.ID != 2 || r.Amount != nil || *r.Active || r.Updated != nil {
+		t.Errorf("second row %+v, want nulls for the empty values", r)
+	}
+	if r := rows[2]; r.ID != 3 || *r.Amount != -2 || r.Active != nil || r.Name != nil {
+		t.Errorf("third row %+v", r)
+	}
+}
+
+func TestParquetOutputErrors(t *testing.T) {
+	notNullable := false
+	for _, test := range []struct {
+		name    string
+		columns []Column
+		records [][]string
+		err     string
+	}{
+		{name: "invalid value", columns: []Column{{Name: "id", Type: ColumnTypeInt}}, records: [][]string{{"one"}}, err: `column id: "one" is not an int`},
+		{name: "null", columns: []Column{{Name: "id", Type: ColumnTypeInt, Nullable: &notNullable}}, records: [][]string{{""}}, err: "null in a column that is not nullable"},
+		{name: "duplicate", columns: []Column{{Name: "id", Type: ColumnTypeInt}, {Name: "id", Type: ColumnTypeInt, Position: 1}}, records: [][]string{{"1", "2"}}, err: "duplicate column id"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sink, err := newStreamSink(context.Background(), OutputFormatParquet, filepath.Join(t.TempDir(), "out.parquet"), defaultOutputBufferSize, 0, encoderOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+			if err := sink.WriteRecords(test.columns, test.records); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+)
+
+func init() {
+	outputOpeners["unix"] = openUnixOutput
+}
+
+// readerGoneError reports that the reader of a socket or named pipe output
+// disconnected before the output was complete
+type readerGoneError struct {
+	output string
+	err    error
+}
+
+func (e *readerGoneError) Error() string {
+	return fmt.Sprintf("output %v: reader disconnected: %v", e.output, e.err)
+}
+
+func (e *readerGoneError) Unwrap() error {
+	return e.err
+}
+
+// pipeOutput is a socket or named pipe output, whose writes fail with a
+// readerGoneError once the reader disconnects
+type pipeOutput struct {
+	io.WriteCloser
+	name string
+}
+
+func (p *pipeOutput) Write(b []byte) (int, error) {
+	n, err := p.WriteCloser.Write(b)
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		err = &readerGoneError{output: p.name, err: err}
+	}
+	return n, err
+}
+
+// openUnixOutput connects to the Unix domain socket of unix:///path, on which
+// a reader is listening, streaming the output to it
+func openUnixOutput(ctx context.Context, u *url.URL) (io.WriteCloser, error) {
+	path := u.Path
+	if len(u.Host) > 0 {
+		// unix://relative/path
+		path = u.Host + u.Path
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("output %v: expected unix:///path", u)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("output %v: %w", u, err)
+	}
+	return &pipeOutput{WriteCloser: conn, name: u.String()}, nil
+}
+
+// openNamedPipe opens the existing named pipe at path for writing, waiting for
+// a reader to open its other end
+func openNamedPipe(path string) (io.WriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeOutput{WriteCloser: f, name: path}, nil
+}
+
+// isNamedPipe reports whether path is an existing named pipe
+func isNamedPipe(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
@@ -0,0 +1,170 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// shortTempDir returns a temporary directory whose path is short enough for a
+// Unix domain socket, removed when the test ends
+func shortTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "dpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// readAll returns a channel of everything read from the reader opened by open
+func readAll(t *testing.T, open func() (io.ReadCloser, error)) <-chan string {
+	t.Helper()
+	ch := make(chan string, 1)
+	go func() {
+		r, err := open()
+		if err != nil {
+			t.Error(err)
+			ch <- ""
+			return
+		}
+		defer r.Close()
+		b, _ := io.ReadAll(r)
+		ch <- string(b)
+	}()
+	return ch
+}
+
+// receivedFrom returns what was read, failing the test if the reader does not finish
+func receivedFrom(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	select {
+	case s := <-ch:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader did not finish")
+		return ""
+	}
+}
+
+func TestNamedPipeOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.fifo")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skip(err)
+	}
+	if !isNamedPipe(path) || isNamedPipe(filepath.Dir(path)) {
+		t.Fatal("named pipe not identified")
+	}
+	ch := readAll(t, func() (io.ReadCloser, error) { return os.Open(path) })
+
+	s := newPageServer(t, numberedPages(3, 2))
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-output-format", "csv", "-output", path); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if got := receivedFrom(t, ch); got != "id\n0\n1\n2\n3\n4\n5\n" {
+		t.Errorf("read %q", got)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Error("named pipe replaced")
+	}
+}
+
+func TestUnixSocketOutput(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "out.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	ch := readAll(t, func() (io.ReadCloser, error) { return l.Accept() })
+
+	s := newPageServer(t, numberedPages(2, 1))
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-output-format", "ndjson", "-output", "unix://"+path); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if got := receivedFrom(t, ch); got != "{\"id\":\"0\"}\n{\"id\":\"1\"}\n" {
+		t.Errorf("read %q", got)
+	}
+
+	if _, err := openOutput(context.Background(), "unix://"); err == nil || !strings.Contains(err.Error(), "expected unix:///path") {
+		t.Errorf("got %v, want the missing path reported", err)
+	}
+	if _, err := openOutput(context.Background(), "unix://"+path+".missing"); err == nil || !strings.HasPrefix(err.Error(), "output unix://"+path+".missing: ") {
+		t.Errorf("got %v, want the failed connection reported", err)
+	}
+}
+
+// writeUntilError writes to w until a write fails, returning the failure
+func writeUntilError(t *testing.T, w io.Writer) error {
+	t.Helper()
+	b := make([]byte, 64*1024)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	t.Fatal("writes did not fail")
+	return nil
+}
+
+func TestReaderGone(t *testing.T) {
+	t.Run("named pipe", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.fifo")
+		if err := syscall.Mkfifo(path, 0o600); err != nil {
+			t.Skip(err)
+		}
+		opened := make(chan *os.File, 1)
+		go func() {
+			r, err := os.Open(path)
+			if err != nil {
+				t.Error(err)
+			}
+			opened <- r
+		}()
+		w, err := openOutput(context.Background(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		(<-opened).Close()
+
+		var gone *readerGoneError
+		if err := writeUntilError(t, w); !errors.As(err, &gone) || !strings.HasPrefix(err.Error(), "output "+path+": reader disconnected: ") {
+			t.Errorf("got %v, want the reader's disconnection reported", err)
+		}
+	})
+
+	t.Run("socket", func(t *testing.T) {
+		path := filepath.Join(shortTempDir(t), "out.sock")
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			t.Skip(err)
+		}
+		defer l.Close()
+		go func() {
+			if conn, err := l.Accept(); err == nil {
+				conn.Close()
+			}
+		}()
+		w, err := openOutput(context.Background(), "unix://"+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		var gone *readerGoneError
+		if err := writeUntilError(t, w); !errors.As(err, &gone) {
+			t.Errorf("got %v, want the reader's disconnection reported", err)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+//go:build s3
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func init() {
+	outputOpeners["s3"] = openS3Output
+}
+
+// s3Output streams what is written to it to an S3 object as a multipart upload,
+// so the object is never held in memory in full
+type s3Output struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// openS3Output starts the upload of s3://bucket/key, using the default AWS
+// configuration (environment, shared config, AWS_ENDPOINT_URL).  Path style
+// addressing, as needed by most S3 compatible stores, is used if
+// AWS_S3_USE_PATH_STYLE is "true"
+func openS3Output(ctx context.Context, u *url.URL) (io.WriteCloser, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if len(bucket) == 0 || len(key) == 0 {
+		return nil, fmt.Errorf("output %v: expected s3://bucket/key", u)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = os.Getenv("AWS_S3_USE_PATH_STYLE") == "true"
+	})
+
+	pr, pw := io.Pipe()
+	out := &s3Output{pw: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := manager.NewUploader(client).Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		})
+		pr.CloseWithError(err)
+		out.done <- err
+	}()
+	return out, nil
+}
+
+func (o *s3Output) Write(p []byte) (int, error) {
+	return o.pw.Write(p)
+}
+
+// Close completes the upload, returning any error from it
+func (o *s3Output) Close() error {
+	o.pw.Close()
+	return <-o.done
+}
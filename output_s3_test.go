@@ -0,0 +1,163 @@
+//go:build s3
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// s3Mock is an S3 compatible store of path style addressed objects, accepting
+// single and multipart uploads
+type s3Mock struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[string]map[int][]byte
+	uploads int
+}
+
+func (m *s3Mock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := readS3Body(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		m.uploads++
+		id := strconv.Itoa(m.uploads)
+		m.parts[id] = map[int][]byte{}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><UploadId>%v</UploadId></InitiateMultipartUploadResult>`, id)
+	case r.Method == http.MethodPut && q.Has("partNumber"):
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		m.parts[q.Get("uploadId")][n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%v"`, n))
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		parts := m.parts[q.Get("uploadId")]
+		numbers := []int{}
+		for n := range parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var object []byte
+		for _, n := range numbers {
+			object = append(object, parts[n]...)
+		}
+		m.objects[r.URL.Path] = object
+		fmt.Fprint(w, `<CompleteMultipartUploadResult></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodPut:
+		m.objects[r.URL.Path] = body
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
+
+// readS3Body returns the content of the request, decoding aws-chunked bodies
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return io.ReadAll(r.Body)
+	}
+	var content []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(strings.SplitN(line, ";", 2)[0]), 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return content, nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		content = append(content, chunk[:size]...)
+	}
+}
+
+func newS3Mock(t *testing.T) *s3Mock {
+	t.Helper()
+	m := &s3Mock{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+	t.Setenv("AWS_ENDPOINT_URL", s.URL)
+	t.Setenv("AWS_S3_USE_PATH_STYLE", "true")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	return m
+}
+
+func TestS3Output(t *testing.T) {
+	for _, size := range []int{10, 6 << 20} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			m := newS3Mock(t)
+			u, _ := url.Parse("s3://bucket/dir/records.ndjson")
+			out, err := openOutput(context.Background(), u.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Written in pieces, as the encoders do, over the multipart size when large
+			content := bytes.Repeat([]byte("0123456789"), size/10)
+			for b := content; len(b) > 0; b = b[min(len(b), 64<<10):] {
+				if _, err := out.Write(b[:min(len(b), 64<<10)]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := out.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if got := m.objects["/bucket/dir/records.ndjson"]; !bytes.Equal(got, content) {
+				t.Errorf("uploaded %v bytes, want the %v written", len(got), len(content))
+			}
+			if multipart := m.uploads > 0; multipart != (size > 5<<20) {
+				t.Errorf("%v multipart uploads of %v bytes", m.uploads, size)
+			}
+		})
+	}
+}
+
+func TestS3OutputURL(t *testing.T) {
+	newS3Mock(t)
+	if _, err := openOutput(context.Background(), "s3://bucket"); err == nil || !strings.Contains(err.Error(), "expected s3://bucket/key") {
+		t.Errorf("got %v, want the expected URL form", err)
+	}
+}
+
+func TestS3OutputOfRun(t *testing.T) {
+	m := newS3Mock(t)
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", "s3://bucket/out.csv"); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if got := string(m.objects["/bucket/out.csv"]); got != "id\n1\n2\n" {
+		t.Errorf("uploaded %q", got)
+	}
+}
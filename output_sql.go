@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// defaultSQLBatchSize is the default number of rows of each INSERT statement
+const defaultSQLBatchSize = 500
+
+// How the values of the sql output format are written
+const (
+	SQLValuesTyped = "typed"
+	SQLValuesText  = "text"
+)
+
+// sqlEncoder writes records as multi-row INSERT statements into table, with up
+// to batchSize rows per statement.  Identifiers are double quoted and values
+// single quoted, as ANSI SQL and most databases expect, with quotes within them
+// doubled, and null values written as NULL.  With SQLValuesTyped, the values of
+// int, float and bool columns are written as numbers and booleans where they
+// parse as such, leaving the database to convert the others, whilst with
+// SQLValuesText every value is a string literal.  As the output is a script,
+// the values are literals rather than parameters
+type sqlEncoder struct {
+	table     string
+	batchSize int
+	values    string
+}
+
+// quoteSQLIdentifier returns the identifier double quoted, with each part of a
+// schema qualified name such as schema.table quoted separately
+func quoteSQLIdentifier(name string, qualified bool) string {
+	parts := []string{name}
+	if qualified {
+		parts = strings.Split(name, ".")
+	}
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+// sqlLiteral returns the value of a column of the type as an SQL literal
+func (e *sqlEncoder) sqlLiteral(typ, value string) string {
+	if len(value) == 0 {
+		return "NULL"
+	}
+	if e.values == SQLValuesTyped {
+		switch typ {
+		case ColumnTypeInt:
+			if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return value
+			}
+		case ColumnTypeFloat:
+			// Decimal forms only, as Go also parses hexadecimal, infinite and NaN forms
+			if _, err := strconv.ParseFloat(value, 64); err == nil && len(strings.Trim(value, "0123456789+-.eE")) == 0 {
+				return value
+			}
+		case ColumnTypeBool:
+			if b, err := strconv.ParseBool(value); err == nil {
+				return strings.ToUpper(strconv.FormatBool(b))
+			}
+		}
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func (e *sqlEncoder) encode(w io.Writer, columns []Column, records [][]string) error {
+	if len(records) == 0 {
+		return nil
+	}
+	columns = columnsByPosition(columns)
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = quoteSQLIdentifier(col.Name, false)
+	}
+	insert := fmt.Sprintf("INSERT INTO %v (%v) VALUES\n", quoteSQLIdentifier(e.table, true), strings.Join(names, ", "))
+
+	var b strings.Builder
+	values := make([]string, len(columns))
+	for start := 0; start < len(records); start += e.batchSize {
+		b.Reset()
+		b.WriteString(insert)
+		batch := records[start:min(start+e.batchSize, len(records))]
+		for r, record := range batch {
+			for i, col := range columns {
+				value := ""
+				if col.Position < len(record) {
+					value = record[col.Position]
+				}
+				values[i] = e.sqlLiteral(col.Type, value)
+			}
+			b.WriteString("  (")
+			b.WriteString(strings.Join(values, ", "))
+			if r < len(batch)-1 {
+				b.WriteString("),\n")
+			} else {
+				b.WriteString(");\n")
+			}
+		}
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// sqlColumns are columns of each type, one with a double quote in its name
+var sqlColumns = []Column{
+	{Name: "id", Type: ColumnTypeInt, Position: 0},
+	{Name: `na"me`, Type: ColumnTypeString, Position: 1},
+	{Name: "score", Type: ColumnTypeFloat, Position: 2},
+	{Name: "ok", Type: ColumnTypeBool, Position: 3},
+}
+
+// sqlRecords exercise quoting, nulls and values not parsing as their type
+var sqlRecords = [][]string{
+	{"1", "O'Brien", "1.5", "true"},
+	{"2", "", "", "false"},
+	{"3", "a;b--c", "1e3", ""},
+	{"n/a", "x", "0x10", "maybe"},
+	{"5", "last"},
+}
+
+// runSQLite returns the output of sqlite3 running the script on an in-memory
+// database, skipping the test without sqlite3
+func runSQLite(t *testing.T, script string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not found")
+	}
+	cmd := exec.Command("sqlite3", "-bail", ":memory:")
+	cmd.Stdin = strings.NewReader(script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("sqlite3: %v: %v", err, stderr.String())
+	}
+	return string(out)
+}
+
+func TestSQLEncoder(t *testing.T) {
+	var b bytes.Buffer
+	e := &sqlEncoder{table: "s.t", batchSize: 2, values: SQLValuesTyped}
+	if err := e.encode(&b, sqlColumns, sqlRecords[:3]); err != nil {
+		t.Fatal(err)
+	}
+	want := `INSERT INTO "s"."t" ("id", "na""me", "score", "ok") VALUES
+  (1, 'O''Brien', 1.5, TRUE),
+  (2, NULL, NULL, FALSE);
+INSERT INTO "s"."t" ("id", "na""me", "score", "ok") VALUES
+  (3, 'a;b--c', 1e3, NULL);
+`
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	e.values = SQLValuesText
+	if err := e.encode(&b, sqlColumns, sqlRecords[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if want := "INSERT INTO \"s\".\"t\" (\"id\", \"na\"\"me\", \"score\", \"ok\") VALUES\n  ('1', 'O''Brien', '1.5', 'true');\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestSQLLiteral(t *testing.T) {
+	e := &sqlEncoder{values: SQLValuesTyped}
+	for _, test := range []struct {
+		typ, value, want string
+	}{
+		{ColumnTypeInt, "-12", "-12"},
+		{ColumnTypeInt, "1.5", "'1.5'"},
+		{ColumnTypeFloat, "-1.5E-3", "-1.5E-3"},
+		{ColumnTypeFloat, "0x10", "'0x10'"},
+		{ColumnTypeFloat, "NaN", "'NaN'"},
+		{ColumnTypeBool, "1", "TRUE"},
+		{ColumnTypeBool, "no", "'no'"},
+		{ColumnTypeString, "it's", "'it''s'"},
+		{ColumnTypeTimestamp, "", "NULL"},
+	} {
+		if got := e.sqlLiteral(test.typ, test.value); got != test.want {
+			t.Errorf("%v %q: got %v, want %v", test.typ, test.value, got, test.want)
+		}
+	}
+}
+
+func TestSQLOutputExecutes(t *testing.T) {
+	for _, values := range []string{SQLValuesTyped, SQLValuesText} {
+		t.Run(values, func(t *testing.T) {
+			var b bytes.Buffer
+			b.WriteString(`CREATE TABLE "people" ("id", "na""me", "score", "ok");` + "\n")
+			e := &sqlEncoder{table: "main.people", batchSize: 2, values: values}
+			if err := e.encode(&b, sqlColumns, sqlRecords); err != nil {
+				t.Fatal(err)
+			}
+			b.WriteString(".nullvalue NULL\n")
+			b.WriteString(`SELECT "id", "na""me", "score", "ok", typeof("id") FROM "people" ORDER BY rowid;` + "\n")
+
+			idType := "integer"
+			if values == SQLValuesText {
+				idType = "text"
+			}
+			want := strings.Join([]string{
+				"1|O'Brien|1.5|1|" + idType,
+				"2|NULL|NULL|0|" + idType,
+				"3|a;b--c|1000.0|NULL|" + idType,
+				"n/a|x|0x10|maybe|text",
+				"5|last|NULL|NULL|" + idType,
+			}, "\n") + "\n"
+			if values == SQLValuesText {
+				want = strings.NewReplacer("|1.5|1|", "|1.5|true|", "|NULL|0|", "|NULL|false|", "|1000.0|", "|1e3|").Replace(want)
+			}
+			if got := runSQLite(t, b.String()); got != want {
+				t.Errorf("got\n%v\nwant\n%v", got, want)
+			}
+		})
+	}
+}
+
+func TestSQLOutputFlag(t *testing.T) {
+	s := newPageServer(t, nil)
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		json.NewEncoder(w).Encode(ResultSet{Data: Data{Header: Header{Columns: sqlColumns}, Records: Records(sqlRecords)}})
+		return true
+	})
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "sql", "-table", "people", "-sql-batch-size", "2", "-records-only")
+	if code != 0 || strings.Count(stdout, "INSERT INTO") != 3 {
+		t.Fatalf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	script := `CREATE TABLE "people" ("id", "na""me", "score", "ok");` + "\n" + stdout + "SELECT count(*), sum(\"ok\" IS NULL) FROM \"people\";\n"
+	if got := runSQLite(t, script); got != "5|2\n" {
+		t.Errorf("got %q, want the 5 records inserted", got)
+	}
+
+	for _, args := range [][]string{{"-output-format", "sql"}, {"-table", "people"}, {"-output-format", "sql", "-table", "people", "-sql-values", "hex"}, {"-output-format", "sql", "-table", "people", "-sql-batch-size", "0"}} {
+		if _, _, code := runMain(t, append([]string{"-url", s.URL, "-hash", "h", "-token", "t1"}, args...)...); code == 0 {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
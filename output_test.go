@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamSinkFlushInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	sink, err := newStreamSink(context.Background(), OutputFormatCSV, path, defaultOutputBufferSize, 50*time.Millisecond, encoderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := testColumns("id")
+	read := func() string {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	if err := sink.WriteRecords(columns, [][]string{{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "" {
+		t.Errorf("flushed %q within the flush interval", got)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := sink.WriteRecords(columns, [][]string{{"2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "id\n1\n2\n" {
+		t.Errorf("after the flush interval got %q", got)
+	}
+	if err := sink.WriteRecords(columns, [][]string{{"3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "id\n1\n2\n3\n" {
+		t.Errorf("after Close got %q", got)
+	}
+}
+
+func TestOutputFlushedOnFailure(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{{{"1"}, {"2"}}, {{"3"}}, {{"4"}}}))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "t3" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return true
+		}
+		return false
+	})
+	path := filepath.Join(t.TempDir(), "out.csv")
+
+	// The records retrieved before the failure are all written, despite the
+	// buffer being larger than them and never reaching its flush interval
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", path, "-output-flush-interval", "1h")
+	if code == 0 {
+		t.Fatalf("run succeeded, stderr %q", stderr)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); got != "id\n1\n2\n3\n" {
+		t.Errorf("output %q, want the records of the first two pages", got)
+	}
+}
+
+func BenchmarkStreamSink(b *testing.B) {
+	columns := testColumns("id", "name", "value")
+	records := make([][]string, 100)
+	for i := range records {
+		records[i] = []string{fmt.Sprint(i), strings.Repeat("n", 20), fmt.Sprint(i * 1000)}
+	}
+	for _, size := range []int{1, 4 * 1024, defaultOutputBufferSize} {
+		b.Run(fmt.Sprintf("buffer %v", size), func(b *testing.B) {
+			sink, err := newStreamSink(context.Background(), OutputFormatNDJSON, filepath.Join(b.TempDir(), "out.ndjson"), size, 0, encoderOptions{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer sink.Close()
+			for b.Loop() {
+				if err := sink.WriteRecords(columns, records); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputSchemeWithoutTag(t *testing.T) {
+	for scheme, tag := range outputSchemeTags {
+		if _, ok := outputOpeners[scheme]; ok {
+			continue
+		}
+		_, err := openOutput(context.Background(), scheme+"://bucket/key")
+		if want := "requires building with -tags " + tag; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%v: got %v, want %q", scheme, err, want)
+		}
+	}
+	if _, err := openOutput(context.Background(), "ftp://host/file"); err == nil || !strings.Contains(err.Error(), "unsupported output scheme ftp") {
+		t.Errorf("got %v, want an unsupported scheme", err)
+	}
+}
+
+func TestNoHeader(t *testing.T) {
+	columns := []Column{{Name: "b", Type: "string", Position: 1}, {Name: "a", Type: "string", Position: 0}}
+	for _, test := range []struct {
+		format   string
+		noHeader bool
+		want     string
+	}{
+		{format: OutputFormatCSV, want: "a,b\n1,x\n2,y\n"},
+		{format: OutputFormatCSV, noHeader: true, want: "1,x\n2,y\n"},
+		{format: OutputFormatFixed, noHeader: true, want: "1 x \n2 y \n"},
+	} {
+		t.Run(fmt.Sprintf("%v %v", test.format, test.noHeader), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out")
+			sink, err := newStreamSink(context.Background(), test.format, path, defaultOutputBufferSize, 0, encoderOptions{noHeader: test.noHeader})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, records := range [][][]string{{{"1", "x"}}, {{"2", "y"}}} {
+				if err := sink.WriteRecords(columns, records); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := os.ReadFile(path); string(b) != test.want {
+				t.Errorf("wrote %q, want %q", b, test.want)
+			}
+		})
+	}
+}
+
+func TestNoHeaderPartitioned(t *testing.T) {
+	columns := testColumns("region", "id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"eu", "1"}, {"us", "2"}}, {{"eu", "3"}}}))
+	dir := t.TempDir()
+
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", dir, "-partition-by", "region", "-no-header")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	entries, _ := os.ReadDir(dir)
+	got := map[string]string{}
+	for _, entry := range entries {
+		b, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+		got[entry.Name()] = string(b)
+	}
+	if len(got) != 2 {
+		t.Fatalf("wrote %v", got)
+	}
+	for name, content := range got {
+		if strings.Contains(content, "region") {
+			t.Errorf("%v has a header: %q", name, content)
+		}
+		if !strings.HasPrefix(content, "eu,") && !strings.HasPrefix(content, "us,") {
+			t.Errorf("%v: %q, want the records in column order", name, content)
+		}
+	}
+}
+
+func TestNDJSONRecordSeparator(t *testing.T) {
+	columns := testColumns("id")
+	for _, rs := range []bool{false, true} {
+		t.Run(fmt.Sprint(rs), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.ndjson")
+			sink, err := newStreamSink(context.Background(), OutputFormatNDJSON, path, defaultOutputBufferSize, 0, encoderOptions{recordSeparator: rs})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.WriteRecords(columns, [][]string{{"1"}, {"2"}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+			want := "{\"id\":\"1\"}\n{\"id\":\"2\"}\n"
+			if rs {
+				want = "\x1e{\"id\":\"1\"}\n\x1e{\"id\":\"2\"}\n"
+			}
+			if b, _ := os.ReadFile(path); string(b) != want {
+				t.Errorf("wrote %q, want %q", b, want)
+			}
+		})
+	}
+}
+
+func TestOutputAppend(t *testing.T) {
+	columns := testColumns("id", "name")
+	for _, test := range []struct {
+		name     string
+		format   string
+		existing string
+		want     string
+		err      string
+	}{
+		{name: "matching", format: OutputFormatCSV, existing: "id,name\n1,a\n", want: "id,name\n1,a\n2,b\n"},
+		{name: "mismatching", format: OutputFormatCSV, existing: "id,label\n1,a\n", err: "do not match the header id,label"},
+		{name: "new", format: OutputFormatCSV, want: "id,name\n2,b\n"},
+		{name: "ndjson", format: OutputFormatNDJSON, existing: "{\"id\":\"1\",\"name\":\"a\"}\n", want: "{\"id\":\"1\",\"name\":\"a\"}\n{\"id\":\"2\",\"name\":\"b\"}\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out")
+			if len(test.existing) > 0 {
+				if err := os.WriteFile(path, []byte(test.existing), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sink, err := newStreamSink(context.Background(), test.format, path, defaultOutputBufferSize, 0, encoderOptions{appendOutput: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = sink.WriteRecords(columns, [][]string{{"2", "b"}})
+			if cerr := sink.Close(); err == nil {
+				err = cerr
+			}
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("got %v, want %q", err, test.err)
+				}
+				if b, _ := os.ReadFile(path); string(b) != test.existing {
+					t.Errorf("file changed to %q", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := os.ReadFile(path); string(b) != test.want {
+				t.Errorf("file %q, want %q", b, test.want)
+			}
+		})
+	}
+}
+
+func TestOutputAppendFlag(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1"}, [][][]string{{{"1"}}}))
+	path := filepath.Join(t.TempDir(), "out.csv")
+	for range 2 {
+		if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", path, "-output-append"); code != 0 {
+			t.Fatalf("exit %v, stderr %q", code, stderr)
+		}
+	}
+	if b, _ := os.ReadFile(path); string(b) != "id\n1\n1\n" {
+		t.Errorf("file %q, want the records of both runs under a single header", b)
+	}
+}
+
+// jsonStrings are values exercising each escape of appendJSONString
+var jsonStrings = []string{"", "plain", `quote " and \ backslash`, "tab\tnew\nline\rcr\bbs\fff", "\x00\x01\x1f\x7f", "<html> & </html>",
+	"line\u2028para\u2029sep", "invalid \xff\xfe utf-8", "truncated \xe2\x82", "héllo wörld 日本 🎉", "\ufffd"}
+
+func TestNDJSONEncoderMatchesEncodingJSON(t *testing.T) {
+	// Duplicate names take the value of the last column in the record, as in a map
+	columns := []Column{{Name: "b", Position: 0}, {Name: "a", Position: 1}, {Name: "b", Position: 2}, {Name: "c", Position: 3}}
+	records := [][]string{}
+	for i, s := range jsonStrings {
+		records = append(records, []string{s, jsonStrings[(i+1)%len(jsonStrings)], jsonStrings[(i+2)%len(jsonStrings)], s})
+	}
+	records = append(records, []string{"short", "record"})
+
+	var got bytes.Buffer
+	if err := (&ndjsonEncoder{}).encode(&got, columns, records); err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	for _, record := range records {
+		obj := map[string]string{}
+		for _, col := range columns {
+			if col.Position < len(record) {
+				obj[col.Name] = record[col.Position]
+			}
+		}
+		b, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.Write(append(b, '\n'))
+	}
+	if got.String() != want.String() {
+		t.Errorf("encoded\n%q\nwant\n%q", got.String(), want.String())
+	}
+
+	for _, s := range jsonStrings {
+		b, _ := json.Marshal(s)
+		if got := appendJSONString(nil, s); string(got) != string(b) {
+			t.Errorf("%q: got %s, want %s", s, got, b)
+		}
+	}
+}
+
+func BenchmarkNDJSONEncoder(b *testing.B) {
+	columns := testColumns("id", "name", "value", "note")
+	records := make([][]string, 1000)
+	for i := range records {
+		records[i] = []string{fmt.Sprint(i), strings.Repeat("n", 20), fmt.Sprint(i * 1000), jsonStrings[i%len(jsonStrings)]}
+	}
+	b.Run("appendJSONString", func(b *testing.B) {
+		enc := &ndjsonEncoder{}
+		b.ReportAllocs()
+		for b.Loop() {
+			if err := enc.encode(io.Discard, columns, records); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encoding/json map", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, record := range records {
+				obj := make(map[string]string, len(columns))
+				for _, col := range columns {
+					obj[col.Name] = record[col.Position]
+				}
+				out, err := json.Marshal(obj)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Discard.Write(append(out, '\n'))
+			}
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+)
+
+// pageCacheKey identifies a page held by a PageCache.  Besides the (hash, token)
+// of the page, the endpoint and request body are included, so that clients
+// sharing a cache but requesting pages differently, such as with other server
+// fields or page limits, do not share pages
+type pageCacheKey struct {
+	url     string
+	hash    string
+	token   string
+	request string
+}
+
+// pageCacheEntry is a decoded page held by a PageCache, with the status and
+// headers of the response it was served with
+type pageCacheEntry struct {
+	key       pageCacheKey
+	status    int
+	header    http.Header
+	body      []byte
+	pageBytes int64
+	result    map[string]interface{}
+}
+
+// response returns a stand in for the response the entry was served with
+func (e *pageCacheEntry) response() *http.Response {
+	return &http.Response{StatusCode: e.status, Header: e.header, Body: http.NoBody}
+}
+
+// PageCache holds up to a number of decoded pages in memory, by (hash, token),
+// so that paginating the same pages again within the process reuses them in
+// place of requesting them from the server.  Pages are held once retrieved and
+// decoded without error, and the least recently used page is evicted once the
+// cache is full.  As a cached page is never revalidated with the server, a
+// cache should only be used while the pages of a (hash, token) do not change;
+// Purge discards every page, such as when the result set is known to have
+// changed.  A PageCache is safe for concurrent use, and may be shared by clients
+type PageCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[pageCacheKey]*list.Element
+}
+
+// NewPageCache returns an empty PageCache holding up to size pages
+func NewPageCache(size int) *PageCache {
+	return &PageCache{size: max(size, 1), order: list.New(), entries: map[pageCacheKey]*list.Element{}}
+}
+
+// get returns the cached page of the key, or nil if it is not cached
+func (p *PageCache) get(key pageCacheKey) *pageCacheEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	el, ok := p.entries[key]
+	if !ok {
+		return nil
+	}
+	p.order.MoveToFront(el)
+	return el.Value.(*pageCacheEntry)
+}
+
+// put caches the page, evicting the least recently used page if the cache is full
+func (p *PageCache) put(entry *pageCacheEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if el, ok := p.entries[entry.key]; ok {
+		el.Value = entry
+		p.order.MoveToFront(el)
+		return
+	}
+	p.entries[entry.key] = p.order.PushFront(entry)
+	for p.order.Len() > p.size {
+		oldest := p.order.Back()
+		p.order.Remove(oldest)
+		delete(p.entries, oldest.Value.(*pageCacheEntry).key)
+	}
+}
+
+// Len returns the number of pages cached
+func (p *PageCache) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.order.Len()
+}
+
+// Purge discards every cached page
+func (p *PageCache) Purge() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.order.Init()
+	clear(p.entries)
+}
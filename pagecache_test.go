@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPageCache(t *testing.T) {
+	columns := testColumns("id", "name")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{{{"1", "a"}}, {{"2", "b"}, {"3", "c"}}, {}}))
+	cache := NewPageCache(10)
+
+	first, second := &memorySink{}, &memorySink{}
+	r1, err := NewClient(s.URL, WithPageCache(cache), WithRecordSink(first)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := len(s.received())
+	if requests != 3 || cache.Len() != 3 {
+		t.Fatalf("%v requests, %v pages cached, want every page requested and cached", requests, cache.Len())
+	}
+	r2, err := NewClient(s.URL, WithPageCache(cache), WithRecordSink(second)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.received()) - requests; n != 0 {
+		t.Errorf("%v requests by the second run, want its pages from the cache", n)
+	}
+	if r2.PageCount != r1.PageCount || !reflect.DeepEqual(r2.RecordCounts, r1.RecordCounts) || !reflect.DeepEqual(second.records, first.records) ||
+		!reflect.DeepEqual(second.columns, first.columns) {
+		t.Errorf("cached run %+v of %v, want that of the first run %+v of %v", r2, second.records, r1, first.records)
+	}
+
+	// Pages requested differently are not shared
+	if _, err := NewClient(s.URL, WithPageCache(cache), WithServerFields("id")).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.received()) - requests; n != 3 {
+		t.Errorf("%v requests with server fields, want every page requested", n)
+	}
+
+	cache.Purge()
+	if cache.Len() != 0 {
+		t.Errorf("%v pages cached after purging", cache.Len())
+	}
+}
+
+func TestPageCacheFailedPage(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "t2" {
+			w.Write([]byte("not json"))
+			return true
+		}
+		return false
+	})
+	cache := NewPageCache(10)
+	if _, err := NewClient(s.URL, WithPageCache(cache)).consumeAllPages(context.Background(), "h", "t1"); err == nil {
+		t.Fatal("undecodable page accepted")
+	}
+	if cache.Len() != 1 {
+		t.Errorf("%v pages cached, want only the decoded page", cache.Len())
+	}
+}
+
+func TestPageCacheEviction(t *testing.T) {
+	cache := NewPageCache(2)
+	key := func(token string) pageCacheKey { return pageCacheKey{hash: "h", token: token} }
+	for _, token := range []string{"t1", "t2"} {
+		cache.put(&pageCacheEntry{key: key(token)})
+	}
+	// Using t1 leaves t2 the least recently used
+	cache.get(key("t1"))
+	cache.put(&pageCacheEntry{key: key("t3")})
+	if cache.Len() != 2 || cache.get(key("t1")) == nil || cache.get(key("t2")) != nil || cache.get(key("t3")) == nil {
+		t.Errorf("got %v pages, want t2 evicted", cache.Len())
+	}
+	// Replacing a page does not evict another
+	cache.put(&pageCacheEntry{key: key("t3"), status: http.StatusOK})
+	if e := cache.get(key("t3")); cache.Len() != 2 || e == nil || e.status != http.StatusOK {
+		t.Errorf("got %v pages, t3 %+v, want it replaced", cache.Len(), e)
+	}
+}
+
+func TestPageCacheSizeFlag(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	jobs := filepath.Join(t.TempDir(), "jobs")
+	if err := os.WriteFile(jobs, []byte("h t1 a\nh t1 b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-jobs", jobs, "-concurrency", "1", "-page-cache-size", "10")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if n := len(s.received()); n != 2 {
+		t.Errorf("%v requests, want the second job served from the cache", n)
+	}
+	if strings.Count(stdout, "  Pages: 2\n") != 2 {
+		t.Errorf("stdout %q, want both jobs to retrieve 2 pages", stdout)
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-page-cache-size", "-1"); code == 0 {
+		t.Error("negative size accepted")
+	}
+}
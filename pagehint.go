@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nextPageHintHeader is the header in which a server may hint at the duration
+// of the request for the next page, as a Go duration or a number of seconds
+const nextPageHintHeader = "X-Next-Page-Hint"
+
+// serverTimingNextPage is the Server-Timing metric in which a server may hint at
+// the duration of the request for the next page, as its dur in milliseconds
+const serverTimingNextPage = "next-page"
+
+// nextPageHint returns the duration the response headers hint the request for
+// the next page will take, or 0 if they give none
+func nextPageHint(h http.Header) time.Duration {
+	if v := strings.TrimSpace(h.Get(nextPageHintHeader)); len(v) > 0 {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+			return time.Duration(secs * float64(time.Second))
+		}
+	}
+
+	if d, ok := serverTimings(h)[serverTimingNextPage]; ok {
+		return d
+	}
+	return 0
+}
+
+// serverTimings returns the durations of the metrics of the Server-Timing
+// headers that have one, keyed by metric name
+func serverTimings(h http.Header) map[string]time.Duration {
+	timings := map[string]time.Duration{}
+	// e.g. Server-Timing: db;dur=53, next-page;dur=2500
+	for _, line := range h.Values("Server-Timing") {
+		for _, metric := range strings.Split(line, ",") {
+			params := strings.Split(metric, ";")
+			for _, param := range params[1:] {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if ms, err := strconv.ParseFloat(value, 64); name == "dur" && err == nil && ms >= 0 {
+					timings[strings.TrimSpace(params[0])] = time.Duration(ms * float64(time.Millisecond))
+				}
+			}
+		}
+	}
+	return timings
+}
+
+// serverDuration returns the server's processing time for the response, from
+// the server time header: the total of its metrics other than the next page
+// hint if it is Server-Timing, otherwise a Go duration or a number of
+// milliseconds.  It is 0 if there is no server time header, or no time given
+func (c *Client) serverDuration(h http.Header) time.Duration {
+	if len(c.serverTimeHeader) == 0 {
+		return 0
+	}
+	if http.CanonicalHeaderKey(c.serverTimeHeader) == "Server-Timing" {
+		total := time.Duration(0)
+		for name, d := range serverTimings(h) {
+			if name != serverTimingNextPage {
+				total += d
+			}
+		}
+		return total
+	}
+
+	v := strings.TrimSpace(h.Get(c.serverTimeHeader))
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return d
+	}
+	if ms, err := strconv.ParseFloat(v, 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	return 0
+}
+
+// Handling of a page whose server processing time exceeds the budget of WithMaxServerTime
+const (
+	ServerTimeWarn  = "warn"
+	ServerTimeAbort = "abort"
+)
+
+// serverTimeError reports a page whose server processing time exceeded the budget
+type serverTimeError struct {
+	token    string
+	duration time.Duration
+	budget   time.Duration
+}
+
+func (e *serverTimeError) Error() string {
+	return fmt.Sprintf("page for token %v took the server %v to process, exceeding the budget of %v", e.token, e.duration, e.budget)
+}
+
+// checkServerTime returns a serverTimeError if the server's processing time of
+// the page for token exceeds the budget, or under ServerTimeWarn logs a warning
+func (c *Client) checkServerTime(token string, d time.Duration) error {
+	if c.maxServerTime <= 0 || d <= c.maxServerTime {
+		return nil
+	}
+	err := &serverTimeError{token: c.tokenRef(token), duration: d, budget: c.maxServerTime}
+	if c.serverTimePolicy == ServerTimeAbort {
+		return err
+	}
+	log.Printf("Warning: %v", err)
+	return nil
+}
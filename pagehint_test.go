@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextPageHint(t *testing.T) {
+	for _, test := range []struct {
+		header http.Header
+		want   time.Duration
+	}{
+		{header: http.Header{}, want: 0},
+		{header: http.Header{"X-Next-Page-Hint": {"2s"}}, want: 2 * time.Second},
+		{header: http.Header{"X-Next-Page-Hint": {"1.5"}}, want: 1500 * time.Millisecond},
+		{header: http.Header{"X-Next-Page-Hint": {"soon"}}, want: 0},
+		{header: http.Header{"Server-Timing": {"db;dur=53, next-page;desc=\"heavy\";dur=2500"}}, want: 2500 * time.Millisecond},
+		{header: http.Header{"Server-Timing": {"db;dur=53"}}, want: 0},
+	} {
+		if got := nextPageHint(test.header); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.header, got, test.want)
+		}
+	}
+}
+
+func TestNextPageHints(t *testing.T) {
+	for _, use := range []bool{false, true} {
+		s := slowPageServer(t, 10, "t7", 5*time.Millisecond, 300*time.Millisecond)
+		slow := s.handle
+		s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+			if req.Token == "t6" {
+				w.Header().Set("X-Next-Page-Hint", "500ms")
+			}
+			return slow(w, r, req)
+		})
+
+		_, err := NewClient(s.URL, WithSlowPageFactor(5), WithNextPageHints(use), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t0")
+		var se *slowPageError
+		if use && err != nil {
+			t.Errorf("with hints: %v, want the hinted page given longer", err)
+		}
+		if !use && (!errors.As(err, &se) || se.token != "t7") {
+			t.Errorf("without hints: got %v, want a slowPageError of t7", err)
+		}
+	}
+}
+
+func TestServerDuration(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "X-Processing-Time", header: http.Header{"X-Processing-Time": {"12.5"}}, want: 12500 * time.Microsecond},
+		{name: "X-Processing-Time", header: http.Header{"X-Processing-Time": {"2s"}}, want: 2 * time.Second},
+		{name: "X-Processing-Time", header: http.Header{}, want: 0},
+		{name: "server-timing", header: http.Header{"Server-Timing": {"db;dur=20, app;dur=5, next-page;dur=900"}}, want: 25 * time.Millisecond},
+		{name: "", header: http.Header{"X-Processing-Time": {"12"}}, want: 0},
+	} {
+		c := NewClient("http://localhost", WithServerTimeHeader(test.name))
+		if got := c.serverDuration(test.header); got != test.want {
+			t.Errorf("%q %v: got %v, want %v", test.name, test.header, got, test.want)
+		}
+	}
+}
+
+func TestServerTimeSplit(t *testing.T) {
+	s := newPageServer(t, numberedPages(3, 1))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		time.Sleep(15 * time.Millisecond)
+		w.Header().Set("X-Processing-Time", "10")
+		return false
+	})
+
+	result, err := NewClient(s.URL, WithServerTimeHeader("X-Processing-Time")).consumeAllPages(context.Background(), "h", "t0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ServerDuration != 30*time.Millisecond || result.RequestDuration <= result.ServerDuration {
+		t.Errorf("server %v of request %v, want 30ms of server time within the request time", result.ServerDuration, result.RequestDuration)
+	}
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-server-time-header", "X-Processing-Time")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	for _, pattern := range []string{`Duration to retrieve pages: \S+\n`, `Server processing: 30ms, network and queueing: \S+\n`, `Duration to unmarshal pages: \S+\n`} {
+		if !regexp.MustCompile(pattern).MatchString(stdout) {
+			t.Errorf("summary %q, want %v", stdout, pattern)
+		}
+	}
+}
+
+// serverTimeServer returns a pageServer of numberedPages(3, 1) reporting the
+// processing time of each token in X-Processing-Time, taking latency over each
+func serverTimeServer(t *testing.T, latency time.Duration, times map[string]string) *pageServer {
+	t.Helper()
+	s := newPageServer(t, numberedPages(3, 1))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		time.Sleep(latency)
+		w.Header().Set("X-Processing-Time", times[req.Token])
+		return false
+	})
+	return s
+}
+
+func TestMaxServerTime(t *testing.T) {
+	times := map[string]string{"t0": "10", "t1": "500", "t2": "10"}
+
+	t.Run("abort", func(t *testing.T) {
+		s := serverTimeServer(t, 0, times)
+		_, err := NewClient(s.URL, WithServerTimeHeader("X-Processing-Time"), WithMaxServerTime(100*time.Millisecond, ServerTimeAbort), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t0")
+		var ste *serverTimeError
+		if !errors.As(err, &ste) || err.Error() != "page for token t1 took the server 500ms to process, exceeding the budget of 100ms" {
+			t.Fatalf("got %v, want the slow page reported", err)
+		}
+		if got := s.tokens(); !reflect.DeepEqual(got, []string{"t0", "t1"}) {
+			t.Errorf("requested %v, want no page after the slow page", got)
+		}
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		s := serverTimeServer(t, 0, times)
+		r, err := NewClient(s.URL, WithServerTimeHeader("X-Processing-Time"), WithMaxServerTime(100*time.Millisecond, ServerTimeWarn)).consumeAllPages(context.Background(), "h", "t0")
+		if err != nil || r.PageCount != 3 {
+			t.Errorf("got %v pages, %v, want the run completed", r.PageCount, err)
+		}
+	})
+
+	t.Run("network time", func(t *testing.T) {
+		// A slow network with a fast server is within the budget
+		s := serverTimeServer(t, 60*time.Millisecond, map[string]string{"t0": "5", "t1": "5", "t2": "5"})
+		r, err := NewClient(s.URL, WithServerTimeHeader("X-Processing-Time"), WithMaxServerTime(50*time.Millisecond, ServerTimeAbort)).consumeAllPages(context.Background(), "h", "t0")
+		if err != nil || r.PageCount != 3 {
+			t.Errorf("got %v pages, %v, want the run completed", r.PageCount, err)
+		}
+	})
+}
+
+func TestMaxServerTimeFlag(t *testing.T) {
+	s := serverTimeServer(t, 0, map[string]string{"t0": "10", "t1": "500", "t2": "10"})
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t0", "-server-time-header", "X-Processing-Time", "-max-server-time", "100ms", "-show-tokens"}
+	if _, stderr, code := runMain(t, args...); code != 0 || !strings.Contains(stderr, "Warning: page for token t1 took the server 500ms to process, exceeding the budget of 100ms") {
+		t.Errorf("warn: exit %v, stderr %q", code, stderr)
+	}
+	if stdout, stderr, code := runMain(t, append(args, "-on-max-server-time", ServerTimeAbort)...); code == 0 || !strings.Contains(stdout+stderr, "exceeding the budget of 100ms") {
+		t.Errorf("abort: exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	for _, args := range [][]string{{"-max-server-time", "100ms"}, {"-server-time-header", "X-Processing-Time", "-max-server-time", "-1s"}, {"-server-time-header", "X-Processing-Time", "-on-max-server-time", "retry"}} {
+		if _, _, code := runMain(t, append([]string{"-url", s.URL, "-hash", "h", "-token", "t0"}, args...)...); code == 0 {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import "time"
+
+// Default bounds of the limit of AdaptiveOffsetPagination
+const (
+	defaultMinPageLimit = 10
+	defaultMaxPageLimit = 100000
+)
+
+// AdaptiveOffsetPagination is OffsetPagination whose limit adapts from Limit,
+// starting afresh for each pagination, to keep the time taken to retrieve each
+// page near Target, within MinLimit and MaxLimit.  After each full page the
+// limit is scaled by the ratio of Target to the page's retrieval time, by at
+// most a factor of 2 either way, so that on a server whose time per page
+// grows with its records the limit settles where pages take about Target
+type AdaptiveOffsetPagination struct {
+	OffsetPagination
+	MinLimit int
+	MaxLimit int
+	Target   time.Duration
+}
+
+// adaptivePageSizer is the Pagination of a single pagination using
+// AdaptiveOffsetPagination, whose OffsetPagination has the current limit
+type adaptivePageSizer struct {
+	OffsetPagination
+	config AdaptiveOffsetPagination
+}
+
+// observe adapts the limit to a page of records retrieved in d.  Only a full
+// page is used, as the time of a short page does not reflect the limit
+func (s *adaptivePageSizer) observe(d time.Duration, records int) {
+	if records < s.Limit || d <= 0 {
+		return
+	}
+	ratio := min(max(float64(s.config.Target)/float64(d), 0.5), 2)
+	s.Limit = min(max(int(float64(s.Limit)*ratio), s.config.MinLimit), s.config.MaxLimit)
+}
+
+// withPageSizer returns the client for a pagination, which when the client uses
+// AdaptiveOffsetPagination is a copy with its own adaptivePageSizer, otherwise
+// the client itself and nil
+func (c *Client) withPageSizer() (*Client, *adaptivePageSizer) {
+	var config AdaptiveOffsetPagination
+	switch p := c.pagination.(type) {
+	case AdaptiveOffsetPagination:
+		config = p
+	case *adaptivePageSizer:
+		config = p.config
+	default:
+		return c, nil
+	}
+	sizer := &adaptivePageSizer{OffsetPagination: config.OffsetPagination, config: config}
+	pc := *c
+	pc.pagination = sizer
+	return &pc, sizer
+}
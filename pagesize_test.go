@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// latencyServer returns a server answering offset page requests for n records,
+// each page taking 20ms plus 0.5ms per record on the clock, so that pages of
+// 360 records take 200ms, with the limits requested
+func latencyServer(t *testing.T, clock *fakeClock, n int) (*httptest.Server, func() []int) {
+	t.Helper()
+	columns := testColumns("id")
+	var mu sync.Mutex
+	limits := []int{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OffsetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		limits = append(limits, req.Limit)
+		mu.Unlock()
+
+		page := [][]string{}
+		for i := req.Offset; i < min(req.Offset+req.Limit, n); i++ {
+			page = append(page, []string{fmt.Sprint(i)})
+		}
+		clock.advance(20*time.Millisecond + time.Duration(len(page))*500*time.Microsecond)
+		w.Write(testPage("", columns, page...))
+	}))
+	t.Cleanup(s.Close)
+	return s, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return limits
+	}
+}
+
+func TestAdaptivePageSizerObserve(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		d       time.Duration
+		records int
+		want    int
+	}{
+		{name: "on target", d: 100 * time.Millisecond, records: 100, want: 100},
+		{name: "fast", d: 80 * time.Millisecond, records: 100, want: 125},
+		{name: "very fast", d: time.Millisecond, records: 100, want: 200},
+		{name: "very slow", d: time.Second, records: 100, want: 50},
+		{name: "short page", d: time.Millisecond, records: 99, want: 100},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := &adaptivePageSizer{OffsetPagination: OffsetPagination{Limit: 100}, config: AdaptiveOffsetPagination{MinLimit: 1, MaxLimit: 1000, Target: 100 * time.Millisecond}}
+			s.observe(test.d, test.records)
+			if s.Limit != test.want {
+				t.Errorf("limit %v, want %v", s.Limit, test.want)
+			}
+		})
+	}
+}
+
+func TestAdaptiveOffsetPagination(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		min, max int
+		first    []int
+		settles  func(limit int) bool
+	}{
+		{name: "converges", min: defaultMinPageLimit, max: defaultMaxPageLimit, first: []int{50, 100, 200, 333}, settles: func(limit int) bool { return limit >= 350 && limit <= 370 }},
+		{name: "capped", min: defaultMinPageLimit, max: 150, first: []int{50, 100, 150, 150}, settles: func(limit int) bool { return limit == 150 }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newFakeClock()
+			s, limits := latencyServer(t, clock, 5000)
+			p := AdaptiveOffsetPagination{OffsetPagination: OffsetPagination{Limit: 50}, MinLimit: test.min, MaxLimit: test.max, Target: 200 * time.Millisecond}
+			sink := &memorySink{}
+			r, err := NewClient(s.URL, WithPagination(p), WithClock(clock), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.TotalRecords() != 5000 || len(sink.records) != 5000 {
+				t.Errorf("got %v records, want every record once", r.TotalRecords())
+			}
+			got := limits()
+			if len(got) < 8 || !reflect.DeepEqual(got[:4], test.first) {
+				t.Fatalf("requested limits %v, want them to start %v", got, test.first)
+			}
+			// The last page is short, so is not observed
+			for _, limit := range got[5 : len(got)-1] {
+				if !test.settles(limit) {
+					t.Errorf("requested limits %v, want them settled", got)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestAdaptivePageSizerPerPagination(t *testing.T) {
+	c := NewClient("http://localhost", WithPagination(AdaptiveOffsetPagination{OffsetPagination: OffsetPagination{Limit: 50}, MinLimit: 1, MaxLimit: 1000, Target: time.Second}))
+	a, sa := c.withPageSizer()
+	sa.observe(time.Millisecond, 50)
+	// A pagination started from a client already adapting starts afresh
+	_, sb := a.withPageSizer()
+	if sa.Limit != 100 || sb.Limit != 50 {
+		t.Errorf("limits %v and %v, want each pagination to adapt on its own", sa.Limit, sb.Limit)
+	}
+	if _, s := NewClient("http://localhost").withPageSizer(); s != nil {
+		t.Error("page sizer for token pagination")
+	}
+}
+
+func TestPageTargetDurationFlag(t *testing.T) {
+	s := newOffsetServer(t, testColumns("id"), [][]string{{"a"}, {"b"}, {"c"}})
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-pagination", "offset", "-page-limit", "2", "-page-limit-min", "1", "-page-target-duration", "1s", "-records-only", "-output-format", "csv")
+	if code != 0 || stdout != "id\na\nb\nc\n" {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-page-target-duration", "1s"); code == 0 {
+		t.Error("-page-target-duration accepted with token pagination")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Pagination styles selectable with -pagination
+const (
+	PaginationToken  = "token"
+	PaginationOffset = "offset"
+)
+
+// defaultPageLimit is the number of records requested per page by OffsetPagination
+const defaultPageLimit = 1000
+
+// Pagination is a strategy for requesting successive pages of a result set.
+// Each page is identified by a cursor string: the page token for token based
+// pagination, or the record offset for offset based pagination
+type Pagination interface {
+	// requestBody returns the body of the request for the page at cursor, asking
+	// the server for only the fields columns if any are given
+	requestBody(hash, cursor string, fields []string) ([]byte, error)
+	// nextCursor returns the cursor of the page following the page at cursor,
+	// from its decoded response and its records (nil if they could not be
+	// decoded), or "" if there are no further pages
+	nextCursor(c *Client, result map[string]interface{}, cursor string, records []interface{}) (string, error)
+}
+
+// TokenPagination requests each page with the opaque token returned by the server
+// in the previous page
+type TokenPagination struct{}
+
+func (TokenPagination) requestBody(hash, cursor string, fields []string) ([]byte, error) {
+	return json.Marshal(Request{Hash: hash, Token: cursor, Fields: fields})
+}
+
+func (TokenPagination) nextCursor(c *Client, result map[string]interface{}, cursor string, records []interface{}) (string, error) {
+	return c.nextToken(result)
+}
+
+// OffsetRequest is the body of a page request using offset based pagination
+type OffsetRequest struct {
+	Hash   string   `json:"hash"`
+	Offset int      `json:"offset"`
+	Limit  int      `json:"limit"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// OffsetPagination requests pages of up to Limit records by their offset in the
+// result set, with the first short or empty page ending the pagination
+type OffsetPagination struct {
+	Limit int
+}
+
+// parseOffset returns the offset of the cursor
+func parseOffset(cursor string) (int, error) {
+	offset, err := strconv.Atoi(cursor)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid offset %q", cursor)
+	}
+	return offset, nil
+}
+
+func (p OffsetPagination) requestBody(hash, cursor string, fields []string) ([]byte, error) {
+	offset, err := parseOffset(cursor)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(OffsetRequest{Hash: hash, Offset: offset, Limit: p.Limit, Fields: fields})
+}
+
+func (p OffsetPagination) nextCursor(c *Client, result map[string]interface{}, cursor string, records []interface{}) (string, error) {
+	if records == nil {
+		return "", errors.New("next offset: records not decoded")
+	}
+	if len(records) < p.Limit || len(records) == 0 {
+		return "", nil
+	}
+	offset, err := parseOffset(cursor)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(offset + len(records)), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// offsetServer is an httptest.Server answering offset page requests with the
+// records from the offset, up to the limit, recording the requests received
+type offsetServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []OffsetRequest
+}
+
+func newOffsetServer(t *testing.T, columns []Column, records [][]string) *offsetServer {
+	t.Helper()
+	s := &offsetServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OffsetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+
+		end := min(req.Offset+req.Limit, len(records))
+		page := [][]string{}
+		if req.Offset < end {
+			page = records[req.Offset:end]
+		}
+		w.Write(testPage("", columns, page...))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestPaginationStrategies(t *testing.T) {
+	columns := testColumns("id")
+	records := [][]string{}
+	for i := range 7 {
+		records = append(records, []string{fmt.Sprint(i)})
+	}
+
+	t.Run("token", func(t *testing.T) {
+		s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{records[:3], records[3:6], records[6:]}))
+		sink := &memorySink{}
+		r, err := NewClient(s.URL, WithPagination(TokenPagination{}), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.PageCount != 3 || !reflect.DeepEqual(sink.records, records) || !reflect.DeepEqual(s.tokens(), []string{"t1", "t2", "t3"}) {
+			t.Errorf("got %v pages of %v, requesting %v", r.PageCount, sink.records, s.tokens())
+		}
+	})
+
+	for _, test := range []struct {
+		name    string
+		limit   int
+		offsets []int
+	}{
+		{name: "short last page", limit: 3, offsets: []int{0, 3, 6}},
+		{name: "empty last page", limit: 7, offsets: []int{0, 7}},
+	} {
+		t.Run("offset "+test.name, func(t *testing.T) {
+			s := newOffsetServer(t, columns, records)
+			sink := &memorySink{}
+			r, err := NewClient(s.URL, WithPagination(OffsetPagination{Limit: test.limit}), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.PageCount != len(test.offsets) || !reflect.DeepEqual(sink.records, records) {
+				t.Errorf("got %v pages of %v", r.PageCount, sink.records)
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			offsets := []int{}
+			for _, req := range s.requests {
+				if req.Hash != "h" || req.Limit != test.limit {
+					t.Errorf("request %+v", req)
+				}
+				offsets = append(offsets, req.Offset)
+			}
+			if !reflect.DeepEqual(offsets, test.offsets) {
+				t.Errorf("requested offsets %v, want %v", offsets, test.offsets)
+			}
+		})
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	if offset, err := parseOffset("12"); err != nil || offset != 12 {
+		t.Errorf("got %v, %v", offset, err)
+	}
+	for _, cursor := range []string{"", "-1", "t1"} {
+		if _, err := parseOffset(cursor); err == nil {
+			t.Errorf("%q: parsed", cursor)
+		}
+	}
+}
+
+func TestPaginationFlag(t *testing.T) {
+	columns := testColumns("id")
+	s := newOffsetServer(t, columns, [][]string{{"a"}, {"b"}, {"c"}})
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-pagination", "offset", "-page-limit", "2", "-records-only", "-output-format", "csv")
+	if code != 0 || stdout != "id\na\nb\nc\n" {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// partitionSink is a RecordSink routing records to a separate output per value
+// of a column, in files named by the value beneath a directory.  The output of
+// each partition is created when its first record is written
+type partitionSink struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	column string
+	dir    string
+	format string
+	create func(path string) (RecordSink, error)
+	sinks  map[string]RecordSink
+	order  []string
+}
+
+// newPartitionSink returns a partitionSink writing records in the format to
+// files beneath dir, which may also be an object storage URL prefix
+func newPartitionSink(ctx context.Context, column, format, dir string, bufferSize int, flushInterval time.Duration, opts encoderOptions) (*partitionSink, error) {
+	if _, err := newRecordEncoder(format, opts); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(dir, "://") {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &partitionSink{
+		ctx:    ctx,
+		column: column,
+		dir:    strings.TrimSuffix(dir, "/"),
+		format: format,
+		create: func(path string) (RecordSink, error) {
+			return newStreamSink(ctx, format, path, bufferSize, flushInterval, opts)
+		},
+		sinks: map[string]RecordSink{},
+	}, nil
+}
+
+// partitionName returns a file name for the value that is safe on any
+// filesystem.  Values needing changes to be safe are suffixed with a hash of the
+// value, so that distinct values never share a name
+func partitionName(value string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, value)
+	if safe == value && len(value) > 0 && value != "." && value != ".." && len(value) <= 100 {
+		return value
+	}
+	if len(safe) > 100 {
+		safe = safe[:100]
+	}
+	if len(safe) == 0 {
+		safe = "_empty"
+	}
+	sum := sha256.Sum256([]byte(value))
+	return safe + "-" + hex.EncodeToString(sum[:4])
+}
+
+// WriteRecords writes each record to the output of the partition of its value
+func (p *partitionSink) WriteRecords(columns []Column, records [][]string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pos, err := columnPosition(columns, p.column)
+	if err != nil {
+		return fmt.Errorf("partition: %v", err)
+	}
+
+	groups := map[string][][]string{}
+	values := []string{}
+	for _, record := range records {
+		value := ""
+		if pos < len(record) {
+			value = record[pos]
+		}
+		if _, ok := groups[value]; !ok {
+			values = append(values, value)
+		}
+		groups[value] = append(groups[value], record)
+	}
+
+	for _, value := range values {
+		sink, ok := p.sinks[value]
+		if !ok {
+			path := p.dir + "/" + partitionName(value) + "." + p.format
+			if sink, err = p.create(path); err != nil {
+				return fmt.Errorf("partition %q: %v", value, err)
+			}
+			p.sinks[value] = sink
+			p.order = append(p.order, value)
+		}
+		if err := sink.WriteRecords(columns, groups[value]); err != nil {
+			return fmt.Errorf("partition %q: %v", value, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the outputs of all partitions, returning the first error
+func (p *partitionSink) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for _, value := range p.order {
+		if cerr := p.sinks[value].Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("partition %q: %v", value, cerr)
+		}
+	}
+	return err
+}
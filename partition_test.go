@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPartitionSink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	sink, err := newPartitionSink(context.Background(), "region", OutputFormatCSV, dir, defaultOutputBufferSize, 0, encoderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := testColumns("region", "id")
+	for _, records := range [][][]string{{{"eu", "1"}, {"us", "2"}, {"eu", "3"}}, {{"us", "4"}, {"a/b", "5"}}} {
+		if err := sink.WriteRecords(columns, records); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"eu.csv":                      "region,id\neu,1\neu,3\n",
+		"us.csv":                      "region,id\nus,2\nus,4\n",
+		partitionName("a/b") + ".csv": "region,id\na/b,5\n",
+	} {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != want {
+			t.Errorf("%v: got %q (%v), want %q", name, b, err, want)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Errorf("wrote %v files, want 3", len(entries))
+	}
+}
+
+func TestPartitionSinkUnknownColumn(t *testing.T) {
+	sink, err := newPartitionSink(context.Background(), "missing", OutputFormatNDJSON, t.TempDir(), defaultOutputBufferSize, 0, encoderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.WriteRecords(testColumns("id"), [][]string{{"1"}}); err == nil || !strings.HasPrefix(err.Error(), "partition:") {
+		t.Errorf("got %v, want an error of the missing column", err)
+	}
+}
+
+func TestPartitionName(t *testing.T) {
+	for value, safe := range map[string]bool{"eu": true, "eu-west_1.a": true, "a/b": false, "..": false, "": false, "a b": false, strings.Repeat("x", 101): false} {
+		name := partitionName(value)
+		if (name == value) != safe {
+			t.Errorf("%q named %q", value, name)
+		}
+		if strings.ContainsAny(name, "/\\ ") || name == ".." || len(name) == 0 || len(name) > 109 {
+			t.Errorf("%q named %q, which is unsafe", value, name)
+		}
+	}
+	if partitionName("a/b") == partitionName("a b") {
+		t.Error("distinct values share a name")
+	}
+}
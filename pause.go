@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// pauseFilePollInterval is how often the existence of a pause file is checked
+const pauseFilePollInterval = time.Second
+
+// PauseGate holds pagination between pages while paused, allowing consumers to
+// apply backpressure without cancelling the run.  It is safe for concurrent
+// use, and pauses all paginations of the Clients it is given to
+type PauseGate struct {
+	mu     sync.Mutex
+	resume chan struct{}
+}
+
+// NewPauseGate returns an open PauseGate
+func NewPauseGate() *PauseGate {
+	return &PauseGate{}
+}
+
+// Pause holds paginations once their current page completes
+func (g *PauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume == nil {
+		g.resume = make(chan struct{})
+	}
+}
+
+// Resume releases paused paginations
+func (g *PauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume != nil {
+		close(g.resume)
+		g.resume = nil
+	}
+}
+
+// Paused reports whether the gate is paused
+func (g *PauseGate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resume != nil
+}
+
+// wait blocks while the gate is paused, returning early with the context's
+// error if it ends first
+func (g *PauseGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+	if resume == nil {
+		return nil
+	}
+
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// watchPause pauses the gate while a file exists at path, which is checked on
+// starting and then every interval, resuming it once the file is removed.  It
+// returns once ctx ends
+func watchPause(ctx context.Context, gate *PauseGate, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := os.Stat(path)
+		if present := err == nil; present && !gate.Paused() {
+			log.Printf("Found pause file %v, pausing once the pages in progress complete", path)
+			gate.Pause()
+		} else if !present && gate.Paused() {
+			log.Printf("Pause file %v removed, resuming", path)
+			gate.Resume()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
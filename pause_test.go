@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPauseGate(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3", "t4"}, [][][]string{{{"1"}}, {{"2"}}, {{"3"}}, {{"4"}}}))
+	gate := NewPauseGate()
+	var mu sync.Mutex
+	requested := map[string]time.Time{}
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		requested[req.Token] = time.Now()
+		if req.Token == "t2" {
+			gate.Pause()
+		}
+		return false
+	})
+
+	pause := 100 * time.Millisecond
+	resumed := make(chan time.Time, 1)
+	go func() {
+		for !gate.Paused() {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(pause)
+		resumed <- time.Now()
+		gate.Resume()
+	}()
+
+	r, err := NewClient(s.URL, WithPauseGate(gate)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.PageCount != 4 {
+		t.Errorf("got %v pages, want 4", r.PageCount)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if at := <-resumed; requested["t3"].Before(at) {
+		t.Errorf("t3 requested %v before the resume", at.Sub(requested["t3"]))
+	}
+}
+
+func TestPauseGateCancelled(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	gate := NewPauseGate()
+	gate.Pause()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewClient(s.URL, WithPauseGate(gate)).consumeAllPages(ctx, "h", "t1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline to end the pause", err)
+	}
+	if got := s.tokens(); len(got) != 1 {
+		t.Errorf("requested %v, want only the first page", got)
+	}
+}
+
+func TestWatchPause(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pause")
+	gate := NewPauseGate()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchPause(ctx, gate, path, 5*time.Millisecond)
+		close(done)
+	}()
+
+	waitFor := func(paused bool) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); gate.Paused() != paused; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("gate not paused %v", paused)
+			}
+		}
+	}
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(true)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(false)
+
+	cancel()
+	<-done
+}
+
+func TestPauseFileFlag(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	path := filepath.Join(t.TempDir(), "pause")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The first page is retrieved, then the run waits until the file is removed
+	var mu sync.Mutex
+	var removed, requested time.Time
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		switch req.Token {
+		case "t1":
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				mu.Lock()
+				defer mu.Unlock()
+				removed = time.Now()
+				os.Remove(path)
+			}()
+		case "t2":
+			requested = time.Now()
+		}
+		return false
+	})
+
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-pause-file", path)
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if removed.IsZero() || requested.Before(removed) {
+		t.Errorf("t2 requested at %v, before the pause file was removed at %v", requested, removed)
+	}
+	if !strings.Contains(stderr, "Found pause file") || !strings.Contains(stderr, "removed, resuming") {
+		t.Errorf("stderr %q, want the pause and resume logged", stderr)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// pinError reports a server certificate matching none of the pinned fingerprints
+type pinError struct {
+	fingerprint string
+}
+
+func (e *pinError) Error() string {
+	return fmt.Sprintf("tls: server certificate SHA-256 %v matches no pin", e.fingerprint)
+}
+
+// normalizePin returns the fingerprint as lower case hex without separators
+func normalizePin(pin string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(pin))
+}
+
+// verifyPins returns a tls.Config VerifyConnection callback accepting only a
+// connection whose leaf certificate has a SHA-256 fingerprint in pins
+func verifyPins(pins []string) func(tls.ConnectionState) error {
+	allowed := map[string]bool{}
+	for _, pin := range pins {
+		allowed[normalizePin(pin)] = true
+	}
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return &pinError{fingerprint: "(none)"}
+		}
+		sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		fingerprint := hex.EncodeToString(sum[:])
+		if !allowed[fingerprint] {
+			return &pinError{fingerprint: fingerprint}
+		}
+		return nil
+	}
+}
+
+// pinTransport returns a copy of the transport, or of http.DefaultTransport if it
+// is nil, verifying the server certificate against the pins.  Transports other
+// than *http.Transport are returned unchanged
+func pinTransport(rt http.RoundTripper, pins []string) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+	t = t.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.VerifyConnection = verifyPins(pins)
+	return t
+}
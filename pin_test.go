@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// tlsPageServer returns a TLS httptest.Server of a single page, with the
+// SHA-256 fingerprint of its certificate
+func tlsPageServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	page := testPage("", testColumns("id"), []string{"1"})
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(page)
+	}))
+	t.Cleanup(s.Close)
+	sum := sha256.Sum256(s.Certificate().Raw)
+	return s, hex.EncodeToString(sum[:])
+}
+
+func TestPinnedCertSHA256(t *testing.T) {
+	s, fingerprint := tlsPageServer(t)
+	// The pin is accepted in upper case with colon separators, as tools print them
+	var colons []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		colons = append(colons, strings.ToUpper(fingerprint[i:i+2]))
+	}
+
+	for _, pins := range [][]string{{fingerprint}, {strings.Repeat("0", 64), strings.Join(colons, ":")}} {
+		if _, err := NewClient(s.URL, WithHTTPClient(s.Client()), WithPinnedCertSHA256(pins)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+			t.Errorf("pins %v: %v", pins, err)
+		}
+	}
+
+	_, err := NewClient(s.URL, WithHTTPClient(s.Client()), WithPinnedCertSHA256([]string{strings.Repeat("0", 64)})).consumeAllPages(context.Background(), "h", "t1")
+	var pe *pinError
+	if !errors.As(err, &pe) || pe.fingerprint != fingerprint {
+		t.Errorf("got %v, want a pinError of %v", err, fingerprint)
+	}
+}
+
+func TestPinFlag(t *testing.T) {
+	s, fingerprint := tlsPageServer(t)
+	// The client does not trust the test certificate, so a matching pin alone cannot succeed
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-pin", strings.Repeat("0", 64))
+	if code == 0 || !strings.Contains(stderr, "jobs failed") {
+		t.Errorf("wrong pin: exit %v, stderr %q", code, stderr)
+	}
+	_, _, code = runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-pin", fingerprint)
+	if code == 0 {
+		t.Error("untrusted certificate accepted for its pin")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Preflight is the outcome of a CORS preflight OPTIONS request to the page
+// endpoint, asking whether the page requests, with their method and headers,
+// are allowed
+type Preflight struct {
+	Status         int
+	AllowOrigin    string
+	AllowMethods   []string
+	AllowHeaders   []string
+	MaxAge         string
+	RequestHeaders []string
+}
+
+// MissingMethod reports whether POST, the method of page requests, is not allowed
+func (p Preflight) MissingMethod() bool {
+	return !slices.ContainsFunc(p.AllowMethods, func(m string) bool {
+		return m == "*" || strings.EqualFold(m, http.MethodPost)
+	})
+}
+
+// MissingHeaders returns the headers of page requests that are not allowed
+func (p Preflight) MissingHeaders() []string {
+	if slices.Contains(p.AllowHeaders, "*") {
+		return nil
+	}
+	missing := []string{}
+	for _, h := range p.RequestHeaders {
+		if !slices.ContainsFunc(p.AllowHeaders, func(a string) bool { return strings.EqualFold(a, h) }) {
+			missing = append(missing, h)
+		}
+	}
+	return missing
+}
+
+// OK reports whether the preflight succeeded, allowing page requests
+func (p Preflight) OK() bool {
+	return p.Status >= 200 && p.Status < 300 && !p.MissingMethod() && len(p.MissingHeaders()) == 0
+}
+
+// headerList returns the comma separated values of the header
+func headerList(h http.Header, name string) []string {
+	values := []string{}
+	for _, v := range h.Values(name) {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// preflight sends a CORS preflight OPTIONS request to the page endpoint, from
+// the endpoint's own origin, for the method and headers of page requests.  The
+// allowed methods are those of Access-Control-Allow-Methods, or of Allow from a
+// server not supporting CORS.  The request is not retried
+func (c *Client) preflight(ctx context.Context) (Preflight, error) {
+	pageURL, err := c.pageURL()
+	if err != nil {
+		return Preflight{}, err
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return Preflight{}, err
+	}
+
+	p := Preflight{RequestHeaders: []string{"Content-Type"}}
+	if c.idempotencyKeys {
+		p.RequestHeaders = append(p.RequestHeaders, "Idempotency-Key")
+	}
+	if c.auth != nil {
+		p.RequestHeaders = append(p.RequestHeaders, "Authorization")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodOptions, pageURL, nil)
+	if err != nil {
+		return Preflight{}, err
+	}
+	req.Header.Set("Origin", u.Scheme+"://"+u.Host)
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", strings.ToLower(strings.Join(p.RequestHeaders, ",")))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return Preflight{}, fmt.Errorf("preflight: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	p.Status = resp.StatusCode
+	p.AllowOrigin = resp.Header.Get("Access-Control-Allow-Origin")
+	p.AllowMethods = headerList(resp.Header, "Access-Control-Allow-Methods")
+	if len(p.AllowMethods) == 0 {
+		p.AllowMethods = headerList(resp.Header, "Allow")
+	}
+	p.AllowHeaders = headerList(resp.Header, "Access-Control-Allow-Headers")
+	p.MaxAge = resp.Header.Get("Access-Control-Max-Age")
+	return p, nil
+}
+
+// printPreflight writes the outcome of the preflight request
+func printPreflight(w io.Writer, p Preflight) {
+	list := func(values []string) string {
+		if len(values) == 0 {
+			return "(none)"
+		}
+		return strings.Join(values, ", ")
+	}
+	fmt.Fprintf(w, "Preflight: status %v\n", p.Status)
+	fmt.Fprintf(w, "  Allowed origin: %v\n", cmp.Or(p.AllowOrigin, "(none)"))
+	fmt.Fprintf(w, "  Allowed methods: %v\n", list(p.AllowMethods))
+	fmt.Fprintf(w, "  Allowed headers: %v\n", list(p.AllowHeaders))
+	if len(p.MaxAge) > 0 {
+		fmt.Fprintf(w, "  Max age: %v\n", p.MaxAge)
+	}
+	if p.MissingMethod() {
+		fmt.Fprintf(w, "  Not allowed: method %v\n", http.MethodPost)
+	}
+	if missing := p.MissingHeaders(); len(missing) > 0 {
+		fmt.Fprintf(w, "  Not allowed: headers %v\n", list(missing))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// corsServer returns a pageServer of numberedPages(1, 1) answering OPTIONS
+// requests with the status and headers
+func corsServer(t *testing.T, status int, headers map[string]string) *pageServer {
+	t.Helper()
+	s := newPageServer(t, numberedPages(1, 1))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if r.Method != http.MethodOptions {
+			return false
+		}
+		for name, value := range headers {
+			w.Header().Set(name, value)
+		}
+		w.WriteHeader(status)
+		return true
+	})
+	return s
+}
+
+func TestPreflight(t *testing.T) {
+	allowed := map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "content-type, idempotency-key",
+		"Access-Control-Max-Age":       "600",
+	}
+	s := corsServer(t, http.StatusNoContent, allowed)
+	p, err := NewClient(s.URL, WithIdempotencyKeys(true)).preflight(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Preflight{Status: http.StatusNoContent, AllowOrigin: "*", AllowMethods: []string{"GET", "POST"}, AllowHeaders: []string{"content-type", "idempotency-key"}, MaxAge: "600", RequestHeaders: []string{"Content-Type", "Idempotency-Key"}}
+	if !reflect.DeepEqual(p, want) || !p.OK() {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+	req := s.received()[0]
+	if req.method != http.MethodOptions || req.header.Get("Origin") != s.URL || req.header.Get("Access-Control-Request-Method") != http.MethodPost || req.header.Get("Access-Control-Request-Headers") != "content-type,idempotency-key" {
+		t.Errorf("sent %v %v", req.method, req.header)
+	}
+
+	// The Authorization header of bearer tokens is not allowed
+	token := func(context.Context) (string, error) { return "secret", nil }
+	p, err = NewClient(s.URL, WithTokenSource(token)).preflight(context.Background())
+	if err != nil || p.OK() || !reflect.DeepEqual(p.MissingHeaders(), []string{"Authorization"}) {
+		t.Errorf("got %+v, %v, want Authorization reported as not allowed", p, err)
+	}
+
+	// A server without CORS support gives the methods it allows in Allow
+	s = corsServer(t, http.StatusMethodNotAllowed, map[string]string{"Allow": "POST"})
+	p, err = NewClient(s.URL).preflight(context.Background())
+	if err != nil || p.OK() || p.MissingMethod() || !reflect.DeepEqual(p.AllowMethods, []string{"POST"}) {
+		t.Errorf("got %+v, %v", p, err)
+	}
+}
+
+func TestPrintPreflight(t *testing.T) {
+	var b bytes.Buffer
+	printPreflight(&b, Preflight{Status: http.StatusOK, AllowMethods: []string{"GET"}, AllowHeaders: []string{"content-type"}, RequestHeaders: []string{"Content-Type", "Authorization"}})
+	want := `Preflight: status 200
+  Allowed origin: (none)
+  Allowed methods: GET
+  Allowed headers: content-type
+  Not allowed: method POST
+  Not allowed: headers Authorization
+`
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestPreflightFlag(t *testing.T) {
+	s := corsServer(t, http.StatusOK, map[string]string{"Access-Control-Allow-Methods": "POST", "Access-Control-Allow-Headers": "*"})
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-preflight-require")
+	if code != 0 || !strings.Contains(stdout, "Preflight: status 200\n") || !strings.Contains(stdout, "  Allowed headers: *\n") {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	// A preflight not allowing page requests only fails the run if required
+	s = corsServer(t, http.StatusForbidden, nil)
+	stdout, stderr, code = runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-preflight")
+	if code != 0 || !strings.Contains(stdout, "Preflight: status 403\n") || !strings.Contains(stdout, "  Not allowed: method POST\n") {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	stdout, stderr, code = runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-preflight-require")
+	if code == 0 || !strings.Contains(stdout+stderr, "preflight: page requests not allowed") {
+		t.Errorf("exit %v, stdout %q, stderr %q, want the run failed", code, stdout, stderr)
+	}
+	// The preflights have no token
+	if got := s.tokens(); !reflect.DeepEqual(got, []string{"", "t0", ""}) {
+		t.Errorf("requested %v, want no page after the required preflight failed", got)
+	}
+
+	// Replayed pages have no server to preflight
+	for _, flag := range []string{"-preflight", "-preflight-require"} {
+		_, stderr, code = runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", flag, "-replay-dir", t.TempDir())
+		if want := flag + " cannot be used with -replay-dir"; code == 0 || !strings.Contains(stderr, want) {
+			t.Errorf("exit %v, stderr %q, want %q", code, stderr, want)
+		}
+	}
+}
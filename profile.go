@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// profileExactDistinct is the number of distinct values of a column counted
+// exactly, beyond which they are estimated with a HyperLogLog sketch
+const profileExactDistinct = 10000
+
+// hllPrecision is the number of hash bits selecting a HyperLogLog register,
+// giving 2^14 registers and a standard error of about 0.8%
+const hllPrecision = 14
+
+// hyperLogLog estimates the number of distinct values added to it
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+// add adds the value with the hash
+func (h *hyperLogLog) add(hash uint64) {
+	i := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	h.registers[i] = max(h.registers[i], rank)
+}
+
+// estimate returns the estimated number of distinct values, using linear
+// counting while registers are still empty, as it is the more accurate there
+func (h *hyperLogLog) estimate() int {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(e))
+}
+
+// ColumnProfile holds the statistics of the values of a column.  Values are
+// counted including nulls, which are held as "".  The lengths, in characters,
+// and distinct values are of the values that are not null, and the numeric
+// statistics of int and float columns are of the values that parse as numbers,
+// with the others counted as invalid
+type ColumnProfile struct {
+	Name                string   `json:"name"`
+	Type                string   `json:"type"`
+	Count               int      `json:"count"`
+	Nulls               int      `json:"nulls"`
+	Distinct            int      `json:"distinct"`
+	DistinctApproximate bool     `json:"distinct_approximate,omitempty"`
+	MinLength           *int     `json:"min_length,omitempty"`
+	MaxLength           *int     `json:"max_length,omitempty"`
+	Invalid             int      `json:"invalid,omitempty"`
+	Min                 *float64 `json:"min,omitempty"`
+	Max                 *float64 `json:"max,omitempty"`
+	Mean                *float64 `json:"mean,omitempty"`
+}
+
+// columnStats accumulates the statistics of a column
+type columnStats struct {
+	profile  ColumnProfile
+	numeric  bool
+	values   map[string]bool
+	sketch   *hyperLogLog
+	numbers  int
+	sum      float64
+	min, max float64
+	minLen   int
+	maxLen   int
+}
+
+// add adds a value of the column
+func (s *columnStats) add(seed maphash.Seed, v string) {
+	s.profile.Count++
+	if len(v) == 0 {
+		s.profile.Nulls++
+		return
+	}
+
+	if s.sketch != nil {
+		s.sketch.add(maphash.String(seed, v))
+	} else if !s.values[v] {
+		s.values[v] = true
+		if len(s.values) > profileExactDistinct {
+			s.sketch = &hyperLogLog{}
+			for value := range s.values {
+				s.sketch.add(maphash.String(seed, value))
+			}
+			s.values = nil
+		}
+	}
+
+	if s.numeric {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			s.profile.Invalid++
+			return
+		}
+		if s.numbers == 0 || f < s.min {
+			s.min = f
+		}
+		if s.numbers == 0 || f > s.max {
+			s.max = f
+		}
+		s.numbers++
+		s.sum += f
+		return
+	}
+
+	n := utf8.RuneCountInString(v)
+	if s.profile.Count-s.profile.Nulls == 1 || n < s.minLen {
+		s.minLen = n
+	}
+	s.maxLen = max(s.maxLen, n)
+}
+
+// result returns the column's profile
+func (s *columnStats) result() ColumnProfile {
+	p := s.profile
+	if s.sketch != nil {
+		p.Distinct, p.DistinctApproximate = s.sketch.estimate(), true
+	} else {
+		p.Distinct = len(s.values)
+	}
+	switch {
+	case s.numeric && s.numbers > 0:
+		mean := s.sum / float64(s.numbers)
+		p.Min, p.Max, p.Mean = &s.min, &s.max, &mean
+	case !s.numeric && p.Count > p.Nulls:
+		p.MinLength, p.MaxLength = &s.minLen, &s.maxLen
+	}
+	return p
+}
+
+// profileSink is a RecordSink computing the statistics of each column of the
+// records as they are written, by column name in order of first appearance
+type profileSink struct {
+	mu      sync.Mutex
+	seed    maphash.Seed
+	names   []string
+	columns map[string]*columnStats
+}
+
+// newProfileSink returns an empty profileSink
+func newProfileSink() *profileSink {
+	return &profileSink{seed: maphash.MakeSeed(), columns: map[string]*columnStats{}}
+}
+
+// WriteRecords adds the values of the records to the statistics of their columns
+func (p *profileSink) WriteRecords(columns []Column, records [][]string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, col := range columns {
+		s, ok := p.columns[col.Name]
+		if !ok {
+			s = &columnStats{
+				profile: ColumnProfile{Name: col.Name, Type: col.Type},
+				numeric: col.Type == ColumnTypeInt || col.Type == ColumnTypeFloat,
+				values:  map[string]bool{},
+			}
+			p.columns[col.Name] = s
+			p.names = append(p.names, col.Name)
+		}
+		for _, record := range records {
+			v := ""
+			if col.Position < len(record) {
+				v = record[col.Position]
+			}
+			s.add(p.seed, v)
+		}
+	}
+	return nil
+}
+
+// Close does nothing, with the profiles still available
+func (p *profileSink) Close() error {
+	return nil
+}
+
+// profiles returns the profile of each column
+func (p *profileSink) profiles() []ColumnProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	profiles := make([]ColumnProfile, 0, len(p.names))
+	for _, name := range p.names {
+		profiles = append(profiles, p.columns[name].result())
+	}
+	return profiles
+}
+
+// printProfile writes the column profiles to w as a table, or as JSON
+func printProfile(w io.Writer, format string, profiles []ColumnProfile) error {
+	if format == StatsFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(profiles)
+	}
+
+	opt := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'g', -1, 64)
+	}
+	length := func(v *int) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLUMN\tTYPE\tCOUNT\tNULLS\tDISTINCT\tMIN_LEN\tMAX_LEN\tINVALID\tMIN\tMAX\tMEAN")
+	for _, p := range profiles {
+		distinct := strconv.Itoa(p.Distinct)
+		if p.DistinctApproximate {
+			distinct = "~" + distinct
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", p.Name, p.Type, p.Count, p.Nulls, distinct,
+			length(p.MinLength), length(p.MaxLength), p.Invalid, opt(p.Min), opt(p.Max), opt(p.Mean))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/maphash"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	columns := []Column{{Name: "id", Type: ColumnTypeInt, Position: 0}, {Name: "amount", Type: ColumnTypeFloat, Position: 1}, {Name: "name", Type: ColumnTypeString, Position: 2}}
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{
+		{{"1", "2.5", "ab"}, {"2", "", "héllo"}, {"3", "x", "ab"}},
+		{{"4", "-1.5", ""}, {"5", "8", "c"}},
+	}))
+	sink := newProfileSink()
+	if _, err := NewClient(s.URL, WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	f := func(v float64) *float64 { return &v }
+	n := func(v int) *int { return &v }
+	want := []ColumnProfile{
+		{Name: "id", Type: ColumnTypeInt, Count: 5, Distinct: 5, Min: f(1), Max: f(5), Mean: f(3)},
+		{Name: "amount", Type: ColumnTypeFloat, Count: 5, Nulls: 1, Distinct: 4, Invalid: 1, Min: f(-1.5), Max: f(8), Mean: f(3)},
+		{Name: "name", Type: ColumnTypeString, Count: 5, Nulls: 1, Distinct: 3, MinLength: n(1), MaxLength: n(5)},
+	}
+	if got := sink.profiles(); !reflect.DeepEqual(got, want) {
+		b, _ := json.Marshal(got)
+		t.Errorf("got %s", b)
+	}
+
+	var b bytes.Buffer
+	if err := printProfile(&b, StatsFormatText, want); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 || strings.Join(strings.Fields(lines[2]), " ") != "amount float 5 1 4 1 -1.5 8 3" {
+		t.Errorf("table %q", b.String())
+	}
+}
+
+func TestProfileDistinctEstimate(t *testing.T) {
+	s := &columnStats{values: map[string]bool{}}
+	seed := maphash.MakeSeed()
+	const n = 50000
+	for i := range n {
+		s.add(seed, fmt.Sprint("v", i))
+		// Repeated values are not counted again
+		s.add(seed, "v0")
+	}
+	p := s.result()
+	if !p.DistinctApproximate || p.Distinct < n*97/100 || p.Distinct > n*103/100 {
+		t.Errorf("estimated %v distinct, approximate %v, want within 3%% of %v", p.Distinct, p.DistinctApproximate, n)
+	}
+
+	exact := &columnStats{values: map[string]bool{}}
+	for i := range profileExactDistinct {
+		exact.add(seed, fmt.Sprint(i))
+	}
+	if p := exact.result(); p.DistinctApproximate || p.Distinct != profileExactDistinct {
+		t.Errorf("got %v distinct, approximate %v, want counted exactly", p.Distinct, p.DistinctApproximate)
+	}
+}
+
+func TestProfileFlag(t *testing.T) {
+	columns := []Column{{Name: "id", Type: ColumnTypeInt, Position: 0}}
+	s := newPageServer(t, chainPages(columns, []string{"t1"}, [][][]string{{{"1"}, {"3"}}}))
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-profile", "-stats-format", StatsFormatJSON)
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if want := `"name": "id",
+    "type": "int",
+    "count": 2,
+    "nulls": 0,
+    "distinct": 2,
+    "min": 1,
+    "max": 3,
+    "mean": 2`; !strings.Contains(stdout, want) {
+		t.Errorf("stdout %q, want the profile %q", stdout, want)
+	}
+}
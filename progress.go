@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progress counts the pages and records retrieved so far in a run, for
+// reporting at intervals whilst the run is in progress.  The estimated pages
+// of each job, by hash, give a best-effort estimate of the time remaining, and
+// last is the token of the page most recently retrieved
+type progress struct {
+	started   time.Time
+	pages     atomic.Int64
+	records   atomic.Int64
+	mu        sync.Mutex
+	estimates map[string]int
+	last      string
+}
+
+// newProgress returns a progress for a run starting now
+func newProgress() *progress {
+	return &progress{started: time.Now(), estimates: map[string]int{}}
+}
+
+// page adds the retrieved page to the counts
+func (p *progress) page(_ context.Context, e PageEvent) {
+	p.pages.Add(1)
+	p.records.Add(int64(e.Records))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = e.Token
+	if e.EstimatedPages > 0 {
+		p.estimates[e.Hash] = e.EstimatedPages
+	}
+}
+
+// lastToken returns the token of the page most recently retrieved
+func (p *progress) lastToken() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.last
+}
+
+// estimate returns the sum of the estimated pages of the jobs with an estimate
+func (p *progress) estimate() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pages := 0
+	for _, n := range p.estimates {
+		pages += n
+	}
+	return int64(pages)
+}
+
+// report writes the progress every interval to w, until ctx ends
+func (p *progress) report(ctx context.Context, w io.Writer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastTime := p.started
+	lastRecords := int64(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			records := p.records.Load()
+			pages := p.pages.Load()
+			rate := float64(records-lastRecords) / now.Sub(lastTime).Seconds()
+			elapsed := now.Sub(p.started)
+			estimated := ""
+			if total := p.estimate(); total > 0 && pages > 0 {
+				remaining := time.Duration(max(total-pages, 0)) * elapsed / time.Duration(pages)
+				estimated = fmt.Sprintf(", estimated pages: ~%v, estimated remaining: ~%v", total, remaining.Round(100*time.Millisecond))
+			}
+			fmt.Fprintf(w, "Progress: pages: %v, records: %v, rate: %.1f records/s, elapsed: %v%v\n", pages, records, rate, elapsed.Round(time.Millisecond), estimated)
+			lastTime, lastRecords = now, records
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReport(t *testing.T) {
+	p := newProgress()
+	ctx, cancel := context.WithCancel(context.Background())
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		p.report(ctx, &buf, 10*time.Millisecond)
+		close(done)
+	}()
+	p.page(ctx, PageEvent{Hash: "h", Token: "t1", Records: 3})
+	p.page(ctx, PageEvent{Hash: "h", Token: "t2", Records: 2, EstimatedPages: 4})
+	time.Sleep(35 * time.Millisecond)
+	cancel()
+	<-done
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("reported %q, want a line per interval", buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "Progress: pages: 2, records: 5, rate: ") || !strings.Contains(lines[0], "estimated pages: ~4") {
+		t.Errorf("reported %q", lines[0])
+	}
+	if got := p.lastToken(); got != "t2" {
+		t.Errorf("last token %v", got)
+	}
+}
+
+func TestStatsIntervalFlag(t *testing.T) {
+	s := newPageServer(t, numberedPages(5, 2))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		time.Sleep(40 * time.Millisecond)
+		return false
+	})
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-stats-interval", "25ms", "-output-format", "csv")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	reports := regexp.MustCompile(`(?m)^Progress: pages: \d+, records: \d+, rate: [\d.]+ records/s, elapsed: `).FindAllString(stderr, -1)
+	if len(reports) < 2 {
+		t.Errorf("%v interim summaries in stderr %q, want several", len(reports), stderr)
+	}
+	if strings.Contains(stdout, "Progress:") {
+		t.Errorf("progress written to stdout %q", stdout)
+	}
+}
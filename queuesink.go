@@ -0,0 +1,72 @@
+package main
+
+import "sync"
+
+// queuedPage is a page of records waiting to be written by a queueSink
+type queuedPage struct {
+	columns []Column
+	records [][]string
+}
+
+// queueSink is a RecordSink handing pages to a goroutine that writes them to
+// its sink, so that a slow output overlaps the retrieval of the pages that
+// follow.  Up to depth pages are held in memory, being written or waiting to
+// be, after which WriteRecords blocks until the output catches up.  Pages are
+// written in the order they are given.  A failed write is returned by the
+// next call to WriteRecords, or by Close, with later pages discarded
+type queueSink struct {
+	sink  RecordSink
+	pages chan queuedPage
+	done  chan struct{}
+	mu    sync.Mutex
+	err   error
+}
+
+// newQueueSink returns a queueSink holding up to depth pages, which must be at least 1
+func newQueueSink(sink RecordSink, depth int) *queueSink {
+	q := &queueSink{sink: sink, pages: make(chan queuedPage, depth-1), done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+// run writes the queued pages to the sink until the queue is closed
+func (q *queueSink) run() {
+	defer close(q.done)
+	for p := range q.pages {
+		if q.failed() != nil {
+			continue
+		}
+		if err := q.sink.WriteRecords(p.columns, p.records); err != nil {
+			q.mu.Lock()
+			q.err = err
+			q.mu.Unlock()
+		}
+	}
+}
+
+// failed returns the error of the first failed write, if any
+func (q *queueSink) failed() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// WriteRecords queues the records to be written, which must not be modified afterwards
+func (q *queueSink) WriteRecords(columns []Column, records [][]string) error {
+	if err := q.failed(); err != nil {
+		return err
+	}
+	q.pages <- queuedPage{columns: columns, records: records}
+	return nil
+}
+
+// Close writes the pages still queued and closes the sink, returning the first error
+func (q *queueSink) Close() error {
+	close(q.pages)
+	<-q.done
+	err := q.failed()
+	if cerr := q.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
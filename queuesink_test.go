@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// queued returns the sink behind a queueSink of depth, as -output-queue-depth does
+func queued(sink RecordSink, depth int) RecordSink {
+	if depth == 0 {
+		return sink
+	}
+	return newQueueSink(sink, depth)
+}
+
+// slowSink is a memorySink taking delay over each write
+type slowSink struct {
+	memorySink
+	delay time.Duration
+}
+
+func (s *slowSink) WriteRecords(columns []Column, records [][]string) error {
+	time.Sleep(s.delay)
+	return s.memorySink.WriteRecords(columns, records)
+}
+
+func TestQueueSinkDepths(t *testing.T) {
+	s := newPageServer(t, numberedPages(20, 3))
+	for _, depth := range []int{0, 1, 8} {
+		t.Run(fmt.Sprint(depth), func(t *testing.T) {
+			sink := &slowSink{delay: time.Millisecond}
+			q := queued(sink, depth)
+			r, err := NewClient(s.URL, WithRecordSink(q)).consumeAllPages(context.Background(), "h", "t0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := q.Close(); err != nil {
+				t.Fatal(err)
+			}
+			want := [][]string{}
+			for i := range 60 {
+				want = append(want, []string{fmt.Sprint(i)})
+			}
+			if r.TotalRecords() != 60 || !reflect.DeepEqual(sink.records, want) {
+				t.Errorf("got %v records, wrote %v, want every record in order", r.TotalRecords(), sink.records)
+			}
+			if sink.writes != 20 || !sink.closed {
+				t.Errorf("%v writes, closed %v, want each page written and the sink closed", sink.writes, sink.closed)
+			}
+		})
+	}
+}
+
+func TestQueueSinkError(t *testing.T) {
+	failure := errors.New("disk full")
+	sink := &failingSink{err: failure}
+	q := newQueueSink(sink, 2)
+	columns := testColumns("id")
+	// The failure of the first page is returned once it has been written
+	var err error
+	for deadline := time.Now().Add(time.Second); err == nil && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		err = q.WriteRecords(columns, [][]string{{"1"}})
+	}
+	if !errors.Is(err, failure) {
+		t.Errorf("got %v, want the failed write", err)
+	}
+	if err := q.Close(); !errors.Is(err, failure) || !sink.closed {
+		t.Errorf("closed %v with %v, want the sink closed and the failure returned", sink.closed, err)
+	}
+}
+
+func TestOutputQueueDepthFlag(t *testing.T) {
+	s := newPageServer(t, numberedPages(4, 2))
+	dir := t.TempDir()
+	outputs := map[int]string{}
+	for _, depth := range []int{0, 1, 8} {
+		path := filepath.Join(dir, fmt.Sprint(depth, ".csv"))
+		if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-output-format", "csv", "-output", path, "-output-queue-depth", fmt.Sprint(depth)); code != 0 {
+			t.Fatalf("exit %v, stderr %q", code, stderr)
+		}
+		b, _ := os.ReadFile(path)
+		outputs[depth] = string(b)
+	}
+	if want := "id\n0\n1\n2\n3\n4\n5\n6\n7\n"; outputs[0] != want || outputs[1] != want || outputs[8] != want {
+		t.Errorf("got %q, want %q at each depth", outputs, want)
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-output-queue-depth", "-1"); code == 0 {
+		t.Error("negative depth accepted")
+	}
+}
+
+func BenchmarkQueueSinkDepth(b *testing.B) {
+	// Both the server and the output take time over each page, so that queueing
+	// overlaps them
+	s := newPageServer(b, numberedPages(20, 100))
+	s.setHandle(func(http.ResponseWriter, *http.Request, Request) bool {
+		time.Sleep(200 * time.Microsecond)
+		return false
+	})
+	for _, depth := range []int{0, 1, 2, 8} {
+		b.Run(fmt.Sprint("depth-", depth), func(b *testing.B) {
+			for b.Loop() {
+				q := queued(&slowSink{delay: 200 * time.Microsecond}, depth)
+				if _, err := NewClient(s.URL, WithRecordSink(q)).consumeAllPages(context.Background(), "h", "t0"); err != nil {
+					b.Fatal(err)
+				}
+				if err := q.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Handling of the records held by an output that buffers them until the run
+// completes once they exceed the memory limit
+const (
+	MemoryLimitError = "error"
+	MemoryLimitSpill = "spill"
+)
+
+// bufferedBatchSize is the number of records an output that buffers them passes
+// on to its sink at a time, once the run completes
+const bufferedBatchSize = 1000
+
+// memoryLimit bounds the records held in memory by an output that buffers them:
+// -flatten, -allow-schema-evolution and fixed output sizing its columns.  Beyond
+// records, the buffer fails under MemoryLimitError, or moves the records to a
+// temporary file under MemoryLimitSpill.  A limit of 0 holds any number
+type memoryLimit struct {
+	records int
+	policy  string
+}
+
+// memoryLimitError reports buffered records exceeding the memory limit
+type memoryLimitError struct {
+	limit int
+}
+
+func (e *memoryLimitError) Error() string {
+	return fmt.Sprintf("buffered records exceed the memory limit of %v records", e.limit)
+}
+
+// recordBuffer holds records in the order they are added, in memory until they
+// exceed the memory limit, and then, when spilling, in a temporary file of a
+// JSON array per record
+type recordBuffer struct {
+	limit   memoryLimit
+	n       int
+	records [][]string
+	spill   *os.File
+	w       *bufio.Writer
+	enc     *json.Encoder
+}
+
+// newRecordBuffer returns an empty recordBuffer bounded by limit
+func newRecordBuffer(limit memoryLimit) *recordBuffer {
+	return &recordBuffer{limit: limit}
+}
+
+// len returns the number of records held
+func (b *recordBuffer) len() int {
+	return b.n
+}
+
+// spilled returns true if the records have been moved to a temporary file
+func (b *recordBuffer) spilled() bool {
+	return b.spill != nil
+}
+
+// add appends the records, spilling those held to a temporary file, or failing,
+// if they would exceed the memory limit
+func (b *recordBuffer) add(records [][]string) error {
+	if b.spill == nil && b.limit.records > 0 && b.n+len(records) > b.limit.records {
+		if b.limit.policy != MemoryLimitSpill {
+			return &memoryLimitError{limit: b.limit.records}
+		}
+		f, err := os.CreateTemp("", "dataproxy-records-*.ndjson")
+		if err != nil {
+			return fmt.Errorf("spilling records: %w", err)
+		}
+		b.spill, b.w = f, bufio.NewWriter(f)
+		b.enc = json.NewEncoder(b.w)
+		held := b.records
+		b.records = nil
+		if err := b.write(held); err != nil {
+			return err
+		}
+	}
+
+	b.n += len(records)
+	if b.spill != nil {
+		return b.write(records)
+	}
+	b.records = append(b.records, records...)
+	return nil
+}
+
+// write appends the records to the spill file
+func (b *recordBuffer) write(records [][]string) error {
+	for _, record := range records {
+		if err := b.enc.Encode(record); err != nil {
+			return fmt.Errorf("spilling records: %w", err)
+		}
+	}
+	return nil
+}
+
+// each calls fn with each record held, in order, stopping at the first error
+func (b *recordBuffer) each(fn func(record []string) error) error {
+	if b.spill == nil {
+		for _, record := range b.records {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := b.w.Flush(); err != nil {
+		return fmt.Errorf("spilling records: %w", err)
+	}
+	if _, err := b.spill.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("reading spilled records: %w", err)
+	}
+	dec := json.NewDecoder(bufio.NewReader(b.spill))
+	for range b.n {
+		var record []string
+		if err := dec.Decode(&record); err != nil {
+			return fmt.Errorf("reading spilled records: %w", err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if _, err := b.spill.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("reading spilled records: %w", err)
+	}
+	return nil
+}
+
+// release discards the records held, removing any spill file
+func (b *recordBuffer) release() error {
+	b.n, b.records = 0, nil
+	if b.spill == nil {
+		return nil
+	}
+	name := b.spill.Name()
+	err := b.spill.Close()
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+	b.spill, b.w, b.enc = nil, nil, nil
+	return err
+}
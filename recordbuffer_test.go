@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestRecordBufferMemoryLimitError(t *testing.T) {
+	b := newRecordBuffer(memoryLimit{records: 3, policy: MemoryLimitError})
+	if err := b.add([][]string{{"a"}, {"b"}, {"c"}}); err != nil {
+		t.Fatalf("add within the limit: %v", err)
+	}
+	err := b.add([][]string{{"d"}})
+	var me *memoryLimitError
+	if !errors.As(err, &me) || me.limit != 3 {
+		t.Fatalf("add beyond the limit: got %v, want a memoryLimitError of 3", err)
+	}
+	if b.spilled() {
+		t.Error("records spilled under the error policy")
+	}
+}
+
+func TestRecordBufferSpill(t *testing.T) {
+	b := newRecordBuffer(memoryLimit{records: 2, policy: MemoryLimitSpill})
+	want := [][]string{}
+	for i := range 5 {
+		record := []string{strconv.Itoa(i), "value \"" + strconv.Itoa(i) + "\""}
+		want = append(want, record)
+		if err := b.add([][]string{record}); err != nil {
+			t.Fatalf("add %v: %v", i, err)
+		}
+	}
+	if !b.spilled() || b.len() != len(want) {
+		t.Fatalf("spilled %v with %v records, want spilled with %v", b.spilled(), b.len(), len(want))
+	}
+	name := b.spill.Name()
+
+	// The records can be read more than once, and added to in between
+	for range 2 {
+		got := [][]string{}
+		if err := b.each(func(record []string) error {
+			got = append(got, record)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("records: got %v, want %v", got, want)
+		}
+		want = append(want, []string{"more", ""})
+		if err := b.add([][]string{{"more", ""}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("spill file %v not removed: %v", name, err)
+	}
+}
+
+func TestBufferedOutputsMemoryLimit(t *testing.T) {
+	columns := testColumns("id", "attrs")
+	pages := [][][]string{
+		{{"1", `{"a":"x"}`}, {"2", `{"b":"y"}`}},
+		{{"3", "plain"}, {"4", `{"a":"z","b":"w"}`}},
+	}
+
+	fixed := func(limit memoryLimit) (RecordSink, *bytes.Buffer) {
+		var buf bytes.Buffer
+		return &streamSink{out: nopCloser{&buf}, buf: bufio.NewWriter(&buf), enc: &fixedEncoder{records: newRecordBuffer(limit)}}, &buf
+	}
+	outputs := map[string]func(limit memoryLimit) (RecordSink, func() any){
+		"flatten": func(limit memoryLimit) (RecordSink, func() any) {
+			m := &memorySink{}
+			return newFlattenSink(m, []string{"attrs"}, limit), func() any { return []any{m.columns, m.records} }
+		},
+		"schema evolution": func(limit memoryLimit) (RecordSink, func() any) {
+			m := &memorySink{}
+			return newSchemaUnionSink(m, limit), func() any { return []any{m.columns, m.records} }
+		},
+		"fixed": func(limit memoryLimit) (RecordSink, func() any) {
+			s, buf := fixed(limit)
+			return s, func() any { return buf.String() }
+		},
+	}
+
+	write := func(sink RecordSink) error {
+		for _, page := range pages {
+			if err := sink.WriteRecords(columns, page); err != nil {
+				sink.Close()
+				return err
+			}
+		}
+		return sink.Close()
+	}
+
+	for name, newOutput := range outputs {
+		t.Run(name, func(t *testing.T) {
+			unlimited, want := newOutput(memoryLimit{})
+			if err := write(unlimited); err != nil {
+				t.Fatal(err)
+			}
+
+			limited, _ := newOutput(memoryLimit{records: 3, policy: MemoryLimitError})
+			var me *memoryLimitError
+			if err := write(limited); !errors.As(err, &me) {
+				t.Errorf("error policy: got %v, want a memoryLimitError", err)
+			}
+
+			spilling, got := newOutput(memoryLimit{records: 3, policy: MemoryLimitSpill})
+			if err := write(spilling); err != nil {
+				t.Fatalf("spill policy: %v", err)
+			}
+			if !reflect.DeepEqual(got(), want()) {
+				t.Errorf("spill policy: got %v, want %v", got(), want())
+			}
+		})
+	}
+}
+
+func TestMaxMemoryRecordsFlag(t *testing.T) {
+	columns := testColumns("id", "attrs")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{
+		{{"1", `{"a":"x"}`}, {"2", `{"a":"y"}`}},
+		{{"3", `{"a":"z"}`}},
+	}))
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t1", "-records-only", "-output-format", "csv", "-flatten", "attrs", "-max-memory-records", "2"}
+
+	_, stderr, code := runMain(t, args...)
+	if code == 0 {
+		t.Fatalf("with -on-memory-limit error: exited 0, stderr %q", stderr)
+	}
+
+	stdout, stderr, code := runMain(t, append(args, "-on-memory-limit", "spill")...)
+	want := "id,attrs,attrs.a\n1,,x\n2,,y\n3,,z\n"
+	if code != 0 || stdout != want {
+		t.Errorf("with -on-memory-limit spill: exit %v, stdout %q, want %q, stderr %q", code, stdout, want, stderr)
+	}
+}
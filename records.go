@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Records are the values of a page of records.  Values are decoded from JSON
+// strings, or from numbers, booleans and nulls which are held in their string
+// form (with null as "")
+type Records [][]string
+
+// UnmarshalJSON decodes an array of records, coercing non-string values to strings
+func (r *Records) UnmarshalJSON(b []byte) error {
+	var raw [][]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	records := make(Records, len(raw))
+	for i, cells := range raw {
+		records[i] = make([]string, len(cells))
+		for j, cell := range cells {
+			if len(cell) > 0 && cell[0] == '"' {
+				if err := json.Unmarshal(cell, &records[i][j]); err != nil {
+					return err
+				}
+				continue
+			}
+			if bytes.Equal(cell, []byte("null")) {
+				continue
+			}
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, cell); err != nil {
+				return err
+			}
+			records[i][j] = compact.String()
+		}
+	}
+	*r = records
+	return nil
+}
+
+// cellString returns the string form of a generically decoded record value,
+// consistent with Records
+func cellString(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case nil:
+		return "", nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case json.Number:
+		return t.String(), nil
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return "", fmt.Errorf("unsupported value: %v", err)
+		}
+		return string(b), nil
+	}
+}
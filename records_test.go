@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordsMixedTypes(t *testing.T) {
+	var rs ResultSet
+	body := `{"meta":{"next":""},"data":{"header":{"columns":[]},"records":[["a",1,2.5,true,null,{"k":[1, 2]}],["b",-3e2,false,"",12345678901234567890,[ ]]]}}`
+	if err := json.Unmarshal([]byte(body), &rs); err != nil {
+		t.Fatal(err)
+	}
+	want := Records{{"a", "1", "2.5", "true", "", `{"k":[1,2]}`}, {"b", "-3e2", "false", "", "12345678901234567890", "[]"}}
+	if !reflect.DeepEqual(rs.Data.Records, want) {
+		t.Errorf("got %q, want %q", rs.Data.Records, want)
+	}
+}
+
+func TestCellString(t *testing.T) {
+	for _, test := range []struct {
+		v    interface{}
+		want string
+	}{
+		{"a", "a"}, {nil, ""}, {true, "true"}, {2.5, "2.5"}, {float64(1e21), "1000000000000000000000"}, {json.Number("12345678901234567890"), "12345678901234567890"},
+	} {
+		if got, err := cellString(test.v); err != nil || got != test.want {
+			t.Errorf("%v: got %q, %v, want %q", test.v, got, err, test.want)
+		}
+	}
+}
+
+func TestMixedTypePage(t *testing.T) {
+	s := newPageServer(t, map[string][]byte{
+		"t1": []byte(`{"meta":{"next":""},"data":{"header":{"columns":[{"name":"s","type":"string","position":0},{"name":"n","type":"int","position":1},{"name":"b","type":"bool","position":2}]},"records":[["a",1,true],["b",2.5,false],[null,null,null]]}}`),
+	})
+	sink := &memorySink{}
+	r, err := NewClient(s.URL, WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"a", "1", "true"}, {"b", "2.5", "false"}, {"", "", ""}}
+	if r.TotalRecords() != 3 || !reflect.DeepEqual(sink.records, want) {
+		t.Errorf("got %v records %q, want %q", r.TotalRecords(), sink.records, want)
+	}
+}
+
+func TestObjectRecords(t *testing.T) {
+	columns := []Column{{Name: "name", Type: "string", Position: 1}, {Name: "id", Type: "int", Position: 0}, {Name: "active", Type: "bool", Position: 2}}
+	header, _ := json.Marshal(Header{Columns: columns})
+	page := func(next, records string) []byte {
+		return []byte(`{"meta":{"next":"` + next + `"},"data":{"header":` + string(header) + `,"records":` + records + `}}`)
+	}
+	s := newPageServer(t, map[string][]byte{
+		"t1": page("t2", `[{"id":1,"name":"a","active":true},{"name":"b","id":2,"extra":"ignored"}]`),
+		"t2": page("", `[{"active":false,"id":3,"name":null}]`),
+	})
+	sink := &memorySink{}
+
+	r, err := NewClient(s.URL, WithObjectRecords(true), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"1", "a", "true"}, {"2", "b", ""}, {"3", "", "false"}}
+	if r.TotalRecords() != 3 || !reflect.DeepEqual(sink.records, want) {
+		t.Errorf("records %v, want %v", sink.records, want)
+	}
+}
+
+func TestObjectRecordsErrors(t *testing.T) {
+	if _, err := objectStringRecords(testColumns("id"), []interface{}{[]interface{}{"1"}}); err == nil || err.Error() != "record 0 is not an object" {
+		t.Errorf("got %v, want a positional record rejected", err)
+	}
+	columns := []Column{{Name: "id", Type: "string", Position: -1}}
+	if _, err := objectStringRecords(columns, nil); err == nil || !strings.Contains(err.Error(), "negative position") {
+		t.Errorf("got %v, want the negative position", err)
+	}
+}
+
+func TestUseNumber(t *testing.T) {
+	header, _ := json.Marshal(Header{Columns: []Column{{Name: "id", Type: "int", Position: 0}, {Name: "ratio", Type: "float", Position: 1}}})
+	s := newPageServer(t, map[string][]byte{
+		"t1": []byte(`{"meta":{"next":""},"data":{"header":` + string(header) + `,"records":[[9223372036854775807,0.10000000000000000555]]}}`),
+	})
+
+	exact, rounded := &memorySink{}, &memorySink{}
+	if _, err := NewClient(s.URL, WithUseNumber(true), WithRecordSink(exact)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"9223372036854775807", "0.10000000000000000555"}}; !reflect.DeepEqual(exact.records, want) {
+		t.Errorf("with json.Number got %v, want %v", exact.records, want)
+	}
+	if _, err := NewClient(s.URL, WithRecordSink(rounded)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if rounded.records[0][0] == "9223372036854775807" {
+		t.Errorf("without json.Number got %v, want the float64 rounding", rounded.records)
+	}
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-use-number", "-records-only", "-output-format", "csv")
+	if code != 0 || stdout != "id,ratio\n9223372036854775807,0.10000000000000000555\n" {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+}
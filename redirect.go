@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// defaultMaxRedirects is the number of redirects followed by default, as for http.Client
+const defaultMaxRedirects = 10
+
+// Handling of the Authorization header when a page request is redirected to another host
+const (
+	RedirectAuthStrip    = "strip"
+	RedirectAuthPreserve = "preserve"
+)
+
+// checkRedirect follows at most maxRedirects redirects of a page request,
+// re-sending the original request body with its method, so that 301, 302 and
+// 303 redirects remain POSTs of the page request.  The Authorization header is
+// sent to a different host only with RedirectAuthPreserve
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if c.maxRedirects == 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > c.maxRedirects {
+		return fmt.Errorf("stopped after %v redirects", c.maxRedirects)
+	}
+
+	orig := via[0]
+	if req.Method != orig.Method {
+		if orig.GetBody == nil {
+			return errors.New("redirect: request body cannot be re-sent")
+		}
+		body, err := orig.GetBody()
+		if err != nil {
+			return err
+		}
+		req.Method = orig.Method
+		req.Body, req.GetBody, req.ContentLength = body, orig.GetBody, orig.ContentLength
+		req.Header.Set("Content-Type", orig.Header.Get("Content-Type"))
+	}
+
+	auth := orig.Header.Get("Authorization")
+	if len(auth) > 0 && req.URL.Host != orig.URL.Host {
+		if c.redirectAuth == RedirectAuthPreserve {
+			req.Header.Set("Authorization", auth)
+		} else {
+			req.Header.Del("Authorization")
+		}
+	}
+	return nil
+}
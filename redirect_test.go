@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// redirectServer returns a server redirecting every request to target with the status
+func redirectServer(t *testing.T, target string, status int) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target+r.URL.Path, status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestRedirects(t *testing.T) {
+	columns := testColumns("id")
+	source := func(context.Context) (string, error) { return "secret", nil }
+	for _, status := range []int{http.StatusFound, http.StatusTemporaryRedirect} {
+		for _, auth := range []string{RedirectAuthStrip, RedirectAuthPreserve} {
+			t.Run(http.StatusText(status)+" "+auth, func(t *testing.T) {
+				target := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+				s := redirectServer(t, target.URL, status)
+
+				r, err := NewClient(s.URL, WithRedirects(defaultMaxRedirects, auth), WithTokenSource(source)).consumeAllPages(context.Background(), "h", "t1")
+				if err != nil || r.PageCount != 2 {
+					t.Fatalf("got %v pages, %v", r.PageCount, err)
+				}
+				for _, req := range target.received() {
+					if req.method != http.MethodPost || req.Hash != "h" || !strings.Contains(string(req.body), `"token":"`+req.Token+`"`) {
+						t.Errorf("redirected as %v with body %q", req.method, req.body)
+					}
+					want := ""
+					if auth == RedirectAuthPreserve {
+						want = "Bearer secret"
+					}
+					if got := req.header.Get("Authorization"); got != want {
+						t.Errorf("Authorization %q, want %q", got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	target := newPageServer(t, chainPages(testColumns("id"), []string{"t1"}, [][][]string{{{"1"}}}))
+	second := redirectServer(t, target.URL, http.StatusFound)
+	first := redirectServer(t, second.URL, http.StatusFound)
+
+	if _, err := NewClient(first.URL, WithRedirects(2, RedirectAuthStrip)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Errorf("two redirects: %v", err)
+	}
+	_, err := NewClient(first.URL, WithRedirects(1, RedirectAuthStrip)).consumeAllPages(context.Background(), "h", "t1")
+	if err == nil || !strings.Contains(err.Error(), "stopped after 1 redirects") {
+		t.Errorf("got %v, want the redirects stopped", err)
+	}
+	if _, err := NewClient(first.URL, WithRedirects(0, RedirectAuthStrip)).consumeAllPages(context.Background(), "h", "t1"); err == nil {
+		t.Error("redirect followed with a maximum of 0")
+	}
+}
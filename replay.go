@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Compression of captured page bodies, named as their Content-Encoding
+const (
+	CaptureCompressNone = "none"
+	CaptureCompressGzip = "gzip"
+	CaptureCompressZstd = "zstd"
+)
+
+// captureCompressions are the compressions of captures, in the order replay looks for them
+var captureCompressions = []string{CaptureCompressNone, CaptureCompressGzip, CaptureCompressZstd}
+
+// captureExtensions are the extensions added to the name of a capture by its compression
+var captureExtensions = map[string]string{
+	CaptureCompressNone: "",
+	CaptureCompressGzip: ".gz",
+	CaptureCompressZstd: ".zst",
+}
+
+// capturedPageFile returns the name of the file holding the captured response
+// of the page for token with the compression, within dir
+func capturedPageFile(dir, token, compression string) string {
+	return filepath.Join(dir, url.PathEscape(token)+".json"+captureExtensions[compression])
+}
+
+// capturePage writes the body of the page for token to its file in dir, with the
+// compression, as read by replayTransport.  An existing capture of any
+// compression is an error unless overwrite is set, when it is replaced.  The
+// token is shown in errors in the form given by redact
+func capturePage(dir, token string, body []byte, overwrite bool, compression string, redact TokenRedactor) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, other := range captureCompressions {
+		path := capturedPageFile(dir, token, other)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if !overwrite {
+			return fmt.Errorf("capture of token %v already exists in %v", redact(token), dir)
+		}
+		if other != compression {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := compressBody(compression, body)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(capturedPageFile(dir, token, compression), data)
+}
+
+// replayTransport is an http.RoundTripper answering page requests from
+// responses captured to files named by their token, instead of the network.
+// Tokens are shown in errors in the form given by redact
+type replayTransport struct {
+	dir    string
+	redact TokenRedactor
+}
+
+// RoundTrip returns the captured response for the token of the page request
+func (t replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var r struct {
+		Token  string `json:"token"`
+		Offset *int   `json:"offset"`
+	}
+	if req.Body == nil {
+		return nil, fmt.Errorf("replay: request has no body")
+	}
+	err := json.NewDecoder(req.Body).Decode(&r)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("replay: %v", err)
+	}
+
+	// Pages requested by offset are captured with the offset as their token
+	token := r.Token
+	if r.Offset != nil {
+		token = strconv.Itoa(*r.Offset)
+	}
+
+	// A compressed capture is returned with its compression as the Content-Encoding
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	body, err := os.ReadFile(capturedPageFile(t.dir, token, CaptureCompressNone))
+	for _, compression := range captureCompressions[1:] {
+		if err == nil {
+			break
+		}
+		if compressed, cerr := os.ReadFile(capturedPageFile(t.dir, token, compression)); cerr == nil {
+			body, err = compressed, nil
+			header.Set("Content-Encoding", compression)
+		}
+	}
+	if err != nil {
+		// The path of the capture holds the token, so is left out
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		return nil, fmt.Errorf("replay: no captured page for token %v in %v: %v", t.redact(token), t.dir, err)
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
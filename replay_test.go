@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplayDir(t *testing.T) {
+	columns := testColumns("id", "name")
+	pages := chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1", "a"}, {"2", "b"}}, {{"3", "c"}}})
+	dir := t.TempDir()
+	for token, page := range pages {
+		if err := os.WriteFile(capturedPageFile(dir, token, CaptureCompressNone), page, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newPageServer(t, pages)
+
+	live, replayed := &memorySink{}, &memorySink{}
+	liveResult, err := NewClient(s.URL, WithRecordSink(live)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := len(s.received())
+	replayResult, err := NewClient(s.URL, WithReplayDir(dir), WithRecordSink(replayed)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.received()) != requests {
+		t.Error("replay requested pages of the server")
+	}
+	if replayResult.PageCount != liveResult.PageCount || !reflect.DeepEqual(replayResult.RecordCounts, liveResult.RecordCounts) ||
+		!reflect.DeepEqual(replayResult.PageSizes, liveResult.PageSizes) {
+		t.Errorf("replayed %+v, live %+v", replayResult, liveResult)
+	}
+	if !reflect.DeepEqual(replayed.records, live.records) || !reflect.DeepEqual(replayed.columns, live.columns) {
+		t.Errorf("replayed %v, live %v", replayed.records, live.records)
+	}
+}
+
+func TestReplayDirMissingPage(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(capturedPageFile(dir, "t1", CaptureCompressNone), testPage("t2", testColumns("id"), []string{"1"}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewClient("http://localhost", WithReplayDir(dir)).consumeAllPages(context.Background(), "h", "t1")
+	if err == nil || !strings.Contains(err.Error(), "no captured page for token "+HashedToken("t2")+" in "+dir+": ") {
+		t.Errorf("got %v, want the missing capture of t2", err)
+	}
+}
+
+func TestReplayDirFlag(t *testing.T) {
+	dir := t.TempDir()
+	for token, page := range chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}) {
+		if err := os.WriteFile(capturedPageFile(dir, token, CaptureCompressNone), page, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stdout, stderr, code := runMain(t, "-url", "http://127.0.0.1:1", "-hash", "h", "-token", "t1", "-replay-dir", dir, "-records-only", "-output-format", "csv")
+	if code != 0 || stdout != "id\n1\n2\n" {
+		t.Errorf("exit %v, output %q, stderr %q", code, stdout, stderr)
+	}
+}
+
+func TestCaptureDir(t *testing.T) {
+	columns := testColumns("id")
+	pages := chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}})
+	s := compressedServer(t, "gzip", pages)
+	dir := t.TempDir()
+
+	if _, err := NewClient(s.URL, WithCaptureDir(dir, false)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	for token, page := range pages {
+		captured, err := os.ReadFile(capturedPageFile(dir, token, CaptureCompressNone))
+		if err != nil || !reflect.DeepEqual(captured, page) {
+			t.Errorf("token %v: captured %q, %v, want the decompressed %q", token, captured, err, page)
+		}
+	}
+
+	_, err := NewClient(s.URL, WithCaptureDir(dir, false)).consumeAllPages(context.Background(), "h", "t1")
+	if err == nil || !strings.Contains(err.Error(), "capture of token "+HashedToken("t1")+" already exists in "+dir) {
+		t.Errorf("got %v, want the existing capture", err)
+	}
+	if _, err := NewClient(s.URL, WithCaptureDir(dir, true)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Errorf("overwriting: %v", err)
+	}
+}
+
+func TestCaptureDirFlag(t *testing.T) {
+	pages := chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}})
+	s := newPageServer(t, pages)
+	dir := t.TempDir()
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t1", "-capture-dir", dir}
+
+	if _, stderr, code := runMain(t, args...); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != len(pages) {
+		t.Errorf("captured %v files, want %v", len(entries), len(pages))
+	}
+	if _, _, code := runMain(t, args...); code == 0 {
+		t.Error("existing captures overwritten without -capture-overwrite")
+	}
+	if _, stderr, code := runMain(t, append(args, "-capture-overwrite")...); code != 0 {
+		t.Errorf("-capture-overwrite: exit %v, stderr %q", code, stderr)
+	}
+}
+
+func TestCaptureCompress(t *testing.T) {
+	columns := testColumns("id", "name")
+	pages := chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1", "a"}, {"2", "b"}}, {{"3", "c"}}})
+	s := newPageServer(t, pages)
+	live := &memorySink{}
+	if _, err := NewClient(s.URL, WithRecordSink(live)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compression := range []string{CaptureCompressGzip, CaptureCompressZstd} {
+		t.Run(compression, func(t *testing.T) {
+			dir := t.TempDir()
+			if _, err := NewClient(s.URL, WithCaptureDir(dir, false), WithCaptureCompression(compression)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+				t.Fatal(err)
+			}
+			for token, page := range pages {
+				path := capturedPageFile(dir, token, compression)
+				if filepath.Ext(path) != captureExtensions[compression] {
+					t.Errorf("captured to %v, want the %v extension", path, captureExtensions[compression])
+				}
+				f, err := os.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r, err := NewClient(s.URL).decompressReader(compression, f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(r)
+				r.Close()
+				f.Close()
+				if err != nil || !bytes.Equal(b, page) {
+					t.Errorf("token %v: captured %q, %v, want %q", token, b, err, page)
+				}
+			}
+
+			replayed := &memorySink{}
+			if _, err := NewClient("http://127.0.0.1:1", WithReplayDir(dir), WithRecordSink(replayed)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(replayed.records, live.records) || !reflect.DeepEqual(replayed.columns, live.columns) {
+				t.Errorf("replayed %v, live %v", replayed.records, live.records)
+			}
+		})
+	}
+}
+
+func TestCaptureCompressOverwrite(t *testing.T) {
+	pages := chainPages(testColumns("id"), []string{"t1"}, [][][]string{{{"1"}}})
+	s := newPageServer(t, pages)
+	dir := t.TempDir()
+	if _, err := NewClient(s.URL, WithCaptureDir(dir, false), WithCaptureCompression(CaptureCompressGzip)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	// A capture of another compression counts as existing
+	_, err := NewClient(s.URL, WithCaptureDir(dir, false)).consumeAllPages(context.Background(), "h", "t1")
+	if err == nil || !strings.Contains(err.Error(), "capture of token "+HashedToken("t1")+" already exists in "+dir) {
+		t.Errorf("got %v, want the existing capture", err)
+	}
+	// Overwriting removes it, so that replay cannot read the stale page
+	if _, err := NewClient(s.URL, WithCaptureDir(dir, true), WithCaptureCompression(CaptureCompressZstd)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(capturedPageFile(dir, "t1", CaptureCompressZstd)) {
+		t.Errorf("got %v, want only the zstd capture", entries)
+	}
+}
+
+func TestCaptureCompressFlag(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	dir := t.TempDir()
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-capture-dir", dir, "-capture-compress", "gzip"); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	f, err := os.Open(capturedPageFile(dir, "t1", CaptureCompressGzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := gzip.NewReader(f); err != nil {
+		t.Errorf("capture not gzip: %v", err)
+	}
+
+	stdout, stderr, code := runMain(t, "-url", "http://127.0.0.1:1", "-hash", "h", "-token", "t1", "-replay-dir", dir, "-records-only", "-output-format", "csv")
+	if code != 0 || stdout != "id\n1\n2\n" {
+		t.Errorf("exit %v, output %q, stderr %q", code, stdout, stderr)
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-capture-dir", dir, "-capture-compress", "bzip2"); code == 0 {
+		t.Error("unknown compression accepted")
+	}
+}
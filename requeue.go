@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// transientJobError reports whether a job failed in a way that may pass if it is
+// run again later: a network error, or a 429 or 5xx response, once any retries
+// of the request are exhausted
+func transientJobError(err error) bool {
+	var de *decodeError
+	if errors.As(err, &de) && de.status != 0 {
+		return de.status == http.StatusTooManyRequests || de.status >= 500
+	}
+	return classifyFailure(nil, err) == RetryClassNetwork
+}
+
+// writeTracker is a RecordSink noting whether an attempt at a job has written
+// any records to the sink, as a job that has cannot be run again without
+// writing them twice
+type writeTracker struct {
+	sink  RecordSink
+	wrote atomic.Bool
+}
+
+// WriteRecords notes the write, and writes the records to the sink
+func (w *writeTracker) WriteRecords(columns []Column, records [][]string) error {
+	w.wrote.Store(true)
+	return w.sink.WriteRecords(columns, records)
+}
+
+// Close does nothing, as the sink is shared by all jobs
+func (w *writeTracker) Close() error {
+	return nil
+}
+
+// forAttempt returns the client for an attempt at the job, reporting whether
+// the attempt wrote any records, with the function closing the job's own sink,
+// if it has one, once the attempt is complete
+func (c *Client) forAttempt(job Job) (*Client, func() bool, func() error, error) {
+	jc, closeSink, err := c.withJobSink(job)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	jc = jc.forJob(job)
+	if jc.sink == nil {
+		return jc, func() bool { return false }, closeSink, nil
+	}
+	tracker := &writeTracker{sink: jc.sink}
+	ac := *jc
+	ac.sink = tracker
+	return &ac, tracker.wrote.Load, closeSink, nil
+}
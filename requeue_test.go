@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTransientJobError(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network", err: fmt.Errorf("page: %w", &net.OpError{Op: "dial", Err: errors.New("refused")}), want: true},
+		{name: "rate limited", err: &decodeError{status: http.StatusTooManyRequests, err: errors.New("429")}, want: true},
+		{name: "server", err: &pageError{err: &decodeError{status: http.StatusBadGateway, err: errors.New("502")}}, want: true},
+		{name: "not found", err: &decodeError{status: http.StatusNotFound, err: errors.New("404")}},
+		{name: "undecodable", err: &decodeError{err: errors.New("invalid character")}},
+		{name: "cancelled", err: context.Canceled},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := transientJobError(test.err); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// failOnceServer returns a pageServer of numberedPages(2, 1) failing the first
+// request with the status for the hash and token
+func failOnceServer(t *testing.T, hash, token string, status int) *pageServer {
+	t.Helper()
+	s := newPageServer(t, numberedPages(2, 1))
+	var once sync.Once
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		failed := false
+		if req.Hash == hash && req.Token == token {
+			once.Do(func() {
+				w.WriteHeader(status)
+				failed = true
+			})
+		}
+		return failed
+	})
+	return s
+}
+
+func TestJobRequeue(t *testing.T) {
+	jobs := []Job{{Hash: "a", Token: "t0"}, {Hash: "b", Token: "t0"}, {Hash: "c", Token: "t0"}}
+
+	t.Run("requeued", func(t *testing.T) {
+		s := failOnceServer(t, "a", "t0", http.StatusServiceUnavailable)
+		results := NewClient(s.URL).consumeJobs(context.Background(), jobs, 1, JobErrorContinue, 1)
+		for _, r := range results {
+			want := 0
+			if r.Job.Hash == "a" {
+				want = 1
+			}
+			if r.Err != nil || r.PageCount != 2 || r.Requeues != want {
+				t.Errorf("%v: got %v pages, %v requeues, %v, want it completed after %v requeues", r.Job.Hash, r.PageCount, r.Requeues, r.Err, want)
+			}
+		}
+		// The requeued job runs again behind those waiting to start
+		hashes := []string{}
+		for _, req := range s.received() {
+			if req.Token == "t0" {
+				hashes = append(hashes, req.Hash)
+			}
+		}
+		if got := strings.Join(hashes, ""); got != "abca" {
+			t.Errorf("jobs started in the order %v, want abca", got)
+		}
+	})
+
+	t.Run("no requeues", func(t *testing.T) {
+		s := failOnceServer(t, "a", "t0", http.StatusServiceUnavailable)
+		results := NewClient(s.URL).consumeJobs(context.Background(), jobs, 2, JobErrorContinue, 0)
+		if results[0].Err == nil || results[0].Requeues != 0 {
+			t.Errorf("got %v requeues, %v, want the job failed", results[0].Requeues, results[0].Err)
+		}
+	})
+
+	t.Run("not transient", func(t *testing.T) {
+		s := failOnceServer(t, "a", "t0", http.StatusBadRequest)
+		results := NewClient(s.URL).consumeJobs(context.Background(), jobs, 2, JobErrorContinue, 1)
+		if results[0].Err == nil || results[0].Requeues != 0 {
+			t.Errorf("got %v requeues, %v, want the job failed", results[0].Requeues, results[0].Err)
+		}
+	})
+
+	t.Run("after writing", func(t *testing.T) {
+		// The second page fails once the first page's records are written
+		s := failOnceServer(t, "a", "t1", http.StatusServiceUnavailable)
+		sink := &memorySink{}
+		results := NewClient(s.URL, WithRecordSink(sink)).consumeJobs(context.Background(), jobs[:1], 1, JobErrorContinue, 1)
+		if results[0].Err == nil || results[0].Requeues != 0 {
+			t.Errorf("got %v requeues, %v, want the job failed rather than its records written twice", results[0].Requeues, results[0].Err)
+		}
+		if len(sink.records) != 1 {
+			t.Errorf("wrote %v", sink.records)
+		}
+
+		// Without output there is nothing to duplicate
+		s = failOnceServer(t, "a", "t1", http.StatusServiceUnavailable)
+		results = NewClient(s.URL).consumeJobs(context.Background(), jobs[:1], 1, JobErrorContinue, 1)
+		if results[0].Err != nil || results[0].Requeues != 1 {
+			t.Errorf("got %v requeues, %v, want the job completed once requeued", results[0].Requeues, results[0].Err)
+		}
+	})
+}
+
+func TestJobRequeuesFlag(t *testing.T) {
+	s := failOnceServer(t, "h", "t0", http.StatusServiceUnavailable)
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-job-requeues", "1")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if !strings.Contains(stdout, "  Requeued: 1\n") || !strings.Contains(stderr, "Requeueing job: hash: h, requeue: 1 of 1") {
+		t.Errorf("stdout %q, stderr %q, want the requeue logged and reported", stdout, stderr)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Classes of failed page request, each retried according to its own RetryPolicy
+const (
+	RetryClassNetwork     = "network"
+	RetryClassTLS         = "tls"
+	RetryClassServer      = "5xx"
+	RetryClassRateLimited = "429"
+)
+
+// RetryPolicy is the number of times a failed page request is retried, and the
+// delay before the first retry, which doubles for each subsequent retry
+type RetryPolicy struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+// classifyFailure returns the retry class of the outcome of a page request, or
+// "" if it has not failed in a way that can be retried
+func classifyFailure(resp *http.Response, err error) string {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return ""
+		}
+		var certErr *tls.CertificateVerificationError
+		var unknownAuthority x509.UnknownAuthorityError
+		var invalid x509.CertificateInvalidError
+		var hostname x509.HostnameError
+		var header tls.RecordHeaderError
+		var pinErr *pinError
+		if errors.As(err, &pinErr) || errors.As(err, &certErr) || errors.As(err, &unknownAuthority) || errors.As(err, &invalid) ||
+			errors.As(err, &hostname) || errors.As(err, &header) {
+			return RetryClassTLS
+		}
+		var netErr net.Error
+		var fileErr *tokenFileError
+		if errors.As(err, &netErr) || errors.As(err, &fileErr) {
+			return RetryClassNetwork
+		}
+		return ""
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return RetryClassRateLimited
+	case resp.StatusCode >= 500:
+		return RetryClassServer
+	}
+	return ""
+}
+
+// retryDelay returns the delay before the n'th retry (from 1) under the policy
+func retryDelay(policy RetryPolicy, n int) time.Duration {
+	d := policy.Backoff
+	for i := 1; i < n; i++ {
+		d *= 2
+	}
+	return d
+}
+
+// retryPolicies is a repeatable flag of class=attempts[:backoff] retry policies
+type retryPolicies map[string]RetryPolicy
+
+func (r retryPolicies) String() string {
+	items := []string{}
+	for class, policy := range r {
+		items = append(items, fmt.Sprintf("%v=%v:%v", class, policy.Attempts, policy.Backoff))
+	}
+	slices.Sort(items)
+	return strings.Join(items, ",")
+}
+
+func (r retryPolicies) Set(s string) error {
+	class, spec, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("%q: expected class=attempts[:backoff]", s)
+	}
+	switch class {
+	case RetryClassNetwork, RetryClassTLS, RetryClassServer, RetryClassRateLimited:
+	default:
+		return fmt.Errorf("%q: unknown retry class %q", s, class)
+	}
+
+	attempts, backoff, hasBackoff := strings.Cut(spec, ":")
+	var policy RetryPolicy
+	var err error
+	if policy.Attempts, err = strconv.Atoi(attempts); err != nil || policy.Attempts < 0 {
+		return fmt.Errorf("%q: invalid attempts", s)
+	}
+	if hasBackoff {
+		if policy.Backoff, err = time.ParseDuration(backoff); err != nil || policy.Backoff < 0 {
+			return fmt.Errorf("%q: invalid backoff", s)
+		}
+	}
+	r[class] = policy
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		status int
+		err    error
+		want   string
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "not found", status: http.StatusNotFound},
+		{name: "rate limited", status: http.StatusTooManyRequests, want: RetryClassRateLimited},
+		{name: "server", status: http.StatusBadGateway, want: RetryClassServer},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("refused")}, want: RetryClassNetwork},
+		{name: "tls", err: fmt.Errorf("get: %w", x509.UnknownAuthorityError{}), want: RetryClassTLS},
+		{name: "pin", err: &pinError{}, want: RetryClassTLS},
+		{name: "token file", err: fmt.Errorf("authentication: %w", &tokenFileError{os.ErrNotExist}), want: RetryClassNetwork},
+		{name: "cancelled", err: fmt.Errorf("get: %w", context.Canceled)},
+		{name: "other", err: errors.New("other")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var resp *http.Response
+			if test.err == nil {
+				resp = &http.Response{StatusCode: test.status}
+			}
+			if got := classifyFailure(resp, test.err); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryClasses(t *testing.T) {
+	policies := map[string]RetryPolicy{
+		RetryClassNetwork:     {Attempts: 1},
+		RetryClassServer:      {Attempts: 3},
+		RetryClassRateLimited: {Attempts: 2},
+	}
+	for _, test := range []struct {
+		class string
+		// server returns a server failing in the way of the class, counting its attempts
+		server func(attempts *atomic.Int32) *httptest.Server
+	}{
+		{class: RetryClassNetwork, server: func(attempts *atomic.Int32) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			}))
+		}},
+		{class: RetryClassTLS, server: func(attempts *atomic.Int32) *httptest.Server {
+			s := httptest.NewUnstartedServer(http.NotFoundHandler())
+			s.TLS = &tls.Config{GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				attempts.Add(1)
+				return nil, nil
+			}}
+			s.StartTLS()
+			return s
+		}},
+		{class: RetryClassServer, server: func(attempts *atomic.Int32) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+		}},
+		{class: RetryClassRateLimited, server: func(attempts *atomic.Int32) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(http.StatusTooManyRequests)
+			}))
+		}},
+	} {
+		t.Run(test.class, func(t *testing.T) {
+			var attempts atomic.Int32
+			s := test.server(&attempts)
+			defer s.Close()
+
+			opts := []Option{}
+			for class, policy := range policies {
+				opts = append(opts, WithRetryPolicy(class, policy))
+			}
+			NewClient(s.URL, opts...).consumeAllPages(context.Background(), "h", "t1")
+			// TLS failures have no policy, so are not retried
+			if want := int32(policies[test.class].Attempts + 1); attempts.Load() != want {
+				t.Errorf("%v attempts, want %v", attempts.Load(), want)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1"}, [][][]string{{{"1"}}}))
+	var failures atomic.Int32
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if failures.Add(1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return true
+		}
+		return false
+	})
+
+	start := time.Now()
+	r, err := NewClient(s.URL, WithRetryPolicy(RetryClassServer, RetryPolicy{Attempts: 2, Backoff: 20 * time.Millisecond})).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil || r.TotalRecords() != 1 {
+		t.Fatalf("got %v records, %v, want the page once retried", r.TotalRecords(), err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("retried within %v, want the 20ms and 40ms backoffs", elapsed)
+	}
+}
+
+func TestRetriedRequestBody(t *testing.T) {
+	// The first attempt fails, and the second is redirected before being served
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1"}, [][][]string{{{"1"}}}))
+	var attempts atomic.Int32
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		switch attempts.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		case 2:
+			http.Redirect(w, r, "/moved", http.StatusTemporaryRedirect)
+			return true
+		}
+		return false
+	})
+
+	r, err := NewClient(s.URL, WithRetryPolicy(RetryClassServer, RetryPolicy{Attempts: 1})).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil || r.TotalRecords() != 1 {
+		t.Fatalf("got %v records, %v, want the page once retried", r.TotalRecords(), err)
+	}
+	received := s.received()
+	if len(received) != 3 {
+		t.Fatalf("%v requests, want the failure, the redirect and the page", len(received))
+	}
+	for i, req := range received {
+		if len(req.body) == 0 || string(req.body) != string(received[0].body) || req.Token != "t1" {
+			t.Errorf("request %v: body %q, want that of the first attempt, %q", i, req.body, received[0].body)
+		}
+	}
+	if received[2].path != "/moved" {
+		t.Errorf("served at %v, want the redirect followed", received[2].path)
+	}
+}
+
+func TestRetryPoliciesFlag(t *testing.T) {
+	r := retryPolicies{}
+	for _, s := range []string{"5xx=3:100ms", "network=2", "tls=0"} {
+		if err := r.Set(s); err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+	}
+	want := retryPolicies{RetryClassServer: {Attempts: 3, Backoff: 100 * time.Millisecond}, RetryClassNetwork: {Attempts: 2}, RetryClassTLS: {}}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("got %v, want %v", r, want)
+	}
+	// Shown in the same order on each run, for -explain
+	if got := r.String(); got != "5xx=3:100ms,network=2:0s,tls=0:0s" {
+		t.Errorf("got %q", got)
+	}
+	for _, s := range []string{"5xx", "dns=1", "5xx=-1", "5xx=1:soon"} {
+		if err := r.Set(s); err == nil {
+			t.Errorf("%q: parsed", s)
+		}
+	}
+}
+
+func TestMaxConcurrentRetries(t *testing.T) {
+	columns := testColumns("id")
+	pages := map[string][]byte{}
+	jobs := []Job{}
+	for i := range 6 {
+		token := fmt.Sprintf("j%v", i)
+		pages[token] = testPage("", columns, []string{token})
+		jobs = append(jobs, Job{Hash: "h", Token: token})
+	}
+
+	run := func(t *testing.T, opts ...Option) int {
+		s := newPageServer(t, pages)
+		// Each job's first two requests fail, with the retries tracked while in progress
+		var mu sync.Mutex
+		attempts := map[string]int{}
+		inFlight, maxInFlight := 0, 0
+		s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+			mu.Lock()
+			attempts[req.Token]++
+			attempt := attempts[req.Token]
+			if attempt > 1 {
+				inFlight++
+				maxInFlight = max(maxInFlight, inFlight)
+			}
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			if attempt > 1 {
+				inFlight--
+			}
+			mu.Unlock()
+			if attempt <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return true
+			}
+			return false
+		})
+
+		opts = append(opts, WithRetryPolicy(RetryClassServer, RetryPolicy{Attempts: 2}))
+		for _, r := range NewClient(s.URL, opts...).consumeJobs(context.Background(), jobs, len(jobs), JobErrorContinue, 0) {
+			if r.Err != nil {
+				t.Fatalf("job %v: %v", r.Job.Token, r.Err)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return maxInFlight
+	}
+
+	if n := run(t, WithMaxConcurrentRetries(1)); n != 1 {
+		t.Errorf("%v retries in progress at once, want them serialized", n)
+	}
+	if n := run(t, WithMaxConcurrentRetries(2)); n > 2 {
+		t.Errorf("%v retries in progress at once, want at most 2", n)
+	}
+	if n := run(t); n < 2 {
+		t.Errorf("%v retries in progress at once without a limit, want several", n)
+	}
+}
+
+func TestRetryOnEmpty(t *testing.T) {
+	columns := testColumns("id")
+	for _, test := range []struct {
+		name     string
+		spurious int
+		want     int
+		requests int
+	}{
+		{name: "spurious", spurious: 1, want: 2, requests: 3},
+		{name: "terminal", spurious: -1, want: 1, requests: 4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+			var mu sync.Mutex
+			empties := 0
+			s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				if req.Token != "t2" || (test.spurious >= 0 && empties >= test.spurious) {
+					return false
+				}
+				empties++
+				w.Write(testPage("", columns))
+				return true
+			})
+
+			result, err := NewClient(s.URL, WithRetryOnEmpty(2, time.Millisecond)).consumeAllPages(context.Background(), "h", "t1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := result.TotalRecords(); got != test.want {
+				t.Errorf("got %v records, want %v", got, test.want)
+			}
+			if got := len(s.tokens()); got != test.requests {
+				t.Errorf("made %v requests, want %v", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestRetryOnEmptyFirstPage(t *testing.T) {
+	s := newPageServer(t, map[string][]byte{"t1": testPage("", testColumns("id"))})
+	if _, err := NewClient(s.URL, WithRetryOnEmpty(2, time.Millisecond)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.tokens(); len(got) != 1 {
+		t.Errorf("requested %v, want an empty first page accepted as the end of data", got)
+	}
+}
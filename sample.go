@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// samplingSink is a RecordSink passing each record on to its underlying sink
+// with probability rate, using a seeded generator so that a single pagination
+// is sampled reproducibly
+type samplingSink struct {
+	mu      sync.Mutex
+	sink    RecordSink
+	rate    float64
+	rng     *rand.Rand
+	seen    int
+	sampled int
+}
+
+// newSamplingSink returns a samplingSink writing to sink
+func newSamplingSink(sink RecordSink, rate float64, seed int64) *samplingSink {
+	return &samplingSink{sink: sink, rate: rate, rng: rand.New(rand.NewSource(seed))}
+}
+
+// WriteRecords writes the sampled subset of the records to the underlying sink
+func (s *samplingSink) WriteRecords(columns []Column, records [][]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample := make([][]string, 0, int(float64(len(records))*s.rate)+1)
+	for _, record := range records {
+		if s.rng.Float64() < s.rate {
+			sample = append(sample, record)
+		}
+	}
+	s.seen += len(records)
+	s.sampled += len(sample)
+
+	if len(sample) == 0 {
+		return nil
+	}
+	return s.sink.WriteRecords(columns, sample)
+}
+
+// Close closes the underlying sink
+func (s *samplingSink) Close() error {
+	return s.sink.Close()
+}
+
+// counts returns the number of records seen, and the number of those sampled
+func (s *samplingSink) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen, s.sampled
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSamplingSink(t *testing.T) {
+	columns := testColumns("id")
+	records := [][]string{}
+	for i := range 1000 {
+		records = append(records, []string{fmt.Sprint(i)})
+	}
+	sample := func(rate float64, seed int64) [][]string {
+		m := &memorySink{}
+		s := newSamplingSink(m, rate, seed)
+		for i := 0; i < len(records); i += 100 {
+			if err := s.WriteRecords(columns, records[i:i+100]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if seen, sampled := s.counts(); seen != len(records) || sampled != len(m.records) {
+			t.Errorf("counted %v sampled of %v, wrote %v of %v", sampled, seen, len(m.records), len(records))
+		}
+		return m.records
+	}
+
+	first := sample(0.1, 42)
+	if again := sample(0.1, 42); !reflect.DeepEqual(first, again) {
+		t.Error("the same seed sampled differently")
+	}
+	if other := sample(0.1, 7); reflect.DeepEqual(first, other) {
+		t.Error("different seeds sampled the same records")
+	}
+	if len(first) < 50 || len(first) > 150 {
+		t.Errorf("sampled %v of %v at a rate of 0.1", len(first), len(records))
+	}
+	if got := sample(0, 42); len(got) != 0 {
+		t.Errorf("sampled %v at a rate of 0", len(got))
+	}
+	if got := sample(1, 42); !reflect.DeepEqual(got, records) {
+		t.Errorf("sampled %v at a rate of 1, want all", len(got))
+	}
+}
+
+func TestSampleRateFlag(t *testing.T) {
+	columns := testColumns("id")
+	records := [][][]string{}
+	tokens := []string{}
+	for page := range 5 {
+		tokens = append(tokens, fmt.Sprintf("t%v", page))
+		records = append(records, nil)
+		for i := range 20 {
+			records[page] = append(records[page], []string{fmt.Sprint(page*20 + i)})
+		}
+	}
+	s := newPageServer(t, chainPages(columns, tokens, records))
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "t0", "-output-format", "csv", "-output", "-", "-sample-rate", "0.25", "-sample-seed", "3"}
+
+	first, stderr, code := runMain(t, args...)
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if again, _, _ := runMain(t, args...); first != again {
+		t.Errorf("sampled\n%v\nthen\n%v", first, again)
+	}
+
+	sampled := len(strings.Split(strings.TrimSpace(first), "\n")) - 1
+	if sampled == 0 || sampled == 100 {
+		t.Errorf("sampled %v of 100", sampled)
+	}
+	if want := fmt.Sprintf("Sampled records: %v of 100", sampled); !strings.Contains(stderr, want) {
+		t.Errorf("summary %q, want %q", stderr, want)
+	}
+}
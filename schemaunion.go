@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// unionPage is a page of records held by a schemaUnionSink, with the position
+// in the union of the columns of each of its values and its number of records
+type unionPage struct {
+	positions map[int]int
+	count     int
+}
+
+// schemaUnionSink is a RecordSink for pages whose columns may change, such as
+// when a server adds columns part way through a pagination.  As the union of
+// the columns is known only once every page is seen, the records are held in
+// memory, within the memory limit, until Close, which writes them to the sink
+// with the union of the columns of all pages.  The union is ordered by first
+// appearance, and the values of columns missing from a page are null, making
+// those columns nullable.  A column whose type changes between pages is an error
+type schemaUnionSink struct {
+	sink    RecordSink
+	mu      sync.Mutex
+	columns []Column
+	names   map[string]int
+	pages   []unionPage
+	records *recordBuffer
+}
+
+// newSchemaUnionSink returns a schemaUnionSink writing to sink
+func newSchemaUnionSink(sink RecordSink, limit memoryLimit) *schemaUnionSink {
+	return &schemaUnionSink{sink: sink, names: map[string]int{}, records: newRecordBuffer(limit)}
+}
+
+// WriteRecords adds the columns to the union, and holds the records
+func (s *schemaUnionSink) WriteRecords(columns []Column, records [][]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page := unionPage{positions: map[int]int{}, count: len(records)}
+	for _, col := range columnsByPosition(columns) {
+		i, ok := s.names[col.Name]
+		if !ok {
+			i = len(s.columns)
+			s.names[col.Name] = i
+			union := col
+			union.Position = i
+			s.columns = append(s.columns, union)
+		} else if s.columns[i].Type != col.Type {
+			return fmt.Errorf("schema evolution: column %v changes type from %v to %v", col.Name, s.columns[i].Type, col.Type)
+		} else if col.Nullable == nil || *col.Nullable {
+			s.columns[i].Nullable = col.Nullable
+		}
+		page.positions[col.Position] = i
+	}
+	if err := s.records.add(records); err != nil {
+		return fmt.Errorf("schema evolution: %w", err)
+	}
+	s.pages = append(s.pages, page)
+	return nil
+}
+
+// Close writes the records held with the union of the columns, and closes the
+// sink, returning the first error
+func (s *schemaUnionSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A column missing from any page holds nulls
+	nullable := true
+	for _, page := range s.pages {
+		present := map[int]bool{}
+		for _, i := range page.positions {
+			present[i] = true
+		}
+		for i := range s.columns {
+			if !present[i] {
+				s.columns[i].Nullable = &nullable
+			}
+		}
+	}
+
+	// The records of each page are written together, in batches of at most
+	// bufferedBatchSize records
+	pages := s.pages
+	written := 0
+	batch := [][]string{}
+	err := s.records.each(func(record []string) error {
+		for written == pages[0].count {
+			pages, written = pages[1:], 0
+		}
+		union := make([]string, len(s.columns))
+		for pos, value := range record {
+			if i, ok := pages[0].positions[pos]; ok {
+				union[i] = value
+			}
+		}
+		batch = append(batch, union)
+		if written++; written == pages[0].count || len(batch) == bufferedBatchSize {
+			err := s.sink.WriteRecords(s.columns, batch)
+			batch = [][]string{}
+			return err
+		}
+		return nil
+	})
+	if err == nil && len(s.pages) > 0 && s.records.len() == 0 {
+		err = s.sink.WriteRecords(s.columns, [][]string{})
+	}
+	s.pages = nil
+	if rerr := s.records.release(); err == nil {
+		err = rerr
+	}
+
+	if cerr := s.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
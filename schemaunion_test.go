@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaUnionSink(t *testing.T) {
+	notNullable := false
+	first := []Column{{Name: "id", Type: ColumnTypeInt, Position: 0, Nullable: &notNullable}, {Name: "name", Type: ColumnTypeString, Position: 1, Nullable: &notNullable}}
+	// The second page adds a column in the middle of the header, and its
+	// columns are not in position order
+	second := []Column{{Name: "name", Type: ColumnTypeString, Position: 2, Nullable: &notNullable}, {Name: "id", Type: ColumnTypeInt, Position: 0, Nullable: &notNullable},
+		{Name: "email", Type: ColumnTypeString, Position: 1, Nullable: &notNullable}}
+	s := newPageServer(t, map[string][]byte{
+		"t1": testPage("t2", first, []string{"1", "a"}, []string{"2", "b"}),
+		"t2": testPage("", second, []string{"3", "c@x", "c"}),
+	})
+
+	mem := &memorySink{}
+	sink := newSchemaUnionSink(mem, memoryLimit{})
+	if _, err := NewClient(s.URL, WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if mem.writes != 0 {
+		t.Errorf("%v writes before closing, want the records held until the union is known", mem.writes)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A column missing from a page is nullable
+	nullable := true
+	wantColumns := []Column{{Name: "id", Type: ColumnTypeInt, Position: 0, Nullable: &notNullable}, {Name: "name", Type: ColumnTypeString, Position: 1, Nullable: &notNullable},
+		{Name: "email", Type: ColumnTypeString, Position: 2, Nullable: &nullable}}
+	if !reflect.DeepEqual(mem.columns, wantColumns) {
+		t.Errorf("got columns %+v, want %+v", mem.columns, wantColumns)
+	}
+	if want := [][]string{{"1", "a", ""}, {"2", "b", ""}, {"3", "c", "c@x"}}; !reflect.DeepEqual(mem.records, want) {
+		t.Errorf("wrote %v, want %v", mem.records, want)
+	}
+	if mem.writes != 2 || !mem.closed {
+		t.Errorf("%v writes, closed %v, want a write for each page and the sink closed", mem.writes, mem.closed)
+	}
+}
+
+func TestSchemaUnionSinkErrors(t *testing.T) {
+	columns := testColumns("id")
+	retyped := []Column{{Name: "id", Type: ColumnTypeInt, Position: 0}}
+	sink := newSchemaUnionSink(&memorySink{}, memoryLimit{})
+	if err := sink.WriteRecords(columns, [][]string{{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteRecords(retyped, [][]string{{"2"}}); err == nil || err.Error() != "schema evolution: column id changes type from string to int" {
+		t.Errorf("got %v, want the type change rejected", err)
+	}
+
+	sink = newSchemaUnionSink(&memorySink{}, memoryLimit{records: 1, policy: MemoryLimitError})
+	if err := sink.WriteRecords(columns, [][]string{{"1"}, {"2"}}); err == nil || !strings.HasPrefix(err.Error(), "schema evolution: buffered records exceed") {
+		t.Errorf("got %v, want the memory limit exceeded", err)
+	}
+}
+
+func TestAllowSchemaEvolutionFlag(t *testing.T) {
+	s := newPageServer(t, map[string][]byte{
+		"t1": testPage("t2", testColumns("id"), []string{"1"}),
+		"t2": testPage("", testColumns("id", "name"), []string{"2", "b"}),
+	})
+	path := filepath.Join(t.TempDir(), "out.csv")
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-output-format", "csv", "-output", path, "-allow-schema-evolution"); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "id,name\n1,\n2,b\n" {
+		t.Errorf("file %q, want the records under the union of the columns", b)
+	}
+}
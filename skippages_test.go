@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSkipPages(t *testing.T) {
+	for _, test := range []struct {
+		skip  int
+		first int
+	}{
+		{skip: 0, first: 0},
+		{skip: 2, first: 4},
+		{skip: 5, first: 10},
+		{skip: 9, first: 10},
+	} {
+		t.Run(fmt.Sprint(test.skip), func(t *testing.T) {
+			s := newPageServer(t, numberedPages(5, 2))
+			sink := &memorySink{}
+			r, err := NewClient(s.URL, WithSkipPages(test.skip), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := [][]string{}
+			for i := test.first; i < 10; i++ {
+				want = append(want, []string{fmt.Sprint(i)})
+			}
+			if len(sink.records) != len(want) || (len(want) > 0 && !reflect.DeepEqual(sink.records, want)) {
+				t.Errorf("wrote %v, want %v", sink.records, want)
+			}
+			// The skipped pages are still retrieved to follow their tokens
+			if r.PageCount != 5 || len(s.received()) != 5 {
+				t.Errorf("got %v pages from %v requests, want each page retrieved", r.PageCount, len(s.received()))
+			}
+		})
+	}
+}
+
+func TestSkipPagesFlag(t *testing.T) {
+	s := newPageServer(t, numberedPages(4, 2))
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-skip-pages", "1", "-output-format", "csv", "-records-only")
+	if code != 0 || stdout != "id\n2\n3\n4\n5\n6\n7\n" {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	// -head counts the records after those skipped
+	if stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-skip-pages", "2", "-head", "3"); code != 0 || stdout != "4\n5\n6\n" {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	for _, args := range [][]string{{"-skip-pages", "-1"}, {"-skip-pages", "1", "-ndjson-stream"}} {
+		if _, _, code := runMain(t, append([]string{"-url", s.URL, "-hash", "h", "-token", "t0"}, args...)...); code == 0 {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// slowPageServer returns a pageServer of a chain of n pages, t0 to t(n-1), each
+// taking fast to answer except the page for slowToken, which takes slow
+func slowPageServer(t *testing.T, n int, slowToken string, fast, slow time.Duration) *pageServer {
+	t.Helper()
+	tokens := []string{}
+	records := [][][]string{}
+	for i := range n {
+		tokens = append(tokens, fmt.Sprintf("t%v", i))
+		records = append(records, [][]string{{fmt.Sprint(i)}})
+	}
+	s := newPageServer(t, chainPages(testColumns("id"), tokens, records))
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == slowToken {
+			select {
+			case <-time.After(slow):
+			case <-r.Context().Done():
+			}
+		} else {
+			time.Sleep(fast)
+		}
+		return false
+	})
+	return s
+}
+
+func TestSlowPageFactor(t *testing.T) {
+	s := slowPageServer(t, 10, "t7", 5*time.Millisecond, 2*time.Second)
+
+	start := time.Now()
+	_, err := NewClient(s.URL, WithSlowPageFactor(5), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t0")
+	var slow *slowPageError
+	if !errors.As(err, &slow) || slow.token != "t7" {
+		t.Fatalf("got %v, want a slowPageError of t7", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("abandoned after %v, want well before the slow page completed", elapsed)
+	}
+	if got := s.tokens(); len(got) != 8 {
+		t.Errorf("requested %v, want the slow page to end the pagination", got)
+	}
+}
+
+func TestSlowPageFactorMinSamples(t *testing.T) {
+	// The slow page precedes slowPageMinSamples pages, so completes
+	s := slowPageServer(t, slowPageMinSamples+2, "t1", 2*time.Millisecond, 100*time.Millisecond)
+	r, err := NewClient(s.URL, WithSlowPageFactor(2)).consumeAllPages(context.Background(), "h", "t0")
+	if err != nil || r.PageCount != slowPageMinSamples+2 {
+		t.Errorf("got %v pages, %v, want all %v", r.PageCount, err, slowPageMinSamples+2)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StatusTally counts responses by HTTP status class ("2xx", "3xx", "4xx", "5xx")
+type StatusTally map[string]int
+
+// record counts a response with the status code.  Recording to a nil tally is a no-op
+func (t StatusTally) record(code int) {
+	if t != nil {
+		t[fmt.Sprintf("%dxx", code/100)]++
+	}
+}
+
+// add includes the counts of other in the tally
+func (t StatusTally) add(other StatusTally) {
+	for class, n := range other {
+		t[class] += n
+	}
+}
+
+// String lists the counts in status class order, e.g. "2xx: 10, 5xx: 2"
+func (t StatusTally) String() string {
+	classes := make([]string, 0, len(t))
+	for class := range t {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%v: %v", class, t[class])
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
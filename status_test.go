@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestStatusTally(t *testing.T) {
+	tally := StatusTally{}
+	for _, code := range []int{200, 204, 304, 404, 503, 500, 200} {
+		tally.record(code)
+	}
+	if got, want := tally.String(), "2xx: 3, 3xx: 1, 4xx: 1, 5xx: 2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	tally.add(StatusTally{"2xx": 1, "1xx": 1})
+	if got, want := tally.String(), "1xx: 1, 2xx: 4, 3xx: 1, 4xx: 1, 5xx: 2"; got != want {
+		t.Errorf("after add got %q, want %q", got, want)
+	}
+	if got := (StatusTally{}).String(); got != "none" {
+		t.Errorf("empty tally %q", got)
+	}
+	StatusTally(nil).record(200)
+}
+
+func TestStatusTallyOfRun(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}}))
+	// t2 fails with a 503 then a 429 before succeeding
+	var mu sync.Mutex
+	failures := map[string][]int{"t2": {http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if codes := failures[req.Token]; len(codes) > 0 {
+			failures[req.Token] = codes[1:]
+			w.WriteHeader(codes[0])
+			return true
+		}
+		return false
+	})
+
+	c := NewClient(s.URL, WithRetryPolicy(RetryClassServer, RetryPolicy{Attempts: 1}), WithRetryPolicy(RetryClassRateLimited, RetryPolicy{Attempts: 1}))
+	r, err := c.consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (StatusTally{"2xx": 2, "4xx": 1, "5xx": 1}); !reflect.DeepEqual(r.StatusCounts, want) {
+		t.Errorf("got %v, want %v", r.StatusCounts, want)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// States of a run given by its status file
+const (
+	RunStateRunning = "running"
+	RunStateDone    = "done"
+	RunStateError   = "error"
+)
+
+// RunStatus is the snapshot of the progress of a run written to its status
+// file.  Rate is the mean records per second since the run started, and the
+// last token is that of the page most recently retrieved, redacted as in logs
+type RunStatus struct {
+	State     string    `json:"state"`
+	Pages     int64     `json:"pages"`
+	Records   int64     `json:"records"`
+	Rate      float64   `json:"rate"`
+	Elapsed   float64   `json:"elapsed_seconds"`
+	LastToken string    `json:"last_token,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// statusFile rewrites a file with the RunStatus of the run, replacing it
+// atomically so that a watcher polling the file always reads a whole snapshot.
+// Once a terminal state is written the file is no longer updated
+type statusFile struct {
+	mu       sync.Mutex
+	path     string
+	prog     *progress
+	redact   TokenRedactor
+	terminal bool
+}
+
+// write writes the status of the run in the state, with the error of a failed run
+func (s *statusFile) write(state string, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.terminal {
+		return nil
+	}
+	now := time.Now()
+	elapsed := now.Sub(s.prog.started)
+	st := RunStatus{
+		State:   state,
+		Pages:   s.prog.pages.Load(),
+		Records: s.prog.records.Load(),
+		Elapsed: elapsed.Seconds(),
+		Time:    now.UTC(),
+	}
+	if elapsed > 0 {
+		st.Rate = float64(st.Records) / elapsed.Seconds()
+	}
+	if last := s.prog.lastToken(); len(last) > 0 {
+		st.LastToken = s.redact(last)
+	}
+	if err != nil {
+		st.Error = err.Error()
+	}
+	s.terminal = state != RunStateRunning
+	return writeJSONFile(s.path, st)
+}
+
+// run writes the running status every interval, until ctx ends
+func (s *statusFile) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.write(RunStateRunning, nil); err != nil {
+				log.Printf("Unable to write status file: %v", err)
+			}
+		}
+	}
+}
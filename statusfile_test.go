@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readStatus returns the RunStatus of the status file at path
+func readStatus(t *testing.T, path string) RunStatus {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st RunStatus
+	if err := json.Unmarshal(b, &st); err != nil {
+		t.Fatalf("%v: %q", err, b)
+	}
+	return st
+}
+
+func TestStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	prog := newProgress()
+	prog.started = time.Now().Add(-2 * time.Second)
+	s := &statusFile{path: path, prog: prog, redact: func(token string) string { return "redacted-" + token }}
+
+	prog.page(context.Background(), PageEvent{Hash: "h", Token: "t1", Records: 10})
+	prog.page(context.Background(), PageEvent{Hash: "h", Token: "t2", Records: 6})
+	if err := s.write(RunStateRunning, nil); err != nil {
+		t.Fatal(err)
+	}
+	st := readStatus(t, path)
+	if st.State != RunStateRunning || st.Pages != 2 || st.Records != 16 || st.LastToken != "redacted-t2" || st.Error != "" {
+		t.Errorf("got %+v", st)
+	}
+	if st.Elapsed < 2 || st.Rate <= 0 || st.Rate > 8 || time.Since(st.Time) > time.Minute {
+		t.Errorf("elapsed %v, rate %v, time %v", st.Elapsed, st.Rate, st.Time)
+	}
+
+	// The terminal state is final
+	if err := s.write(RunStateError, errors.New("page 3: status 500")); err != nil {
+		t.Fatal(err)
+	}
+	prog.page(context.Background(), PageEvent{Hash: "h", Token: "t3", Records: 1})
+	if err := s.write(RunStateRunning, nil); err != nil {
+		t.Fatal(err)
+	}
+	if st := readStatus(t, path); st.State != RunStateError || st.Error != "page 3: status 500" || st.Pages != 2 {
+		t.Errorf("got %+v, want the error state kept", st)
+	}
+	// The file is replaced whole, leaving no temporary files
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("%v files, want only the status file", len(entries))
+	}
+}
+
+func TestStatusFileRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	prog := newProgress()
+	s := &statusFile{path: path, prog: prog, redact: RawToken}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.run(ctx, 5*time.Millisecond)
+
+	prog.page(ctx, PageEvent{Hash: "h", Token: "t1", Records: 3})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			if st := readStatus(t, path); st.Pages == 1 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("status file not updated")
+		}
+	}
+}
+
+func TestStatusFileFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	s := newPageServer(t, numberedPages(3, 2))
+	// The status during the run is read whilst the last page is in progress
+	var during RunStatus
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "t2" {
+			time.Sleep(100 * time.Millisecond)
+			during = readStatus(t, path)
+		}
+		return false
+	})
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-status-file", path, "-status-interval", "10ms"); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if during.State != RunStateRunning || during.Pages != 2 || during.Records != 4 || during.LastToken == "" || during.LastToken == "t1" {
+		t.Errorf("during the run got %+v, want two pages and the last token redacted", during)
+	}
+	if st := readStatus(t, path); st.State != RunStateDone || st.Pages != 3 || st.Records != 6 {
+		t.Errorf("got %+v, want the run done", st)
+	}
+
+	s = newPageServer(t, nil)
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-status-file", path); code == 0 {
+		t.Fatal("run succeeded")
+	}
+	if st := readStatus(t, path); st.State != RunStateError || st.Error == "" {
+		t.Errorf("got %+v, want the failed run's error", st)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+)
+
+// stopFilePollInterval is how often the existence of a stop file is checked
+const stopFilePollInterval = time.Second
+
+// StopGate stops paginations cleanly between pages once stopped.  Unlike
+// cancelling their context, the pages in progress complete and their records
+// are written before the paginations end.  It is safe for concurrent use, and
+// stops all paginations of the Clients it is given to
+type StopGate struct {
+	stopped atomic.Bool
+}
+
+// NewStopGate returns a StopGate that is not stopped
+func NewStopGate() *StopGate {
+	return &StopGate{}
+}
+
+// Stop ends paginations once their current page completes, and prevents
+// further paginations from starting
+func (g *StopGate) Stop() {
+	g.stopped.Store(true)
+}
+
+// Stopped reports whether the gate is stopped
+func (g *StopGate) Stopped() bool {
+	return g.stopped.Load()
+}
+
+// watchStop stops the gate on receipt of any of the signals, or once a file
+// exists at path if set, which is checked every interval.  It returns once the
+// gate is stopped or ctx ends
+func watchStop(ctx context.Context, gate *StopGate, path string, interval time.Duration, signals ...os.Signal) {
+	received := make(chan os.Signal, 1)
+	if len(signals) > 0 {
+		signal.Notify(received, signals...)
+		defer signal.Stop(received)
+	}
+
+	var poll <-chan time.Time
+	if len(path) > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-received:
+			log.Printf("Received %v, stopping once the pages in progress complete", sig)
+			gate.Stop()
+			return
+		case <-poll:
+			if _, err := os.Stat(path); err == nil {
+				log.Printf("Found stop file %v, stopping once the pages in progress complete", path)
+				gate.Stop()
+				return
+			}
+		}
+	}
+}
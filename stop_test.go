@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStopGate(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{{{"1"}}, {{"2"}}, {{"3"}}}))
+	gate := NewStopGate()
+	// The stop arrives while the second page is in progress
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "t2" {
+			gate.Stop()
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	})
+
+	sink := &memorySink{}
+	r, err := NewClient(s.URL, WithStopGate(gate), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.PageCount != 2 || !r.TimeLimited {
+		t.Errorf("got %v pages, stopped early %v, want the page in progress completed", r.PageCount, r.TimeLimited)
+	}
+	if got, want := sink.records, [][]string{{"1"}, {"2"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrote %v, want %v", got, want)
+	}
+	if got := s.tokens(); !reflect.DeepEqual(got, []string{"t1", "t2"}) {
+		t.Errorf("requested %v, want no page after the stop", got)
+	}
+}
+
+func TestWatchStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stop")
+	gate := NewStopGate()
+	done := make(chan struct{})
+	go func() {
+		watchStop(context.Background(), gate, path, 5*time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if gate.Stopped() {
+		t.Fatal("stopped before the stop file exists")
+	}
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stop file not found")
+	}
+	if !gate.Stopped() {
+		t.Error("gate not stopped")
+	}
+}
+
+func TestStopFileFlag(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{{{"1"}}, {{"2"}}, {{"3"}}}))
+	dir := t.TempDir()
+	stopPath, output := filepath.Join(dir, "stop"), filepath.Join(dir, "out.csv")
+	// The stop file appears while the second page is in progress, which outlasts
+	// the poll of the file
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "t2" {
+			if err := os.WriteFile(stopPath, nil, 0o644); err != nil {
+				t.Error(err)
+			}
+			time.Sleep(stopFilePollInterval + 200*time.Millisecond)
+		}
+		return false
+	})
+
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-stop-file", stopPath, "-output-format", "csv", "-output", output)
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if b, _ := os.ReadFile(output); string(b) != "id\n1\n2\n" {
+		t.Errorf("file %q, want the records of the pages up to the stop", b)
+	}
+	if got := s.tokens(); !reflect.DeepEqual(got, []string{"t1", "t2"}) {
+		t.Errorf("requested %v, want no page after the stop", got)
+	}
+	if !strings.Contains(stderr, "Found stop file") || !strings.Contains(stdout+stderr, "Stopped early: stop requested") {
+		t.Errorf("stdout %q, stderr %q, want the stop logged and reported", stdout, stderr)
+	}
+}
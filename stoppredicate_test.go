@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// descendingPages returns pages t1 to t4 of an updated column in descending
+// order, two records each, whose first page declares the total of 8 records
+func descendingPages() map[string][]byte {
+	columns := []Column{{Name: "id", Type: ColumnTypeInt, Position: 0}, {Name: "updated", Type: ColumnTypeString, Position: 1}}
+	pages := chainPages(columns, []string{"t1", "t2", "t3", "t4"}, [][][]string{
+		{{"8", "2024-04-02"}, {"7", "2024-04-01"}},
+		{{"6", "2024-03-15"}, {"5", "2024-02-28"}},
+		{{"4", "2024-02-01"}, {"3", "2024-01-20"}},
+		{{"2", "2024-01-10"}, {"1", "2024-01-01"}},
+	})
+	var rs ResultSet
+	json.Unmarshal(pages["t1"], &rs)
+	total := 8
+	rs.Meta.Total = &total
+	pages["t1"], _ = json.Marshal(rs)
+	return pages
+}
+
+func TestStopPredicate(t *testing.T) {
+	s := newPageServer(t, descendingPages())
+	// The first record before March is the first of the second page
+	before := func(columns []Column, record []string) bool { return record[1] < "2024-03-01" }
+	for _, test := range []struct {
+		name    string
+		stop    func([]Column, []string) bool
+		tokens  []string
+		records int
+	}{
+		{name: "match", stop: before, tokens: []string{"t1", "t2"}, records: 4},
+		{name: "first record", stop: func([]Column, []string) bool { return true }, tokens: []string{"t1"}, records: 2},
+		{name: "no match", stop: func([]Column, []string) bool { return false }, tokens: []string{"t1", "t2", "t3", "t4"}, records: 8},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			requests := len(s.received())
+			sink := &memorySink{}
+			// A pagination ended by the predicate is not checked against the server's total
+			r, err := NewClient(s.URL, WithStopPredicate(test.stop), WithRecordSink(sink), WithTotalMismatchPolicy(TotalMismatchError)).consumeAllPages(context.Background(), "h", "t1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens := []string{}
+			for _, req := range s.received()[requests:] {
+				tokens = append(tokens, req.Token)
+			}
+			if !reflect.DeepEqual(tokens, test.tokens) || r.TotalRecords() != test.records || len(sink.records) != test.records {
+				t.Errorf("requested %v, got %v records, wrote %v, want %v of %v records", tokens, r.TotalRecords(), len(sink.records), test.tokens, test.records)
+			}
+		})
+	}
+}
+
+func TestStopPredicateAfterFilter(t *testing.T) {
+	// The predicate sees the records as output, so filtered records cannot match
+	s := newPageServer(t, descendingPages())
+	e, err := CompileRecordExpr("id % 2 == 0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &memorySink{}
+	stop := func(columns []Column, record []string) bool { return record[0] == "5" || record[0] == "4" }
+	if _, err := NewClient(s.URL, WithRecordExpr(e), WithStopPredicate(stop), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"8", "2024-04-02"}, {"6", "2024-03-15"}, {"4", "2024-02-01"}}; !reflect.DeepEqual(sink.records, want) {
+		t.Errorf("wrote %v, want %v", sink.records, want)
+	}
+}
+
+func TestRecordExprMatches(t *testing.T) {
+	e, err := CompileRecordExpr(`id > 2`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		record []string
+		want   bool
+		err    string
+	}{
+		{record: []string{"3", "", "", ""}, want: true},
+		{record: []string{"2", "", "", ""}},
+		{record: []string{"x", "", "", ""}, err: `expr: column id: "x" is not a valid int`},
+	} {
+		got, err := e.matches(exprColumns, test.record)
+		if got != test.want || (err == nil) != (test.err == "") || (err != nil && err.Error() != test.err) {
+			t.Errorf("%v: got %v, %v, want %v, %q", test.record, got, err, test.want, test.err)
+		}
+	}
+
+	// An expression that is not boolean never matches
+	e, err = CompileRecordExpr(`id + 1`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := e.matches(exprColumns, []string{"3", "", "", ""}); got || err == nil || !strings.HasPrefix(err.Error(), "expr: invalid operation") {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if e.stopPredicate()(exprColumns, []string{"3", "", "", ""}) {
+		t.Error("stop predicate matched")
+	}
+}
+
+func TestStopWhenFlag(t *testing.T) {
+	s := newPageServer(t, descendingPages())
+	path := filepath.Join(t.TempDir(), "out.csv")
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-stop-when", `updated < "2024-03-01"`, "-output-format", "csv", "-output", path, "-on-total-mismatch", "error"); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "id,updated\n8,2024-04-02\n7,2024-04-01\n6,2024-03-15\n5,2024-02-28\n" {
+		t.Errorf("file %q, want the pages up to and including the match", b)
+	}
+	if got := s.tokens(); !reflect.DeepEqual(got, []string{"t1", "t2"}) {
+		t.Errorf("requested %v, want no page after the match", got)
+	}
+
+	for _, args := range [][]string{{"-stop-when", "id +"}, {"-stop-when", "id > 1", "-ndjson-stream"}} {
+		if stdout, _, code := runMain(t, append([]string{"-url", s.URL, "-hash", "h", "-token", "t1"}, args...)...); code == 0 {
+			t.Errorf("%v: accepted, stdout %q", args, stdout)
+		}
+	}
+	// An expression failing for the records is logged, and never stops the run
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-stop-when", "id + 1", "-output-format", "csv", "-output", path)
+	if code != 0 || strings.Count(stderr, `Unable to evaluate stop expression "id + 1", taken as false: expr: invalid operation`) != 1 {
+		t.Errorf("exit %v, stderr %q, want the failure logged once", code, stderr)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+)
+
+// chanSink is a RecordSink sending each record to a channel, until ctx ends
+type chanSink struct {
+	ctx context.Context
+	ch  chan<- []string
+}
+
+// WriteRecords sends the records to the channel in order
+func (s chanSink) WriteRecords(columns []Column, records [][]string) error {
+	for _, record := range records {
+		select {
+		case s.ch <- record:
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (chanSink) Close() error {
+	return nil
+}
+
+// RecordsChan paginates the result set of (hash, firstToken), sending each
+// record to the returned records channel as it is retrieved.  The records
+// channel is closed once pagination ends, after which any error is delivered
+// on the error channel, which is then closed.  Cancelling ctx stops pagination.
+// Records are sent instead of to any sink set with WithRecordSink
+func (c *Client) RecordsChan(ctx context.Context, hash, firstToken string) (<-chan []string, <-chan error) {
+	records := make(chan []string)
+	errs := make(chan error, 1)
+
+	cc := *c
+	cc.sink = chanSink{ctx: ctx, ch: records}
+
+	go func() {
+		defer close(errs)
+		_, err := cc.consumeAllPages(ctx, hash, firstToken)
+		close(records)
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return records, errs
+}
+
+// printHead writes the first n records of the result set of (hash, firstToken)
+// to w as CSV rows, without a header, cancelling the pagination once they are
+// received so that only the pages holding them are retrieved
+func (c *Client) printHead(ctx context.Context, w io.Writer, hash, firstToken string, n int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cw := csv.NewWriter(w)
+	written := 0
+	records, errs := c.RecordsChan(ctx, hash, firstToken)
+	for record := range records {
+		if written == n {
+			continue
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+		if written++; written == n {
+			cancel()
+		}
+	}
+	cw.Flush()
+
+	if err := <-errs; err != nil && !(written == n && errors.Is(err, context.Canceled)) {
+		return err
+	}
+	return cw.Error()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// numberedPages returns a chain of n pages, t0 to t(n-1), of perPage records
+// numbered from 0
+func numberedPages(n, perPage int) map[string][]byte {
+	tokens := []string{}
+	records := [][][]string{}
+	for page := range n {
+		tokens = append(tokens, fmt.Sprintf("t%v", page))
+		records = append(records, [][]string{})
+		for i := range perPage {
+			records[page] = append(records[page], []string{fmt.Sprint(page*perPage + i)})
+		}
+	}
+	return chainPages(testColumns("id"), tokens, records)
+}
+
+func TestRecordsChan(t *testing.T) {
+	s := newPageServer(t, numberedPages(3, 2))
+	sink := &memorySink{}
+
+	records, errs := NewClient(s.URL, WithRecordSink(sink)).RecordsChan(context.Background(), "h", "t0")
+	got := [][]string{}
+	for record := range records {
+		got = append(got, record)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"0"}, {"1"}, {"2"}, {"3"}, {"4"}, {"5"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if len(sink.records) > 0 {
+		t.Errorf("records also written to the client's sink: %v", sink.records)
+	}
+}
+
+func TestRecordsChanCancelled(t *testing.T) {
+	s := newPageServer(t, numberedPages(10, 2))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	records, errs := NewClient(s.URL).RecordsChan(ctx, "h", "t0")
+	received := 0
+	for range records {
+		if received++; received == 3 {
+			cancel()
+		}
+	}
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want the cancellation", err)
+	}
+	if _, open := <-errs; open {
+		t.Error("error channel not closed")
+	}
+	if n := len(s.received()); n >= 10 {
+		t.Errorf("requested all %v pages after cancelling", n)
+	}
+}
+
+func TestRecordsChanError(t *testing.T) {
+	pages := numberedPages(2, 1)
+	delete(pages, "t1")
+	s := newPageServer(t, pages)
+
+	records, errs := NewClient(s.URL).RecordsChan(context.Background(), "h", "t0")
+	got := [][]string{}
+	for record := range records {
+		got = append(got, record)
+	}
+	if err := <-errs; err == nil {
+		t.Error("missing page not reported")
+	}
+	if !reflect.DeepEqual(got, [][]string{{"0"}}) {
+		t.Errorf("received %v, want the records before the error", got)
+	}
+}
+
+func TestPrintHead(t *testing.T) {
+	s := newPageServer(t, numberedPages(10, 2))
+	var buf bytes.Buffer
+	if err := NewClient(s.URL).printHead(context.Background(), &buf, "h", "t0", 3); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "0\n1\n2\n" {
+		t.Errorf("printed %q", buf.String())
+	}
+	if n := len(s.received()); n > 3 {
+		t.Errorf("requested %v pages for 3 records of 2 per page", n)
+	}
+
+	// A result set with fewer records is printed in full
+	buf.Reset()
+	if err := NewClient(s.URL).printHead(context.Background(), &buf, "h", "t8", 10); err != nil || buf.String() != "16\n17\n18\n19\n" {
+		t.Errorf("printed %q, %v", buf.String(), err)
+	}
+}
+
+func TestHeadFlag(t *testing.T) {
+	s := newPageServer(t, numberedPages(10, 2))
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-head", "4")
+	if code != 0 || stdout != "0\n1\n2\n3\n" {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-head", "4", "-preview"); code == 0 {
+		t.Error("-head accepted with -preview")
+	}
+}
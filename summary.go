@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"time"
+)
+
+// summaryHeader is the header row of a -summary-csv file
+var summaryHeader = []string{"timestamp", "hash", "first_token", "pages", "records", "request_duration", "unmarshal_duration", "elapsed", "error"}
+
+// appendSummaryCSV appends a row of the stats of each result to the CSV file at
+// path, writing the header row first if the file is new or empty.  started and
+// elapsed are the start time and wall clock duration of the run
+func appendSummaryCSV(path string, started time.Time, elapsed time.Duration, results []JobResult) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		w.Write(summaryHeader)
+	}
+	for _, r := range results {
+		errText := ""
+		if r.Err != nil {
+			errText = r.Err.Error()
+		}
+		w.Write([]string{
+			started.UTC().Format(time.RFC3339),
+			r.Job.Hash,
+			r.Job.Token,
+			fmt.Sprint(r.PageCount),
+			fmt.Sprint(r.TotalRecords()),
+			r.RequestDuration.String(),
+			r.UnmarshalDuration.String(),
+			elapsed.String(),
+			errText,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
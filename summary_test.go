@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSummaryCSV(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}, {"2"}}, {{"3"}}}))
+	path := filepath.Join(t.TempDir(), "summary.csv")
+
+	for range 2 {
+		if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-show-tokens", "-summary-csv", path); code != 0 {
+			t.Fatalf("exit %v, stderr %q", code, stderr)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %v rows, want a header and two runs: %v", len(rows), rows)
+	}
+	if !slices.Equal(rows[0], summaryHeader) {
+		t.Errorf("header %v", rows[0])
+	}
+	for _, row := range rows[1:] {
+		if row[1] != "h" || row[2] != "t1" || row[3] != "2" || row[4] != "3" || row[8] != "" {
+			t.Errorf("row %v, want 2 pages and 3 records of h/t1 without an error", row)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// throttleIncreaseAfter is the number of consecutive requests that are not rate
+// limited after which an adaptiveThrottle increases its rate
+const throttleIncreaseAfter = 10
+
+// throttleReduceInterval is the minimum time between reductions of the rate of an adaptiveThrottle
+const throttleReduceInterval = time.Second
+
+// adaptiveThrottle spaces the page requests of all paginations using a client to
+// a rate that adapts to the server, AIMD style: a 429 response halves the
+// rate, whilst each run of throttleIncreaseAfter other responses adds the
+// minimum rate back, within the minimum and maximum rates
+type adaptiveThrottle struct {
+	mu        sync.Mutex
+	rate      float64
+	min       float64
+	max       float64
+	next      time.Time
+	successes int
+	reduced   time.Time
+	clock     Clock
+}
+
+// newAdaptiveThrottle returns an adaptiveThrottle starting at the initial rate,
+// in requests per second
+func newAdaptiveThrottle(initial, minRate, maxRate float64) *adaptiveThrottle {
+	return &adaptiveThrottle{rate: initial, min: minRate, max: maxRate, clock: realClock{}}
+}
+
+// wait reserves the next request slot at the current rate and waits for it,
+// returning early with the error of ctx if it ends first
+func (t *adaptiveThrottle) wait(ctx context.Context) error {
+	t.mu.Lock()
+	now := t.clock.Now()
+	slot := t.next
+	if slot.Before(now) {
+		slot = now
+	}
+	t.next = slot.Add(time.Duration(float64(time.Second) / t.rate))
+	t.mu.Unlock()
+
+	return sleepCtx(ctx, t.clock, slot.Sub(now))
+}
+
+// record adapts the rate to a response, which was rate limited if limited is
+// set.  The rate is reduced at most once per throttleReduceInterval, as the
+// responses to requests already sent, and the server's own window, lag behind
+// a reduction
+func (t *adaptiveThrottle) record(limited bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if limited {
+		t.successes = 0
+		now := t.clock.Now()
+		if now.Sub(t.reduced) < throttleReduceInterval {
+			return
+		}
+		t.reduced = now
+		if rate := max(t.min, t.rate/2); rate < t.rate {
+			t.rate = rate
+			log.Printf("Rate limited, reducing request rate to %.2f/s", t.rate)
+		}
+		return
+	}
+	t.successes++
+	if t.successes >= throttleIncreaseAfter {
+		t.successes = 0
+		t.rate = min(t.max, t.rate+t.min)
+	}
+}
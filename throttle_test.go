@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time advances only when waited on, so that a wait
+// of any length returns at once with the time moved on by its duration
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// newFakeClock returns a fakeClock starting at an arbitrary fixed time
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- f.now
+	return ch
+}
+
+// advance moves the time on by d
+func (f *fakeClock) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func TestAdaptiveThrottleRates(t *testing.T) {
+	throttle := newAdaptiveThrottle(8, 1, 10)
+	throttle.clock = newFakeClock()
+
+	for range throttleIncreaseAfter * 5 {
+		throttle.record(false)
+	}
+	if throttle.rate != 10 {
+		t.Errorf("rate %v after sustained success, want the maximum of 10", throttle.rate)
+	}
+
+	throttle.record(true)
+	throttle.record(true)
+	if throttle.rate != 5 {
+		t.Errorf("rate %v after two 429s within the reduce interval, want a single halving to 5", throttle.rate)
+	}
+	for range 5 {
+		throttle.clock.(*fakeClock).advance(throttleReduceInterval)
+		throttle.record(true)
+	}
+	if throttle.rate != 1 {
+		t.Errorf("rate %v after repeated 429s, want the minimum of 1", throttle.rate)
+	}
+}
+
+func TestAdaptiveThrottleConverges(t *testing.T) {
+	// The server allows limit requests in any second of the fake clock
+	const limit, pages = 10, 400
+	clock := newFakeClock()
+	s := newPageServer(t, numberedPages(pages, 1))
+	var mu sync.Mutex
+	var accepted []time.Time
+	limited := 0
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		now := clock.Now()
+		recent := 0
+		for _, at := range accepted {
+			if now.Sub(at) < time.Second {
+				recent++
+			}
+		}
+		if recent >= limit {
+			limited++
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return true
+		}
+		accepted = append(accepted, now)
+		return false
+	})
+
+	c := NewClient(s.URL, WithClock(clock), WithAdaptiveThrottle(50, 1, 100),
+		WithRetryPolicy(RetryClassRateLimited, RetryPolicy{Attempts: 50}))
+	if _, err := c.consumeAllPages(context.Background(), "h", "t0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Over the second half of the run the rate is sustained close to the limit,
+	// with few requests rate limited
+	mu.Lock()
+	defer mu.Unlock()
+	half := accepted[pages/2:]
+	rate := float64(len(half)-1) / half[len(half)-1].Sub(half[0]).Seconds()
+	if rate < limit/2 || rate > limit {
+		t.Errorf("converged to %.1f requests/s, want within half of the limit of %v", rate, limit)
+	}
+	if limited > pages/5 {
+		t.Errorf("%v requests rate limited, want the rate to settle below the limit", limited)
+	}
+}
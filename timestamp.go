@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestampLayouts are the formats attempted, in order, when parsing a timestamp
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseTimestamp parses a timestamp in one of timestampLayouts, or as integer
+// seconds since the Unix epoch.  Timestamps without a zone are taken as UTC
+func parseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unable to parse timestamp %q", s)
+}
+
+// sinceFilter identifies records whose timestamp column is before a cutoff
+type sinceFilter struct {
+	column string
+	cutoff time.Time
+}
+
+// parseSince parses a "column=cutoff" specification into a sinceFilter
+func parseSince(spec string) (*sinceFilter, error) {
+	i := strings.Index(spec, "=")
+	if i < 1 {
+		return nil, fmt.Errorf("since %q: expected column=timestamp", spec)
+	}
+	cutoff, err := parseTimestamp(spec[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("since %q: %v", spec, err)
+	}
+	return &sinceFilter{column: spec[:i], cutoff: cutoff}, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	for _, s := range []string{"2024-03-01T12:30:00Z", "2024-03-01T13:30:00+01:00", "2024-03-01T12:30:00", "2024-03-01 12:30:00", " 1709296200 "} {
+		got, err := parseTimestamp(s)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("%q: got %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := parseTimestamp("yesterday"); err == nil {
+		t.Error("parsed yesterday")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	f, err := parseSince("updated=2024-03-01")
+	if err != nil || f.column != "updated" || !f.cutoff.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v, %v", f, err)
+	}
+	for _, spec := range []string{"2024-03-01", "=2024-03-01", "updated=soon"} {
+		if _, err := parseSince(spec); err == nil {
+			t.Errorf("%q: parsed", spec)
+		}
+	}
+}
+
+func TestSinceFilter(t *testing.T) {
+	columns := testColumns("id", "updated")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{
+		{{"1", "2024-02-28T23:59:59Z"}, {"2", "2024-03-01T00:00:00Z"}},
+		{{"3", "2024-03-02"}, {"4", "2024-01-01"}},
+	}))
+	sink := &memorySink{}
+	cutoff := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	r, err := NewClient(s.URL, WithSince("updated", cutoff), WithRecordSink(sink)).consumeAllPages(context.Background(), "h", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.TotalRecords() != 2 || r.FilteredRecords != 2 || !reflect.DeepEqual(r.RecordCounts, []int{1, 1}) {
+		t.Errorf("got %v records per page, %v filtered, want [1 1] and 2 filtered", r.RecordCounts, r.FilteredRecords)
+	}
+	want := [][]string{{"2", "2024-03-01T00:00:00Z"}, {"3", "2024-03-02"}}
+	if !reflect.DeepEqual(sink.records, want) {
+		t.Errorf("output %v, want %v", sink.records, want)
+	}
+}
+
+func TestSinceFilterUnparseable(t *testing.T) {
+	columns := testColumns("id", "updated")
+	s := newPageServer(t, chainPages(columns, []string{"t1"}, [][][]string{{{"1", "soon"}}}))
+	_, err := NewClient(s.URL, WithSince("updated", time.Now())).consumeAllPages(context.Background(), "h", "t1")
+	var de *decodeError
+	if !errors.As(err, &de) {
+		t.Fatalf("got %v, want a decodeError", err)
+	}
+}
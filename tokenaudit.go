@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// tokenAudit writes a line for each page retrieved, giving its page number,
+// token, next token and record count, so that the cursor chain of each
+// pagination can be followed without the records.  Tokens are shown by redact,
+// and if decode is set are followed by their contents when they are base64
+// encoded JSON
+type tokenAudit struct {
+	mu     sync.Mutex
+	w      io.Writer
+	redact TokenRedactor
+	decode bool
+}
+
+// decodeToken returns the indented JSON of a token that is base64 encoded JSON,
+// in any of the standard and URL safe alphabets with or without padding, with
+// false for any other token
+func decodeToken(token string) (string, bool) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		data, err := enc.DecodeString(token)
+		if err != nil || !json.Valid(data) {
+			continue
+		}
+		var b bytes.Buffer
+		if err := json.Indent(&b, data, "    ", "  "); err != nil {
+			continue
+		}
+		return b.String(), true
+	}
+	return "", false
+}
+
+// page writes the line of the retrieved page
+func (a *tokenAudit) page(_ context.Context, e PageEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fmt.Fprintf(a.w, "Page: %v, hash: %v, token: %q, next: %q, records: %v\n", e.Page, e.Hash, a.redact(e.Token), a.redact(e.Next), e.Records)
+	if !a.decode {
+		return
+	}
+	if decoded, ok := decodeToken(e.Token); ok {
+		fmt.Fprintf(a.w, "  Decoded token: %v\n", decoded)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenAudit(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2", "t3"}, [][][]string{{{"1"}, {"2"}}, {{"3"}}, {}}))
+	var b bytes.Buffer
+	audit := &tokenAudit{w: &b, redact: RawToken}
+	if _, err := NewClient(s.URL, WithPageHook(audit.page)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	want := `Page: 1, hash: h, token: "t1", next: "t2", records: 2
+Page: 2, hash: h, token: "t2", next: "t3", records: 1
+Page: 3, hash: h, token: "t3", next: "", records: 0
+`
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestDecodeToken(t *testing.T) {
+	payload := []byte(`{"offset":20}`)
+	for _, token := range []string{base64.StdEncoding.EncodeToString(payload), base64.RawURLEncoding.EncodeToString(payload)} {
+		if got, ok := decodeToken(token); !ok || got != "{\n      \"offset\": 20\n    }" {
+			t.Errorf("%v: got %q, %v", token, got, ok)
+		}
+	}
+	for _, token := range []string{"t1", base64.StdEncoding.EncodeToString([]byte("not json"))} {
+		if got, ok := decodeToken(token); ok {
+			t.Errorf("%v: decoded as %q", token, got)
+		}
+	}
+}
+
+func TestTokenAuditDecode(t *testing.T) {
+	columns := testColumns("id")
+	cursor := base64.StdEncoding.EncodeToString([]byte(`{"offset":1,"sort":["id"]}`))
+	s := newPageServer(t, chainPages(columns, []string{"t1", cursor, "!!not base64"}, [][][]string{{{"1"}}, {{"2"}}, {}}))
+	var b bytes.Buffer
+	audit := &tokenAudit{w: &b, redact: RawToken, decode: true}
+	if _, err := NewClient(s.URL, WithPageHook(audit.page)).consumeAllPages(context.Background(), "h", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	want := `Page: 1, hash: h, token: "t1", next: "` + cursor + `", records: 1
+Page: 2, hash: h, token: "` + cursor + `", next: "!!not base64", records: 1
+  Decoded token: {
+      "offset": 1,
+      "sort": [
+        "id"
+      ]
+    }
+Page: 3, hash: h, token: "!!not base64", next: "", records: 0
+`
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+	// The raw tokens are still sent
+	if got := s.tokens(); !reflect.DeepEqual(got, []string{"t1", cursor, "!!not base64"}) {
+		t.Errorf("requested %v", got)
+	}
+}
+
+func TestPrintTokensFlag(t *testing.T) {
+	columns := testColumns("id")
+	s := newPageServer(t, chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}, {"3"}}}))
+	path := filepath.Join(t.TempDir(), "audit")
+	want := `Page: 1, hash: h, token: "t1", next: "t2", records: 1
+Page: 2, hash: h, token: "t2", next: "", records: 2
+`
+
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-print-tokens", "-show-tokens")
+	if code != 0 || !strings.Contains(stderr, want) {
+		t.Errorf("exit %v, stderr %q, want the chain %q", code, stderr, want)
+	}
+
+	if _, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-print-tokens", "-print-tokens-file", path, "-show-tokens"); code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Errorf("file %q, want %q", b, want)
+	}
+
+	// The file holds the chain of a run that fails after its pages are retrieved
+	_, _, code = runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-print-tokens", "-print-tokens-file", path, "-show-tokens", "-expect-records", "5")
+	if code == 0 {
+		t.Fatal("record count mismatch not reported")
+	}
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Errorf("file %q, want %q", b, want)
+	}
+
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-print-tokens-file", path); code == 0 {
+		t.Error("-print-tokens-file accepted without -print-tokens")
+	}
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-decode-tokens"); code == 0 {
+		t.Error("-decode-tokens accepted without -print-tokens")
+	}
+}
+
+func TestDecodeTokensFlag(t *testing.T) {
+	columns := testColumns("id")
+	cursor := base64.RawURLEncoding.EncodeToString([]byte(`{"after":"2026-01-01"}`))
+	s := newPageServer(t, chainPages(columns, []string{cursor}, [][][]string{{{"1"}}}))
+	// The contents are shown although the token itself is redacted
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", cursor, "-print-tokens", "-decode-tokens")
+	if code != 0 || !strings.Contains(stderr, "  Decoded token: {\n      \"after\": \"2026-01-01\"\n    }\n") || strings.Contains(stderr, `token: "`+cursor+`"`) {
+		t.Errorf("exit %v, stderr %q", code, stderr)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Handling of a page whose next token is that of a page already retrieved in the pagination
+const (
+	TokenReuseWarn  = "warn"
+	TokenReuseError = "error"
+)
+
+// reusedTokenError reports a page whose next token was already requested in the
+// pagination, so that following it would retrieve pages again, or loop forever
+type reusedTokenError struct {
+	token string
+	next  string
+}
+
+func (e *reusedTokenError) Error() string {
+	return fmt.Sprintf("page for token %v gave next token %v, which was already requested", e.token, e.next)
+}
+
+// checkTokenReuse returns a reusedTokenError if the next token of the page for
+// token was already requested, or under TokenReuseWarn logs a warning and
+// returns "" to stop the pagination.  Otherwise next is returned
+func (c *Client) checkTokenReuse(hash, token, next string, requested map[string]bool) (string, error) {
+	if !requested[next] {
+		return next, nil
+	}
+	err := &reusedTokenError{token: c.tokenRef(token), next: c.tokenRef(next)}
+	if c.tokenReuse == TokenReuseError {
+		return "", err
+	}
+	log.Printf("Warning: hash: %v: %v, stopping pagination", hash, err)
+	return "", nil
+}
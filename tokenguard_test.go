@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cyclingServer returns a pageServer of t1 -> t2 -> t3, whose page for t3 gives
+// the next token t2 once it has answered failedAttempts requests for it with a 503
+func cyclingServer(t *testing.T, failedAttempts int) *pageServer {
+	t.Helper()
+	columns := testColumns("id")
+	pages := chainPages(columns, []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}})
+	pages["t2"] = testPage("t3", columns, []string{"2"})
+	pages["t3"] = testPage("t2", columns, []string{"3"})
+	s := newPageServer(t, pages)
+	var mu sync.Mutex
+	attempts := 0
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Token == "t3" && attempts < failedAttempts {
+			attempts++
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+	return s
+}
+
+func TestTokenReuse(t *testing.T) {
+	for _, failedAttempts := range []int{0, 1} {
+		s := cyclingServer(t, failedAttempts)
+		retry := WithRetryPolicy(RetryClassServer, RetryPolicy{Attempts: 2, Backoff: time.Millisecond})
+
+		result, err := NewClient(s.URL, retry).consumeAllPages(context.Background(), "h", "t1")
+		if err != nil {
+			t.Fatalf("warn after %v failed attempts: %v", failedAttempts, err)
+		}
+		if result.PageCount != 3 || result.TotalRecords() != 3 {
+			t.Errorf("warn after %v failed attempts: got %v pages of %v records, want each page once", failedAttempts, result.PageCount, result.TotalRecords())
+		}
+
+		s = cyclingServer(t, failedAttempts)
+		_, err = NewClient(s.URL, retry, WithTokenReusePolicy(TokenReuseError), WithTokenRedactor(RawToken)).consumeAllPages(context.Background(), "h", "t1")
+		var re *reusedTokenError
+		if !errors.As(err, &re) || re.token != "t3" || re.next != "t2" {
+			t.Errorf("error after %v failed attempts: got %v, want a reusedTokenError of t3 giving t2", failedAttempts, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// TokenRedactor returns the form of a pagination token shown in logs and errors
+type TokenRedactor func(token string) string
+
+// redactedTokenLength is the number of hex digits of a token's hash shown by HashedToken
+const redactedTokenLength = 12
+
+// HashedToken is the default TokenRedactor, showing a truncated SHA-256 hash of
+// the token, which is stable so that a token can be followed through the logs
+func HashedToken(token string) string {
+	if len(token) == 0 {
+		return token
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])[:redactedTokenLength]
+}
+
+// RawToken is a TokenRedactor showing the token as it is, for debugging
+func RawToken(token string) string {
+	return token
+}
+
+// tokenRef returns the form of the token to be shown in logs and errors
+func (c *Client) tokenRef(token string) string {
+	return c.redactToken(token)
+}
+
+// redactTokenIn returns s, the start of a response body, with each occurrence of
+// the token, as encoded in JSON, replaced by its form shown in logs and errors
+func (c *Client) redactTokenIn(s, token string) string {
+	ref := c.tokenRef(token)
+	if len(token) == 0 || ref == token {
+		return s
+	}
+	var b strings.Builder
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	e.Encode(token)
+	encoded := strings.TrimSuffix(b.String(), "\n")
+	return strings.ReplaceAll(s, encoded[1:len(encoded)-1], ref)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHashedToken(t *testing.T) {
+	a, b := HashedToken("secret-1"), HashedToken("secret-2")
+	if a != HashedToken("secret-1") || a == b {
+		t.Errorf("got %v and %v, want a stable form distinct per token", a, b)
+	}
+	if !strings.HasPrefix(a, "sha256:") || len(a) != len("sha256:")+redactedTokenLength || strings.Contains(a, "secret") {
+		t.Errorf("got %v", a)
+	}
+	if HashedToken("") != "" {
+		t.Error("empty token redacted")
+	}
+}
+
+func TestTokensRedactedInLogs(t *testing.T) {
+	tokens := []string{"secret-t1", "secret-t2", "secret-t3"}
+	pages := chainPages(testColumns("id"), tokens, [][][]string{{{"1"}}, {{"2"}}, {{"3"}}})
+	// The second page is skipped, its body, holding the next token, logged
+	pages["secret-t2"] = []byte(`{"meta":{"next":"secret-t3"},"data":{"header":{"columns":[{"name":"id","type":"string","position":0}]},"records":"broken"}}`)
+	s := newPageServer(t, pages)
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if req.Token == "secret-t3" {
+			http.Error(w, "failed", http.StatusBadRequest)
+			return true
+		}
+		return false
+	})
+	args := []string{"-url", s.URL, "-hash", "h", "-token", "secret-t1", "-print-tokens", "-on-decode-error", DecodeErrorSkip}
+
+	stdout, stderr, code := runMain(t, args...)
+	if code == 0 {
+		t.Fatal("run succeeded")
+	}
+	if out := stdout + stderr; strings.Contains(out, "secret-t") {
+		t.Errorf("raw token in output %q", out)
+	}
+	if !strings.Contains(stderr, `{\"meta\":{\"next\":\"`+HashedToken("secret-t3")+`\"}`) {
+		t.Errorf("stderr %q, want the skipped page's body logged with its next token redacted", stderr)
+	}
+	// The failing token is shown in the same redacted form wherever it appears
+	if n := strings.Count(stdout+stderr, HashedToken("secret-t3")); n < 2 {
+		t.Errorf("redacted failing token shown %v times in %q", n, stdout+stderr)
+	}
+
+	stdout, stderr, _ = runMain(t, append(args, "-show-tokens")...)
+	if !strings.Contains(stdout+stderr, "secret-t3") {
+		t.Errorf("-show-tokens output %q, want the raw tokens", stdout+stderr)
+	}
+}
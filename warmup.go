@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// warmup establishes a connection to the page endpoint ahead of pagination,
+// with a HEAD request whose response, of any status, is discarded, so that the
+// DNS lookup, connect and TLS handshake are not included in the duration of the
+// first page.  The page endpoint accepting only POST usually answers with 405,
+// which still establishes the connection.  The connection is left idle in the
+// client's pool for reuse.  Pages replayed from captures need no connection, so
+// are not warmed up
+func (c *Client) warmup(ctx context.Context) error {
+	if _, ok := c.httpClient.Transport.(replayTransport); ok {
+		return nil
+	}
+	pageURL, err := c.pageURL()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, pageURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("warmup: %w", err)
+	}
+	// Draining the body allows the connection to be reused
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowConnectServer returns a pageServer of numberedPages(3, 1) taking setup
+// over each new connection, as a DNS lookup, connect and TLS handshake might,
+// and answering HEAD requests with 405 as the page endpoint does, with the
+// number of connections made
+func slowConnectServer(t *testing.T, setup time.Duration) (*pageServer, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	conns := 0
+	s := &pageServer{pages: numberedPages(3, 1)}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+			time.Sleep(setup)
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	s.setHandle(func(w http.ResponseWriter, r *http.Request, req Request) bool {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return true
+		}
+		return false
+	})
+	return s, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return conns
+	}
+}
+
+// pageDurations returns the request durations of the pages of numberedPages(3, 1)
+func pageDurations(t *testing.T, c *Client) []time.Duration {
+	t.Helper()
+	durations := []time.Duration{}
+	for _, token := range []string{"t0", "t1", "t2"} {
+		page, err := c.consumePage(context.Background(), "h", token, false, nil, nil, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		durations = append(durations, page.requestDuration)
+	}
+	return durations
+}
+
+func TestWarmup(t *testing.T) {
+	const setup = 200 * time.Millisecond
+
+	s, conns := slowConnectServer(t, setup)
+	c := NewClient(s.URL)
+	if err := c.warmup(context.Background()); err != nil {
+		t.Fatalf("got %v, want the 405 response accepted", err)
+	}
+	// The first page is comparable to the others, as its connection is made
+	durations := pageDurations(t, c)
+	for i, d := range durations {
+		if d >= setup/2 {
+			t.Errorf("page %v took %v of %v, want no connection setup", i+1, d, durations)
+		}
+	}
+	if got := conns(); got != 1 {
+		t.Errorf("%v connections, want the warmup connection reused", got)
+	}
+	if req := s.received()[0]; req.method != http.MethodHead {
+		t.Errorf("first request %v, want HEAD", req.method)
+	}
+
+	// Without warmup the first page includes the connection setup
+	s, _ = slowConnectServer(t, setup)
+	durations = pageDurations(t, NewClient(s.URL))
+	if durations[0] < setup || durations[1] >= setup/2 {
+		t.Errorf("took %v, want the first page alone to include the connection setup", durations)
+	}
+}
+
+func TestWarmupFailure(t *testing.T) {
+	s, _ := slowConnectServer(t, 0)
+	s.Close()
+	if err := NewClient(s.URL).warmup(context.Background()); err == nil || !strings.HasPrefix(err.Error(), "warmup: ") {
+		t.Errorf("got %v, want the failed connection", err)
+	}
+}
+
+func TestWarmupConnectionFlag(t *testing.T) {
+	s, conns := slowConnectServer(t, 0)
+	stdout, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t0", "-warmup-connection")
+	if code != 0 || !strings.Contains(stdout, "Pages: 3") {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if strings.Contains(stderr, "Warning") {
+		t.Errorf("stderr %q, want the 405 not reported", stderr)
+	}
+	if reqs := s.received(); len(reqs) != 4 || reqs[0].method != http.MethodHead || conns() != 1 {
+		t.Errorf("%v requests over %v connections, want the HEAD then the pages over one", len(reqs), conns())
+	}
+
+	// Replayed pages are not warmed up
+	dir := t.TempDir()
+	for token, page := range numberedPages(3, 1) {
+		if err := os.WriteFile(capturedPageFile(dir, token, CaptureCompressNone), page, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stdout, stderr, code = runMain(t, "-url", "http://127.0.0.1:1", "-hash", "h", "-token", "t0", "-warmup-connection", "-replay-dir", dir)
+	if code != 0 || !strings.Contains(stdout, "Pages: 3") || strings.Contains(stderr, "Warning") {
+		t.Errorf("exit %v, stdout %q, stderr %q", code, stdout, stderr)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webhookTimeout bounds each delivery of an event to the webhook
+const webhookTimeout = 10 * time.Second
+
+// PageEvent describes a page retrieved by a pagination, with Next the token of
+// the page that follows it, or "" if it is the last.  Page is the number of the
+// page within its pagination, from 1.  EstimatedPages is the estimatePages
+// estimate of the number of pages of the job, or 0 if unknown
+type PageEvent struct {
+	Page           int
+	Hash           string
+	Token          string
+	Next           string
+	Records        int
+	Bytes          int64
+	EstimatedPages int
+}
+
+// webhookEvent is the JSON body POSTed to the webhook for each event
+type webhookEvent struct {
+	Event        string    `json:"event"`
+	Time         time.Time `json:"time"`
+	Hash         string    `json:"hash,omitempty"`
+	Token        string    `json:"token,omitempty"`
+	Jobs         int       `json:"jobs,omitempty"`
+	FailedJobs   int       `json:"failed_jobs,omitempty"`
+	Pages        int       `json:"pages,omitempty"`
+	Records      int       `json:"records,omitempty"`
+	Bytes        int64     `json:"bytes,omitempty"`
+	SkippedPages int       `json:"skipped_pages,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// webhook delivers run events to a URL.  Failed deliveries are logged and do
+// not affect the run.  Page events are sent at most once per pageInterval, or
+// not at all if it is 0.  Tokens are sent in the form given by redactToken
+type webhook struct {
+	url          string
+	pageInterval time.Duration
+	redactToken  TokenRedactor
+	mu           sync.Mutex
+	lastPage     time.Time
+}
+
+// send POSTs the event to the webhook
+func (h *webhook) send(ctx context.Context, e webhookEvent) {
+	e.Time = time.Now().UTC()
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Webhook: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(b))
+	if err != nil {
+		log.Printf("Webhook: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Webhook: %v event: %v", e.Event, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Webhook: %v event: status %v", e.Event, resp.StatusCode)
+	}
+}
+
+// page sends a page event, unless one was sent within the page interval
+func (h *webhook) page(ctx context.Context, p PageEvent) {
+	if h.pageInterval <= 0 {
+		return
+	}
+	h.mu.Lock()
+	if time.Since(h.lastPage) < h.pageInterval {
+		h.mu.Unlock()
+		return
+	}
+	h.lastPage = time.Now()
+	h.mu.Unlock()
+
+	h.send(ctx, webhookEvent{Event: "page", Hash: p.Hash, Token: h.redactToken(p.Token), Records: p.Records, Bytes: p.Bytes})
+}
+
+// start sends the event for the start of a run of the jobs
+func (h *webhook) start(ctx context.Context, jobs int) {
+	h.send(ctx, webhookEvent{Event: "start", Jobs: jobs})
+}
+
+// finish sends an event for the result of each job, followed by the event
+// completing the run, which is "failed" if any job failed
+func (h *webhook) finish(ctx context.Context, results []JobResult, a Aggregate) {
+	for _, r := range results {
+		e := webhookEvent{Event: "job", Hash: r.Job.Hash, Token: h.redactToken(r.Job.Token), Pages: r.PageCount, Records: r.TotalRecords(), SkippedPages: r.SkippedPages}
+		if r.Err != nil {
+			e.Error = r.Err.Error()
+		}
+		h.send(ctx, e)
+	}
+
+	e := webhookEvent{Event: "complete", Jobs: a.Jobs, FailedJobs: a.FailedJobs, Pages: a.PageCount, Records: a.RecordCount, SkippedPages: a.SkippedPages}
+	if a.FailedJobs > 0 {
+		e.Event = "failed"
+		e.Error = fmt.Sprintf("%v of %v jobs failed", a.FailedJobs, a.Jobs)
+	}
+	h.send(ctx, e)
+}
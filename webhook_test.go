@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// webhookReceiver is an httptest.Server recording the webhook events POSTed to it
+type webhookReceiver struct {
+	*httptest.Server
+	mu     sync.Mutex
+	events []webhookEvent
+}
+
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	t.Helper()
+	h := &webhookReceiver{}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhookEvent
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&e) != nil {
+			t.Errorf("webhook received %v %v", r.Method, r.Header.Get("Content-Type"))
+		}
+		h.mu.Lock()
+		h.events = append(h.events, e)
+		h.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+// received returns the names of the events received so far
+func (h *webhookReceiver) received() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	names := []string{}
+	for _, e := range h.events {
+		names = append(names, e.Event)
+	}
+	return names
+}
+
+func TestWebhookEvents(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}, {"2"}}, {{"3"}}}))
+	h := newWebhookReceiver(t, http.StatusOK)
+
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-webhook", h.URL, "-webhook-page-interval", "1ns")
+	if code != 0 {
+		t.Fatalf("exit %v, stderr %q", code, stderr)
+	}
+	if got, want := h.received(), []string{"start", "page", "page", "job", "complete"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("received %v, want %v", got, want)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e := h.events[4]; e.Jobs != 1 || e.Pages != 2 || e.Records != 3 || len(e.Error) > 0 || e.Time.IsZero() {
+		t.Errorf("complete event %+v", e)
+	}
+	if e := h.events[3]; e.Hash != "h" || e.Records != 3 || e.Token == "t1" {
+		t.Errorf("job event %+v, want a redacted token", e)
+	}
+}
+
+func TestWebhookFailure(t *testing.T) {
+	pages := chainPages(testColumns("id"), []string{"t1", "t2"}, [][][]string{{{"1"}}, {{"2"}}})
+	delete(pages, "t2")
+	s := newPageServer(t, pages)
+	h := newWebhookReceiver(t, http.StatusOK)
+
+	if _, _, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-webhook", h.URL); code == 0 {
+		t.Fatal("failed run exited 0")
+	}
+	if got, want := h.received(), []string{"start", "job", "failed"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("received %v, want %v", got, want)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e := h.events[2]; e.FailedJobs != 1 || e.Error != "1 of 1 jobs failed" {
+		t.Errorf("failed event %+v", e)
+	}
+}
+
+func TestWebhookUnavailable(t *testing.T) {
+	s := newPageServer(t, chainPages(testColumns("id"), []string{"t1"}, [][][]string{{{"1"}}}))
+	h := newWebhookReceiver(t, http.StatusInternalServerError)
+
+	_, stderr, code := runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-webhook", h.URL)
+	if code != 0 {
+		t.Errorf("exit %v, want the run unaffected by the webhook failing, stderr %q", code, stderr)
+	}
+	if len(h.received()) != 3 {
+		t.Errorf("received %v", h.received())
+	}
+
+	_, stderr, code = runMain(t, "-url", s.URL, "-hash", "h", "-token", "t1", "-webhook", "http://127.0.0.1:1/events")
+	if code != 0 {
+		t.Errorf("unreachable webhook: exit %v, stderr %q", code, stderr)
+	}
+}